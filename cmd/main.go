@@ -6,10 +6,16 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/brian-nunez/bbaas-api/internal/githuboidc"
 	"github.com/brian-nunez/bbaas-api/internal/httpserver"
+	"github.com/brian-nunez/bbaas-api/internal/mailer"
+	"github.com/brian-nunez/bbaas-api/internal/sso"
+	"github.com/brian-nunez/bbaas-api/internal/users"
 )
 
 func main() {
@@ -18,15 +24,88 @@ func main() {
 	cdpPublicBaseURL := getenvOrDefault("CDP_PUBLIC_BASE_URL", "")
 	dbDriver := getenvOrDefault("DB_DRIVER", "sqlite")
 	dbDSN := getenvOrDefault("DB_DSN", "")
+	trustedProxies := strings.Split(getenvOrDefault("TRUSTED_PROXIES", ""), ",")
+	apiRateLimitPerMinute, err := strconv.Atoi(getenvOrDefault("API_RATE_LIMIT_PER_MINUTE", "120"))
+	if err != nil || apiRateLimitPerMinute < 0 {
+		log.Fatalf("API_RATE_LIMIT_PER_MINUTE must be a non-negative integer")
+	}
+	tokenExchangeRateLimitPerMinute, err := strconv.Atoi(getenvOrDefault("TOKEN_EXCHANGE_RATE_LIMIT_PER_MINUTE", "30"))
+	if err != nil || tokenExchangeRateLimitPerMinute < 0 {
+		log.Fatalf("TOKEN_EXCHANGE_RATE_LIMIT_PER_MINUTE must be a non-negative integer")
+	}
+	maxApplicationsPerOrganization, err := strconv.Atoi(getenvOrDefault("MAX_APPLICATIONS_PER_ORGANIZATION", "0"))
+	if err != nil || maxApplicationsPerOrganization < 0 {
+		log.Fatalf("MAX_APPLICATIONS_PER_ORGANIZATION must be a non-negative integer")
+	}
+	staleAPIKeyDays, err := strconv.Atoi(getenvOrDefault("API_KEY_STALE_DAYS", "30"))
+	if err != nil || staleAPIKeyDays < 0 {
+		log.Fatalf("API_KEY_STALE_DAYS must be a non-negative integer")
+	}
+	sessionIdleTimeoutHours, err := strconv.Atoi(getenvOrDefault("SESSION_IDLE_TIMEOUT_HOURS", "168"))
+	if err != nil || sessionIdleTimeoutHours <= 0 {
+		log.Fatalf("SESSION_IDLE_TIMEOUT_HOURS must be a positive integer")
+	}
+	sessionAbsoluteTimeoutHours, err := strconv.Atoi(getenvOrDefault("SESSION_ABSOLUTE_TIMEOUT_HOURS", "720"))
+	if err != nil || sessionAbsoluteTimeoutHours <= 0 {
+		log.Fatalf("SESSION_ABSOLUTE_TIMEOUT_HOURS must be a positive integer")
+	}
+
+	requireEmailVerification, err := strconv.ParseBool(getenvOrDefault("REQUIRE_EMAIL_VERIFICATION", "false"))
+	if err != nil {
+		log.Fatalf("REQUIRE_EMAIL_VERIFICATION must be true or false")
+	}
+
+	disablePasswordLogin, err := strconv.ParseBool(getenvOrDefault("DISABLE_PASSWORD_LOGIN", "false"))
+	if err != nil {
+		log.Fatalf("DISABLE_PASSWORD_LOGIN must be true or false")
+	}
 
 	server, err := httpserver.Bootstrap(httpserver.BootstrapConfig{
 		StaticDirectories: map[string]string{
 			"/assets": "./assets",
 		},
-		CDPManagerBaseURL: cdpManagerBaseURL,
-		CDPPublicBaseURL:  cdpPublicBaseURL,
-		DBDriver:          dbDriver,
-		DBDSN:             dbDSN,
+		CDPManagerBaseURL:     cdpManagerBaseURL,
+		CDPPublicBaseURL:      cdpPublicBaseURL,
+		DBDriver:              dbDriver,
+		DBDSN:                 dbDSN,
+		TrustedProxies:        trustedProxies,
+		APIRateLimitPerMinute: apiRateLimitPerMinute,
+		GitHubOIDC: githuboidc.Config{
+			JWKSURL:  getenvOrDefault("GITHUB_OIDC_JWKS_URL", githuboidc.DefaultJWKSURL),
+			Issuer:   getenvOrDefault("GITHUB_OIDC_ISSUER", githuboidc.DefaultIssuer),
+			Audience: getenvOrDefault("GITHUB_OIDC_AUDIENCE", githuboidc.DefaultAudience),
+		},
+		StaleAPIKeyAfter: time.Duration(staleAPIKeyDays) * 24 * time.Hour,
+		TokenHashPepper:  os.Getenv("TOKEN_HASH_PEPPER"),
+		CookieSecure:     getenvOrDefault("COOKIE_SECURE", "auto"),
+		PublicBaseURL:    getenvOrDefault("APP_BASE_URL", fmt.Sprintf("http://localhost:%s", port)),
+		SMTP: mailer.SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     getenvOrDefault("SMTP_PORT", "587"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     getenvOrDefault("MAIL_FROM", "BBAAS <no-reply@localhost>"),
+		},
+		MailDirectory:            os.Getenv("MAIL_DIR"),
+		RequireEmailVerification: requireEmailVerification,
+		TOTPEncryptionKey:        os.Getenv("TOTP_ENCRYPTION_KEY"),
+		SSO: sso.Config{
+			Issuer:       os.Getenv("OIDC_ISSUER"),
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+			Scopes:       strings.Fields(strings.ReplaceAll(getenvOrDefault("OIDC_SCOPES", "openid email profile"), ",", " ")),
+			GroupsClaim:  getenvOrDefault("OIDC_GROUPS_CLAIM", sso.DefaultGroupsClaim),
+			AdminGroups:  splitList(os.Getenv("OIDC_ADMIN_GROUPS")),
+		},
+		DisablePasswordLogin:            disablePasswordLogin,
+		MaxApplicationsPerOrganization:  maxApplicationsPerOrganization,
+		TokenExchangeRateLimitPerMinute: tokenExchangeRateLimitPerMinute,
+		TokenSigningEncryptionKey:       os.Getenv("TOKEN_SIGNING_ENCRYPTION_KEY"),
+		Sessions: users.SessionPolicy{
+			IdleTimeout:     time.Duration(sessionIdleTimeoutHours) * time.Hour,
+			AbsoluteTimeout: time.Duration(sessionAbsoluteTimeoutHours) * time.Hour,
+		},
 	})
 	if err != nil {
 		log.Fatalf("could not bootstrap server: %v", err)
@@ -63,3 +142,15 @@ func getenvOrDefault(key string, fallback string) string {
 
 	return value
 }
+
+// splitList reads a comma-separated setting, ignoring blank entries.
+func splitList(value string) []string {
+	var values []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			values = append(values, entry)
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,53 @@
+// Command token-hashes reports how many API keys and sessions are still stored under the
+// legacy unkeyed SHA-256 hash. They are rehashed with TOKEN_HASH_PEPPER the next time they
+// are used; sessions that are never used again simply expire.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+)
+
+func main() {
+	db, _, err := data.Open(data.Config{
+		Driver: getenvOrDefault("DB_DRIVER", "sqlite"),
+		DSN:    getenvOrDefault("DB_DSN", ""),
+	})
+	if err != nil {
+		log.Fatalf("open database: %v", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := data.RunMigrations(ctx, db); err != nil {
+		log.Fatalf("run database migrations: %v", err)
+	}
+
+	counts, err := data.NewStore(db).CountLegacyTokenHashes(ctx, security.HashVersionHMACSHA256, time.Now().UTC())
+	if err != nil {
+		log.Fatalf("count legacy token hashes: %v", err)
+	}
+
+	fmt.Printf("API keys with legacy hashes: %d\n", counts.APIKeys)
+	fmt.Printf("Sessions with legacy hashes: %d\n", counts.Sessions)
+	if os.Getenv("TOKEN_HASH_PEPPER") == "" {
+		fmt.Println("TOKEN_HASH_PEPPER is not set, so new tokens are still stored with legacy hashes.")
+	}
+}
+
+func getenvOrDefault(key string, fallback string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	return value
+}
@@ -9,6 +9,7 @@ require (
 	github.com/labstack/echo/v4 v4.13.4
 	golang.org/x/crypto v0.38.0
 	modernc.org/sqlite v1.37.0
+	rsc.io/qr v0.2.0
 )
 
 require (
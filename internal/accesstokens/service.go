@@ -0,0 +1,446 @@
+// Package accesstokens mints short-lived signed tokens from API keys and verifies them
+// without a database lookup per request.
+package accesstokens
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/applications"
+	"github.com/brian-nunez/bbaas-api/internal/authorization"
+	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/jwt"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+)
+
+const (
+	MaxTTL     = time.Hour
+	MinTTL     = time.Minute
+	DefaultTTL = 15 * time.Minute
+
+	// SigningKeyRotationInterval is how long a signing key is used to mint new tokens.
+	// Retired keys are kept long enough to verify every token they signed.
+	SigningKeyRotationInterval = 24 * time.Hour
+
+	issuer            = "bbaas"
+	clockSkewLeeway   = 30 * time.Second
+	minReloadInterval = 10 * time.Second
+)
+
+var (
+	ErrInvalidToken    = errors.New("invalid access token")
+	ErrTokenExpired    = errors.New("access token has expired")
+	ErrInvalidTTL      = fmt.Errorf("token TTL must be between %d and %d seconds", int(MinTTL/time.Second), int(MaxTTL/time.Second))
+	ErrUnknownScope    = errors.New("unknown scope")
+	ErrScopeNotGranted = errors.New("API key does not hold the requested scope")
+	ErrBrowserNotFound = errors.New("browser not found")
+	ErrMintWithToken   = errors.New("access tokens cannot be used to mint other tokens")
+)
+
+type MintRequest struct {
+	// TTL defaults to DefaultTTL when zero.
+	TTL time.Duration
+	// Scopes narrows the token to a subset of the key's scopes; empty keeps all of them.
+	Scopes []string
+	// BrowserID optionally restricts the token to a single browser of the application.
+	BrowserID string
+}
+
+type Token struct {
+	ID        string
+	Token     string
+	ExpiresAt time.Time
+	Scopes    []string
+	BrowserID string
+}
+
+type claims struct {
+	jwt.RegisteredClaims
+	ApplicationID      string   `json:"app"`
+	Scopes             []string `json:"scopes"`
+	BrowserID          string   `json:"bid,omitempty"`
+	ApplicationCIDRs   []string `json:"app_cidrs,omitempty"`
+	KeyCIDRs           []string `json:"key_cidrs,omitempty"`
+	RateLimitPerMinute int      `json:"rate_limit,omitempty"`
+}
+
+type signingKey struct {
+	id        string
+	secret    []byte
+	createdAt time.Time
+}
+
+// Service mints and verifies access tokens. Signing keys live in the database so every
+// instance can verify tokens minted by the others; they are cached in memory and reloaded
+// when a token names a key the cache does not know yet.
+type Service struct {
+	store     *data.Store
+	secretBox *security.SecretBox
+	now       func() time.Time
+
+	mu           sync.RWMutex
+	keys         map[string]signingKey
+	current      signingKey
+	lastReloadAt time.Time
+}
+
+func NewService(store *data.Store) *Service {
+	return &Service{
+		store: store,
+		now:   time.Now,
+		keys:  make(map[string]signingKey),
+	}
+}
+
+// UseSecretBox encrypts signing key secrets at rest. Keys stored in plaintext before it was
+// configured are encrypted the next time they are loaded.
+func (s *Service) UseSecretBox(secretBox *security.SecretBox) {
+	s.secretBox = secretBox
+}
+
+// Mint issues a token for the authenticated API key. Tokens cannot mint further tokens.
+func (s *Service) Mint(ctx context.Context, principal applications.APIKeyPrincipal, request MintRequest) (Token, error) {
+	if principal.IsAccessToken() {
+		return Token{}, ErrMintWithToken
+	}
+
+	ttl := request.TTL
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	if ttl < MinTTL || ttl > MaxTTL {
+		return Token{}, ErrInvalidTTL
+	}
+
+	scopes, err := narrowScopes(principal.Scopes, request.Scopes)
+	if err != nil {
+		return Token{}, err
+	}
+
+	browserID := strings.TrimSpace(request.BrowserID)
+	if browserID != "" {
+		session, found, err := s.store.GetBrowserSessionByExternalID(ctx, principal.ApplicationID, browserID)
+		if err != nil {
+			return Token{}, fmt.Errorf("lookup tracked browser session: %w", err)
+		}
+		if !found || session.Status == "COMPLETED" {
+			return Token{}, ErrBrowserNotFound
+		}
+	}
+
+	key, err := s.signingKey(ctx)
+	if err != nil {
+		return Token{}, err
+	}
+
+	tokenID, err := security.GeneratePrefixedToken("tok", 12)
+	if err != nil {
+		return Token{}, fmt.Errorf("generate access token id: %w", err)
+	}
+
+	now := s.now().UTC()
+	expiresAt := now.Add(ttl).Truncate(time.Second)
+	signed, err := jwt.SignHS256(key.id, key.secret, claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   principal.KeyID,
+			ID:        tokenID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: expiresAt.Unix(),
+		},
+		ApplicationID:      principal.ApplicationID,
+		Scopes:             scopes,
+		BrowserID:          browserID,
+		ApplicationCIDRs:   prefixStrings(principal.IPAllowlist.Application),
+		KeyCIDRs:           prefixStrings(principal.IPAllowlist.Key),
+		RateLimitPerMinute: principal.RateLimitPerMinute,
+	})
+	if err != nil {
+		return Token{}, fmt.Errorf("sign access token: %w", err)
+	}
+
+	return Token{
+		ID:        tokenID,
+		Token:     signed,
+		ExpiresAt: expiresAt,
+		Scopes:    scopes,
+		BrowserID: browserID,
+	}, nil
+}
+
+// Authenticate verifies a token offline and returns the principal it was minted for.
+// Revoking the underlying key does not invalidate tokens that were already minted; they
+// stop working when they expire.
+func (s *Service) Authenticate(ctx context.Context, rawToken string) (applications.APIKeyPrincipal, error) {
+	token, err := jwt.Parse(rawToken)
+	if err != nil {
+		return applications.APIKeyPrincipal{}, ErrInvalidToken
+	}
+
+	key, found, err := s.verificationKey(ctx, token.Header.KeyID)
+	if err != nil {
+		return applications.APIKeyPrincipal{}, err
+	}
+	if !found {
+		return applications.APIKeyPrincipal{}, ErrInvalidToken
+	}
+	if err := token.VerifyHS256(key.secret); err != nil {
+		return applications.APIKeyPrincipal{}, ErrInvalidToken
+	}
+
+	var tokenClaims claims
+	if err := token.DecodeClaims(&tokenClaims); err != nil {
+		return applications.APIKeyPrincipal{}, ErrInvalidToken
+	}
+	if tokenClaims.Issuer != issuer || tokenClaims.Subject == "" || tokenClaims.ApplicationID == "" || tokenClaims.ExpiresAt == 0 {
+		return applications.APIKeyPrincipal{}, ErrInvalidToken
+	}
+	if err := tokenClaims.ValidateTimes(s.now(), clockSkewLeeway); err != nil {
+		if errors.Is(err, jwt.ErrExpired) {
+			return applications.APIKeyPrincipal{}, ErrTokenExpired
+		}
+		return applications.APIKeyPrincipal{}, ErrInvalidToken
+	}
+
+	return applications.APIKeyPrincipal{
+		KeyID:         tokenClaims.Subject,
+		ApplicationID: tokenClaims.ApplicationID,
+		Scopes:        tokenClaims.Scopes,
+		IPAllowlist: applications.IPAllowlist{
+			Application: parsePrefixes(tokenClaims.ApplicationCIDRs),
+			Key:         parsePrefixes(tokenClaims.KeyCIDRs),
+		},
+		RateLimitPerMinute: tokenClaims.RateLimitPerMinute,
+		AccessTokenID:      tokenClaims.ID,
+		BrowserID:          tokenClaims.BrowserID,
+	}, nil
+}
+
+// RotateSigningKeys creates a new signing key once the current one is older than
+// SigningKeyRotationInterval and deletes keys that can no longer have valid tokens.
+func (s *Service) RotateSigningKeys(ctx context.Context) error {
+	current, err := s.signingKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	// A key signs tokens for at most one rotation interval (plus one job run), and those
+	// tokens live for at most MaxTTL after that.
+	cutoff := s.now().UTC().Add(-(2*SigningKeyRotationInterval + MaxTTL))
+	if _, err := s.store.DeleteTokenSigningKeysCreatedBefore(ctx, cutoff, current.id); err != nil {
+		return err
+	}
+
+	return s.reload(ctx)
+}
+
+// signingKey returns the key new tokens are signed with, creating one when the newest
+// stored key is due for rotation.
+func (s *Service) signingKey(ctx context.Context) (signingKey, error) {
+	now := s.now().UTC()
+
+	s.mu.RLock()
+	current := s.current
+	s.mu.RUnlock()
+	if current.id != "" && now.Sub(current.createdAt) < SigningKeyRotationInterval {
+		return current, nil
+	}
+
+	if err := s.reload(ctx); err != nil {
+		return signingKey{}, err
+	}
+
+	s.mu.RLock()
+	current = s.current
+	s.mu.RUnlock()
+	if current.id != "" && now.Sub(current.createdAt) < SigningKeyRotationInterval {
+		return current, nil
+	}
+
+	return s.createSigningKey(ctx, now)
+}
+
+func (s *Service) createSigningKey(ctx context.Context, now time.Time) (signingKey, error) {
+	keyID, err := security.GeneratePrefixedToken("tsk", 8)
+	if err != nil {
+		return signingKey{}, fmt.Errorf("generate token signing key id: %w", err)
+	}
+	secret, err := security.GeneratePrefixedToken("", 32)
+	if err != nil {
+		return signingKey{}, fmt.Errorf("generate token signing key secret: %w", err)
+	}
+
+	record := data.TokenSigningKeyRecord{ID: keyID, Secret: secret, CreatedAt: now}
+	stored := record
+	if s.secretBox != nil {
+		stored.Secret, err = s.secretBox.Seal(secret, keyID)
+		if err != nil {
+			return signingKey{}, fmt.Errorf("encrypt token signing key secret: %w", err)
+		}
+	}
+	if err := s.store.CreateTokenSigningKey(ctx, stored); err != nil {
+		return signingKey{}, fmt.Errorf("create token signing key: %w", err)
+	}
+
+	key := mapSigningKeyRecord(record)
+	s.mu.Lock()
+	s.keys[key.id] = key
+	s.current = key
+	s.mu.Unlock()
+
+	return key, nil
+}
+
+func (s *Service) verificationKey(ctx context.Context, keyID string) (signingKey, bool, error) {
+	if keyID == "" {
+		return signingKey{}, false, nil
+	}
+
+	s.mu.RLock()
+	key, found := s.keys[keyID]
+	lastReloadAt := s.lastReloadAt
+	s.mu.RUnlock()
+	if found {
+		return key, true, nil
+	}
+
+	// Another instance may have rotated. Reload, but not on every request carrying a
+	// made-up key ID.
+	if s.now().Sub(lastReloadAt) < minReloadInterval {
+		return signingKey{}, false, nil
+	}
+	if err := s.reload(ctx); err != nil {
+		return signingKey{}, false, err
+	}
+
+	s.mu.RLock()
+	key, found = s.keys[keyID]
+	s.mu.RUnlock()
+	return key, found, nil
+}
+
+func (s *Service) reload(ctx context.Context) error {
+	records, err := s.store.ListTokenSigningKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("load token signing keys: %w", err)
+	}
+
+	keys := make(map[string]signingKey, len(records))
+	var current signingKey
+	for _, record := range records {
+		record.Secret, err = s.openSecret(ctx, record)
+		if err != nil {
+			// Tokens signed with a key we cannot read are rejected; a fresh key is created
+			// for new tokens.
+			log.Printf("skip token signing key %s: %v", record.ID, err)
+			continue
+		}
+		key := mapSigningKeyRecord(record)
+		keys[key.id] = key
+		if current.id == "" || key.createdAt.After(current.createdAt) {
+			current = key
+		}
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.current = current
+	s.lastReloadAt = s.now()
+	s.mu.Unlock()
+
+	return nil
+}
+
+// openSecret returns the plaintext secret of record, encrypting it in place when it was
+// stored before a secret box was configured.
+func (s *Service) openSecret(ctx context.Context, record data.TokenSigningKeyRecord) (string, error) {
+	if security.IsSealed(record.Secret) {
+		if s.secretBox == nil {
+			return "", errors.New("secret is encrypted but no encryption key is configured")
+		}
+		return s.secretBox.Open(record.Secret, record.ID)
+	}
+	if s.secretBox == nil {
+		return record.Secret, nil
+	}
+
+	sealed, err := s.secretBox.Seal(record.Secret, record.ID)
+	if err != nil {
+		return "", fmt.Errorf("encrypt token signing key secret: %w", err)
+	}
+	if err := s.store.UpdateTokenSigningKeySecret(ctx, record.ID, sealed); err != nil {
+		return "", err
+	}
+
+	return record.Secret, nil
+}
+
+// narrowScopes returns the requested scopes in canonical order, or all granted scopes when
+// none were requested.
+func narrowScopes(granted []string, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return granted, nil
+	}
+
+	for _, scope := range requested {
+		scope = strings.TrimSpace(scope)
+		if !authorization.IsKnownScope(scope) {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownScope, scope)
+		}
+		if !slices.Contains(granted, scope) {
+			return nil, fmt.Errorf("%w: %s", ErrScopeNotGranted, scope)
+		}
+	}
+
+	narrowed := make([]string, 0, len(requested))
+	for _, scope := range authorization.AllScopes {
+		if slices.ContainsFunc(requested, func(candidate string) bool { return strings.TrimSpace(candidate) == scope }) {
+			narrowed = append(narrowed, scope)
+		}
+	}
+
+	return narrowed, nil
+}
+
+func mapSigningKeyRecord(record data.TokenSigningKeyRecord) signingKey {
+	return signingKey{
+		id:        record.ID,
+		secret:    []byte(record.Secret),
+		createdAt: record.CreatedAt.UTC(),
+	}
+}
+
+func prefixStrings(prefixes []netip.Prefix) []string {
+	if len(prefixes) == 0 {
+		return nil
+	}
+
+	values := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		values = append(values, prefix.String())
+	}
+
+	return values
+}
+
+// parsePrefixes mirrors how stored allowlists are loaded: an entry that does not parse
+// becomes an empty prefix that matches nothing, so the restriction is never lifted.
+func parsePrefixes(values []string) []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			prefix = netip.Prefix{}
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	return prefixes
+}
@@ -0,0 +1,281 @@
+package accesstokens
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/applications"
+	"github.com/brian-nunez/bbaas-api/internal/authorization"
+	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/data/datatest"
+	"github.com/brian-nunez/bbaas-api/internal/jwt"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+	"github.com/brian-nunez/bbaas-api/internal/users"
+)
+
+func TestMintAndAuthenticate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	principal := createPrincipal(t, store)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	service := NewService(store)
+	service.now = func() time.Time { return now }
+
+	token, err := service.Mint(ctx, principal, MintRequest{
+		TTL:    10 * time.Minute,
+		Scopes: []string{authorization.ScopeBrowsersRead},
+	})
+	if err != nil {
+		t.Fatalf("mint token: %v", err)
+	}
+	if !token.ExpiresAt.Equal(now.Add(10 * time.Minute)) {
+		t.Fatalf("expected token to expire in 10 minutes, got %s", token.ExpiresAt)
+	}
+
+	// A fresh service instance has an empty cache and must load the key from the store.
+	verifier := NewService(store)
+	verifier.now = func() time.Time { return now.Add(time.Minute) }
+	authenticated, err := verifier.Authenticate(ctx, token.Token)
+	if err != nil {
+		t.Fatalf("authenticate token: %v", err)
+	}
+	if authenticated.KeyID != principal.KeyID || authenticated.ApplicationID != principal.ApplicationID {
+		t.Fatalf("expected token to identify the minting key, got %+v", authenticated)
+	}
+	if !authenticated.IsAccessToken() || authenticated.AccessTokenID != token.ID {
+		t.Fatalf("expected principal to carry the access token id, got %q", authenticated.AccessTokenID)
+	}
+	if !slices.Equal(authenticated.Scopes, []string{authorization.ScopeBrowsersRead}) {
+		t.Fatalf("expected narrowed scopes, got %v", authenticated.Scopes)
+	}
+	if authenticated.RateLimitPerMinute != principal.RateLimitPerMinute {
+		t.Fatalf("expected rate limit %d, got %d", principal.RateLimitPerMinute, authenticated.RateLimitPerMinute)
+	}
+	if authenticated.IPAllowlist.Allows(netip.MustParseAddr("192.0.2.1")) {
+		t.Fatalf("expected key allowlist to carry over to the token")
+	}
+	if !authenticated.IPAllowlist.Allows(netip.MustParseAddr("10.1.2.3")) {
+		t.Fatalf("expected allowed range to carry over to the token")
+	}
+
+	verifier.now = func() time.Time { return now.Add(11 * time.Minute) }
+	if _, err := verifier.Authenticate(ctx, token.Token); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("expected expired token error, got %v", err)
+	}
+
+	header, rest, _ := strings.Cut(token.Token, ".")
+	_, signature, _ := strings.Cut(rest, ".")
+	forgedPayload := base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"bbaas","sub":"other","app":"other","exp":9999999999}`))
+	tampered := header + "." + forgedPayload + "." + signature
+	if _, err := service.Authenticate(ctx, tampered); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected tampered token to be rejected, got %v", err)
+	}
+
+	if _, err := service.Mint(ctx, authenticated, MintRequest{}); !errors.Is(err, ErrMintWithToken) {
+		t.Fatalf("expected tokens to be unable to mint tokens, got %v", err)
+	}
+}
+
+func TestMintValidatesRequest(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	principal := createPrincipal(t, store)
+	service := NewService(store)
+
+	if _, err := service.Mint(ctx, principal, MintRequest{TTL: 2 * time.Hour}); !errors.Is(err, ErrInvalidTTL) {
+		t.Fatalf("expected TTL over one hour to be rejected, got %v", err)
+	}
+	if _, err := service.Mint(ctx, principal, MintRequest{Scopes: []string{authorization.ScopeBrowsersClose}}); !errors.Is(err, ErrScopeNotGranted) {
+		t.Fatalf("expected scope the key lacks to be rejected, got %v", err)
+	}
+	if _, err := service.Mint(ctx, principal, MintRequest{Scopes: []string{"browsers:everything"}}); !errors.Is(err, ErrUnknownScope) {
+		t.Fatalf("expected unknown scope to be rejected, got %v", err)
+	}
+	if _, err := service.Mint(ctx, principal, MintRequest{BrowserID: "missing"}); !errors.Is(err, ErrBrowserNotFound) {
+		t.Fatalf("expected untracked browser to be rejected, got %v", err)
+	}
+
+	now := time.Now().UTC()
+	if err := store.CreateBrowserSession(ctx, data.BrowserSessionRecord{
+		ID:                "bsn_test",
+		ApplicationID:     principal.ApplicationID,
+		ExternalBrowserID: "browser-1",
+		Status:            "RUNNING",
+		CreatedAt:         now,
+		LastActiveAt:      now,
+		ExpiresAt:         now.Add(time.Minute),
+	}); err != nil {
+		t.Fatalf("create browser session: %v", err)
+	}
+
+	token, err := service.Mint(ctx, principal, MintRequest{BrowserID: "browser-1"})
+	if err != nil {
+		t.Fatalf("mint browser-bound token: %v", err)
+	}
+	if !token.ExpiresAt.After(now.Add(DefaultTTL - time.Minute)) {
+		t.Fatalf("expected default TTL, got expiry %s", token.ExpiresAt)
+	}
+
+	authenticated, err := service.Authenticate(ctx, token.Token)
+	if err != nil {
+		t.Fatalf("authenticate browser-bound token: %v", err)
+	}
+	if authenticated.BrowserID != "browser-1" {
+		t.Fatalf("expected browser id to carry over, got %q", authenticated.BrowserID)
+	}
+}
+
+func TestRotateSigningKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	principal := createPrincipal(t, store)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	service := NewService(store)
+	service.now = func() time.Time { return now }
+
+	oldToken, err := service.Mint(ctx, principal, MintRequest{TTL: MaxTTL})
+	if err != nil {
+		t.Fatalf("mint token: %v", err)
+	}
+
+	now = now.Add(SigningKeyRotationInterval)
+	if err := service.RotateSigningKeys(ctx); err != nil {
+		t.Fatalf("rotate signing keys: %v", err)
+	}
+	newToken, err := service.Mint(ctx, principal, MintRequest{TTL: MaxTTL})
+	if err != nil {
+		t.Fatalf("mint token after rotation: %v", err)
+	}
+
+	oldKeyID := mustKeyID(t, oldToken.Token)
+	if newKeyID := mustKeyID(t, newToken.Token); newKeyID == oldKeyID {
+		t.Fatalf("expected a new signing key after rotation, still using %s", oldKeyID)
+	}
+
+	now = now.Add(2*SigningKeyRotationInterval + MaxTTL + time.Minute)
+	if err := service.RotateSigningKeys(ctx); err != nil {
+		t.Fatalf("rotate signing keys: %v", err)
+	}
+
+	keys, err := store.ListTokenSigningKeys(ctx)
+	if err != nil {
+		t.Fatalf("list signing keys: %v", err)
+	}
+	for _, key := range keys {
+		if key.ID == oldKeyID {
+			t.Fatalf("expected the first signing key to be pruned")
+		}
+	}
+}
+
+func TestSigningKeySecretsAreEncrypted(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	principal := createPrincipal(t, store)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	legacy := NewService(store)
+	legacy.now = func() time.Time { return now }
+
+	legacyToken, err := legacy.Mint(ctx, principal, MintRequest{})
+	if err != nil {
+		t.Fatalf("mint token: %v", err)
+	}
+
+	secretBox, err := security.NewSecretBox("test-encryption-key")
+	if err != nil {
+		t.Fatalf("create secret box: %v", err)
+	}
+	service := NewService(store)
+	service.UseSecretBox(secretBox)
+	service.now = func() time.Time { return now }
+	if _, err := service.Authenticate(ctx, legacyToken.Token); err != nil {
+		t.Fatalf("expected a token signed with a plaintext key to verify, got %v", err)
+	}
+
+	now = now.Add(SigningKeyRotationInterval)
+	token, err := service.Mint(ctx, principal, MintRequest{})
+	if err != nil {
+		t.Fatalf("mint token: %v", err)
+	}
+
+	keys, err := store.ListTokenSigningKeys(ctx)
+	if err != nil {
+		t.Fatalf("list signing keys: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected two signing keys, got %d", len(keys))
+	}
+	for _, key := range keys {
+		if !security.IsSealed(key.Secret) {
+			t.Fatalf("expected signing key %s to be stored encrypted", key.ID)
+		}
+	}
+
+	if _, err := legacy.Authenticate(ctx, token.Token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected a service without the encryption key to reject the token, got %v", err)
+	}
+}
+
+func mustKeyID(t *testing.T, rawToken string) string {
+	t.Helper()
+
+	token, err := jwt.Parse(rawToken)
+	if err != nil {
+		t.Fatalf("parse token: %v", err)
+	}
+
+	return token.Header.KeyID
+}
+
+func createPrincipal(t *testing.T, store *data.Store) applications.APIKeyPrincipal {
+	t.Helper()
+
+	ctx := context.Background()
+	user, err := users.NewService(store, security.NewTokenHasher("test-pepper"), users.SessionPolicy{}).Register(ctx, "tokens@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+
+	appsService := applications.NewService(store, authorization.NewWebAuthorizer(), security.NewTokenHasher("test-pepper"))
+	application, err := appsService.RegisterApplication(ctx, user, applications.RegisterApplicationInput{
+		Name:       "CI",
+		GitHubLink: "https://github.com/example-org/ci",
+		Domain:     "example.com",
+	})
+	if err != nil {
+		t.Fatalf("register application: %v", err)
+	}
+
+	created, err := appsService.CreateAPIKey(ctx, user, application.ID, applications.CreateAPIKeyInput{
+		Name:               "CI",
+		Scopes:             []string{authorization.ScopeBrowsersSpawn, authorization.ScopeBrowsersRead},
+		RateLimitPerMinute: 30,
+	})
+	if err != nil {
+		t.Fatalf("create API key: %v", err)
+	}
+	if _, err := appsService.UpdateAPIKeyAllowedCIDRs(ctx, user, application.ID, created.APIKey.ID, "10.0.0.0/8"); err != nil {
+		t.Fatalf("update API key allowed CIDRs: %v", err)
+	}
+
+	principal, err := appsService.AuthenticateAPIKey(ctx, created.Token)
+	if err != nil {
+		t.Fatalf("authenticate API key: %v", err)
+	}
+
+	return principal
+}
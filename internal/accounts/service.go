@@ -0,0 +1,53 @@
+// Package accounts lets users delete their own account, shutting down what it runs first.
+package accounts
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/brian-nunez/bbaas-api/internal/applications"
+	"github.com/brian-nunez/bbaas-api/internal/browsers"
+	"github.com/brian-nunez/bbaas-api/internal/users"
+)
+
+var ErrConfirmationMismatch = errors.New("type your email address exactly to confirm")
+
+type Service struct {
+	users        *users.Service
+	applications *applications.Service
+	browsers     *browsers.Service
+}
+
+func NewService(usersService *users.Service, applicationsService *applications.Service, browserService *browsers.Service) *Service {
+	return &Service{
+		users:        usersService,
+		applications: applicationsService,
+		browsers:     browserService,
+	}
+}
+
+// Delete removes the user's account after they retype their email address and password. The
+// running browsers of applications deleted with the account are closed first, and shared
+// organizations must keep another owner.
+func (s *Service) Delete(ctx context.Context, user users.User, confirmEmail string, password string, client users.Client) error {
+	if !strings.EqualFold(strings.TrimSpace(confirmEmail), user.Email) {
+		return ErrConfirmationMismatch
+	}
+	if err := s.users.Reauthenticate(ctx, user, password, client); err != nil {
+		return err
+	}
+	if err := s.users.CheckDeletable(ctx, user); err != nil {
+		return err
+	}
+
+	if _, err := s.browsers.CloseForDeletedUser(ctx, user.ID); err != nil {
+		return err
+	}
+	if err := s.users.Delete(ctx, user.ID); err != nil {
+		return err
+	}
+
+	// Keys of deleted applications, and of handed-over ones, must stop resolving from the cache.
+	return s.applications.InvalidateAuthCache(ctx)
+}
@@ -0,0 +1,132 @@
+package accounts
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/brian-nunez/bbaas-api/internal/applications"
+	"github.com/brian-nunez/bbaas-api/internal/authorization"
+	"github.com/brian-nunez/bbaas-api/internal/browsers"
+	"github.com/brian-nunez/bbaas-api/internal/data/datatest"
+	"github.com/brian-nunez/bbaas-api/internal/organizations"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+	"github.com/brian-nunez/bbaas-api/internal/users"
+)
+
+func TestDeleteAccount(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	usersService := users.NewService(store, security.NewTokenHasher("test-pepper"), users.SessionPolicy{})
+	appsService := applications.NewService(store, authorization.NewWebAuthorizer(), security.NewTokenHasher("test-pepper"))
+	orgsService := organizations.NewService(store, authorization.NewWebAuthorizer())
+	managerClient := browsers.NewMemoryManagerClient()
+	browserService := browsers.NewService(managerClient, store, authorization.NewAPIAuthorizer(), "")
+	service := NewService(usersService, appsService, browserService)
+
+	if _, err := usersService.Register(ctx, "root@example.com", "password123"); err != nil {
+		t.Fatalf("register admin: %v", err)
+	}
+	leaving, err := usersService.Register(ctx, "leaving@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	teammate, err := usersService.Register(ctx, "teammate@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+
+	team, err := orgsService.Create(ctx, leaving, "Team")
+	if err != nil {
+		t.Fatalf("create organization: %v", err)
+	}
+	if _, err := orgsService.AddMember(ctx, leaving, team.ID, teammate.Email, authorization.OrganizationRoleDeveloper); err != nil {
+		t.Fatalf("add member: %v", err)
+	}
+
+	personalApp, personalKey := registerApplication(t, appsService, leaving, "")
+	teamApp, teamKey := registerApplication(t, appsService, leaving, team.ID)
+
+	principal, err := appsService.AuthenticateAPIKey(ctx, personalKey)
+	if err != nil {
+		t.Fatalf("authenticate API key: %v", err)
+	}
+	spawned, err := browserService.SpawnForAPIKey(ctx, principal, browsers.SpawnRequest{})
+	if err != nil {
+		t.Fatalf("spawn browser: %v", err)
+	}
+
+	if err := service.Delete(ctx, leaving, "someone@example.com", "password123", users.Client{}); !errors.Is(err, ErrConfirmationMismatch) {
+		t.Fatalf("expected ErrConfirmationMismatch, got %v", err)
+	}
+	if err := service.Delete(ctx, leaving, leaving.Email, "wrong-password", users.Client{}); !errors.Is(err, users.ErrIncorrectPassword) {
+		t.Fatalf("expected ErrIncorrectPassword, got %v", err)
+	}
+	var ownershipErr *users.OwnershipRequiredError
+	if err := service.Delete(ctx, leaving, leaving.Email, "password123", users.Client{}); !errors.As(err, &ownershipErr) {
+		t.Fatalf("expected OwnershipRequiredError, got %v", err)
+	}
+	if len(ownershipErr.Organizations) != 1 || ownershipErr.Organizations[0] != "Team" {
+		t.Fatalf("unexpected blocking organizations %v", ownershipErr.Organizations)
+	}
+	if _, err := managerClient.Get(ctx, spawned.Browser.ID); err != nil {
+		t.Fatalf("expected a blocked deletion to leave browsers running, got %v", err)
+	}
+
+	if err := orgsService.UpdateMemberRole(ctx, leaving, team.ID, teammate.ID, authorization.OrganizationRoleOwner); err != nil {
+		t.Fatalf("promote teammate: %v", err)
+	}
+	if err := service.Delete(ctx, leaving, " Leaving@Example.com ", "password123", users.Client{}); err != nil {
+		t.Fatalf("delete account: %v", err)
+	}
+
+	if _, err := usersService.GetUser(ctx, leaving.ID); !errors.Is(err, users.ErrUserNotFound) {
+		t.Fatalf("expected the user to be gone, got %v", err)
+	}
+	if _, err := managerClient.Get(ctx, spawned.Browser.ID); err == nil {
+		t.Fatalf("expected the running browser to be closed")
+	}
+	if _, found, err := store.GetApplicationByID(ctx, personalApp.ID); err != nil || found {
+		t.Fatalf("expected the personal application to be deleted, found=%v err=%v", found, err)
+	}
+	if _, err := appsService.AuthenticateAPIKey(ctx, personalKey); err == nil {
+		t.Fatalf("expected the personal application's API key to stop working")
+	}
+
+	handedOver, found, err := store.GetApplicationByID(ctx, teamApp.ID)
+	if err != nil || !found {
+		t.Fatalf("expected the team application to stay, found=%v err=%v", found, err)
+	}
+	if handedOver.OwnerUserID != teammate.ID {
+		t.Fatalf("expected the team application to be handed to the teammate, owner is %q", handedOver.OwnerUserID)
+	}
+	if _, err := appsService.AuthenticateAPIKey(ctx, teamKey); err != nil {
+		t.Fatalf("expected the team application's API key to keep working, got %v", err)
+	}
+}
+
+func registerApplication(t *testing.T, appsService *applications.Service, actor users.User, organizationID string) (applications.Application, string) {
+	t.Helper()
+
+	ctx := context.Background()
+	application, err := appsService.RegisterApplication(ctx, actor, applications.RegisterApplicationInput{
+		Name:           "Runner",
+		GitHubLink:     "https://github.com/example-org",
+		Domain:         "example.com",
+		OrganizationID: organizationID,
+	})
+	if err != nil {
+		t.Fatalf("register application: %v", err)
+	}
+	createdKey, err := appsService.CreateAPIKey(ctx, actor, application.ID, applications.CreateAPIKeyInput{
+		Name:   "Primary",
+		Scopes: authorization.AllScopes,
+	})
+	if err != nil {
+		t.Fatalf("create API key: %v", err)
+	}
+
+	return application, createdKey.Token
+}
@@ -0,0 +1,402 @@
+// Package admin is the site admin console: managing user accounts and looking after every
+// application and running browser. Every action is checked against the admin policies of
+// authorization.WebAuthorizer and recorded in the audit trail.
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/applications"
+	"github.com/brian-nunez/bbaas-api/internal/audit"
+	"github.com/brian-nunez/bbaas-api/internal/authorization"
+	"github.com/brian-nunez/bbaas-api/internal/browsers"
+	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/users"
+)
+
+const (
+	UsersPageSize        = 25
+	ApplicationsPageSize = 25
+	// RunningBrowsersLimit caps the running browser listing.
+	RunningBrowsersLimit = 200
+	// RecentActivityLimit is how many audit events the console shows.
+	RecentActivityLimit = 20
+)
+
+var (
+	ErrForbidden  = errors.New("forbidden")
+	ErrSelfAction = errors.New("you cannot change your own role or disable your own account")
+)
+
+// Pagination describes one page of a longer listing. Page starts at 1.
+type Pagination struct {
+	Page     int
+	PageSize int
+	Total    int
+}
+
+func (p Pagination) HasPrevious() bool {
+	return p.Page > 1
+}
+
+func (p Pagination) HasNext() bool {
+	return p.Page*p.PageSize < p.Total
+}
+
+type UserPage struct {
+	Pagination
+	Query string
+	Users []users.User
+}
+
+type Application struct {
+	ID               string
+	Name             string
+	OrganizationID   string
+	OrganizationName string
+	CreatorEmail     string
+	CreatedAt        time.Time
+	// ArchivedAt is set while the application is archived.
+	ArchivedAt      *time.Time
+	ActiveAPIKeys   int
+	RunningBrowsers int
+	// PendingTransfer is set while the application is offered to another user.
+	PendingTransfer *applications.Transfer
+}
+
+type ApplicationPage struct {
+	Pagination
+	Applications []Application
+}
+
+type RunningBrowser struct {
+	ApplicationID    string
+	ApplicationName  string
+	OrganizationName string
+	BrowserID        string
+	Headless         bool
+	CreatedAt        time.Time
+	LastActiveAt     time.Time
+	ExpiresAt        time.Time
+}
+
+type Service struct {
+	store         *data.Store
+	users         *users.Service
+	applications  *applications.Service
+	browsers      *browsers.Service
+	webAuthorizer *authorization.WebAuthorizer
+	audit         *audit.Recorder
+	now           func() time.Time
+}
+
+func NewService(store *data.Store, usersService *users.Service, applicationsService *applications.Service, browserService *browsers.Service, webAuthorizer *authorization.WebAuthorizer, recorder *audit.Recorder) *Service {
+	return &Service{
+		store:         store,
+		users:         usersService,
+		applications:  applicationsService,
+		browsers:      browserService,
+		webAuthorizer: webAuthorizer,
+		audit:         recorder,
+		now:           time.Now,
+	}
+}
+
+func (s *Service) SearchUsers(ctx context.Context, actor users.User, query string, page int) (UserPage, error) {
+	if err := s.authorize(actor, "admin.users.read"); err != nil {
+		return UserPage{}, err
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	matches, total, err := s.users.SearchUsers(ctx, query, page, UsersPageSize)
+	if err != nil {
+		return UserPage{}, err
+	}
+
+	return UserPage{
+		Pagination: Pagination{Page: page, PageSize: UsersPageSize, Total: total},
+		Query:      query,
+		Users:      matches,
+	}, nil
+}
+
+func (s *Service) UpdateUserRole(ctx context.Context, actor users.User, userID string, role string) error {
+	if err := s.authorize(actor, "admin.users.update_role"); err != nil {
+		return err
+	}
+
+	target, err := s.getOtherUser(ctx, actor, userID)
+	if err != nil {
+		return err
+	}
+	if target.Role == role {
+		return nil
+	}
+
+	if err := s.users.UpdateRole(ctx, target.ID, role); err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, audit.Event{
+		ActorUserID: actor.ID,
+		Action:      "user.role_changed",
+		TargetType:  "user",
+		TargetID:    target.ID,
+		Changes:     map[string]audit.Change{"role": {From: target.Role, To: role}},
+	})
+
+	return nil
+}
+
+// DisableUser blocks the user's logins, ends their sessions and stops the API keys of
+// applications in organizations left without an enabled member.
+func (s *Service) DisableUser(ctx context.Context, actor users.User, userID string) error {
+	if err := s.authorize(actor, "admin.users.disable"); err != nil {
+		return err
+	}
+
+	target, err := s.getOtherUser(ctx, actor, userID)
+	if err != nil {
+		return err
+	}
+
+	disabled, err := s.users.Disable(ctx, target.ID)
+	if err != nil {
+		return err
+	}
+	if !disabled {
+		return nil
+	}
+	if err := s.applications.InvalidateAuthCache(ctx); err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, audit.Event{
+		ActorUserID: actor.ID,
+		Action:      "user.disabled",
+		TargetType:  "user",
+		TargetID:    target.ID,
+		Changes:     map[string]audit.Change{"disabled": {From: false, To: true}},
+	})
+
+	return nil
+}
+
+func (s *Service) EnableUser(ctx context.Context, actor users.User, userID string) error {
+	if err := s.authorize(actor, "admin.users.disable"); err != nil {
+		return err
+	}
+
+	target, err := s.getOtherUser(ctx, actor, userID)
+	if err != nil {
+		return err
+	}
+
+	enabled, err := s.users.Enable(ctx, target.ID)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return nil
+	}
+	if err := s.applications.InvalidateAuthCache(ctx); err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, audit.Event{
+		ActorUserID: actor.ID,
+		Action:      "user.enabled",
+		TargetType:  "user",
+		TargetID:    target.ID,
+		Changes:     map[string]audit.Change{"disabled": {From: true, To: false}},
+	})
+
+	return nil
+}
+
+// LogoutUser ends every session of the user and returns how many there were.
+func (s *Service) LogoutUser(ctx context.Context, actor users.User, userID string) (int, error) {
+	if err := s.authorize(actor, "admin.users.logout"); err != nil {
+		return 0, err
+	}
+
+	target, err := s.users.GetUser(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	revoked, err := s.users.RevokeAllSessions(ctx, target)
+	if err != nil {
+		return 0, err
+	}
+
+	s.audit.Record(ctx, audit.Event{
+		ActorUserID: actor.ID,
+		Action:      "user.sessions_revoked",
+		TargetType:  "user",
+		TargetID:    target.ID,
+		Changes:     map[string]audit.Change{"sessions": {From: revoked, To: 0}},
+	})
+
+	return revoked, nil
+}
+
+func (s *Service) ListApplications(ctx context.Context, actor users.User, page int) (ApplicationPage, error) {
+	if err := s.authorize(actor, "admin.applications.read"); err != nil {
+		return ApplicationPage{}, err
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	records, total, err := s.store.ListAllApplications(ctx, ApplicationsPageSize, (page-1)*ApplicationsPageSize)
+	if err != nil {
+		return ApplicationPage{}, fmt.Errorf("list all applications: %w", err)
+	}
+
+	applicationIDs := make([]string, 0, len(records))
+	for _, record := range records {
+		applicationIDs = append(applicationIDs, record.Application.ID)
+	}
+	transfers, err := s.store.ListApplicationTransfersByApplicationIDs(ctx, applicationIDs, s.now().UTC())
+	if err != nil {
+		return ApplicationPage{}, fmt.Errorf("list application transfers: %w", err)
+	}
+	transferByApplicationID := make(map[string]applications.Transfer, len(transfers))
+	for _, transfer := range transfers {
+		transferByApplicationID[transfer.ApplicationID] = applications.TransferFromRecord(transfer)
+	}
+
+	applicationsPage := ApplicationPage{
+		Pagination:   Pagination{Page: page, PageSize: ApplicationsPageSize, Total: total},
+		Applications: make([]Application, 0, len(records)),
+	}
+	for _, record := range records {
+		application := Application{
+			ID:               record.Application.ID,
+			Name:             record.Application.Name,
+			OrganizationID:   record.Application.OrganizationID,
+			OrganizationName: record.OrganizationName,
+			CreatorEmail:     record.CreatorEmail,
+			CreatedAt:        record.Application.CreatedAt,
+			ArchivedAt:       record.Application.ArchivedAt,
+			ActiveAPIKeys:    record.ActiveAPIKeys,
+			RunningBrowsers:  record.RunningBrowsers,
+		}
+		if transfer, found := transferByApplicationID[record.Application.ID]; found {
+			application.PendingTransfer = &transfer
+		}
+		applicationsPage.Applications = append(applicationsPage.Applications, application)
+	}
+
+	return applicationsPage, nil
+}
+
+// TransferApplication offers any application to the user registered with recipientEmail, for
+// example when its owners have left. The recipient still has to accept it.
+func (s *Service) TransferApplication(ctx context.Context, actor users.User, applicationID string, recipientEmail string) (applications.Transfer, error) {
+	if err := s.authorize(actor, "admin.applications.transfer"); err != nil {
+		return applications.Transfer{}, err
+	}
+
+	return s.applications.StartTransfer(ctx, actor, applicationID, recipientEmail)
+}
+
+func (s *Service) CancelTransfer(ctx context.Context, actor users.User, transferID string) error {
+	if err := s.authorize(actor, "admin.applications.transfer"); err != nil {
+		return err
+	}
+
+	return s.applications.CancelTransfer(ctx, actor, transferID)
+}
+
+func (s *Service) ListRunningBrowsers(ctx context.Context, actor users.User) ([]RunningBrowser, error) {
+	if err := s.authorize(actor, "admin.browsers.read"); err != nil {
+		return nil, err
+	}
+
+	records, err := s.store.ListRunningBrowserSessions(ctx, RunningBrowsersLimit)
+	if err != nil {
+		return nil, fmt.Errorf("list running browsers: %w", err)
+	}
+
+	runningBrowsers := make([]RunningBrowser, 0, len(records))
+	for _, record := range records {
+		runningBrowsers = append(runningBrowsers, RunningBrowser{
+			ApplicationID:    record.Session.ApplicationID,
+			ApplicationName:  record.ApplicationName,
+			OrganizationName: record.OrganizationName,
+			BrowserID:        record.Session.ExternalBrowserID,
+			Headless:         record.Session.Headless,
+			CreatedAt:        record.Session.CreatedAt,
+			LastActiveAt:     record.Session.LastActiveAt,
+			ExpiresAt:        record.Session.ExpiresAt,
+		})
+	}
+
+	return runningBrowsers, nil
+}
+
+func (s *Service) CloseBrowser(ctx context.Context, actor users.User, applicationID string, browserID string) error {
+	if err := s.authorize(actor, "admin.browsers.close"); err != nil {
+		return err
+	}
+
+	if err := s.browsers.ForceClose(ctx, applicationID, browserID); err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, audit.Event{
+		ActorUserID: actor.ID,
+		Action:      "browser.force_closed",
+		TargetType:  "browser",
+		TargetID:    browserID,
+		Changes: map[string]audit.Change{
+			"application_id": {From: applicationID, To: applicationID},
+			"status":         {From: "RUNNING", To: "COMPLETED"},
+		},
+	})
+
+	return nil
+}
+
+// RecentActivity returns the latest audit events.
+func (s *Service) RecentActivity(ctx context.Context, actor users.User) ([]audit.Entry, error) {
+	if err := s.authorize(actor, "admin.users.read"); err != nil {
+		return nil, err
+	}
+
+	return s.audit.Recent(ctx, RecentActivityLimit)
+}
+
+// getOtherUser looks up the target of an action admins may not take on themselves.
+func (s *Service) getOtherUser(ctx context.Context, actor users.User, userID string) (users.User, error) {
+	target, err := s.users.GetUser(ctx, userID)
+	if err != nil {
+		return users.User{}, err
+	}
+	if target.ID == actor.ID {
+		return users.User{}, ErrSelfAction
+	}
+
+	return target, nil
+}
+
+func (s *Service) authorize(actor users.User, action string) error {
+	roles := []string{"user"}
+	if actor.IsAdmin() {
+		roles = append(roles, "admin")
+	}
+
+	subject := authorization.WebSubject{UserID: actor.ID, Roles: roles}
+	if !s.webAuthorizer.Can(subject, authorization.OrganizationResource{}, action) {
+		return ErrForbidden
+	}
+
+	return nil
+}
@@ -0,0 +1,264 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/brian-nunez/bbaas-api/internal/applications"
+	"github.com/brian-nunez/bbaas-api/internal/audit"
+	"github.com/brian-nunez/bbaas-api/internal/authorization"
+	"github.com/brian-nunez/bbaas-api/internal/browsers"
+	"github.com/brian-nunez/bbaas-api/internal/data/datatest"
+	"github.com/brian-nunez/bbaas-api/internal/organizations"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+	"github.com/brian-nunez/bbaas-api/internal/users"
+)
+
+func TestDisableUserBlocksLoginAndAPIKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	usersService := users.NewService(store, security.NewTokenHasher("test-pepper"), users.SessionPolicy{})
+	appsService := applications.NewService(store, authorization.NewWebAuthorizer(), security.NewTokenHasher("test-pepper"))
+	browserService := browsers.NewService(browsers.NewMemoryManagerClient(), store, authorization.NewAPIAuthorizer(), "")
+	service := NewService(store, usersService, appsService, browserService, authorization.NewWebAuthorizer(), audit.NewRecorder(store))
+
+	root, err := usersService.Register(ctx, "root@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register admin: %v", err)
+	}
+	builder, err := usersService.Register(ctx, "builder@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+
+	application, err := appsService.RegisterApplication(ctx, builder, applications.RegisterApplicationInput{
+		Name:       "Runner",
+		GitHubLink: "https://github.com/example-org",
+		Domain:     "example.com",
+	})
+	if err != nil {
+		t.Fatalf("register application: %v", err)
+	}
+	createdKey, err := appsService.CreateAPIKey(ctx, builder, application.ID, applications.CreateAPIKeyInput{
+		Name:   "Primary",
+		Scopes: authorization.AllScopes,
+	})
+	if err != nil {
+		t.Fatalf("create API key: %v", err)
+	}
+	organizationsService := organizations.NewService(store, authorization.NewWebAuthorizer())
+	team, err := organizationsService.Create(ctx, builder, "Team")
+	if err != nil {
+		t.Fatalf("create organization: %v", err)
+	}
+	if _, err := organizationsService.AddMember(ctx, builder, team.ID, root.Email, "developer"); err != nil {
+		t.Fatalf("add organization member: %v", err)
+	}
+	teamApplication, err := appsService.RegisterApplication(ctx, builder, applications.RegisterApplicationInput{
+		OrganizationID: team.ID,
+		Name:           "Shared runner",
+		GitHubLink:     "https://github.com/example-org",
+		Domain:         "example.com",
+	})
+	if err != nil {
+		t.Fatalf("register team application: %v", err)
+	}
+	teamKey, err := appsService.CreateAPIKey(ctx, builder, teamApplication.ID, applications.CreateAPIKeyInput{
+		Name:   "Shared",
+		Scopes: authorization.AllScopes,
+	})
+	if err != nil {
+		t.Fatalf("create team API key: %v", err)
+	}
+	if _, _, err := usersService.Login(ctx, builder.Email, "password123", users.Client{}); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	if err := service.DisableUser(ctx, builder, root.ID); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected non-admins to be forbidden, got %v", err)
+	}
+	if err := service.DisableUser(ctx, root, root.ID); !errors.Is(err, ErrSelfAction) {
+		t.Fatalf("expected ErrSelfAction, got %v", err)
+	}
+
+	if err := service.DisableUser(ctx, root, builder.ID); err != nil {
+		t.Fatalf("disable user: %v", err)
+	}
+	if _, _, err := usersService.Login(ctx, builder.Email, "password123", users.Client{}); !errors.Is(err, users.ErrAccountDisabled) {
+		t.Fatalf("expected ErrAccountDisabled, got %v", err)
+	}
+	if _, err := appsService.AuthenticateAPIKey(ctx, createdKey.Token); err == nil {
+		t.Fatalf("expected the disabled user's API key to stop working")
+	}
+	if _, err := appsService.AuthenticateAPIKey(ctx, teamKey.Token); err != nil {
+		t.Fatalf("expected keys of applications shared with enabled members to keep working, got %v", err)
+	}
+	sessions, err := usersService.ListSessions(ctx, builder, "")
+	if err != nil {
+		t.Fatalf("list sessions: %v", err)
+	}
+	if len(sessions) != 0 {
+		t.Fatalf("expected disabling to end every session, got %d", len(sessions))
+	}
+
+	if err := service.EnableUser(ctx, root, builder.ID); err != nil {
+		t.Fatalf("enable user: %v", err)
+	}
+	if _, _, err := usersService.Login(ctx, builder.Email, "password123", users.Client{}); err != nil {
+		t.Fatalf("expected login to work again, got %v", err)
+	}
+	if _, err := appsService.AuthenticateAPIKey(ctx, createdKey.Token); err != nil {
+		t.Fatalf("expected the API key to work again, got %v", err)
+	}
+
+	activity, err := service.RecentActivity(ctx, root)
+	if err != nil {
+		t.Fatalf("recent activity: %v", err)
+	}
+	if len(activity) != 2 || activity[0].Action != "user.enabled" || activity[1].Action != "user.disabled" {
+		t.Fatalf("unexpected audit trail %+v", activity)
+	}
+	if activity[1].ActorEmail != root.Email || activity[1].TargetID != builder.ID || activity[1].Changes["disabled"].To != true {
+		t.Fatalf("unexpected audit entry %+v", activity[1])
+	}
+}
+
+func TestUpdateRoleSearchAndForceClose(t *testing.T) {
+	t.Parallel()
+
+	ctx := audit.WithRequest(context.Background(), audit.Request{IP: "203.0.113.9", UserAgent: "test", RequestID: "req-1"})
+	store := datatest.NewStore(t)
+	usersService := users.NewService(store, security.NewTokenHasher("test-pepper"), users.SessionPolicy{})
+	appsService := applications.NewService(store, authorization.NewWebAuthorizer(), security.NewTokenHasher("test-pepper"))
+	browserService := browsers.NewService(browsers.NewMemoryManagerClient(), store, authorization.NewAPIAuthorizer(), "")
+	service := NewService(store, usersService, appsService, browserService, authorization.NewWebAuthorizer(), audit.NewRecorder(store))
+
+	root, err := usersService.Register(ctx, "root@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register admin: %v", err)
+	}
+	for i := range UsersPageSize + 1 {
+		if _, err := usersService.Register(ctx, fmt.Sprintf("user%02d@example.com", i), "password123"); err != nil {
+			t.Fatalf("register user: %v", err)
+		}
+	}
+
+	page, err := service.SearchUsers(ctx, root, "user", 1)
+	if err != nil {
+		t.Fatalf("search users: %v", err)
+	}
+	if page.Total != UsersPageSize+1 || len(page.Users) != UsersPageSize || !page.HasNext() || page.HasPrevious() {
+		t.Fatalf("unexpected first page total=%d users=%d", page.Total, len(page.Users))
+	}
+	page, err = service.SearchUsers(ctx, root, "user", 2)
+	if err != nil {
+		t.Fatalf("search users: %v", err)
+	}
+	if len(page.Users) != 1 || page.HasNext() {
+		t.Fatalf("unexpected second page %+v", page.Users)
+	}
+	promoted := page.Users[0]
+
+	if err := service.UpdateUserRole(ctx, root, promoted.ID, "owner"); !errors.Is(err, users.ErrInvalidRole) {
+		t.Fatalf("expected ErrInvalidRole, got %v", err)
+	}
+	if err := service.UpdateUserRole(ctx, root, root.ID, "user"); !errors.Is(err, ErrSelfAction) {
+		t.Fatalf("expected ErrSelfAction, got %v", err)
+	}
+	if err := service.UpdateUserRole(ctx, root, promoted.ID, "admin"); err != nil {
+		t.Fatalf("update role: %v", err)
+	}
+	promoted, err = usersService.GetUser(ctx, promoted.ID)
+	if err != nil {
+		t.Fatalf("reload user: %v", err)
+	}
+	if !promoted.IsAdmin() {
+		t.Fatalf("expected the user to be promoted")
+	}
+
+	application, err := appsService.RegisterApplication(ctx, promoted, applications.RegisterApplicationInput{
+		Name:       "Runner",
+		GitHubLink: "https://github.com/example-org",
+		Domain:     "example.com",
+	})
+	if err != nil {
+		t.Fatalf("register application: %v", err)
+	}
+	createdKey, err := appsService.CreateAPIKey(ctx, promoted, application.ID, applications.CreateAPIKeyInput{
+		Name:   "Primary",
+		Scopes: authorization.AllScopes,
+	})
+	if err != nil {
+		t.Fatalf("create API key: %v", err)
+	}
+	principal, err := appsService.AuthenticateAPIKey(ctx, createdKey.Token)
+	if err != nil {
+		t.Fatalf("authenticate API key: %v", err)
+	}
+	spawned, err := browserService.SpawnForAPIKey(ctx, principal, browsers.SpawnRequest{})
+	if err != nil {
+		t.Fatalf("spawn browser: %v", err)
+	}
+
+	allApplications, err := service.ListApplications(ctx, promoted, 1)
+	if err != nil {
+		t.Fatalf("list applications: %v", err)
+	}
+	if allApplications.Total != 1 || allApplications.Applications[0].RunningBrowsers != 1 || allApplications.Applications[0].ActiveAPIKeys != 1 {
+		t.Fatalf("unexpected applications %+v", allApplications)
+	}
+	if allApplications.Applications[0].PendingTransfer != nil {
+		t.Fatalf("expected no pending transfer yet, got %+v", allApplications.Applications[0].PendingTransfer)
+	}
+	transfer, err := service.TransferApplication(ctx, root, application.ID, root.Email)
+	if err != nil {
+		t.Fatalf("transfer application: %v", err)
+	}
+	allApplications, err = service.ListApplications(ctx, root, 1)
+	if err != nil {
+		t.Fatalf("list applications: %v", err)
+	}
+	if pending := allApplications.Applications[0].PendingTransfer; pending == nil || pending.ID != transfer.ID || pending.RecipientEmail != root.Email {
+		t.Fatalf("expected the pending transfer to be listed, got %+v", pending)
+	}
+
+	running, err := service.ListRunningBrowsers(ctx, root)
+	if err != nil {
+		t.Fatalf("list running browsers: %v", err)
+	}
+	if len(running) != 1 || running[0].BrowserID != spawned.Browser.ID || running[0].ApplicationName != "Runner" {
+		t.Fatalf("unexpected running browsers %+v", running)
+	}
+	if err := service.CloseBrowser(ctx, root, application.ID, spawned.Browser.ID); err != nil {
+		t.Fatalf("force close browser: %v", err)
+	}
+	if err := service.CloseBrowser(ctx, root, application.ID, spawned.Browser.ID); !errors.Is(err, browsers.ErrBrowserNotFound) {
+		t.Fatalf("expected ErrBrowserNotFound, got %v", err)
+	}
+	running, err = service.ListRunningBrowsers(ctx, root)
+	if err != nil {
+		t.Fatalf("list running browsers: %v", err)
+	}
+	if len(running) != 0 {
+		t.Fatalf("expected no running browsers, got %+v", running)
+	}
+
+	activity, err := service.RecentActivity(ctx, root)
+	if err != nil {
+		t.Fatalf("recent activity: %v", err)
+	}
+	if len(activity) != 2 || activity[0].Action != "browser.force_closed" || activity[1].Action != "user.role_changed" {
+		t.Fatalf("unexpected audit trail %+v", activity)
+	}
+	roleChange := activity[1]
+	if roleChange.Changes["role"].From != "user" || roleChange.Changes["role"].To != "admin" {
+		t.Fatalf("unexpected role change %+v", roleChange.Changes)
+	}
+	if roleChange.IP != "203.0.113.9" || roleChange.RequestID != "req-1" {
+		t.Fatalf("expected the request to be recorded, got %+v", roleChange)
+	}
+}
@@ -0,0 +1,285 @@
+package applications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/audit"
+	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+	"github.com/brian-nunez/bbaas-api/internal/users"
+)
+
+const maxAllowedCIDRs = 50
+
+var (
+	ErrIPNotAllowed = errors.New("client IP address is not allowed to use this API key")
+	ErrInvalidCIDR  = errors.New("invalid IP range")
+	ErrTooManyCIDRs = fmt.Errorf("at most %d IP ranges can be configured", maxAllowedCIDRs)
+)
+
+// IPAllowlist holds the ranges an API key may be used from. A client must match the
+// application's ranges when any are set, and the key's ranges when any are set.
+type IPAllowlist struct {
+	Application []netip.Prefix
+	Key         []netip.Prefix
+}
+
+func (l IPAllowlist) IsEmpty() bool {
+	return len(l.Application) == 0 && len(l.Key) == 0
+}
+
+func (l IPAllowlist) Allows(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return prefixesAllow(l.Application, addr) && prefixesAllow(l.Key, addr)
+}
+
+const (
+	// IPDenialBucket is the window denied requests of one key from one IP are counted in.
+	IPDenialBucket = time.Hour
+	// IPDenialRetention is how long denied requests stay listed after the last one.
+	IPDenialRetention = 30 * 24 * time.Hour
+)
+
+// IPDenial counts the requests of a key that were denied from one source IP within an
+// IPDenialBucket starting at CreatedAt.
+type IPDenial struct {
+	ID           string
+	APIKeyID     string
+	SourceIP     string
+	Attempts     int
+	LastDeniedAt time.Time
+	CreatedAt    time.Time
+}
+
+// ParseCIDRList parses a comma, space or newline separated list of CIDR ranges. Bare IP
+// addresses are accepted as single-host ranges. The result is in canonical form.
+func ParseCIDRList(raw string) ([]string, error) {
+	fields := strings.FieldsFunc(raw, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\r' || r == '\t'
+	})
+	if len(fields) > maxAllowedCIDRs {
+		return nil, ErrTooManyCIDRs
+	}
+
+	cidrs := make([]string, 0, len(fields))
+	seen := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		prefix, err := parsePrefix(field)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidCIDR, field)
+		}
+
+		cidr := prefix.String()
+		if seen[cidr] {
+			continue
+		}
+		seen[cidr] = true
+		cidrs = append(cidrs, cidr)
+	}
+
+	return cidrs, nil
+}
+
+// UpdateApplicationAllowedCIDRs replaces the IP ranges every key of the application is
+// restricted to. An empty list removes the restriction.
+func (s *Service) UpdateApplicationAllowedCIDRs(ctx context.Context, actor users.User, applicationID string, rawCIDRs string) (Application, error) {
+	applicationRecord, err := s.getAuthorizedApplication(ctx, actor, applicationID, "applications.update")
+	if err != nil {
+		return Application{}, err
+	}
+	if applicationRecord.ID == "" {
+		return Application{}, ErrApplicationNotFound
+	}
+
+	cidrs, err := ParseCIDRList(rawCIDRs)
+	if err != nil {
+		return Application{}, err
+	}
+
+	now := s.now().UTC()
+	if err := s.store.UpdateApplicationAllowedCIDRs(ctx, applicationRecord.ID, cidrs, now); err != nil {
+		return Application{}, fmt.Errorf("update application allowed CIDRs: %w", err)
+	}
+	if err := s.invalidateAuthCache(ctx); err != nil {
+		return Application{}, err
+	}
+
+	changes := map[string]audit.Change{"allowed_cidrs": {From: applicationRecord.AllowedCIDRs, To: cidrs}}
+	applicationRecord.AllowedCIDRs = cidrs
+	applicationRecord.UpdatedAt = now
+	s.recordEvent(ctx, actor.ID, applicationRecord, "application.allowed_ips_updated", "", changes)
+
+	return mapApplicationRecord(applicationRecord), nil
+}
+
+// UpdateAPIKeyAllowedCIDRs replaces the IP ranges a single key is restricted to. An empty
+// list removes the restriction.
+func (s *Service) UpdateAPIKeyAllowedCIDRs(ctx context.Context, actor users.User, applicationID string, keyID string, rawCIDRs string) ([]string, error) {
+	applicationRecord, err := s.getAuthorizedApplication(ctx, actor, applicationID, "api_keys.update")
+	if err != nil {
+		return nil, err
+	}
+	if applicationRecord.ID == "" {
+		return nil, ErrApplicationNotFound
+	}
+
+	cidrs, err := ParseCIDRList(rawCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	keyRecord, found, err := s.store.GetAPIKeyByID(ctx, applicationRecord.ID, strings.TrimSpace(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("lookup API key: %w", err)
+	}
+	if !found {
+		return nil, ErrAPIKeyNotFound
+	}
+
+	updated, err := s.store.UpdateAPIKeyAllowedCIDRs(ctx, applicationRecord.ID, keyRecord.ID, cidrs)
+	if err != nil {
+		return nil, fmt.Errorf("update API key allowed CIDRs: %w", err)
+	}
+	if !updated {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err := s.invalidateAuthCache(ctx); err != nil {
+		return nil, err
+	}
+
+	s.recordEvent(ctx, actor.ID, applicationRecord, "api_key.allowed_ips_updated", keyRecord.ID, map[string]audit.Change{
+		"allowed_cidrs": {From: keyRecord.AllowedCIDRs, To: cidrs},
+	})
+
+	return cidrs, nil
+}
+
+// AuthorizeClientIP checks clientIP against the principal's allowlists. Denied attempts are
+// counted per key, source IP and IPDenialBucket, and reported as ErrIPNotAllowed.
+func (s *Service) AuthorizeClientIP(ctx context.Context, principal APIKeyPrincipal, clientIP string) error {
+	if principal.IPAllowlist.IsEmpty() {
+		return nil
+	}
+
+	addr, err := netip.ParseAddr(strings.TrimSpace(clientIP))
+	if err == nil && principal.IPAllowlist.Allows(addr) {
+		return nil
+	}
+
+	denialID, err := security.GeneratePrefixedToken("ipd", 14)
+	if err != nil {
+		return fmt.Errorf("generate IP denial id: %w", err)
+	}
+	now := s.now().UTC()
+	if err := s.store.RecordAPIKeyIPDenial(ctx, data.APIKeyIPDenialRecord{
+		ID:            denialID,
+		APIKeyID:      principal.KeyID,
+		ApplicationID: principal.ApplicationID,
+		SourceIP:      clientIP,
+		LastDeniedAt:  now,
+		CreatedAt:     now.Truncate(IPDenialBucket),
+	}); err != nil {
+		return fmt.Errorf("record IP denial: %w", err)
+	}
+
+	return ErrIPNotAllowed
+}
+
+func (s *Service) ListIPDenialsForApplication(ctx context.Context, actor users.User, applicationID string, limit int) ([]IPDenial, error) {
+	applicationRecord, err := s.getAuthorizedApplication(ctx, actor, applicationID, "applications.read")
+	if err != nil {
+		return nil, err
+	}
+	if applicationRecord.ID == "" {
+		return nil, ErrApplicationNotFound
+	}
+
+	records, err := s.store.ListAPIKeyIPDenialsByApplicationID(ctx, applicationRecord.ID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list IP denials: %w", err)
+	}
+
+	denials := make([]IPDenial, 0, len(records))
+	for _, record := range records {
+		denials = append(denials, IPDenial{
+			ID:           record.ID,
+			APIKeyID:     record.APIKeyID,
+			SourceIP:     record.SourceIP,
+			Attempts:     record.AttemptCount,
+			LastDeniedAt: record.LastDeniedAt,
+			CreatedAt:    record.CreatedAt,
+		})
+	}
+
+	return denials, nil
+}
+
+// DeleteOldIPDenials removes denials last seen more than IPDenialRetention ago.
+func (s *Service) DeleteOldIPDenials(ctx context.Context) (int, error) {
+	deleted, err := s.store.DeleteAPIKeyIPDenialsBefore(ctx, s.now().UTC().Add(-IPDenialRetention))
+	if err != nil {
+		return 0, fmt.Errorf("delete old IP denials: %w", err)
+	}
+
+	return deleted, nil
+}
+
+func newIPAllowlist(application data.ApplicationRecord, key data.APIKeyRecord) IPAllowlist {
+	return IPAllowlist{
+		Application: mustParsePrefixes(application.AllowedCIDRs),
+		Key:         mustParsePrefixes(key.AllowedCIDRs),
+	}
+}
+
+// mustParsePrefixes parses stored ranges, which were validated on write. An unparsable
+// entry is kept as an empty prefix so that it matches nothing instead of lifting the
+// restriction.
+func mustParsePrefixes(cidrs []string) []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := parsePrefix(cidr)
+		if err != nil {
+			prefix = netip.Prefix{}
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	return prefixes
+}
+
+func parsePrefix(value string) (netip.Prefix, error) {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func prefixesAllow(prefixes []netip.Prefix, addr netip.Addr) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+
+	for _, prefix := range prefixes {
+		if prefix.IsValid() && prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,140 @@
+package applications
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/data"
+)
+
+const (
+	DefaultAuthCacheSize = 10000
+	// AuthCacheTTL bounds how long a cached key is trusted if a revocation is somehow missed.
+	AuthCacheTTL = 30 * time.Second
+	// AuthCacheNegativeTTL is kept short so a freshly created key is never shadowed for long.
+	AuthCacheNegativeTTL = 5 * time.Second
+	// AuthCacheSyncInterval is how often replicas poll the revocation version.
+	AuthCacheSyncInterval = time.Second
+)
+
+type authCacheEntry struct {
+	keyHash   string
+	auth      data.APIKeyAuthRecord
+	found     bool
+	expiresAt time.Time
+}
+
+// authCache is a bounded LRU of API key lookups keyed by token hash. Unknown hashes are
+// cached too, so repeated requests with a bad key do not reach the database.
+type authCache struct {
+	capacity    int
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	// generation changes on every clear, so a lookup that raced with a revocation cannot
+	// store its now stale result.
+	generation uint64
+	version    int64
+}
+
+func newAuthCache(capacity int, ttl time.Duration, negativeTTL time.Duration) *authCache {
+	return &authCache{
+		capacity:    capacity,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		entries:     make(map[string]*list.Element),
+		order:       list.New(),
+	}
+}
+
+// get returns the cached lookup for keyHash and the generation to pass to put on a miss.
+func (c *authCache) get(keyHash string, now time.Time) (authCacheEntry, bool, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, found := c.entries[keyHash]
+	if !found {
+		return authCacheEntry{}, false, c.generation
+	}
+
+	entry := element.Value.(authCacheEntry)
+	if !now.Before(entry.expiresAt) {
+		c.order.Remove(element)
+		delete(c.entries, keyHash)
+		return authCacheEntry{}, false, c.generation
+	}
+
+	c.order.MoveToFront(element)
+	return entry, true, c.generation
+}
+
+func (c *authCache) put(keyHash string, auth data.APIKeyAuthRecord, found bool, now time.Time, generation uint64) {
+	ttl := c.ttl
+	if !found {
+		ttl = c.negativeTTL
+	}
+	entry := authCacheEntry{keyHash: keyHash, auth: auth, found: found, expiresAt: now.Add(ttl)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	if element, exists := c.entries[keyHash]; exists {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[keyHash] = c.order.PushFront(entry)
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(authCacheEntry).keyHash)
+	}
+}
+
+func (c *authCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+// sync clears the cache when version differs from the last one seen and reports whether it did.
+func (c *authCache) sync(version int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if version == c.version {
+		return false
+	}
+
+	c.version = version
+	c.clearLocked()
+	return true
+}
+
+// invalidate clears the cache after a local change. version is the revocation version the
+// change produced, or zero when it could not be recorded.
+func (c *authCache) invalidate(version int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if version > c.version {
+		c.version = version
+	}
+	c.clearLocked()
+}
+
+func (c *authCache) clearLocked() {
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+	c.generation++
+}
@@ -0,0 +1,160 @@
+package applications
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/authorization"
+	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/data/datatest"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+)
+
+func TestAuthCacheInvalidatesAcrossReplicas(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	replicaA := NewService(store, authorization.NewWebAuthorizer(), security.NewTokenHasher("test-pepper"))
+	replicaB := NewService(store, authorization.NewWebAuthorizer(), security.NewTokenHasher("test-pepper"))
+	user, application := registerApplication(t, store, replicaA, "cache@example.com")
+
+	created, err := replicaA.CreateAPIKey(ctx, user, application.ID, CreateAPIKeyInput{
+		Name:   "Cached",
+		Scopes: []string{authorization.ScopeBrowsersRead},
+	})
+	if err != nil {
+		t.Fatalf("create API key: %v", err)
+	}
+
+	for _, replica := range []*Service{replicaA, replicaB} {
+		if _, err := replica.AuthenticateAPIKey(ctx, created.Token); err != nil {
+			t.Fatalf("authenticate API key: %v", err)
+		}
+	}
+
+	if err := replicaA.RevokeAPIKey(ctx, user, application.ID, created.APIKey.ID); err != nil {
+		t.Fatalf("revoke API key: %v", err)
+	}
+	if _, err := replicaA.AuthenticateAPIKey(ctx, created.Token); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Fatalf("expected the revoking replica to reject the key immediately, got %v", err)
+	}
+	if _, err := replicaB.AuthenticateAPIKey(ctx, created.Token); err != nil {
+		t.Fatalf("expected the other replica to serve its cached lookup until it syncs, got %v", err)
+	}
+
+	if err := replicaB.SyncAuthCache(ctx); err != nil {
+		t.Fatalf("sync auth cache: %v", err)
+	}
+	if _, err := replicaB.AuthenticateAPIKey(ctx, created.Token); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Fatalf("expected the other replica to reject the key after syncing, got %v", err)
+	}
+}
+
+func TestAuthCacheExpiresEntries(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	appsService := NewService(store, authorization.NewWebAuthorizer(), security.NewTokenHasher("test-pepper"))
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	appsService.now = func() time.Time { return now }
+	user, application := registerApplication(t, store, appsService, "cache-ttl@example.com")
+
+	if _, err := appsService.AuthenticateAPIKey(ctx, "bka_unknown"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Fatalf("expected unknown key to be rejected, got %v", err)
+	}
+	if appsService.authCache.len() != 1 {
+		t.Fatalf("expected the unknown key to be cached")
+	}
+
+	created, err := appsService.CreateAPIKey(ctx, user, application.ID, CreateAPIKeyInput{
+		Name:   "Cached",
+		Scopes: []string{authorization.ScopeBrowsersRead},
+	})
+	if err != nil {
+		t.Fatalf("create API key: %v", err)
+	}
+	if _, err := appsService.AuthenticateAPIKey(ctx, created.Token); err != nil {
+		t.Fatalf("authenticate API key: %v", err)
+	}
+
+	// Revoking behind the service's back is only noticed once the cached entry expires.
+	if _, err := store.RevokeAPIKey(ctx, application.ID, created.APIKey.ID, now); err != nil {
+		t.Fatalf("revoke API key in store: %v", err)
+	}
+	if _, err := appsService.AuthenticateAPIKey(ctx, created.Token); err != nil {
+		t.Fatalf("expected cached lookup within the TTL, got %v", err)
+	}
+
+	now = now.Add(AuthCacheTTL)
+	if _, err := appsService.AuthenticateAPIKey(ctx, created.Token); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Fatalf("expected the key to be rejected once the cached entry expired, got %v", err)
+	}
+}
+
+func TestAuthCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	cache := newAuthCache(2, time.Minute, time.Minute)
+
+	_, _, generation := cache.get("a", now)
+	cache.put("a", data.APIKeyAuthRecord{}, true, now, generation)
+	cache.put("b", data.APIKeyAuthRecord{}, true, now, generation)
+	if _, found, _ := cache.get("a", now); !found {
+		t.Fatalf("expected a to be cached")
+	}
+	cache.put("c", data.APIKeyAuthRecord{}, true, now, generation)
+
+	if _, found, _ := cache.get("b", now); found {
+		t.Fatalf("expected least recently used entry to be evicted")
+	}
+	if _, found, _ := cache.get("a", now); !found {
+		t.Fatalf("expected recently used entry to be kept")
+	}
+
+	cache.invalidate(1)
+	cache.put("d", data.APIKeyAuthRecord{}, true, now, generation)
+	if _, found, _ := cache.get("d", now); found {
+		t.Fatalf("expected a lookup started before an invalidation not to be cached")
+	}
+}
+
+func BenchmarkAuthenticateAPIKeyCached(b *testing.B) {
+	benchmarkAuthenticateAPIKey(b, true)
+}
+
+func BenchmarkAuthenticateAPIKeyUncached(b *testing.B) {
+	benchmarkAuthenticateAPIKey(b, false)
+}
+
+func benchmarkAuthenticateAPIKey(b *testing.B, cached bool) {
+	ctx := context.Background()
+	store := datatest.NewStore(b)
+	appsService := NewService(store, authorization.NewWebAuthorizer(), security.NewTokenHasher("test-pepper"))
+	if !cached {
+		appsService.authCache = nil
+	}
+	user, application := registerApplication(b, store, appsService, "bench@example.com")
+
+	created, err := appsService.CreateAPIKey(ctx, user, application.ID, CreateAPIKeyInput{
+		Name:   "Bench",
+		Scopes: []string{authorization.ScopeBrowsersRead},
+	})
+	if err != nil {
+		b.Fatalf("create API key: %v", err)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := appsService.AuthenticateAPIKey(ctx, created.Token); err != nil {
+				b.Errorf("authenticate API key: %v", err)
+				return
+			}
+		}
+	})
+}
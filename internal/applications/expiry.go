@@ -0,0 +1,95 @@
+package applications
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/mailer"
+)
+
+// ExpiryNotifier emails application owners about API keys that are about to expire.
+// Each key is notified at most once.
+type ExpiryNotifier struct {
+	store  *data.Store
+	mailer mailer.Mailer
+	window time.Duration
+	now    func() time.Time
+}
+
+func NewExpiryNotifier(store *data.Store, mailer mailer.Mailer, window time.Duration) *ExpiryNotifier {
+	if window <= 0 {
+		window = 7 * 24 * time.Hour
+	}
+
+	return &ExpiryNotifier{
+		store:  store,
+		mailer: mailer,
+		window: window,
+		now:    time.Now,
+	}
+}
+
+// NotifyExpiringKeys sends one email per key expiring within the notification window and
+// returns how many notifications were sent.
+func (n *ExpiryNotifier) NotifyExpiringKeys(ctx context.Context) (int, error) {
+	now := n.now().UTC()
+	expiringKeys, err := n.store.ListAPIKeysExpiringBefore(ctx, now.Add(n.window), now)
+	if err != nil {
+		return 0, fmt.Errorf("list expiring API keys: %w", err)
+	}
+
+	sent := 0
+	for _, expiring := range expiringKeys {
+		if err := n.mailer.Send(ctx, buildExpiryMessage(expiring, now)); err != nil {
+			return sent, fmt.Errorf("send expiry notice for API key %s: %w", expiring.Key.ID, err)
+		}
+
+		if err := n.store.MarkAPIKeyExpiryNotified(ctx, expiring.Key.ID, now); err != nil {
+			return sent, fmt.Errorf("mark API key %s notified: %w", expiring.Key.ID, err)
+		}
+		sent++
+	}
+
+	return sent, nil
+}
+
+func buildExpiryMessage(expiring data.APIKeyExpiryRecord, now time.Time) mailer.Message {
+	expiresAt := expiring.Key.ExpiresAt.UTC()
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "The API key %q (%s...) for application %q expires %s.\n\n", expiring.Key.Name, expiring.Key.KeyPrefix, expiring.ApplicationName, FormatExpiry(expiresAt, now))
+	fmt.Fprintf(&body, "Expiry: %s\n\n", expiresAt.Format(time.RFC1123))
+	body.WriteString("Create a replacement key from the dashboard and update your deployments before it stops working.\n")
+
+	return mailer.Message{
+		To:      []string{expiring.OwnerEmail},
+		Subject: fmt.Sprintf("API key %q expires soon", expiring.Key.Name),
+		Body:    body.String(),
+	}
+}
+
+// FormatExpiry renders a short human countdown such as "in 3 days" or "expired".
+func FormatExpiry(expiresAt time.Time, now time.Time) string {
+	remaining := expiresAt.Sub(now)
+	switch {
+	case remaining <= 0:
+		return "expired"
+	case remaining < time.Hour:
+		return "in less than an hour"
+	case remaining < 24*time.Hour:
+		hours := int(remaining / time.Hour)
+		if hours == 1 {
+			return "in 1 hour"
+		}
+		return fmt.Sprintf("in %d hours", hours)
+	default:
+		days := int(remaining / (24 * time.Hour))
+		if days == 1 {
+			return "in 1 day"
+		}
+		return fmt.Sprintf("in %d days", days)
+	}
+}
@@ -0,0 +1,278 @@
+package applications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/audit"
+	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/users"
+)
+
+var (
+	ErrGitHubOIDCNotConfigured = errors.New("GitHub Actions authentication is not enabled for this application")
+	ErrGitHubLinkNotRepository = errors.New("the application's GitHub link must point to a repository (https://github.com/<owner>/<repo>) to trust its workflows")
+	ErrInvalidGitHubRef        = errors.New("allowed ref must be a branch name or a full ref such as refs/tags/v1")
+	ErrInvalidEnvironmentName  = errors.New("allowed environment cannot be longer than 255 characters")
+)
+
+// GitHubOIDCTrust lets GitHub Actions workflows from the application's repository exchange
+// their OIDC token for a short-lived access token that acts as APIKeyID. Empty conditions
+// match any ref or environment.
+type GitHubOIDCTrust struct {
+	ApplicationID      string
+	APIKeyID           string
+	AllowedRef         string
+	AllowedEnvironment string
+	// RepositoryID is GitHub's ID of the repository, pinned by the first exchange; empty
+	// until then.
+	RepositoryID string
+	UpdatedAt    time.Time
+}
+
+type GitHubOIDCTrustInput struct {
+	// APIKeyID selects the key whose scopes, allowlist and rate limit exchanged tokens get.
+	// An empty ID disables the trust.
+	APIKeyID string
+	// AllowedRef is a full ref or a bare branch name, which is read as refs/heads/<name>.
+	AllowedRef         string
+	AllowedEnvironment string
+}
+
+// GitHubOIDCTrustResolution is what an exchange needs to check a workflow token and issue
+// a credential.
+type GitHubOIDCTrustResolution struct {
+	Trust      GitHubOIDCTrust
+	Repository string
+	Principal  APIKeyPrincipal
+}
+
+func (s *Service) GetGitHubOIDCTrust(ctx context.Context, actor users.User, applicationID string) (GitHubOIDCTrust, bool, error) {
+	applicationRecord, err := s.getAuthorizedApplication(ctx, actor, applicationID, "applications.read")
+	if err != nil {
+		return GitHubOIDCTrust{}, false, err
+	}
+	if applicationRecord.ID == "" {
+		return GitHubOIDCTrust{}, false, ErrApplicationNotFound
+	}
+
+	record, found, err := s.store.GetGitHubOIDCTrust(ctx, applicationRecord.ID)
+	if err != nil {
+		return GitHubOIDCTrust{}, false, fmt.Errorf("lookup GitHub OIDC trust: %w", err)
+	}
+
+	return mapGitHubOIDCTrustRecord(record), found, nil
+}
+
+func (s *Service) UpdateGitHubOIDCTrust(ctx context.Context, actor users.User, applicationID string, input GitHubOIDCTrustInput) (GitHubOIDCTrust, error) {
+	applicationRecord, err := s.getAuthorizedApplication(ctx, actor, applicationID, "applications.update")
+	if err != nil {
+		return GitHubOIDCTrust{}, err
+	}
+	if applicationRecord.ID == "" {
+		return GitHubOIDCTrust{}, ErrApplicationNotFound
+	}
+
+	previous, _, err := s.store.GetGitHubOIDCTrust(ctx, applicationRecord.ID)
+	if err != nil {
+		return GitHubOIDCTrust{}, fmt.Errorf("lookup GitHub OIDC trust: %w", err)
+	}
+
+	keyID := strings.TrimSpace(input.APIKeyID)
+	if keyID == "" {
+		if err := s.store.DeleteGitHubOIDCTrust(ctx, applicationRecord.ID); err != nil {
+			return GitHubOIDCTrust{}, fmt.Errorf("disable GitHub OIDC trust: %w", err)
+		}
+		s.recordEvent(ctx, actor.ID, applicationRecord, "application.github_actions_disabled", "", trustChanges(previous, data.GitHubOIDCTrustRecord{}))
+		return GitHubOIDCTrust{}, nil
+	}
+
+	if _, ok := GitHubRepository(applicationRecord.GitHubLink); !ok {
+		return GitHubOIDCTrust{}, ErrGitHubLinkNotRepository
+	}
+
+	allowedRef, err := normalizeGitHubRef(input.AllowedRef)
+	if err != nil {
+		return GitHubOIDCTrust{}, err
+	}
+	allowedEnvironment := strings.TrimSpace(input.AllowedEnvironment)
+	if len(allowedEnvironment) > 255 {
+		return GitHubOIDCTrust{}, ErrInvalidEnvironmentName
+	}
+
+	now := s.now().UTC()
+	keyRecord, found, err := s.store.GetAPIKeyByID(ctx, applicationRecord.ID, keyID)
+	if err != nil {
+		return GitHubOIDCTrust{}, fmt.Errorf("lookup API key: %w", err)
+	}
+	if !found || checkKeyUsable(keyRecord, now) != nil || keyRecord.ReplacedByKeyID != "" {
+		return GitHubOIDCTrust{}, ErrAPIKeyNotFound
+	}
+
+	record := data.GitHubOIDCTrustRecord{
+		ApplicationID:      applicationRecord.ID,
+		APIKeyID:           keyRecord.ID,
+		AllowedRef:         allowedRef,
+		AllowedEnvironment: allowedEnvironment,
+		CreatedAt:          now,
+		UpdatedAt:          now,
+	}
+	if err := s.store.UpsertGitHubOIDCTrust(ctx, record); err != nil {
+		return GitHubOIDCTrust{}, fmt.Errorf("save GitHub OIDC trust: %w", err)
+	}
+
+	s.recordEvent(ctx, actor.ID, applicationRecord, "application.github_actions_updated", "", trustChanges(previous, record))
+
+	return mapGitHubOIDCTrustRecord(record), nil
+}
+
+// ResolveGitHubOIDCTrust loads the trust of an application for a token exchange. It reports
+// ErrGitHubOIDCNotConfigured for unknown applications too, so exchanges cannot probe for
+// application IDs.
+func (s *Service) ResolveGitHubOIDCTrust(ctx context.Context, applicationID string) (GitHubOIDCTrustResolution, error) {
+	applicationRecord, found, err := s.store.GetApplicationByID(ctx, strings.TrimSpace(applicationID))
+	if err != nil {
+		return GitHubOIDCTrustResolution{}, fmt.Errorf("lookup application by id: %w", err)
+	}
+	if !found {
+		return GitHubOIDCTrustResolution{}, ErrGitHubOIDCNotConfigured
+	}
+
+	repository, ok := GitHubRepository(applicationRecord.GitHubLink)
+	if !ok {
+		return GitHubOIDCTrustResolution{}, ErrGitHubOIDCNotConfigured
+	}
+
+	trustRecord, found, err := s.store.GetGitHubOIDCTrust(ctx, applicationRecord.ID)
+	if err != nil {
+		return GitHubOIDCTrustResolution{}, fmt.Errorf("lookup GitHub OIDC trust: %w", err)
+	}
+	if !found {
+		return GitHubOIDCTrustResolution{}, ErrGitHubOIDCNotConfigured
+	}
+
+	keyRecord, found, err := s.store.GetAPIKeyByID(ctx, applicationRecord.ID, trustRecord.APIKeyID)
+	if err != nil {
+		return GitHubOIDCTrustResolution{}, fmt.Errorf("lookup API key: %w", err)
+	}
+	if !found {
+		return GitHubOIDCTrustResolution{}, ErrGitHubOIDCNotConfigured
+	}
+	if err := checkKeyUsable(keyRecord, s.now().UTC()); err != nil {
+		return GitHubOIDCTrustResolution{}, err
+	}
+
+	return GitHubOIDCTrustResolution{
+		Trust:      mapGitHubOIDCTrustRecord(trustRecord),
+		Repository: repository,
+		Principal:  newAPIKeyPrincipal(applicationRecord, keyRecord),
+	}, nil
+}
+
+// PinGitHubOIDCRepositoryID pins the trust to the repository ID presented by its first
+// exchange and returns the ID the trust is pinned to, which differs when another exchange
+// pinned it first.
+func (s *Service) PinGitHubOIDCRepositoryID(ctx context.Context, applicationID string, repositoryID string) (string, error) {
+	pinned, err := s.store.PinGitHubOIDCTrustRepositoryID(ctx, applicationID, repositoryID)
+	if err != nil {
+		return "", err
+	}
+	if pinned {
+		return repositoryID, nil
+	}
+
+	record, found, err := s.store.GetGitHubOIDCTrust(ctx, applicationID)
+	if err != nil {
+		return "", fmt.Errorf("lookup GitHub OIDC trust: %w", err)
+	}
+	if !found {
+		return "", ErrGitHubOIDCNotConfigured
+	}
+
+	return record.RepositoryID, nil
+}
+
+// dropGitHubOIDCTrust disables the application's trust after its GitHub link moved to
+// another repository, so the new repository's workflows are only trusted once someone
+// enables them again.
+func (s *Service) dropGitHubOIDCTrust(ctx context.Context, actorUserID string, applicationRecord data.ApplicationRecord) error {
+	previous, found, err := s.store.GetGitHubOIDCTrust(ctx, applicationRecord.ID)
+	if err != nil {
+		return fmt.Errorf("lookup GitHub OIDC trust: %w", err)
+	}
+	if !found {
+		return nil
+	}
+
+	if err := s.store.DeleteGitHubOIDCTrust(ctx, applicationRecord.ID); err != nil {
+		return fmt.Errorf("disable GitHub OIDC trust: %w", err)
+	}
+	s.recordEvent(ctx, actorUserID, applicationRecord, "application.github_actions_disabled", "", trustChanges(previous, data.GitHubOIDCTrustRecord{}))
+
+	return nil
+}
+
+// GitHubRepository returns "owner/repo" for a github.com repository URL. Links to a user or
+// organization do not name a repository.
+func GitHubRepository(link string) (string, bool) {
+	parsedURL, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || !strings.EqualFold(parsedURL.Hostname(), "github.com") {
+		return "", false
+	}
+
+	segments := strings.Split(strings.Trim(parsedURL.Path, "/"), "/")
+	if len(segments) < 2 || segments[0] == "" || segments[1] == "" {
+		return "", false
+	}
+
+	return segments[0] + "/" + strings.TrimSuffix(segments[1], ".git"), true
+}
+
+func normalizeGitHubRef(ref string) (string, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return "", nil
+	}
+	if len(ref) > 255 || strings.ContainsAny(ref, " \t\n*?[") {
+		return "", ErrInvalidGitHubRef
+	}
+	if !strings.HasPrefix(ref, "refs/") {
+		ref = "refs/heads/" + ref
+	}
+
+	return ref, nil
+}
+
+// trustChanges describes a trust update for the audit trail. A zero record stands for no
+// trust, whose fields are recorded as null.
+func trustChanges(previous data.GitHubOIDCTrustRecord, next data.GitHubOIDCTrustRecord) map[string]audit.Change {
+	fields := func(record data.GitHubOIDCTrustRecord) (any, any, any) {
+		if record.APIKeyID == "" {
+			return nil, nil, nil
+		}
+		return record.APIKeyID, record.AllowedRef, record.AllowedEnvironment
+	}
+	fromKey, fromRef, fromEnvironment := fields(previous)
+	toKey, toRef, toEnvironment := fields(next)
+
+	return map[string]audit.Change{
+		"api_key_id":          {From: fromKey, To: toKey},
+		"allowed_ref":         {From: fromRef, To: toRef},
+		"allowed_environment": {From: fromEnvironment, To: toEnvironment},
+	}
+}
+
+func mapGitHubOIDCTrustRecord(record data.GitHubOIDCTrustRecord) GitHubOIDCTrust {
+	return GitHubOIDCTrust{
+		ApplicationID:      record.ApplicationID,
+		APIKeyID:           record.APIKeyID,
+		AllowedRef:         record.AllowedRef,
+		AllowedEnvironment: record.AllowedEnvironment,
+		RepositoryID:       record.RepositoryID,
+		UpdatedAt:          record.UpdatedAt,
+	}
+}
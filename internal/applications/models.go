@@ -1,39 +1,137 @@
 package applications
 
-import "time"
+import (
+	"slices"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/authorization"
+)
 
 type Application struct {
-	ID          string
-	OwnerUserID string
-	Name        string
-	Description string
-	GitHubLink  string
-	Domain      string
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	ID                    string
+	OwnerUserID           string
+	OrganizationID        string
+	Name                  string
+	Description           string
+	GitHubLink            string
+	Domain                string
+	MaxAPIKeyLifetimeDays int
+	AllowedCIDRs          []string
+	RateLimitPerMinute    int
+	CreatedAt             time.Time
+	UpdatedAt             time.Time
+	// ArchivedAt is when the application was archived; nil while it is in use.
+	ArchivedAt *time.Time
+}
+
+func (a Application) IsArchived() bool {
+	return a.ArchivedAt != nil
+}
+
+// Transfer is a pending move of an application to another user. The recipient chooses which of
+// their organizations receives it when they accept.
+type Transfer struct {
+	ID                 string
+	ApplicationID      string
+	ApplicationName    string
+	FromOrganizationID string
+	InitiatedByUserID  string
+	InitiatedByEmail   string
+	RecipientUserID    string
+	RecipientEmail     string
+	ExpiresAt          time.Time
+	CreatedAt          time.Time
 }
 
 type APIKey struct {
-	ID            string
-	ApplicationID string
-	Name          string
-	KeyPrefix     string
-	CanRead       bool
-	CanWrite      bool
-	CanDelete     bool
-	CreatedAt     time.Time
-	LastUsedAt    *time.Time
-	RevokedAt     *time.Time
+	ID                 string
+	ApplicationID      string
+	Name               string
+	KeyPrefix          string
+	Scopes             []string
+	CreatedAt          time.Time
+	LastUsedAt         *time.Time
+	RevokedAt          *time.Time
+	ExpiresAt          *time.Time
+	RotatedFromKeyID   string
+	ReplacedByKeyID    string
+	RotatedAt          *time.Time
+	GraceExpiresAt     *time.Time
+	AllowedCIDRs       []string
+	RateLimitPerMinute int
+	LastUsedIP         string
+	LastUsedUserAgent  string
+	// EndpointUsage is only populated when listing an application's keys, most used first.
+	EndpointUsage []EndpointUsage
+}
+
+type EndpointUsage struct {
+	Endpoint     string
+	RequestCount int
+	LastUsedAt   time.Time
+}
+
+func (k APIKey) IsExpired(now time.Time) bool {
+	return k.ExpiresAt != nil && !k.ExpiresAt.After(now)
+}
+
+func (k APIKey) IsRotated() bool {
+	return k.ReplacedByKeyID != ""
 }
 
-type APIKeyPermissions struct {
-	CanRead   bool
-	CanWrite  bool
-	CanDelete bool
+// InGracePeriod reports whether a rotated key is still accepted alongside its replacement.
+func (k APIKey) InGracePeriod(now time.Time) bool {
+	return k.RevokedAt == nil && k.GraceExpiresAt != nil && k.GraceExpiresAt.After(now)
+}
+
+// UsedDuringGrace reports whether a rotated key authenticated a request after it was rotated,
+// meaning some client has not switched to the replacement yet.
+func (k APIKey) UsedDuringGrace() bool {
+	return k.RotatedAt != nil && k.LastUsedAt != nil && k.LastUsedAt.After(*k.RotatedAt)
+}
+
+// IsStale reports whether an active key has gone unused (or was never used) for longer
+// than unusedFor, making it a candidate for revocation.
+func (k APIKey) IsStale(now time.Time, unusedFor time.Duration) bool {
+	if k.RevokedAt != nil || unusedFor <= 0 {
+		return false
+	}
+
+	lastActivity := k.CreatedAt
+	if k.LastUsedAt != nil {
+		lastActivity = *k.LastUsedAt
+	}
+
+	return now.Sub(lastActivity) > unusedFor
+}
+
+func (k APIKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
 }
 
 type APIKeyPrincipal struct {
 	KeyID         string
 	ApplicationID string
-	Permissions   APIKeyPermissions
+	Scopes        []string
+	IPAllowlist   IPAllowlist
+	// RateLimitPerMinute is the key's effective rate limit; zero means the server default.
+	RateLimitPerMinute int
+	// AccessTokenID is set when the request authenticated with a short-lived access token
+	// minted from the key instead of the key itself.
+	AccessTokenID string
+	// BrowserID restricts an access token to a single browser.
+	BrowserID string
+}
+
+func (p APIKeyPrincipal) IsAccessToken() bool {
+	return p.AccessTokenID != ""
+}
+
+// Subject is the principal as the API authorizer sees it.
+func (p APIKeyPrincipal) Subject() authorization.APIKeySubject {
+	return authorization.APIKeySubject{
+		AppID:  p.ApplicationID,
+		Roles:  []string{"api_key"},
+		Scopes: p.Scopes,
+	}
 }
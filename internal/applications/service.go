@@ -5,33 +5,60 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/brian-nunez/bbaas-api/internal/audit"
 	"github.com/brian-nunez/bbaas-api/internal/authorization"
 	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/organizations"
 	"github.com/brian-nunez/bbaas-api/internal/security"
 	"github.com/brian-nunez/bbaas-api/internal/users"
 )
 
 var (
-	ErrApplicationNameRequired   = errors.New("application name is required")
-	ErrApplicationNameTooLong    = errors.New("application name cannot be longer than 80 characters")
-	ErrDescriptionTooLong        = errors.New("description cannot be longer than 500 characters")
-	ErrGitHubLinkRequired        = errors.New("github link is required")
-	ErrInvalidGitHubLink         = errors.New("github link must be a valid github.com URL")
-	ErrDomainRequired            = errors.New("domain is required")
-	ErrInvalidDomain             = errors.New("domain must be a valid host name")
-	ErrAPIKeyNameRequired        = errors.New("API key name is required")
-	ErrAPIKeyNameTooLong         = errors.New("API key name cannot be longer than 80 characters")
-	ErrAPIKeyPermissionsRequired = errors.New("at least one API key permission must be enabled")
-	ErrApplicationNotFound       = errors.New("application not found")
-	ErrAPIKeyNotFound            = errors.New("API key not found")
-	ErrInvalidAPIKey             = errors.New("invalid API key")
-	ErrForbidden                 = errors.New("forbidden")
+	ErrApplicationNameRequired  = errors.New("application name is required")
+	ErrApplicationNameTooLong   = errors.New("application name cannot be longer than 80 characters")
+	ErrDescriptionTooLong       = errors.New("description cannot be longer than 500 characters")
+	ErrGitHubLinkRequired       = errors.New("github link is required")
+	ErrInvalidGitHubLink        = errors.New("github link must be a valid github.com URL")
+	ErrDomainRequired           = errors.New("domain is required")
+	ErrInvalidDomain            = errors.New("domain must be a valid host name")
+	ErrAPIKeyNameRequired       = errors.New("API key name is required")
+	ErrAPIKeyNameTooLong        = errors.New("API key name cannot be longer than 80 characters")
+	ErrAPIKeyScopesRequired     = errors.New("at least one API key scope must be selected")
+	ErrUnknownAPIKeyScope       = errors.New("unknown API key scope")
+	ErrApplicationNotFound      = errors.New("application not found")
+	ErrAPIKeyNotFound           = errors.New("API key not found")
+	ErrInvalidAPIKey            = errors.New("invalid API key")
+	ErrAPIKeyExpired            = errors.New("API key has expired")
+	ErrAPIKeyExpiryInPast       = errors.New("API key expiry must be in the future")
+	ErrAPIKeyExpiryRequired     = errors.New("this application requires API keys to have an expiry date")
+	ErrAPIKeyLifetimeTooLong    = errors.New("API key expiry exceeds the application's maximum key lifetime")
+	ErrInvalidKeyLifetimePolicy = errors.New("maximum key lifetime must be between 0 and 3650 days")
+	ErrAPIKeyAlreadyRotated     = errors.New("API key has already been rotated")
+	ErrInvalidGracePeriod       = errors.New("grace period must be between 0 and 30 days")
+	ErrInvalidRateLimit         = errors.New("rate limit must be between 0 and 100000 requests per minute")
+	ErrForbidden                = authorization.ErrForbidden
+	ErrEmailNotVerified         = errors.New("verify your email address before creating applications or API keys")
+	ErrApplicationArchived      = errors.New("application is archived")
+	ErrApplicationNotArchived   = errors.New("application is not archived")
+	ErrDeleteNameMismatch       = errors.New("type the application name exactly to confirm deletion")
+	ErrApplicationLimitReached  = errors.New("the organization has reached its application limit")
 )
 
 type RegisterApplicationInput struct {
+	// OrganizationID is the organization that will own the application; empty means the
+	// actor's personal organization.
+	OrganizationID string
+	Name           string
+	Description    string
+	GitHubLink     string
+	Domain         string
+}
+
+type UpdateApplicationInput struct {
 	Name        string
 	Description string
 	GitHubLink  string
@@ -39,8 +66,13 @@ type RegisterApplicationInput struct {
 }
 
 type CreateAPIKeyInput struct {
-	Name        string
-	Permissions APIKeyPermissions
+	Name   string
+	Scopes []string
+	// ExpiresAt is optional; nil creates a key that never expires unless the
+	// application's key lifetime policy requires one.
+	ExpiresAt *time.Time
+	// RateLimitPerMinute overrides the application's rate limit for this key; zero inherits it.
+	RateLimitPerMinute int
 }
 
 type CreateAPIKeyResult struct {
@@ -48,23 +80,86 @@ type CreateAPIKeyResult struct {
 	Token  string
 }
 
+type RotateAPIKeyResult struct {
+	// RotatedKey is the old key; it keeps working until RotatedKey.GraceExpiresAt.
+	RotatedKey APIKey
+	APIKey     APIKey
+	Token      string
+}
+
+const MaxRotationGracePeriod = 30 * 24 * time.Hour
+
+// BrowserCloser closes the running browsers of an application that is being deleted.
+type BrowserCloser interface {
+	CloseForApplication(ctx context.Context, applicationID string) (int, error)
+}
+
 type Service struct {
 	store         *data.Store
 	webAuthorizer *authorization.WebAuthorizer
-	now           func() time.Time
+	apiAuthorizer *authorization.APIAuthorizer
+	tokenHasher   *security.TokenHasher
+	// authCache is nil when API key lookups should always hit the database.
+	authCache *authCache
+	// requireVerifiedEmail blocks unverified users from creating applications and keys.
+	requireVerifiedEmail bool
+	audit                *audit.Recorder
+	// browserCloser is nil when deleted applications have no browsers to close.
+	browserCloser BrowserCloser
+	// maxApplicationsPerOrganization caps how many applications an organization may hold;
+	// zero means no limit.
+	maxApplicationsPerOrganization int
+	now                            func() time.Time
 }
 
-func NewService(store *data.Store, webAuthorizer *authorization.WebAuthorizer) *Service {
+func NewService(store *data.Store, webAuthorizer *authorization.WebAuthorizer, tokenHasher *security.TokenHasher) *Service {
 	return &Service{
 		store:         store,
 		webAuthorizer: webAuthorizer,
+		apiAuthorizer: authorization.NewAPIAuthorizer(),
+		tokenHasher:   tokenHasher,
+		authCache:     newAuthCache(DefaultAuthCacheSize, AuthCacheTTL, AuthCacheNegativeTTL),
 		now:           time.Now,
 	}
 }
 
+// RequireVerifiedEmail turns on the check that the acting user has verified their email
+// before creating applications or API keys.
+func (s *Service) RequireVerifiedEmail(required bool) {
+	s.requireVerifiedEmail = required
+}
+
+func (s *Service) RequiresVerifiedEmail() bool {
+	return s.requireVerifiedEmail
+}
+
+// LimitApplicationsPerOrganization caps how many applications an organization may create or
+// receive by transfer. Zero removes the limit.
+func (s *Service) LimitApplicationsPerOrganization(limit int) {
+	s.maxApplicationsPerOrganization = max(limit, 0)
+}
+
+// UseAuditRecorder records changes to applications and their API keys in the audit trail.
+func (s *Service) UseAuditRecorder(recorder *audit.Recorder) {
+	s.audit = recorder
+}
+
+// UseBrowserCloser closes the running browsers of applications before they are deleted.
+func (s *Service) UseBrowserCloser(closer BrowserCloser) {
+	s.browserCloser = closer
+}
+
 func (s *Service) RegisterApplication(ctx context.Context, actor users.User, input RegisterApplicationInput) (Application, error) {
-	if !s.webAuthorizer.Can(toWebSubject(actor), authorization.OwnedResource{OwnerUserID: actor.ID}, "applications.create") {
-		return Application{}, ErrForbidden
+	if s.requireVerifiedEmail && !actor.IsVerified() {
+		return Application{}, ErrEmailNotVerified
+	}
+
+	organizationID, err := s.authorizeNewApplication(ctx, actor, input.OrganizationID)
+	if err != nil {
+		return Application{}, err
+	}
+	if err := s.checkApplicationLimit(ctx, organizationID); err != nil {
+		return Application{}, err
 	}
 
 	normalizedInput, err := normalizeApplicationInput(input)
@@ -79,25 +174,190 @@ func (s *Service) RegisterApplication(ctx context.Context, actor users.User, inp
 
 	now := s.now().UTC()
 	record := data.ApplicationRecord{
-		ID:          applicationID,
-		OwnerUserID: actor.ID,
-		Name:        normalizedInput.Name,
-		Description: normalizedInput.Description,
-		GitHubLink:  normalizedInput.GitHubLink,
-		Domain:      normalizedInput.Domain,
-		CreatedAt:   now,
-		UpdatedAt:   now,
+		ID:             applicationID,
+		OwnerUserID:    actor.ID,
+		OrganizationID: organizationID,
+		Name:           normalizedInput.Name,
+		Description:    normalizedInput.Description,
+		GitHubLink:     normalizedInput.GitHubLink,
+		Domain:         normalizedInput.Domain,
+		CreatedAt:      now,
+		UpdatedAt:      now,
 	}
 
 	if err := s.store.CreateApplication(ctx, record); err != nil {
 		return Application{}, fmt.Errorf("create application: %w", err)
 	}
 
+	s.recordEvent(ctx, actor.ID, record, "application.created", "", map[string]audit.Change{
+		"name":   {From: nil, To: record.Name},
+		"domain": {From: nil, To: record.Domain},
+	})
+
 	return mapApplicationRecord(record), nil
 }
 
+// UpdateApplication corrects the name, description, GitHub link and domain of an application,
+// validating them like RegisterApplication does.
+func (s *Service) UpdateApplication(ctx context.Context, actor users.User, applicationID string, input UpdateApplicationInput) (Application, error) {
+	applicationRecord, err := s.getAuthorizedApplication(ctx, actor, applicationID, "applications.update")
+	if err != nil {
+		return Application{}, err
+	}
+	if applicationRecord.ID == "" {
+		return Application{}, ErrApplicationNotFound
+	}
+
+	normalizedInput, err := normalizeApplicationInput(RegisterApplicationInput{
+		Name:        input.Name,
+		Description: input.Description,
+		GitHubLink:  input.GitHubLink,
+		Domain:      input.Domain,
+	})
+	if err != nil {
+		return Application{}, err
+	}
+
+	now := s.now().UTC()
+	if err := s.store.UpdateApplicationDetails(ctx, applicationRecord.ID, normalizedInput.Name, normalizedInput.Description, normalizedInput.GitHubLink, normalizedInput.Domain, now); err != nil {
+		return Application{}, fmt.Errorf("update application: %w", err)
+	}
+
+	changes := make(map[string]audit.Change)
+	addChange := func(field string, from string, to string) {
+		if from != to {
+			changes[field] = audit.Change{From: from, To: to}
+		}
+	}
+	addChange("name", applicationRecord.Name, normalizedInput.Name)
+	addChange("description", applicationRecord.Description, normalizedInput.Description)
+	addChange("github_link", applicationRecord.GitHubLink, normalizedInput.GitHubLink)
+	addChange("domain", applicationRecord.Domain, normalizedInput.Domain)
+
+	previousGitHubLink := applicationRecord.GitHubLink
+	applicationRecord.Name = normalizedInput.Name
+	applicationRecord.Description = normalizedInput.Description
+	applicationRecord.GitHubLink = normalizedInput.GitHubLink
+	applicationRecord.Domain = normalizedInput.Domain
+	applicationRecord.UpdatedAt = now
+	if len(changes) == 0 {
+		return mapApplicationRecord(applicationRecord), nil
+	}
+	s.recordEvent(ctx, actor.ID, applicationRecord, "application.updated", "", changes)
+
+	previousRepository, _ := GitHubRepository(previousGitHubLink)
+	repository, _ := GitHubRepository(applicationRecord.GitHubLink)
+	if !strings.EqualFold(previousRepository, repository) {
+		if err := s.dropGitHubOIDCTrust(ctx, actor.ID, applicationRecord); err != nil {
+			return Application{}, err
+		}
+	}
+
+	return mapApplicationRecord(applicationRecord), nil
+}
+
+// ArchiveApplication hides an application from the dashboard and stops its keys from spawning
+// browsers. Its keys, usage and browser history are kept, and it can be restored.
+func (s *Service) ArchiveApplication(ctx context.Context, actor users.User, applicationID string) (Application, error) {
+	applicationRecord, err := s.getAuthorizedApplication(ctx, actor, applicationID, "applications.archive")
+	if err != nil {
+		return Application{}, err
+	}
+	if applicationRecord.ID == "" {
+		return Application{}, ErrApplicationNotFound
+	}
+	if applicationRecord.ArchivedAt != nil {
+		return Application{}, ErrApplicationArchived
+	}
+
+	now := s.now().UTC()
+	if err := s.store.SetApplicationArchivedAt(ctx, applicationRecord.ID, &now, now); err != nil {
+		return Application{}, fmt.Errorf("archive application: %w", err)
+	}
+
+	applicationRecord.ArchivedAt = &now
+	applicationRecord.UpdatedAt = now
+	s.recordEvent(ctx, actor.ID, applicationRecord, "application.archived", "", map[string]audit.Change{"archived": {From: false, To: true}})
+
+	return mapApplicationRecord(applicationRecord), nil
+}
+
+func (s *Service) RestoreApplication(ctx context.Context, actor users.User, applicationID string) (Application, error) {
+	applicationRecord, err := s.getAuthorizedApplication(ctx, actor, applicationID, "applications.archive")
+	if err != nil {
+		return Application{}, err
+	}
+	if applicationRecord.ID == "" {
+		return Application{}, ErrApplicationNotFound
+	}
+	if applicationRecord.ArchivedAt == nil {
+		return Application{}, ErrApplicationNotArchived
+	}
+
+	now := s.now().UTC()
+	if err := s.store.SetApplicationArchivedAt(ctx, applicationRecord.ID, nil, now); err != nil {
+		return Application{}, fmt.Errorf("restore application: %w", err)
+	}
+
+	applicationRecord.ArchivedAt = nil
+	applicationRecord.UpdatedAt = now
+	s.recordEvent(ctx, actor.ID, applicationRecord, "application.restored", "", map[string]audit.Change{"archived": {From: true, To: false}})
+
+	return mapApplicationRecord(applicationRecord), nil
+}
+
+// DeleteApplication removes an application after the actor retypes its name. Its keys are
+// revoked first so nothing new can be spawned, then its running browsers are closed. If
+// closing a browser fails the application is kept, with its keys revoked, and deleting it
+// again picks up where this left off.
+func (s *Service) DeleteApplication(ctx context.Context, actor users.User, applicationID string, confirmName string) error {
+	applicationRecord, err := s.getAuthorizedApplication(ctx, actor, applicationID, "applications.delete")
+	if err != nil {
+		return err
+	}
+	if applicationRecord.ID == "" {
+		return ErrApplicationNotFound
+	}
+	if strings.TrimSpace(confirmName) != applicationRecord.Name {
+		return ErrDeleteNameMismatch
+	}
+
+	revoked, err := s.store.RevokeAPIKeysByApplicationID(ctx, applicationRecord.ID, s.now().UTC())
+	if err != nil {
+		return fmt.Errorf("revoke API keys: %w", err)
+	}
+	if err := s.invalidateAuthCache(ctx); err != nil {
+		return err
+	}
+
+	closed := 0
+	if s.browserCloser != nil {
+		closed, err = s.browserCloser.CloseForApplication(ctx, applicationRecord.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	deleted, err := s.store.DeleteApplication(ctx, applicationRecord.ID)
+	if err != nil {
+		return fmt.Errorf("delete application: %w", err)
+	}
+	if !deleted {
+		return ErrApplicationNotFound
+	}
+
+	s.recordEvent(ctx, actor.ID, applicationRecord, "application.deleted", "", map[string]audit.Change{
+		"name":             {From: applicationRecord.Name, To: nil},
+		"active_api_keys":  {From: revoked, To: 0},
+		"running_browsers": {From: closed, To: 0},
+	})
+
+	return nil
+}
+
+// ListApplicationsForViewer returns the applications of every organization the actor belongs to.
 func (s *Service) ListApplicationsForViewer(ctx context.Context, actor users.User) ([]Application, error) {
-	applicationRecords, err := s.store.ListApplicationsByUserID(ctx, actor.ID)
+	applicationRecords, err := s.store.ListApplicationsByMemberUserID(ctx, actor.ID)
 	if err != nil {
 		return nil, fmt.Errorf("list applications for viewer: %w", err)
 	}
@@ -111,7 +371,7 @@ func (s *Service) ListApplicationsForViewer(ctx context.Context, actor users.Use
 }
 
 func (s *Service) ListAPIKeysForApplication(ctx context.Context, actor users.User, applicationID string) ([]APIKey, error) {
-	applicationRecord, err := s.getOwnedApplication(ctx, actor, applicationID, "applications.read")
+	applicationRecord, err := s.getAuthorizedApplication(ctx, actor, applicationID, "applications.read")
 	if err != nil {
 		return nil, err
 	}
@@ -124,22 +384,43 @@ func (s *Service) ListAPIKeysForApplication(ctx context.Context, actor users.Use
 		return nil, fmt.Errorf("list API keys by application: %w", err)
 	}
 
+	usageRecords, err := s.store.ListAPIKeyEndpointUsageByApplicationID(ctx, applicationRecord.ID)
+	if err != nil {
+		return nil, fmt.Errorf("list API key endpoint usage: %w", err)
+	}
+	usageByKeyID := make(map[string][]EndpointUsage, len(keyRecords))
+	for _, record := range usageRecords {
+		usageByKeyID[record.APIKeyID] = append(usageByKeyID[record.APIKeyID], EndpointUsage{
+			Endpoint:     record.Endpoint,
+			RequestCount: record.RequestCount,
+			LastUsedAt:   record.LastUsedAt,
+		})
+	}
+
 	keys := make([]APIKey, 0, len(keyRecords))
 	for _, record := range keyRecords {
-		keys = append(keys, mapAPIKeyRecord(record))
+		key := mapAPIKeyRecord(record)
+		key.EndpointUsage = usageByKeyID[key.ID]
+		keys = append(keys, key)
 	}
 
 	return keys, nil
 }
 
 func (s *Service) CreateAPIKey(ctx context.Context, actor users.User, applicationID string, input CreateAPIKeyInput) (CreateAPIKeyResult, error) {
-	applicationRecord, err := s.getOwnedApplication(ctx, actor, applicationID, "api_keys.create")
+	if s.requireVerifiedEmail && !actor.IsVerified() {
+		return CreateAPIKeyResult{}, ErrEmailNotVerified
+	}
+	applicationRecord, err := s.getAuthorizedApplication(ctx, actor, applicationID, "api_keys.create")
 	if err != nil {
 		return CreateAPIKeyResult{}, err
 	}
 	if applicationRecord.ID == "" {
 		return CreateAPIKeyResult{}, ErrApplicationNotFound
 	}
+	if applicationRecord.ArchivedAt != nil {
+		return CreateAPIKeyResult{}, ErrApplicationArchived
+	}
 
 	input.Name = strings.TrimSpace(input.Name)
 	if input.Name == "" {
@@ -148,50 +429,257 @@ func (s *Service) CreateAPIKey(ctx context.Context, actor users.User, applicatio
 	if len(input.Name) > 80 {
 		return CreateAPIKeyResult{}, ErrAPIKeyNameTooLong
 	}
-	if !input.Permissions.CanRead && !input.Permissions.CanWrite && !input.Permissions.CanDelete {
-		return CreateAPIKeyResult{}, ErrAPIKeyPermissionsRequired
-	}
-
-	rawToken, err := security.GeneratePrefixedToken("bka", 24)
+	scopes, err := normalizeScopes(input.Scopes)
 	if err != nil {
-		return CreateAPIKeyResult{}, fmt.Errorf("generate API key token: %w", err)
+		return CreateAPIKeyResult{}, err
 	}
 
-	keyID, err := security.GeneratePrefixedToken("key", 14)
-	if err != nil {
-		return CreateAPIKeyResult{}, fmt.Errorf("generate API key id: %w", err)
+	if !isValidRateLimit(input.RateLimitPerMinute) {
+		return CreateAPIKeyResult{}, ErrInvalidRateLimit
 	}
 
-	keyPrefix := rawToken
-	if len(rawToken) > 12 {
-		keyPrefix = rawToken[:12]
+	now := s.now().UTC()
+	expiresAt, err := resolveAPIKeyExpiry(applicationRecord, input.ExpiresAt, now)
+	if err != nil {
+		return CreateAPIKeyResult{}, err
 	}
 
-	now := s.now().UTC()
-	record := data.APIKeyRecord{
-		ID:            keyID,
-		ApplicationID: applicationRecord.ID,
-		Name:          input.Name,
-		KeyPrefix:     keyPrefix,
-		KeyHash:       security.DigestSHA256(rawToken),
-		CanRead:       input.Permissions.CanRead,
-		CanWrite:      input.Permissions.CanWrite,
-		CanDelete:     input.Permissions.CanDelete,
-		CreatedAt:     now,
+	record, rawToken, err := s.newAPIKeyRecord(applicationRecord.ID, input.Name, scopes, expiresAt, now)
+	if err != nil {
+		return CreateAPIKeyResult{}, err
 	}
+	record.RateLimitPerMinute = input.RateLimitPerMinute
 
 	if err := s.store.CreateAPIKey(ctx, record); err != nil {
 		return CreateAPIKeyResult{}, fmt.Errorf("create API key: %w", err)
 	}
 
-	return CreateAPIKeyResult{
+	result := CreateAPIKeyResult{
 		APIKey: mapAPIKeyRecord(record),
 		Token:  rawToken,
-	}, nil
+	}
+	s.recordEvent(ctx, actor.ID, applicationRecord, "api_key.created", record.ID, map[string]audit.Change{
+		"name":       {From: nil, To: record.Name},
+		"scopes":     {From: nil, To: record.Scopes},
+		"expires_at": {From: nil, To: record.ExpiresAt},
+	})
+
+	return result, nil
+}
+
+// UpdateAPIKeyPolicy sets the maximum lifetime, in days, of API keys created for the
+// application from now on. Zero removes the limit. Existing keys are left untouched.
+func (s *Service) UpdateAPIKeyPolicy(ctx context.Context, actor users.User, applicationID string, maxAPIKeyLifetimeDays int) (Application, error) {
+	applicationRecord, err := s.getAuthorizedApplication(ctx, actor, applicationID, "applications.update")
+	if err != nil {
+		return Application{}, err
+	}
+	if applicationRecord.ID == "" {
+		return Application{}, ErrApplicationNotFound
+	}
+
+	if maxAPIKeyLifetimeDays < 0 || maxAPIKeyLifetimeDays > 3650 {
+		return Application{}, ErrInvalidKeyLifetimePolicy
+	}
+
+	now := s.now().UTC()
+	if err := s.store.UpdateApplicationKeyPolicy(ctx, applicationRecord.ID, maxAPIKeyLifetimeDays, now); err != nil {
+		return Application{}, fmt.Errorf("update API key policy: %w", err)
+	}
+
+	changes := map[string]audit.Change{"max_api_key_lifetime_days": {From: applicationRecord.MaxAPIKeyLifetimeDays, To: maxAPIKeyLifetimeDays}}
+	applicationRecord.MaxAPIKeyLifetimeDays = maxAPIKeyLifetimeDays
+	applicationRecord.UpdatedAt = now
+	s.recordEvent(ctx, actor.ID, applicationRecord, "application.key_policy_updated", "", changes)
+
+	return mapApplicationRecord(applicationRecord), nil
+}
+
+// UpdateRateLimit sets how many API requests per minute each key of the application may
+// make. Zero falls back to the server-wide default; keys can override it individually.
+func (s *Service) UpdateRateLimit(ctx context.Context, actor users.User, applicationID string, rateLimitPerMinute int) (Application, error) {
+	applicationRecord, err := s.getAuthorizedApplication(ctx, actor, applicationID, "applications.update")
+	if err != nil {
+		return Application{}, err
+	}
+	if applicationRecord.ID == "" {
+		return Application{}, ErrApplicationNotFound
+	}
+
+	if !isValidRateLimit(rateLimitPerMinute) {
+		return Application{}, ErrInvalidRateLimit
+	}
+
+	now := s.now().UTC()
+	if err := s.store.UpdateApplicationRateLimit(ctx, applicationRecord.ID, rateLimitPerMinute, now); err != nil {
+		return Application{}, fmt.Errorf("update rate limit: %w", err)
+	}
+	if err := s.invalidateAuthCache(ctx); err != nil {
+		return Application{}, err
+	}
+
+	changes := map[string]audit.Change{"rate_limit_per_minute": {From: applicationRecord.RateLimitPerMinute, To: rateLimitPerMinute}}
+	applicationRecord.RateLimitPerMinute = rateLimitPerMinute
+	applicationRecord.UpdatedAt = now
+	s.recordEvent(ctx, actor.ID, applicationRecord, "application.rate_limit_updated", "", changes)
+
+	return mapApplicationRecord(applicationRecord), nil
+}
+
+// RotateAPIKey mints a replacement for an existing key with the same name and permissions.
+// The old key keeps authenticating for gracePeriod and is then retired automatically.
+func (s *Service) RotateAPIKey(ctx context.Context, actor users.User, applicationID string, keyID string, gracePeriod time.Duration) (RotateAPIKeyResult, error) {
+	applicationRecord, err := s.getAuthorizedApplication(ctx, actor, applicationID, "api_keys.rotate")
+	if err != nil {
+		return RotateAPIKeyResult{}, err
+	}
+	if applicationRecord.ID == "" {
+		return RotateAPIKeyResult{}, ErrApplicationNotFound
+	}
+
+	return s.rotateKey(ctx, actor.ID, applicationRecord, keyID, gracePeriod, replacementExpiry)
+}
+
+// RotateAPIKeyForPrincipal lets an API key with the api_keys:rotate scope rotate itself, e.g.
+// from a CI job. The replacement keeps the old key's expiry, so rotating cannot extend a key's life.
+func (s *Service) RotateAPIKeyForPrincipal(ctx context.Context, principal APIKeyPrincipal, gracePeriod time.Duration) (RotateAPIKeyResult, error) {
+	if principal.IsAccessToken() {
+		// Rotating needs the key itself, not a token derived from it.
+		return RotateAPIKeyResult{}, ErrForbidden
+	}
+	if err := s.authorizePrincipal(principal, authorization.ScopeAPIKeysRotate); err != nil {
+		return RotateAPIKeyResult{}, err
+	}
+
+	applicationRecord, found, err := s.store.GetApplicationByID(ctx, principal.ApplicationID)
+	if err != nil {
+		return RotateAPIKeyResult{}, fmt.Errorf("lookup application by id: %w", err)
+	}
+	if !found {
+		return RotateAPIKeyResult{}, ErrApplicationNotFound
+	}
+
+	return s.rotateKey(ctx, "", applicationRecord, principal.KeyID, gracePeriod, keptExpiry)
+}
+
+// GetAPIKeyForPrincipal returns the principal's own key, or the key it replaced, including
+// their rotation state.
+func (s *Service) GetAPIKeyForPrincipal(ctx context.Context, principal APIKeyPrincipal, keyID string) (APIKey, error) {
+	keyRecord, found, err := s.store.GetAPIKeyByID(ctx, principal.ApplicationID, strings.TrimSpace(keyID))
+	if err != nil {
+		return APIKey{}, fmt.Errorf("lookup API key: %w", err)
+	}
+	// Other keys of the application are none of the caller's business.
+	if !found || (keyRecord.ID != principal.KeyID && keyRecord.ReplacedByKeyID != principal.KeyID) {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+
+	return mapAPIKeyRecord(keyRecord), nil
+}
+
+// GetUsageForPrincipal returns the requests the principal's key has made per endpoint, most
+// used first. It needs the usage:read scope.
+func (s *Service) GetUsageForPrincipal(ctx context.Context, principal APIKeyPrincipal) ([]EndpointUsage, error) {
+	if err := s.authorizePrincipal(principal, authorization.ScopeUsageRead); err != nil {
+		return nil, err
+	}
+
+	usageRecords, err := s.store.ListAPIKeyEndpointUsageByKeyID(ctx, principal.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("list API key endpoint usage: %w", err)
+	}
+
+	endpointUsage := make([]EndpointUsage, 0, len(usageRecords))
+	for _, record := range usageRecords {
+		endpointUsage = append(endpointUsage, EndpointUsage{
+			Endpoint:     record.Endpoint,
+			RequestCount: record.RequestCount,
+			LastUsedAt:   record.LastUsedAt,
+		})
+	}
+
+	return endpointUsage, nil
+}
+
+// authorizePrincipal checks an API key request against the API authorizer's scope policies.
+func (s *Service) authorizePrincipal(principal APIKeyPrincipal, scope string) error {
+	return s.apiAuthorizer.Authorize(principal.Subject(), authorization.BrowserResource{AppID: principal.ApplicationID}, scope)
+}
+
+// RetireRotatedAPIKeys revokes rotated keys whose grace period is over.
+func (s *Service) RetireRotatedAPIKeys(ctx context.Context) (int, error) {
+	retired, err := s.store.RetireRotatedAPIKeys(ctx, s.now().UTC())
+	if err != nil {
+		return 0, fmt.Errorf("retire rotated API keys: %w", err)
+	}
+
+	return retired, nil
+}
+
+// rotateKey replaces keyID on behalf of actorUserID, which is empty when the key rotates itself.
+func (s *Service) rotateKey(ctx context.Context, actorUserID string, applicationRecord data.ApplicationRecord, keyID string, gracePeriod time.Duration, expiry expiryPolicy) (RotateAPIKeyResult, error) {
+	if applicationRecord.ArchivedAt != nil {
+		return RotateAPIKeyResult{}, ErrApplicationArchived
+	}
+	if gracePeriod < 0 || gracePeriod > MaxRotationGracePeriod {
+		return RotateAPIKeyResult{}, ErrInvalidGracePeriod
+	}
+
+	oldKey, found, err := s.store.GetAPIKeyByID(ctx, applicationRecord.ID, strings.TrimSpace(keyID))
+	if err != nil {
+		return RotateAPIKeyResult{}, fmt.Errorf("lookup API key: %w", err)
+	}
+	if !found || oldKey.RevokedAt != nil {
+		return RotateAPIKeyResult{}, ErrAPIKeyNotFound
+	}
+	if oldKey.ReplacedByKeyID != "" {
+		return RotateAPIKeyResult{}, ErrAPIKeyAlreadyRotated
+	}
+
+	now := s.now().UTC()
+	expiresAt := expiry(applicationRecord, oldKey, now)
+	if expiresAt != nil && !expiresAt.After(now) {
+		return RotateAPIKeyResult{}, ErrAPIKeyExpired
+	}
+	replacement, rawToken, err := s.newAPIKeyRecord(applicationRecord.ID, oldKey.Name, oldKey.Scopes, expiresAt, now)
+	if err != nil {
+		return RotateAPIKeyResult{}, err
+	}
+	replacement.RotatedFromKeyID = oldKey.ID
+	replacement.AllowedCIDRs = oldKey.AllowedCIDRs
+	replacement.RateLimitPerMinute = oldKey.RateLimitPerMinute
+
+	graceExpiresAt := now.Add(gracePeriod)
+	rotated, err := s.store.RotateAPIKey(ctx, oldKey, replacement, now, graceExpiresAt)
+	if err != nil {
+		return RotateAPIKeyResult{}, fmt.Errorf("rotate API key: %w", err)
+	}
+	if !rotated {
+		return RotateAPIKeyResult{}, ErrAPIKeyAlreadyRotated
+	}
+	if err := s.invalidateAuthCache(ctx); err != nil {
+		return RotateAPIKeyResult{}, err
+	}
+
+	oldKey.ReplacedByKeyID = replacement.ID
+	oldKey.RotatedAt = &now
+	oldKey.GraceExpiresAt = &graceExpiresAt
+
+	result := RotateAPIKeyResult{
+		RotatedKey: mapAPIKeyRecord(oldKey),
+		APIKey:     mapAPIKeyRecord(replacement),
+		Token:      rawToken,
+	}
+	s.recordEvent(ctx, actorUserID, applicationRecord, "api_key.rotated", oldKey.ID, map[string]audit.Change{
+		"replaced_by_key_id": {From: nil, To: replacement.ID},
+		"grace_expires_at":   {From: nil, To: graceExpiresAt},
+	})
+
+	return result, nil
 }
 
 func (s *Service) RevokeAPIKey(ctx context.Context, actor users.User, applicationID string, keyID string) error {
-	applicationRecord, err := s.getOwnedApplication(ctx, actor, applicationID, "api_keys.delete")
+	applicationRecord, err := s.getAuthorizedApplication(ctx, actor, applicationID, "api_keys.delete")
 	if err != nil {
 		return err
 	}
@@ -206,6 +694,11 @@ func (s *Service) RevokeAPIKey(ctx context.Context, actor users.User, applicatio
 	if !revoked {
 		return ErrAPIKeyNotFound
 	}
+	if err := s.invalidateAuthCache(ctx); err != nil {
+		return err
+	}
+
+	s.recordEvent(ctx, actor.ID, applicationRecord, "api_key.revoked", strings.TrimSpace(keyID), map[string]audit.Change{"revoked": {From: false, To: true}})
 
 	return nil
 }
@@ -216,25 +709,242 @@ func (s *Service) AuthenticateAPIKey(ctx context.Context, rawToken string) (APIK
 		return APIKeyPrincipal{}, ErrInvalidAPIKey
 	}
 
-	authRecord, found, err := s.store.GetActiveAPIKeyAuthByHash(ctx, security.DigestSHA256(rawToken))
+	now := s.now().UTC()
+	authRecord, found, err := s.lookupAPIKeyAuth(ctx, rawToken, now)
 	if err != nil {
-		return APIKeyPrincipal{}, fmt.Errorf("lookup API key by hash: %w", err)
+		return APIKeyPrincipal{}, err
 	}
 	if !found {
 		return APIKeyPrincipal{}, ErrInvalidAPIKey
 	}
 
-	_ = s.store.TouchAPIKeyLastUsed(ctx, authRecord.Key.ID, s.now().UTC())
+	if err := checkKeyUsable(authRecord.Key, now); err != nil {
+		return APIKeyPrincipal{}, err
+	}
+
+	return newAPIKeyPrincipal(authRecord.Application, authRecord.Key), nil
+}
+
+// lookupAPIKeyAuth finds the key for rawToken under its current or legacy hash and upgrades
+// legacy hashes on the way.
+func (s *Service) lookupAPIKeyAuth(ctx context.Context, rawToken string, now time.Time) (data.APIKeyAuthRecord, bool, error) {
+	digest := s.tokenHasher.Hash(rawToken)
+
+	var generation uint64
+	if s.authCache != nil {
+		var entry authCacheEntry
+		var cached bool
+		entry, cached, generation = s.authCache.get(digest.Hash, now)
+		if cached {
+			return entry.auth, entry.found, nil
+		}
+	}
+
+	authRecord, found, err := s.store.GetActiveAPIKeyAuthByHash(ctx, s.tokenHasher.CandidateHashes(rawToken)...)
+	if err != nil {
+		return data.APIKeyAuthRecord{}, false, fmt.Errorf("lookup API key by hash: %w", err)
+	}
+
+	if found && authRecord.Key.KeyHash != digest.Hash {
+		if err := s.store.UpdateAPIKeyHash(ctx, authRecord.Key.ID, authRecord.Key.KeyHash, digest.Hash, digest.Version); err != nil {
+			return data.APIKeyAuthRecord{}, false, fmt.Errorf("rehash API key: %w", err)
+		}
+		authRecord.Key.KeyHash = digest.Hash
+		authRecord.Key.HashVersion = digest.Version
+	}
+
+	if s.authCache != nil {
+		s.authCache.put(digest.Hash, authRecord, found, now, generation)
+	}
+
+	return authRecord, found, nil
+}
+
+// SyncAuthCache drops cached API key lookups when another replica has revoked or changed a
+// key since the last sync.
+func (s *Service) SyncAuthCache(ctx context.Context) error {
+	if s.authCache == nil {
+		return nil
+	}
+
+	version, err := s.store.GetAPIKeyRevocationVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("sync API key cache: %w", err)
+	}
+	s.authCache.sync(version)
+
+	return nil
+}
+
+// InvalidateAuthCache is for changes made outside this service that affect whether keys
+// authenticate, such as disabling the account that created an application.
+func (s *Service) InvalidateAuthCache(ctx context.Context) error {
+	return s.invalidateAuthCache(ctx)
+}
+
+// invalidateAuthCache must follow every change that affects how a key authenticates: the
+// local cache is cleared right away and other replicas notice the new revocation version on
+// their next sync.
+func (s *Service) invalidateAuthCache(ctx context.Context) error {
+	version, err := s.store.IncrementAPIKeyRevocationVersion(ctx)
+	if s.authCache != nil {
+		s.authCache.invalidate(version)
+	}
+	if err != nil {
+		return fmt.Errorf("invalidate API key cache: %w", err)
+	}
+
+	return nil
+}
+
+// checkKeyUsable reports why a stored key can no longer authenticate, if it cannot.
+func checkKeyUsable(key data.APIKeyRecord, now time.Time) error {
+	if key.RevokedAt != nil {
+		return ErrInvalidAPIKey
+	}
+	if key.GraceExpiresAt != nil && !key.GraceExpiresAt.After(now) {
+		// Rotated key past its grace period that the retirement job has not revoked yet.
+		return ErrInvalidAPIKey
+	}
+	if key.ExpiresAt != nil && !key.ExpiresAt.After(now) {
+		return ErrAPIKeyExpired
+	}
+
+	return nil
+}
 
+func newAPIKeyPrincipal(application data.ApplicationRecord, key data.APIKeyRecord) APIKeyPrincipal {
 	return APIKeyPrincipal{
-		KeyID:         authRecord.Key.ID,
-		ApplicationID: authRecord.Application.ID,
-		Permissions: APIKeyPermissions{
-			CanRead:   authRecord.Key.CanRead,
-			CanWrite:  authRecord.Key.CanWrite,
-			CanDelete: authRecord.Key.CanDelete,
-		},
-	}, nil
+		KeyID:              key.ID,
+		ApplicationID:      application.ID,
+		Scopes:             key.Scopes,
+		IPAllowlist:        newIPAllowlist(application, key),
+		RateLimitPerMinute: effectiveRateLimit(application, key),
+	}
+}
+
+func (s *Service) newAPIKeyRecord(applicationID string, name string, scopes []string, expiresAt *time.Time, now time.Time) (data.APIKeyRecord, string, error) {
+	rawToken, err := security.GeneratePrefixedToken("bka", 24)
+	if err != nil {
+		return data.APIKeyRecord{}, "", fmt.Errorf("generate API key token: %w", err)
+	}
+
+	keyID, err := security.GeneratePrefixedToken("key", 14)
+	if err != nil {
+		return data.APIKeyRecord{}, "", fmt.Errorf("generate API key id: %w", err)
+	}
+
+	keyPrefix := rawToken
+	if len(rawToken) > 12 {
+		keyPrefix = rawToken[:12]
+	}
+
+	digest := s.tokenHasher.Hash(rawToken)
+	return data.APIKeyRecord{
+		ID:            keyID,
+		ApplicationID: applicationID,
+		Name:          name,
+		KeyPrefix:     keyPrefix,
+		KeyHash:       digest.Hash,
+		HashVersion:   digest.Version,
+		Scopes:        scopes,
+		CreatedAt:     now,
+		ExpiresAt:     expiresAt,
+	}, rawToken, nil
+}
+
+func isValidRateLimit(rateLimitPerMinute int) bool {
+	return rateLimitPerMinute >= 0 && rateLimitPerMinute <= 100000
+}
+
+// effectiveRateLimit resolves the per-minute limit for a key: the key's own limit, then the
+// application's. Zero means the server-wide default applies.
+func effectiveRateLimit(application data.ApplicationRecord, key data.APIKeyRecord) int {
+	if key.RateLimitPerMinute > 0 {
+		return key.RateLimitPerMinute
+	}
+
+	return application.RateLimitPerMinute
+}
+
+// normalizeScopes validates requested scopes and returns them deduplicated in
+// authorization.AllScopes order.
+func normalizeScopes(requested []string) ([]string, error) {
+	for _, scope := range requested {
+		if !authorization.IsKnownScope(strings.TrimSpace(scope)) {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownAPIKeyScope, scope)
+		}
+	}
+
+	scopes := make([]string, 0, len(requested))
+	for _, scope := range authorization.AllScopes {
+		if slices.ContainsFunc(requested, func(candidate string) bool { return strings.TrimSpace(candidate) == scope }) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, ErrAPIKeyScopesRequired
+	}
+
+	return scopes, nil
+}
+
+// expiryPolicy picks the expiry of a rotated key's replacement.
+type expiryPolicy func(application data.ApplicationRecord, oldKey data.APIKeyRecord, now time.Time) *time.Time
+
+// replacementExpiry gives a rotated key the same lifetime as the key it replaces, clamped
+// to the application's current key lifetime policy.
+func replacementExpiry(application data.ApplicationRecord, oldKey data.APIKeyRecord, now time.Time) *time.Time {
+	var expiresAt *time.Time
+	if oldKey.ExpiresAt != nil {
+		renewed := now.Add(oldKey.ExpiresAt.Sub(oldKey.CreatedAt))
+		expiresAt = &renewed
+	}
+
+	if application.MaxAPIKeyLifetimeDays > 0 {
+		latestAllowed := now.Add(time.Duration(application.MaxAPIKeyLifetimeDays) * 24 * time.Hour)
+		if expiresAt == nil || expiresAt.After(latestAllowed) {
+			expiresAt = &latestAllowed
+		}
+	}
+
+	return expiresAt
+}
+
+// keptExpiry gives a rotated key the expiry of the key it replaces, clamped to the
+// application's current key lifetime policy counted from when the old key was created.
+func keptExpiry(application data.ApplicationRecord, oldKey data.APIKeyRecord, _ time.Time) *time.Time {
+	expiresAt := oldKey.ExpiresAt
+	if application.MaxAPIKeyLifetimeDays > 0 {
+		latestAllowed := oldKey.CreatedAt.Add(time.Duration(application.MaxAPIKeyLifetimeDays) * 24 * time.Hour)
+		if expiresAt == nil || expiresAt.After(latestAllowed) {
+			expiresAt = &latestAllowed
+		}
+	}
+
+	return expiresAt
+}
+
+func resolveAPIKeyExpiry(application data.ApplicationRecord, requested *time.Time, now time.Time) (*time.Time, error) {
+	if requested == nil {
+		if application.MaxAPIKeyLifetimeDays > 0 {
+			return nil, ErrAPIKeyExpiryRequired
+		}
+		return nil, nil
+	}
+
+	expiresAt := requested.UTC()
+	if !expiresAt.After(now) {
+		return nil, ErrAPIKeyExpiryInPast
+	}
+	if application.MaxAPIKeyLifetimeDays > 0 {
+		latestAllowed := now.Add(time.Duration(application.MaxAPIKeyLifetimeDays) * 24 * time.Hour)
+		if expiresAt.After(latestAllowed) {
+			return nil, ErrAPIKeyLifetimeTooLong
+		}
+	}
+
+	return &expiresAt, nil
 }
 
 func normalizeApplicationInput(input RegisterApplicationInput) (RegisterApplicationInput, error) {
@@ -310,7 +1020,9 @@ func isValidDomain(domain string) bool {
 	return true
 }
 
-func (s *Service) getOwnedApplication(ctx context.Context, actor users.User, applicationID string, action string) (data.ApplicationRecord, error) {
+// getAuthorizedApplication loads an application and checks the actor's role in the
+// organization that owns it. A missing application yields an empty record and no error.
+func (s *Service) getAuthorizedApplication(ctx context.Context, actor users.User, applicationID string, action string) (data.ApplicationRecord, error) {
 	applicationID = strings.TrimSpace(applicationID)
 	if applicationID == "" {
 		return data.ApplicationRecord{}, ErrApplicationNotFound
@@ -324,9 +1036,14 @@ func (s *Service) getOwnedApplication(ctx context.Context, actor users.User, app
 		return data.ApplicationRecord{}, nil
 	}
 
+	subject, err := authorization.NewOrganizationSubject(ctx, actor.ID, actor.IsAdmin(), record.OrganizationID, s.store.GetOrganizationMemberRole)
+	if err != nil {
+		return data.ApplicationRecord{}, err
+	}
+
 	isAllowed := s.webAuthorizer.Can(
-		toWebSubject(actor),
-		authorization.OwnedResource{OwnerUserID: record.OwnerUserID},
+		subject,
+		authorization.OrganizationResource{OrganizationID: record.OrganizationID},
 		action,
 	)
 	if !isAllowed {
@@ -336,42 +1053,105 @@ func (s *Service) getOwnedApplication(ctx context.Context, actor users.User, app
 	return record, nil
 }
 
+// authorizeNewApplication checks that the actor may add an application to organizationID, which
+// defaults to their personal organization, and returns the organization's ID.
+func (s *Service) authorizeNewApplication(ctx context.Context, actor users.User, organizationID string) (string, error) {
+	organizationID = strings.TrimSpace(organizationID)
+	if organizationID == "" {
+		organizationID = data.PersonalOrganizationID(actor.ID)
+	}
+	subject, err := authorization.NewOrganizationSubject(ctx, actor.ID, actor.IsAdmin(), organizationID, s.store.GetOrganizationMemberRole)
+	if err != nil {
+		return "", err
+	}
+	if !s.webAuthorizer.Can(subject, authorization.OrganizationResource{OrganizationID: organizationID}, "applications.create") {
+		return "", ErrForbidden
+	}
+	if _, isMember := subject.OrganizationRoles[organizationID]; !isMember {
+		// Site admins may add applications to any organization, as long as it exists.
+		_, found, err := s.store.GetOrganizationByID(ctx, organizationID)
+		if err != nil {
+			return "", fmt.Errorf("lookup organization by id: %w", err)
+		}
+		if !found {
+			return "", organizations.ErrOrganizationNotFound
+		}
+	}
+
+	return organizationID, nil
+}
+
+func (s *Service) checkApplicationLimit(ctx context.Context, organizationID string) error {
+	if s.maxApplicationsPerOrganization == 0 {
+		return nil
+	}
+
+	count, err := s.store.CountApplicationsByOrganizationID(ctx, organizationID)
+	if err != nil {
+		return err
+	}
+	if count >= s.maxApplicationsPerOrganization {
+		return ErrApplicationLimitReached
+	}
+
+	return nil
+}
+
 func mapApplicationRecord(record data.ApplicationRecord) Application {
 	return Application{
-		ID:          record.ID,
-		OwnerUserID: record.OwnerUserID,
-		Name:        record.Name,
-		Description: record.Description,
-		GitHubLink:  record.GitHubLink,
-		Domain:      record.Domain,
-		CreatedAt:   record.CreatedAt,
-		UpdatedAt:   record.UpdatedAt,
+		ID:                    record.ID,
+		OwnerUserID:           record.OwnerUserID,
+		OrganizationID:        record.OrganizationID,
+		Name:                  record.Name,
+		Description:           record.Description,
+		GitHubLink:            record.GitHubLink,
+		Domain:                record.Domain,
+		MaxAPIKeyLifetimeDays: record.MaxAPIKeyLifetimeDays,
+		AllowedCIDRs:          record.AllowedCIDRs,
+		RateLimitPerMinute:    record.RateLimitPerMinute,
+		CreatedAt:             record.CreatedAt,
+		UpdatedAt:             record.UpdatedAt,
+		ArchivedAt:            record.ArchivedAt,
 	}
 }
 
 func mapAPIKeyRecord(record data.APIKeyRecord) APIKey {
 	return APIKey{
-		ID:            record.ID,
-		ApplicationID: record.ApplicationID,
-		Name:          record.Name,
-		KeyPrefix:     record.KeyPrefix,
-		CanRead:       record.CanRead,
-		CanWrite:      record.CanWrite,
-		CanDelete:     record.CanDelete,
-		CreatedAt:     record.CreatedAt,
-		LastUsedAt:    record.LastUsedAt,
-		RevokedAt:     record.RevokedAt,
+		ID:                 record.ID,
+		ApplicationID:      record.ApplicationID,
+		Name:               record.Name,
+		KeyPrefix:          record.KeyPrefix,
+		Scopes:             record.Scopes,
+		CreatedAt:          record.CreatedAt,
+		LastUsedAt:         record.LastUsedAt,
+		RevokedAt:          record.RevokedAt,
+		ExpiresAt:          record.ExpiresAt,
+		RotatedFromKeyID:   record.RotatedFromKeyID,
+		ReplacedByKeyID:    record.ReplacedByKeyID,
+		RotatedAt:          record.RotatedAt,
+		GraceExpiresAt:     record.GraceExpiresAt,
+		AllowedCIDRs:       record.AllowedCIDRs,
+		RateLimitPerMinute: record.RateLimitPerMinute,
+		LastUsedIP:         record.LastUsedIP,
+		LastUsedUserAgent:  record.LastUsedUserAgent,
 	}
 }
 
-func toWebSubject(user users.User) authorization.WebSubject {
-	roles := []string{"user"}
-	if user.IsAdmin() {
-		roles = append(roles, "admin")
+// recordEvent adds an action on applicationRecord, or on its key keyID when set, to the audit
+// trail of the application's organization.
+func (s *Service) recordEvent(ctx context.Context, actorUserID string, applicationRecord data.ApplicationRecord, action string, keyID string, changes map[string]audit.Change) {
+	event := audit.Event{
+		ActorUserID:    actorUserID,
+		Action:         action,
+		TargetType:     "application",
+		TargetID:       applicationRecord.ID,
+		OrganizationID: applicationRecord.OrganizationID,
+		Changes:        changes,
 	}
-
-	return authorization.WebSubject{
-		UserID: user.ID,
-		Roles:  roles,
+	if keyID != "" {
+		event.TargetType = "api_key"
+		event.TargetID = keyID
 	}
+
+	s.audit.Record(ctx, event)
 }
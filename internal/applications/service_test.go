@@ -2,10 +2,21 @@ package applications
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/brian-nunez/bbaas-api/internal/audit"
 	"github.com/brian-nunez/bbaas-api/internal/authorization"
 	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/data/datatest"
+	"github.com/brian-nunez/bbaas-api/internal/mailer"
+	"github.com/brian-nunez/bbaas-api/internal/organizations"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+	"github.com/brian-nunez/bbaas-api/internal/usage"
 	"github.com/brian-nunez/bbaas-api/internal/users"
 )
 
@@ -13,9 +24,9 @@ func TestRegisterApplicationAndAPIKeyLifecycle(t *testing.T) {
 	t.Parallel()
 
 	ctx := context.Background()
-	store := setupStore(t)
-	usersService := users.NewService(store)
-	appsService := NewService(store, authorization.NewWebAuthorizer())
+	store := datatest.NewStore(t)
+	usersService := users.NewService(store, security.NewTokenHasher("test-pepper"), users.SessionPolicy{})
+	appsService := NewService(store, authorization.NewWebAuthorizer(), security.NewTokenHasher("test-pepper"))
 
 	user, err := usersService.Register(ctx, "builder@example.com", "password123")
 	if err != nil {
@@ -33,11 +44,8 @@ func TestRegisterApplicationAndAPIKeyLifecycle(t *testing.T) {
 	}
 
 	createdKey, err := appsService.CreateAPIKey(ctx, user, application.ID, CreateAPIKeyInput{
-		Name: "Primary",
-		Permissions: APIKeyPermissions{
-			CanRead:  true,
-			CanWrite: true,
-		},
+		Name:   "Primary",
+		Scopes: []string{authorization.ScopeBrowsersSpawn, authorization.ScopeBrowsersRead},
 	})
 	if err != nil {
 		t.Fatalf("create API key: %v", err)
@@ -53,8 +61,8 @@ func TestRegisterApplicationAndAPIKeyLifecycle(t *testing.T) {
 	if principal.ApplicationID != application.ID {
 		t.Fatalf("expected application id %s, got %s", application.ID, principal.ApplicationID)
 	}
-	if !principal.Permissions.CanWrite {
-		t.Fatalf("expected write permission")
+	if !slices.Equal(principal.Scopes, []string{authorization.ScopeBrowsersSpawn, authorization.ScopeBrowsersRead}) {
+		t.Fatalf("expected principal scopes to match the key, got %v", principal.Scopes)
 	}
 
 	if err := appsService.RevokeAPIKey(ctx, user, application.ID, createdKey.APIKey.ID); err != nil {
@@ -66,21 +74,815 @@ func TestRegisterApplicationAndAPIKeyLifecycle(t *testing.T) {
 	}
 }
 
-func setupStore(t *testing.T) *data.Store {
-	t.Helper()
+func TestOrganizationRolesGateApplicationAccess(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	webAuthorizer := authorization.NewWebAuthorizer()
+	usersService := users.NewService(store, security.NewTokenHasher("test-pepper"), users.SessionPolicy{})
+	appsService := NewService(store, webAuthorizer, security.NewTokenHasher("test-pepper"))
+	organizationsService := organizations.NewService(store, webAuthorizer)
+
+	accounts := make(map[string]users.User)
+	// The first account is the site admin; register it so the others are regular users.
+	for _, email := range []string{"root@example.com", "owner@example.com", "developer@example.com", "viewer@example.com", "outsider@example.com"} {
+		user, err := usersService.Register(ctx, email, "password123")
+		if err != nil {
+			t.Fatalf("register %s: %v", email, err)
+		}
+		accounts[email] = user
+	}
+	owner, developer, viewer, outsider := accounts["owner@example.com"], accounts["developer@example.com"], accounts["viewer@example.com"], accounts["outsider@example.com"]
+
+	team, err := organizationsService.Create(ctx, owner, "Team")
+	if err != nil {
+		t.Fatalf("create organization: %v", err)
+	}
+	for email, role := range map[string]string{
+		"developer@example.com": authorization.OrganizationRoleDeveloper,
+		"viewer@example.com":    authorization.OrganizationRoleViewer,
+	} {
+		if _, err := organizationsService.AddMember(ctx, owner, team.ID, email, role); err != nil {
+			t.Fatalf("add %s: %v", email, err)
+		}
+	}
+
+	input := RegisterApplicationInput{
+		OrganizationID: team.ID,
+		Name:           "Shared",
+		GitHubLink:     "https://github.com/example-org/shared",
+		Domain:         "example.com",
+	}
+	application, err := appsService.RegisterApplication(ctx, owner, input)
+	if err != nil {
+		t.Fatalf("register application: %v", err)
+	}
+	if application.OrganizationID != team.ID || application.OwnerUserID != owner.ID {
+		t.Fatalf("unexpected application %+v", application)
+	}
+	if _, err := appsService.RegisterApplication(ctx, viewer, input); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected viewers not to create applications, got %v", err)
+	}
+
+	keyInput := CreateAPIKeyInput{Name: "CI", Scopes: []string{authorization.ScopeBrowsersSpawn}}
+	createdKey, err := appsService.CreateAPIKey(ctx, developer, application.ID, keyInput)
+	if err != nil {
+		t.Fatalf("expected developers to create keys, got %v", err)
+	}
+	if _, err := appsService.CreateAPIKey(ctx, viewer, application.ID, keyInput); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected viewers not to create keys, got %v", err)
+	}
+	if err := appsService.RevokeAPIKey(ctx, viewer, application.ID, createdKey.APIKey.ID); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected viewers not to revoke keys, got %v", err)
+	}
+	keys, err := appsService.ListAPIKeysForApplication(ctx, viewer, application.ID)
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("expected viewers to see keys, got %d keys err=%v", len(keys), err)
+	}
+
+	if _, err := appsService.ListAPIKeysForApplication(ctx, outsider, application.ID); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected non-members to be forbidden, got %v", err)
+	}
+	if _, err := appsService.RegisterApplication(ctx, outsider, input); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected non-members not to create applications, got %v", err)
+	}
+
+	for _, member := range []users.User{owner, developer, viewer} {
+		visible, err := appsService.ListApplicationsForViewer(ctx, member)
+		if err != nil {
+			t.Fatalf("list applications: %v", err)
+		}
+		if len(visible) != 1 || visible[0].ID != application.ID {
+			t.Fatalf("expected %s to see the shared application, got %+v", member.Email, visible)
+		}
+	}
+
+	// Without an organization, applications go to the creator's personal organization.
+	personalApplication, err := appsService.RegisterApplication(ctx, outsider, RegisterApplicationInput{
+		Name:       "Solo",
+		GitHubLink: "https://github.com/example-org/solo",
+		Domain:     "example.com",
+	})
+	if err != nil {
+		t.Fatalf("register personal application: %v", err)
+	}
+	if personalApplication.OrganizationID != data.PersonalOrganizationID(outsider.ID) {
+		t.Fatalf("expected the personal organization, got %s", personalApplication.OrganizationID)
+	}
+}
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	appsService := NewService(store, authorization.NewWebAuthorizer(), security.NewTokenHasher("test-pepper"))
+	appsService.RequireVerifiedEmail(true)
+
+	admin, application := registerApplication(t, store, appsService, "admin@example.com")
+	if _, err := appsService.CreateAPIKey(ctx, admin, application.ID, CreateAPIKeyInput{
+		Name:   "Bootstrap",
+		Scopes: []string{authorization.ScopeBrowsersRead},
+	}); err != nil {
+		t.Fatalf("expected the verified bootstrap admin to create keys, got %v", err)
+	}
+
+	member, err := users.NewService(store, security.NewTokenHasher("test-pepper"), users.SessionPolicy{}).Register(ctx, "member@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	if _, err := appsService.RegisterApplication(ctx, member, RegisterApplicationInput{
+		Name:       "Unverified",
+		GitHubLink: "https://github.com/example-org/unverified",
+		Domain:     "example.com",
+	}); !errors.Is(err, ErrEmailNotVerified) {
+		t.Fatalf("expected unverified users to be blocked from creating applications, got %v", err)
+	}
+	if _, err := appsService.CreateAPIKey(ctx, member, application.ID, CreateAPIKeyInput{
+		Name:   "Unverified",
+		Scopes: []string{authorization.ScopeBrowsersRead},
+	}); !errors.Is(err, ErrEmailNotVerified) {
+		t.Fatalf("expected unverified users to be blocked from creating keys, got %v", err)
+	}
+
+	appsService.RequireVerifiedEmail(false)
+	if _, err := appsService.RegisterApplication(ctx, member, RegisterApplicationInput{
+		Name:       "Unverified",
+		GitHubLink: "https://github.com/example-org/unverified",
+		Domain:     "example.com",
+	}); err != nil {
+		t.Fatalf("expected creation to be allowed without the requirement, got %v", err)
+	}
+}
+
+func TestAPIKeyExpiry(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	appsService := NewService(store, authorization.NewWebAuthorizer(), security.NewTokenHasher("test-pepper"))
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	appsService.now = func() time.Time { return now }
+
+	user, application := registerApplication(t, store, appsService, "expiry@example.com")
+
+	inPast := now.Add(-time.Minute)
+	if _, err := appsService.CreateAPIKey(ctx, user, application.ID, CreateAPIKeyInput{
+		Name:      "Stale",
+		Scopes:    []string{authorization.ScopeBrowsersRead},
+		ExpiresAt: &inPast,
+	}); !errors.Is(err, ErrAPIKeyExpiryInPast) {
+		t.Fatalf("expected past expiry to be rejected, got %v", err)
+	}
+
+	expiresAt := now.Add(time.Hour)
+	createdKey, err := appsService.CreateAPIKey(ctx, user, application.ID, CreateAPIKeyInput{
+		Name:      "Short lived",
+		Scopes:    []string{authorization.ScopeBrowsersRead},
+		ExpiresAt: &expiresAt,
+	})
+	if err != nil {
+		t.Fatalf("create API key: %v", err)
+	}
+
+	if _, err := appsService.AuthenticateAPIKey(ctx, createdKey.Token); err != nil {
+		t.Fatalf("authenticate API key before expiry: %v", err)
+	}
+
+	now = expiresAt
+	if _, err := appsService.AuthenticateAPIKey(ctx, createdKey.Token); !errors.Is(err, ErrAPIKeyExpired) {
+		t.Fatalf("expected expired API key error, got %v", err)
+	}
+}
+
+func TestAPIKeyLifetimePolicy(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	appsService := NewService(store, authorization.NewWebAuthorizer(), security.NewTokenHasher("test-pepper"))
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	appsService.now = func() time.Time { return now }
+
+	user, application := registerApplication(t, store, appsService, "policy@example.com")
+
+	if _, err := appsService.UpdateAPIKeyPolicy(ctx, user, application.ID, 30); err != nil {
+		t.Fatalf("update API key policy: %v", err)
+	}
+
+	if _, err := appsService.CreateAPIKey(ctx, user, application.ID, CreateAPIKeyInput{
+		Name:   "Forever",
+		Scopes: []string{authorization.ScopeBrowsersRead},
+	}); !errors.Is(err, ErrAPIKeyExpiryRequired) {
+		t.Fatalf("expected non-expiring key to be rejected, got %v", err)
+	}
+
+	tooLate := now.Add(90 * 24 * time.Hour)
+	if _, err := appsService.CreateAPIKey(ctx, user, application.ID, CreateAPIKeyInput{
+		Name:      "Too long",
+		Scopes:    []string{authorization.ScopeBrowsersRead},
+		ExpiresAt: &tooLate,
+	}); !errors.Is(err, ErrAPIKeyLifetimeTooLong) {
+		t.Fatalf("expected key beyond policy to be rejected, got %v", err)
+	}
+
+	allowed := now.Add(30 * 24 * time.Hour)
+	if _, err := appsService.CreateAPIKey(ctx, user, application.ID, CreateAPIKeyInput{
+		Name:      "Within policy",
+		Scopes:    []string{authorization.ScopeBrowsersRead},
+		ExpiresAt: &allowed,
+	}); err != nil {
+		t.Fatalf("create API key within policy: %v", err)
+	}
+}
+
+func TestCreateAPIKeyScopes(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	appsService := NewService(store, authorization.NewWebAuthorizer(), security.NewTokenHasher("test-pepper"))
+	user, application := registerApplication(t, store, appsService, "scopes@example.com")
 
+	if _, err := appsService.CreateAPIKey(ctx, user, application.ID, CreateAPIKeyInput{Name: "Empty"}); !errors.Is(err, ErrAPIKeyScopesRequired) {
+		t.Fatalf("expected key without scopes to be rejected, got %v", err)
+	}
+	if _, err := appsService.CreateAPIKey(ctx, user, application.ID, CreateAPIKeyInput{
+		Name:   "Unknown",
+		Scopes: []string{authorization.ScopeBrowsersRead, "browsers:admin"},
+	}); !errors.Is(err, ErrUnknownAPIKeyScope) {
+		t.Fatalf("expected unknown scope to be rejected, got %v", err)
+	}
+
+	created, err := appsService.CreateAPIKey(ctx, user, application.ID, CreateAPIKeyInput{
+		Name:   "Usage reader",
+		Scopes: []string{authorization.ScopeUsageRead, authorization.ScopeBrowsersRead, authorization.ScopeUsageRead},
+	})
+	if err != nil {
+		t.Fatalf("create API key: %v", err)
+	}
+
+	principal, err := appsService.AuthenticateAPIKey(ctx, created.Token)
+	if err != nil {
+		t.Fatalf("authenticate API key: %v", err)
+	}
+	want := []string{authorization.ScopeBrowsersRead, authorization.ScopeUsageRead}
+	if !slices.Equal(principal.Scopes, want) {
+		t.Fatalf("expected deduplicated scopes %v, got %v", want, principal.Scopes)
+	}
+}
+
+func TestAPIKeyIPAllowlist(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	appsService := NewService(store, authorization.NewWebAuthorizer(), security.NewTokenHasher("test-pepper"))
+	recorder := audit.NewRecorder(store)
+	appsService.UseAuditRecorder(recorder)
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	appsService.now = func() time.Time { return now }
+	user, application := registerApplication(t, store, appsService, "allowlist@example.com")
+
+	created, err := appsService.CreateAPIKey(ctx, user, application.ID, CreateAPIKeyInput{
+		Name:   "CI",
+		Scopes: []string{authorization.ScopeBrowsersRead},
+	})
+	if err != nil {
+		t.Fatalf("create API key: %v", err)
+	}
+
+	if _, err := appsService.UpdateAPIKeyAllowedCIDRs(ctx, user, application.ID, created.APIKey.ID, "10.0.0.0/8, not-an-ip"); !errors.Is(err, ErrInvalidCIDR) {
+		t.Fatalf("expected invalid range to be rejected, got %v", err)
+	}
+	cidrs, err := appsService.UpdateAPIKeyAllowedCIDRs(ctx, user, application.ID, created.APIKey.ID, "10.1.2.3/8\n192.0.2.7")
+	if err != nil {
+		t.Fatalf("update API key allowed CIDRs: %v", err)
+	}
+	if !slices.Equal(cidrs, []string{"10.0.0.0/8", "192.0.2.7/32"}) {
+		t.Fatalf("expected canonical ranges, got %v", cidrs)
+	}
+	if _, err := appsService.UpdateAPIKeyAllowedCIDRs(ctx, user, application.ID, created.APIKey.ID, "10.1.2.3/8 192.0.2.7"); err != nil {
+		t.Fatalf("update API key allowed CIDRs: %v", err)
+	}
+	entries, err := recorder.Recent(ctx, 1)
+	if err != nil {
+		t.Fatalf("list audit events: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Action != "api_key.allowed_ips_updated" || fmt.Sprint(entries[0].Changes["allowed_cidrs"].From) != "[10.0.0.0/8 192.0.2.7/32]" {
+		t.Fatalf("expected the previous ranges to be audited, got %+v", entries)
+	}
+	if _, err := appsService.UpdateApplicationAllowedCIDRs(ctx, user, application.ID, "10.20.0.0/16 192.0.2.0/24"); err != nil {
+		t.Fatalf("update application allowed CIDRs: %v", err)
+	}
+
+	principal, err := appsService.AuthenticateAPIKey(ctx, created.Token)
+	if err != nil {
+		t.Fatalf("authenticate API key: %v", err)
+	}
+
+	for _, allowed := range []string{"10.20.1.1", "192.0.2.7", "::ffff:192.0.2.7"} {
+		if err := appsService.AuthorizeClientIP(ctx, principal, allowed); err != nil {
+			t.Fatalf("expected %s to be allowed: %v", allowed, err)
+		}
+	}
+	// 10.30.0.1 matches the key but not the application; 192.0.2.8 the application but not the key.
+	for _, denied := range []string{"10.30.0.1", "192.0.2.8", "garbage"} {
+		if err := appsService.AuthorizeClientIP(ctx, principal, denied); !errors.Is(err, ErrIPNotAllowed) {
+			t.Fatalf("expected %s to be denied, got %v", denied, err)
+		}
+	}
+
+	denials, err := appsService.ListIPDenialsForApplication(ctx, user, application.ID, 10)
+	if err != nil {
+		t.Fatalf("list IP denials: %v", err)
+	}
+	if len(denials) != 3 || denials[0].APIKeyID != created.APIKey.ID {
+		t.Fatalf("expected three recorded denials for the key, got %+v", denials)
+	}
+
+	// Repeated denials from the same address are counted instead of stored one by one.
+	if err := appsService.AuthorizeClientIP(ctx, principal, "10.30.0.1"); !errors.Is(err, ErrIPNotAllowed) {
+		t.Fatalf("expected 10.30.0.1 to be denied, got %v", err)
+	}
+	denials, err = appsService.ListIPDenialsForApplication(ctx, user, application.ID, 10)
+	if err != nil {
+		t.Fatalf("list IP denials: %v", err)
+	}
+	if len(denials) != 3 || denials[0].SourceIP != "10.30.0.1" || denials[0].Attempts != 2 {
+		t.Fatalf("expected the repeated denial to be counted, got %+v", denials)
+	}
+
+	now = now.Add(IPDenialRetention + time.Minute)
+	deleted, err := appsService.DeleteOldIPDenials(ctx)
+	if err != nil {
+		t.Fatalf("delete old IP denials: %v", err)
+	}
+	if deleted != 3 {
+		t.Fatalf("expected three old denials to be deleted, got %d", deleted)
+	}
+}
+
+func TestAuditFailureKeepsMutation(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	appsService := NewService(store, authorization.NewWebAuthorizer(), security.NewTokenHasher("test-pepper"))
+	appsService.UseAuditRecorder(audit.NewRecorder(store))
+	user, application := registerApplication(t, store, appsService, "audit-failure@example.com")
+
+	// A second connection to the same in-memory database breaks the audit trail underneath the store.
 	db, _, err := data.Open(data.Config{
 		Driver: "sqlite",
-		DSN:    "file::memory:?cache=shared",
+		DSN:    datatest.DSN(t),
 	})
 	if err != nil {
 		t.Fatalf("open test database: %v", err)
 	}
-	t.Cleanup(func() { _ = db.Close() })
+	defer db.Close()
+	if _, err := db.ExecContext(ctx, `DROP TABLE audit_events`); err != nil {
+		t.Fatalf("drop audit events: %v", err)
+	}
+
+	created, err := appsService.CreateAPIKey(ctx, user, application.ID, CreateAPIKeyInput{
+		Name:   "CI",
+		Scopes: []string{authorization.ScopeBrowsersRead},
+	})
+	if err != nil {
+		t.Fatalf("expected the key to be created despite the audit failure, got %v", err)
+	}
+	if _, err := appsService.AuthenticateAPIKey(ctx, created.Token); err != nil {
+		t.Fatalf("authenticate API key: %v", err)
+	}
+}
+
+func TestAPIKeyRateLimitResolution(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	appsService := NewService(store, authorization.NewWebAuthorizer(), security.NewTokenHasher("test-pepper"))
+	user, application := registerApplication(t, store, appsService, "ratelimit@example.com")
+
+	inherited, err := appsService.CreateAPIKey(ctx, user, application.ID, CreateAPIKeyInput{
+		Name:   "Inherited",
+		Scopes: []string{authorization.ScopeBrowsersRead},
+	})
+	if err != nil {
+		t.Fatalf("create inherited key: %v", err)
+	}
+	overridden, err := appsService.CreateAPIKey(ctx, user, application.ID, CreateAPIKeyInput{
+		Name:               "Overridden",
+		Scopes:             []string{authorization.ScopeBrowsersRead},
+		RateLimitPerMinute: 10,
+	})
+	if err != nil {
+		t.Fatalf("create overridden key: %v", err)
+	}
+
+	if _, err := appsService.UpdateRateLimit(ctx, user, application.ID, -1); !errors.Is(err, ErrInvalidRateLimit) {
+		t.Fatalf("expected negative rate limit to be rejected, got %v", err)
+	}
+
+	assertRateLimit := func(token string, want int) {
+		t.Helper()
+		principal, err := appsService.AuthenticateAPIKey(ctx, token)
+		if err != nil {
+			t.Fatalf("authenticate API key: %v", err)
+		}
+		if principal.RateLimitPerMinute != want {
+			t.Fatalf("expected effective rate limit %d, got %d", want, principal.RateLimitPerMinute)
+		}
+	}
+
+	assertRateLimit(inherited.Token, 0)
+	assertRateLimit(overridden.Token, 10)
+
+	if _, err := appsService.UpdateRateLimit(ctx, user, application.ID, 600); err != nil {
+		t.Fatalf("update rate limit: %v", err)
+	}
+	assertRateLimit(inherited.Token, 600)
+	assertRateLimit(overridden.Token, 10)
+}
 
-	if err := data.RunMigrations(context.Background(), db); err != nil {
-		t.Fatalf("run migrations: %v", err)
+func TestExpiryNotifierNotifiesOwnersOnce(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	appsService := NewService(store, authorization.NewWebAuthorizer(), security.NewTokenHasher("test-pepper"))
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	appsService.now = func() time.Time { return now }
+
+	user, application := registerApplication(t, store, appsService, "notify@example.com")
+	for name, expiresIn := range map[string]time.Duration{"Soon": 3 * 24 * time.Hour, "Later": 60 * 24 * time.Hour} {
+		expiresAt := now.Add(expiresIn)
+		if _, err := appsService.CreateAPIKey(ctx, user, application.ID, CreateAPIKeyInput{
+			Name:      name,
+			Scopes:    []string{authorization.ScopeBrowsersRead},
+			ExpiresAt: &expiresAt,
+		}); err != nil {
+			t.Fatalf("create API key %s: %v", name, err)
+		}
+	}
+
+	outbox := &recordingMailer{}
+	notifier := NewExpiryNotifier(store, outbox, 7*24*time.Hour)
+	notifier.now = func() time.Time { return now }
+
+	for range 2 {
+		if _, err := notifier.NotifyExpiringKeys(ctx); err != nil {
+			t.Fatalf("notify expiring keys: %v", err)
+		}
+	}
+
+	if len(outbox.messages) != 1 {
+		t.Fatalf("expected exactly one notification, got %d", len(outbox.messages))
+	}
+	if outbox.messages[0].To[0] != "notify@example.com" || !strings.Contains(outbox.messages[0].Subject, "Soon") {
+		t.Fatalf("unexpected notification %+v", outbox.messages[0])
+	}
+}
+
+func TestRotateAPIKeyWithGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	appsService := NewService(store, authorization.NewWebAuthorizer(), security.NewTokenHasher("test-pepper"))
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	appsService.now = func() time.Time { return now }
+
+	user, application := registerApplication(t, store, appsService, "rotate@example.com")
+	original, err := appsService.CreateAPIKey(ctx, user, application.ID, CreateAPIKeyInput{
+		Name:   "Deploy",
+		Scopes: []string{authorization.ScopeBrowsersRead, authorization.ScopeBrowsersSpawn},
+	})
+	if err != nil {
+		t.Fatalf("create API key: %v", err)
+	}
+
+	rotated, err := appsService.RotateAPIKey(ctx, user, application.ID, original.APIKey.ID, time.Hour)
+	if err != nil {
+		t.Fatalf("rotate API key: %v", err)
+	}
+	if rotated.APIKey.Name != "Deploy" || !slices.Equal(rotated.APIKey.Scopes, original.APIKey.Scopes) {
+		t.Fatalf("expected replacement to copy name and scopes, got %+v", rotated.APIKey)
+	}
+	if rotated.APIKey.RotatedFromKeyID != original.APIKey.ID || rotated.RotatedKey.ReplacedByKeyID != rotated.APIKey.ID {
+		t.Fatalf("expected keys to be linked, got %+v / %+v", rotated.APIKey, rotated.RotatedKey)
+	}
+
+	if _, err := appsService.RotateAPIKey(ctx, user, application.ID, original.APIKey.ID, time.Hour); !errors.Is(err, ErrAPIKeyAlreadyRotated) {
+		t.Fatalf("expected second rotation to fail, got %v", err)
+	}
+
+	now = now.Add(30 * time.Minute)
+	if _, err := appsService.AuthenticateAPIKey(ctx, original.Token); err != nil {
+		t.Fatalf("expected old key to work during grace period: %v", err)
+	}
+	if _, err := appsService.AuthenticateAPIKey(ctx, rotated.Token); err != nil {
+		t.Fatalf("authenticate replacement key: %v", err)
+	}
+
+	// Last use is recorded by the usage aggregator, not by authentication itself.
+	aggregator := usage.NewAggregator(store)
+	aggregator.Record(usage.Event{APIKeyID: original.APIKey.ID, Endpoint: "GET /api/v1/browsers", ClientIP: "192.0.2.7", At: now})
+	if _, err := aggregator.Flush(ctx); err != nil {
+		t.Fatalf("flush usage: %v", err)
+	}
+
+	principal := APIKeyPrincipal{KeyID: rotated.APIKey.ID, ApplicationID: application.ID}
+	oldKey, err := appsService.GetAPIKeyForPrincipal(ctx, principal, original.APIKey.ID)
+	if err != nil {
+		t.Fatalf("get rotated key: %v", err)
+	}
+	if !oldKey.UsedDuringGrace() {
+		t.Fatalf("expected old key to report use during grace period")
+	}
+
+	now = now.Add(time.Hour)
+	if _, err := appsService.AuthenticateAPIKey(ctx, original.Token); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Fatalf("expected old key to be rejected after grace period, got %v", err)
+	}
+
+	retired, err := appsService.RetireRotatedAPIKeys(ctx)
+	if err != nil {
+		t.Fatalf("retire rotated keys: %v", err)
+	}
+	if retired != 1 {
+		t.Fatalf("expected one retired key, got %d", retired)
+	}
+
+	oldKey, err = appsService.GetAPIKeyForPrincipal(ctx, principal, original.APIKey.ID)
+	if err != nil {
+		t.Fatalf("get retired key: %v", err)
+	}
+	if oldKey.RevokedAt == nil {
+		t.Fatalf("expected retired key to be revoked")
+	}
+}
+
+func TestRotateAPIKeyForPrincipalKeepsExpiry(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	appsService := NewService(store, authorization.NewWebAuthorizer(), security.NewTokenHasher("test-pepper"))
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	appsService.now = func() time.Time { return now }
+
+	user, application := registerApplication(t, store, appsService, "self-rotate@example.com")
+	expiresAt := now.Add(30 * 24 * time.Hour)
+	original, err := appsService.CreateAPIKey(ctx, user, application.ID, CreateAPIKeyInput{
+		Name:      "CI",
+		Scopes:    []string{authorization.ScopeBrowsersRead, authorization.ScopeAPIKeysRotate},
+		ExpiresAt: &expiresAt,
+	})
+	if err != nil {
+		t.Fatalf("create API key: %v", err)
+	}
+	unscoped, err := appsService.CreateAPIKey(ctx, user, application.ID, CreateAPIKeyInput{
+		Name:   "Reader",
+		Scopes: []string{authorization.ScopeBrowsersRead},
+	})
+	if err != nil {
+		t.Fatalf("create API key: %v", err)
+	}
+
+	unscopedPrincipal, err := appsService.AuthenticateAPIKey(ctx, unscoped.Token)
+	if err != nil {
+		t.Fatalf("authenticate API key: %v", err)
+	}
+	var missingScopesError *authorization.MissingScopesError
+	if _, err := appsService.RotateAPIKeyForPrincipal(ctx, unscopedPrincipal, 0); !errors.As(err, &missingScopesError) || !slices.Equal(missingScopesError.Scopes, []string{authorization.ScopeAPIKeysRotate}) {
+		t.Fatalf("expected the api_keys:rotate scope to be required, got %v", err)
+	}
+	if _, err := appsService.GetAPIKeyForPrincipal(ctx, unscopedPrincipal, original.APIKey.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Fatalf("expected a sibling key to be hidden, got %v", err)
+	}
+
+	now = now.Add(20 * 24 * time.Hour)
+	principal, err := appsService.AuthenticateAPIKey(ctx, original.Token)
+	if err != nil {
+		t.Fatalf("authenticate API key: %v", err)
+	}
+
+	rotated, err := appsService.RotateAPIKeyForPrincipal(ctx, principal, 0)
+	if err != nil {
+		t.Fatalf("rotate API key: %v", err)
+	}
+	if rotated.APIKey.ExpiresAt == nil || !rotated.APIKey.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("expected replacement to keep expiry %s, got %v", expiresAt, rotated.APIKey.ExpiresAt)
+	}
+	if _, err := appsService.AuthenticateAPIKey(ctx, original.Token); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Fatalf("expected zero grace period to retire the old key immediately, got %v", err)
+	}
+
+	if _, err := appsService.ArchiveApplication(ctx, user, application.ID); err != nil {
+		t.Fatalf("archive application: %v", err)
+	}
+	if _, err := appsService.RotateAPIKey(ctx, user, application.ID, rotated.APIKey.ID, 0); !errors.Is(err, ErrApplicationArchived) {
+		t.Fatalf("expected ErrApplicationArchived, got %v", err)
+	}
+}
+
+func TestLegacyAPIKeyHashesAreUpgraded(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	legacyService := NewService(store, authorization.NewWebAuthorizer(), security.NewTokenHasher(""))
+	appsService := NewService(store, authorization.NewWebAuthorizer(), security.NewTokenHasher("test-pepper"))
+	user, application := registerApplication(t, store, legacyService, "legacy-hash@example.com")
+
+	created, err := legacyService.CreateAPIKey(ctx, user, application.ID, CreateAPIKeyInput{
+		Name:   "Legacy",
+		Scopes: []string{authorization.ScopeBrowsersRead},
+	})
+	if err != nil {
+		t.Fatalf("create API key: %v", err)
+	}
+
+	keyRecord, _, err := store.GetAPIKeyByID(ctx, application.ID, created.APIKey.ID)
+	if err != nil {
+		t.Fatalf("lookup API key: %v", err)
+	}
+	if keyRecord.HashVersion != security.HashVersionSHA256 || keyRecord.KeyHash != security.DigestSHA256(created.Token) {
+		t.Fatalf("expected an unpeppered service to store a legacy hash, got version %d", keyRecord.HashVersion)
+	}
+
+	if _, err := appsService.AuthenticateAPIKey(ctx, created.Token); err != nil {
+		t.Fatalf("authenticate legacy API key: %v", err)
+	}
+
+	keyRecord, _, err = store.GetAPIKeyByID(ctx, application.ID, created.APIKey.ID)
+	if err != nil {
+		t.Fatalf("lookup API key: %v", err)
+	}
+	if keyRecord.HashVersion != security.HashVersionHMACSHA256 || keyRecord.KeyHash == security.DigestSHA256(created.Token) {
+		t.Fatalf("expected the key to be rehashed on use, got version %d", keyRecord.HashVersion)
+	}
+	if _, found, err := store.GetActiveAPIKeyAuthByHash(ctx, security.DigestSHA256(created.Token)); err != nil || found {
+		t.Fatalf("expected the bare SHA-256 digest to no longer match, got found=%v err=%v", found, err)
+	}
+
+	// A fresh replica without a cached lookup finds the key under its new hash.
+	replica := NewService(store, authorization.NewWebAuthorizer(), security.NewTokenHasher("test-pepper"))
+	if _, err := replica.AuthenticateAPIKey(ctx, created.Token); err != nil {
+		t.Fatalf("authenticate rehashed API key: %v", err)
+	}
+}
+
+func TestUpdateArchiveAndDeleteApplication(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	webAuthorizer := authorization.NewWebAuthorizer()
+	usersService := users.NewService(store, security.NewTokenHasher("test-pepper"), users.SessionPolicy{})
+	appsService := NewService(store, webAuthorizer, security.NewTokenHasher("test-pepper"))
+	organizationsService := organizations.NewService(store, webAuthorizer)
+	closer := &recordingBrowserCloser{}
+	appsService.UseBrowserCloser(closer)
+
+	if _, err := usersService.Register(ctx, "root@example.com", "password123"); err != nil {
+		t.Fatalf("register admin: %v", err)
+	}
+	owner, err := usersService.Register(ctx, "owner@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register owner: %v", err)
+	}
+	developer, err := usersService.Register(ctx, "developer@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register developer: %v", err)
+	}
+	team, err := organizationsService.Create(ctx, owner, "Team")
+	if err != nil {
+		t.Fatalf("create organization: %v", err)
+	}
+	if _, err := organizationsService.AddMember(ctx, owner, team.ID, developer.Email, authorization.OrganizationRoleDeveloper); err != nil {
+		t.Fatalf("add developer: %v", err)
+	}
+
+	application, err := appsService.RegisterApplication(ctx, owner, RegisterApplicationInput{
+		OrganizationID: team.ID,
+		Name:           "Runner",
+		GitHubLink:     "https://github.com/example-org/runner",
+		Domain:         "example.com",
+	})
+	if err != nil {
+		t.Fatalf("register application: %v", err)
+	}
+	createdKey, err := appsService.CreateAPIKey(ctx, developer, application.ID, CreateAPIKeyInput{Name: "CI", Scopes: authorization.AllScopes})
+	if err != nil {
+		t.Fatalf("create API key: %v", err)
+	}
+
+	if _, err := appsService.UpdateApplication(ctx, developer, application.ID, UpdateApplicationInput{
+		Name:       "Runner",
+		GitHubLink: "https://gitlab.com/example-org/runner",
+		Domain:     "example.com",
+	}); !errors.Is(err, ErrInvalidGitHubLink) {
+		t.Fatalf("expected ErrInvalidGitHubLink, got %v", err)
+	}
+	updated, err := appsService.UpdateApplication(ctx, developer, application.ID, UpdateApplicationInput{
+		Name:        " Nightly runner ",
+		Description: "Runs every night",
+		GitHubLink:  "https://github.com/example-org/nightly",
+		Domain:      "Nightly.Example.com",
+	})
+	if err != nil {
+		t.Fatalf("update application: %v", err)
+	}
+	if updated.Name != "Nightly runner" || updated.Domain != "nightly.example.com" || updated.Description != "Runs every night" {
+		t.Fatalf("unexpected updated application %+v", updated)
+	}
+
+	archived, err := appsService.ArchiveApplication(ctx, developer, application.ID)
+	if err != nil {
+		t.Fatalf("archive application: %v", err)
+	}
+	if !archived.IsArchived() {
+		t.Fatalf("expected the application to be archived")
+	}
+	if _, err := appsService.ArchiveApplication(ctx, developer, application.ID); !errors.Is(err, ErrApplicationArchived) {
+		t.Fatalf("expected ErrApplicationArchived, got %v", err)
+	}
+	if _, err := appsService.CreateAPIKey(ctx, developer, application.ID, CreateAPIKeyInput{Name: "Late", Scopes: authorization.AllScopes}); !errors.Is(err, ErrApplicationArchived) {
+		t.Fatalf("expected archived applications to refuse new keys, got %v", err)
+	}
+	if _, err := appsService.AuthenticateAPIKey(ctx, createdKey.Token); err != nil {
+		t.Fatalf("expected keys of archived applications to keep authenticating, got %v", err)
+	}
+	restored, err := appsService.RestoreApplication(ctx, developer, application.ID)
+	if err != nil {
+		t.Fatalf("restore application: %v", err)
+	}
+	if restored.IsArchived() {
+		t.Fatalf("expected the application to be restored")
+	}
+
+	if err := appsService.DeleteApplication(ctx, developer, application.ID, "Nightly runner"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected developers not to delete applications, got %v", err)
+	}
+	if err := appsService.DeleteApplication(ctx, owner, application.ID, "Runner"); !errors.Is(err, ErrDeleteNameMismatch) {
+		t.Fatalf("expected ErrDeleteNameMismatch, got %v", err)
+	}
+	if _, err := appsService.AuthenticateAPIKey(ctx, createdKey.Token); err != nil {
+		t.Fatalf("expected a refused deletion to leave keys working, got %v", err)
+	}
+	if err := appsService.DeleteApplication(ctx, owner, application.ID, "Nightly runner"); err != nil {
+		t.Fatalf("delete application: %v", err)
+	}
+	if !slices.Equal(closer.closed, []string{application.ID}) {
+		t.Fatalf("expected the application's browsers to be closed, closed %v", closer.closed)
+	}
+	if _, err := appsService.AuthenticateAPIKey(ctx, createdKey.Token); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Fatalf("expected the deleted application's key to stop working, got %v", err)
+	}
+	if _, found, err := store.GetApplicationByID(ctx, application.ID); err != nil || found {
+		t.Fatalf("expected the application to be gone, found=%v err=%v", found, err)
+	}
+}
+
+type recordingBrowserCloser struct {
+	closed []string
+}
+
+func (c *recordingBrowserCloser) CloseForApplication(ctx context.Context, applicationID string) (int, error) {
+	c.closed = append(c.closed, applicationID)
+	return 0, nil
+}
+
+type recordingMailer struct {
+	messages []mailer.Message
+}
+
+func (m *recordingMailer) Send(ctx context.Context, message mailer.Message) error {
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+func registerApplication(t testing.TB, store *data.Store, appsService *Service, email string) (users.User, Application) {
+	t.Helper()
+
+	ctx := context.Background()
+	user, err := users.NewService(store, security.NewTokenHasher("test-pepper"), users.SessionPolicy{}).Register(ctx, email, "password123")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+
+	application, err := appsService.RegisterApplication(ctx, user, RegisterApplicationInput{
+		Name:       "CDP Suite",
+		GitHubLink: "https://github.com/example-org/cdp-suite",
+		Domain:     "example.com",
+	})
+	if err != nil {
+		t.Fatalf("register application: %v", err)
 	}
 
-	return data.NewStore(db)
+	return user, application
 }
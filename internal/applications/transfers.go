@@ -0,0 +1,260 @@
+package applications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/audit"
+	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+	"github.com/brian-nunez/bbaas-api/internal/users"
+)
+
+// TransferTTL is how long a recipient has to accept an application transfer.
+const TransferTTL = 7 * 24 * time.Hour
+
+var (
+	ErrTransferRecipientNotFound = errors.New("no user is registered with that email address")
+	ErrTransferNotFound          = errors.New("application transfer not found or expired")
+)
+
+// StartTransfer offers the application to the user registered with recipientEmail. Nothing
+// changes until they accept; starting another transfer of the same application replaces this one.
+func (s *Service) StartTransfer(ctx context.Context, actor users.User, applicationID string, recipientEmail string) (Transfer, error) {
+	applicationRecord, err := s.getAuthorizedApplication(ctx, actor, applicationID, "applications.transfer")
+	if err != nil {
+		return Transfer{}, err
+	}
+	if applicationRecord.ID == "" {
+		return Transfer{}, ErrApplicationNotFound
+	}
+
+	recipient, found, err := s.store.GetUserByEmail(ctx, strings.ToLower(strings.TrimSpace(recipientEmail)))
+	if err != nil {
+		return Transfer{}, fmt.Errorf("lookup user by email: %w", err)
+	}
+	if !found {
+		return Transfer{}, ErrTransferRecipientNotFound
+	}
+
+	transferID, err := security.GeneratePrefixedToken("apt", 12)
+	if err != nil {
+		return Transfer{}, fmt.Errorf("generate transfer id: %w", err)
+	}
+
+	now := s.now().UTC()
+	record := data.ApplicationTransferRecord{
+		ID:                 transferID,
+		ApplicationID:      applicationRecord.ID,
+		FromOrganizationID: applicationRecord.OrganizationID,
+		InitiatedByUserID:  actor.ID,
+		RecipientUserID:    recipient.ID,
+		ExpiresAt:          now.Add(TransferTTL),
+		CreatedAt:          now,
+		ApplicationName:    applicationRecord.Name,
+		InitiatedByEmail:   actor.Email,
+		RecipientEmail:     recipient.Email,
+	}
+	if err := s.store.SaveApplicationTransfer(ctx, record); err != nil {
+		return Transfer{}, err
+	}
+
+	s.recordEvent(ctx, actor.ID, applicationRecord, "application.transfer_started", "", map[string]audit.Change{
+		"recipient": {From: nil, To: recipient.Email},
+	})
+
+	return TransferFromRecord(record), nil
+}
+
+// CancelTransfer withdraws a pending transfer on behalf of the application's organization.
+func (s *Service) CancelTransfer(ctx context.Context, actor users.User, transferID string) error {
+	transfer, err := s.getTransfer(ctx, transferID)
+	if err != nil {
+		return err
+	}
+	applicationRecord, err := s.getAuthorizedApplication(ctx, actor, transfer.ApplicationID, "applications.transfer")
+	if err != nil {
+		return err
+	}
+	if applicationRecord.ID == "" {
+		return ErrTransferNotFound
+	}
+
+	if err := s.deleteTransfer(ctx, transfer.ID); err != nil {
+		return err
+	}
+
+	s.recordEvent(ctx, actor.ID, applicationRecord, "application.transfer_cancelled", "", map[string]audit.Change{
+		"recipient": {From: transfer.RecipientEmail, To: nil},
+	})
+
+	return nil
+}
+
+// DeclineTransfer lets the recipient turn a transfer down.
+func (s *Service) DeclineTransfer(ctx context.Context, actor users.User, transferID string) error {
+	transfer, err := s.getTransfer(ctx, transferID)
+	if err != nil {
+		return err
+	}
+	if transfer.RecipientUserID != actor.ID {
+		return ErrTransferNotFound
+	}
+
+	if err := s.deleteTransfer(ctx, transfer.ID); err != nil {
+		return err
+	}
+
+	applicationRecord := data.ApplicationRecord{ID: transfer.ApplicationID, OrganizationID: transfer.FromOrganizationID}
+	s.recordEvent(ctx, actor.ID, applicationRecord, "application.transfer_declined", "", map[string]audit.Change{
+		"recipient": {From: transfer.RecipientEmail, To: nil},
+	})
+
+	return nil
+}
+
+// AcceptTransfer moves the application into organizationID, or the recipient's personal
+// organization when it is empty, and makes the recipient its owner. The recipient needs the
+// same rights as for creating an application there, and the organization's application limit
+// applies. The application keeps its ID, keys, usage and browser history, so clients carry on
+// without rotating keys.
+func (s *Service) AcceptTransfer(ctx context.Context, actor users.User, transferID string, organizationID string) (Application, error) {
+	if s.requireVerifiedEmail && !actor.IsVerified() {
+		return Application{}, ErrEmailNotVerified
+	}
+
+	transfer, err := s.getTransfer(ctx, transferID)
+	if err != nil {
+		return Application{}, err
+	}
+	if transfer.RecipientUserID != actor.ID {
+		return Application{}, ErrTransferNotFound
+	}
+
+	organizationID, err = s.authorizeNewApplication(ctx, actor, organizationID)
+	if err != nil {
+		return Application{}, err
+	}
+	if organizationID != transfer.FromOrganizationID {
+		if err := s.checkApplicationLimit(ctx, organizationID); err != nil {
+			return Application{}, err
+		}
+	}
+
+	applicationRecord, found, err := s.store.GetApplicationByID(ctx, transfer.ApplicationID)
+	if err != nil {
+		return Application{}, fmt.Errorf("lookup application by id: %w", err)
+	}
+	if !found {
+		return Application{}, ErrTransferNotFound
+	}
+
+	now := s.now().UTC()
+	_, accepted, err := s.store.AcceptApplicationTransfer(ctx, transfer.ID, actor.ID, organizationID, s.maxApplicationsPerOrganization, now)
+	if err != nil {
+		return Application{}, err
+	}
+	if !accepted {
+		// Another application may have taken the last slot since the check above.
+		if organizationID != transfer.FromOrganizationID {
+			if err := s.checkApplicationLimit(ctx, organizationID); err != nil {
+				return Application{}, err
+			}
+		}
+		return Application{}, ErrTransferNotFound
+	}
+
+	// Cached keys were checked against the old organization's members; the new organization
+	// decides whether they stay active.
+	if err := s.invalidateAuthCache(ctx); err != nil {
+		return Application{}, err
+	}
+
+	previous := applicationRecord
+	applicationRecord.OrganizationID = organizationID
+	applicationRecord.OwnerUserID = actor.ID
+	applicationRecord.UpdatedAt = now
+
+	changes := map[string]audit.Change{
+		"owner_user_id": {From: previous.OwnerUserID, To: actor.ID},
+	}
+	if previous.OrganizationID != organizationID {
+		changes["organization_id"] = audit.Change{From: previous.OrganizationID, To: organizationID}
+		// Record the move in the organization it left as well, so its owners can see where it went.
+		s.recordEvent(ctx, actor.ID, previous, "application.transferred", "", changes)
+	}
+
+	s.recordEvent(ctx, actor.ID, applicationRecord, "application.transferred", "", changes)
+
+	return mapApplicationRecord(applicationRecord), nil
+}
+
+// ListTransfersForViewer returns pending transfers sent to the actor or of applications in
+// their organizations.
+func (s *Service) ListTransfersForViewer(ctx context.Context, actor users.User) ([]Transfer, error) {
+	records, err := s.store.ListApplicationTransfersForUser(ctx, actor.ID, s.now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	transfers := make([]Transfer, 0, len(records))
+	for _, record := range records {
+		transfers = append(transfers, TransferFromRecord(record))
+	}
+
+	return transfers, nil
+}
+
+// DeleteExpiredTransfers removes transfers nobody accepted in time.
+func (s *Service) DeleteExpiredTransfers(ctx context.Context) (int, error) {
+	return s.store.DeleteExpiredApplicationTransfers(ctx, s.now().UTC())
+}
+
+// getTransfer returns an unexpired transfer.
+func (s *Service) getTransfer(ctx context.Context, transferID string) (data.ApplicationTransferRecord, error) {
+	transferID = strings.TrimSpace(transferID)
+	if transferID == "" {
+		return data.ApplicationTransferRecord{}, ErrTransferNotFound
+	}
+
+	record, found, err := s.store.GetApplicationTransferByID(ctx, transferID)
+	if err != nil {
+		return data.ApplicationTransferRecord{}, err
+	}
+	if !found || !s.now().Before(record.ExpiresAt) {
+		return data.ApplicationTransferRecord{}, ErrTransferNotFound
+	}
+
+	return record, nil
+}
+
+func (s *Service) deleteTransfer(ctx context.Context, transferID string) error {
+	deleted, err := s.store.DeleteApplicationTransfer(ctx, transferID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrTransferNotFound
+	}
+
+	return nil
+}
+
+// TransferFromRecord maps a stored transfer, e.g. for listings outside this package.
+func TransferFromRecord(record data.ApplicationTransferRecord) Transfer {
+	return Transfer{
+		ID:                 record.ID,
+		ApplicationID:      record.ApplicationID,
+		ApplicationName:    record.ApplicationName,
+		FromOrganizationID: record.FromOrganizationID,
+		InitiatedByUserID:  record.InitiatedByUserID,
+		InitiatedByEmail:   record.InitiatedByEmail,
+		RecipientUserID:    record.RecipientUserID,
+		RecipientEmail:     record.RecipientEmail,
+		ExpiresAt:          record.ExpiresAt,
+		CreatedAt:          record.CreatedAt,
+	}
+}
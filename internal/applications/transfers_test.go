@@ -0,0 +1,161 @@
+package applications
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/audit"
+	"github.com/brian-nunez/bbaas-api/internal/authorization"
+	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/data/datatest"
+	"github.com/brian-nunez/bbaas-api/internal/organizations"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+	"github.com/brian-nunez/bbaas-api/internal/users"
+)
+
+func TestApplicationTransfer(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	webAuthorizer := authorization.NewWebAuthorizer()
+	usersService := users.NewService(store, security.NewTokenHasher("test-pepper"), users.SessionPolicy{})
+	appsService := NewService(store, webAuthorizer, security.NewTokenHasher("test-pepper"))
+	recorder := audit.NewRecorder(store)
+	appsService.UseAuditRecorder(recorder)
+	appsService.LimitApplicationsPerOrganization(1)
+	organizationsService := organizations.NewService(store, webAuthorizer)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	appsService.now = func() time.Time { return now }
+
+	if _, err := usersService.Register(ctx, "root@example.com", "password123"); err != nil {
+		t.Fatalf("register admin: %v", err)
+	}
+	owner, err := usersService.Register(ctx, "owner@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register owner: %v", err)
+	}
+	recipient, err := usersService.Register(ctx, "recipient@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register recipient: %v", err)
+	}
+	stranger, err := usersService.Register(ctx, "stranger@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register stranger: %v", err)
+	}
+
+	input := RegisterApplicationInput{Name: "Runner", GitHubLink: "https://github.com/example-org/runner", Domain: "example.com"}
+	application, err := appsService.RegisterApplication(ctx, owner, input)
+	if err != nil {
+		t.Fatalf("register application: %v", err)
+	}
+	if _, err := appsService.RegisterApplication(ctx, owner, input); !errors.Is(err, ErrApplicationLimitReached) {
+		t.Fatalf("expected ErrApplicationLimitReached, got %v", err)
+	}
+	if _, err := appsService.RegisterApplication(ctx, recipient, input); err != nil {
+		t.Fatalf("register recipient application: %v", err)
+	}
+	createdKey, err := appsService.CreateAPIKey(ctx, owner, application.ID, CreateAPIKeyInput{Name: "CI", Scopes: authorization.AllScopes})
+	if err != nil {
+		t.Fatalf("create API key: %v", err)
+	}
+
+	if _, err := appsService.StartTransfer(ctx, stranger, application.ID, recipient.Email); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+	if _, err := appsService.StartTransfer(ctx, owner, application.ID, "nobody@example.com"); !errors.Is(err, ErrTransferRecipientNotFound) {
+		t.Fatalf("expected ErrTransferRecipientNotFound, got %v", err)
+	}
+	transfer, err := appsService.StartTransfer(ctx, owner, application.ID, " Recipient@Example.com ")
+	if err != nil {
+		t.Fatalf("start transfer: %v", err)
+	}
+	if transfer.RecipientUserID != recipient.ID || transfer.ApplicationName != "Runner" {
+		t.Fatalf("unexpected transfer %+v", transfer)
+	}
+
+	if _, err := appsService.AcceptTransfer(ctx, stranger, transfer.ID, ""); !errors.Is(err, ErrTransferNotFound) {
+		t.Fatalf("expected a transfer to someone else to be hidden, got %v", err)
+	}
+	if _, err := appsService.AcceptTransfer(ctx, recipient, transfer.ID, ""); !errors.Is(err, ErrApplicationLimitReached) {
+		t.Fatalf("expected the recipient's full personal organization to be refused, got %v", err)
+	}
+	if _, err := appsService.AcceptTransfer(ctx, recipient, transfer.ID, data.PersonalOrganizationID(owner.ID)); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden for an organization the recipient is not in, got %v", err)
+	}
+	incoming, err := appsService.ListTransfersForViewer(ctx, recipient)
+	if err != nil {
+		t.Fatalf("list transfers: %v", err)
+	}
+	if len(incoming) != 1 || incoming[0].ID != transfer.ID {
+		t.Fatalf("expected the refused transfer to stay pending, got %+v", incoming)
+	}
+
+	team, err := organizationsService.Create(ctx, recipient, "Team")
+	if err != nil {
+		t.Fatalf("create organization: %v", err)
+	}
+	moved, err := appsService.AcceptTransfer(ctx, recipient, transfer.ID, team.ID)
+	if err != nil {
+		t.Fatalf("accept transfer: %v", err)
+	}
+	if moved.ID != application.ID || moved.OrganizationID != team.ID || moved.OwnerUserID != recipient.ID {
+		t.Fatalf("unexpected transferred application %+v", moved)
+	}
+	principal, err := appsService.AuthenticateAPIKey(ctx, createdKey.Token)
+	if err != nil {
+		t.Fatalf("expected the existing key to keep working, got %v", err)
+	}
+	if principal.ApplicationID != application.ID {
+		t.Fatalf("unexpected principal %+v", principal)
+	}
+	if _, err := appsService.ListAPIKeysForApplication(ctx, owner, application.ID); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected the previous owner to lose access, got %v", err)
+	}
+	if _, err := appsService.AcceptTransfer(ctx, recipient, transfer.ID, team.ID); !errors.Is(err, ErrTransferNotFound) {
+		t.Fatalf("expected an accepted transfer to be used up, got %v", err)
+	}
+
+	entries, err := recorder.Recent(ctx, 10)
+	if err != nil {
+		t.Fatalf("list audit events: %v", err)
+	}
+	transferredIn := map[string]bool{}
+	for _, entry := range entries {
+		if entry.Action == "application.transferred" {
+			transferredIn[entry.OrganizationID] = true
+		}
+	}
+	if !transferredIn[team.ID] || !transferredIn[data.PersonalOrganizationID(owner.ID)] {
+		t.Fatalf("expected the transfer to be audited in both organizations, got %v", transferredIn)
+	}
+
+	declined, err := appsService.StartTransfer(ctx, recipient, application.ID, owner.Email)
+	if err != nil {
+		t.Fatalf("start transfer: %v", err)
+	}
+	if err := appsService.DeclineTransfer(ctx, recipient, declined.ID); !errors.Is(err, ErrTransferNotFound) {
+		t.Fatalf("expected only the recipient to decline, got %v", err)
+	}
+	if err := appsService.DeclineTransfer(ctx, owner, declined.ID); err != nil {
+		t.Fatalf("decline transfer: %v", err)
+	}
+
+	expiring, err := appsService.StartTransfer(ctx, recipient, application.ID, owner.Email)
+	if err != nil {
+		t.Fatalf("start transfer: %v", err)
+	}
+	now = now.Add(TransferTTL)
+	if _, err := appsService.AcceptTransfer(ctx, owner, expiring.ID, ""); !errors.Is(err, ErrTransferNotFound) {
+		t.Fatalf("expected an expired transfer to be rejected, got %v", err)
+	}
+	deleted, err := appsService.DeleteExpiredTransfers(ctx)
+	if err != nil {
+		t.Fatalf("delete expired transfers: %v", err)
+	}
+	if deleted != 1 {
+		t.Fatalf("expected the expired transfer to be deleted, deleted %d", deleted)
+	}
+}
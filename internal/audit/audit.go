@@ -0,0 +1,214 @@
+// Package audit records who changed what, and from where, in an append-only trail.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+)
+
+// Request describes the web request an audited action came from.
+type Request struct {
+	IP        string
+	UserAgent string
+	RequestID string
+}
+
+type requestContextKey struct{}
+
+// WithRequest attaches request to ctx so events recorded further down pick it up.
+func WithRequest(ctx context.Context, request Request) context.Context {
+	return context.WithValue(ctx, requestContextKey{}, request)
+}
+
+func RequestFromContext(ctx context.Context) Request {
+	request, _ := ctx.Value(requestContextKey{}).(Request)
+	return request
+}
+
+// Change is one field's value before and after an action.
+type Change struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+type Event struct {
+	// ActorUserID is empty when the action was taken with an API key.
+	ActorUserID string
+	Action      string
+	TargetType  string
+	TargetID    string
+	// OrganizationID lets the organization's owners and admins see the event; empty means
+	// only site admins do.
+	OrganizationID string
+	// Changes is keyed by field name; it may be nil.
+	Changes map[string]Change
+}
+
+// Entry is a recorded event as listed back.
+type Entry struct {
+	ID             string            `json:"id"`
+	ActorUserID    string            `json:"actorUserId"`
+	ActorEmail     string            `json:"actorEmail"`
+	Action         string            `json:"action"`
+	TargetType     string            `json:"targetType"`
+	TargetID       string            `json:"targetId"`
+	OrganizationID string            `json:"organizationId"`
+	IP             string            `json:"ip"`
+	UserAgent      string            `json:"userAgent"`
+	RequestID      string            `json:"requestId"`
+	Changes        map[string]Change `json:"changes"`
+	CreatedAt      time.Time         `json:"createdAt"`
+}
+
+// Filter narrows a search of the trail; see data.AuditEventFilter.
+type Filter = data.AuditEventFilter
+
+type Recorder struct {
+	store *data.Store
+	now   func() time.Time
+}
+
+func NewRecorder(store *data.Store) *Recorder {
+	return &Recorder{
+		store: store,
+		now:   time.Now,
+	}
+}
+
+// Record appends event to the trail, stamped with the request found in ctx. A nil Recorder
+// records nothing. The action being audited has already happened by the time it is recorded,
+// so a failure to record it is logged rather than returned.
+func (r *Recorder) Record(ctx context.Context, event Event) {
+	if r == nil {
+		return
+	}
+
+	if err := r.record(ctx, event); err != nil {
+		log.Printf("record audit event %s on %s %s: %v", event.Action, event.TargetType, event.TargetID, err)
+	}
+}
+
+func (r *Recorder) record(ctx context.Context, event Event) error {
+	eventID, err := security.GeneratePrefixedToken("aud", 12)
+	if err != nil {
+		return fmt.Errorf("generate audit event id: %w", err)
+	}
+
+	changes := event.Changes
+	if changes == nil {
+		changes = map[string]Change{}
+	}
+	encodedChanges, err := json.Marshal(changes)
+	if err != nil {
+		return fmt.Errorf("encode audit changes: %w", err)
+	}
+
+	request := RequestFromContext(ctx)
+	if err := r.store.CreateAuditEvent(ctx, data.AuditEventRecord{
+		ID:             eventID,
+		ActorUserID:    event.ActorUserID,
+		Action:         event.Action,
+		TargetType:     event.TargetType,
+		TargetID:       event.TargetID,
+		OrganizationID: event.OrganizationID,
+		IP:             request.IP,
+		UserAgent:      request.UserAgent,
+		RequestID:      request.RequestID,
+		Changes:        string(encodedChanges),
+		CreatedAt:      r.now().UTC(),
+	}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Recent returns the latest events, newest first. Callers authorize the request.
+func (r *Recorder) Recent(ctx context.Context, limit int) ([]Entry, error) {
+	records, err := r.store.ListAuditEvents(ctx, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list audit events: %w", err)
+	}
+
+	return mapEntries(records)
+}
+
+// Search returns one page of the events matching filter, newest first, and how many match
+// in total. Callers authorize the request.
+func (r *Recorder) Search(ctx context.Context, filter Filter, limit int, offset int) ([]Entry, int, error) {
+	records, total, err := r.store.SearchAuditEvents(ctx, filter, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("search audit events: %w", err)
+	}
+
+	entries, err := mapEntries(records)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return entries, total, nil
+}
+
+// Export writes every event matching filter to w as JSON Lines, oldest first, and returns
+// how many it wrote. Callers authorize the request.
+func (r *Recorder) Export(ctx context.Context, filter Filter, w io.Writer) (int, error) {
+	encoder := json.NewEncoder(w)
+	exported := 0
+	err := r.store.EachAuditEvent(ctx, filter, func(record data.AuditEventRecord) error {
+		entry, err := mapEntry(record)
+		if err != nil {
+			return err
+		}
+		if err := encoder.Encode(entry); err != nil {
+			return fmt.Errorf("write audit event %s: %w", record.ID, err)
+		}
+		exported++
+		return nil
+	})
+	if err != nil {
+		return exported, fmt.Errorf("export audit events: %w", err)
+	}
+
+	return exported, nil
+}
+
+func mapEntries(records []data.AuditEventRecord) ([]Entry, error) {
+	entries := make([]Entry, 0, len(records))
+	for _, record := range records {
+		entry, err := mapEntry(record)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func mapEntry(record data.AuditEventRecord) (Entry, error) {
+	entry := Entry{
+		ID:             record.ID,
+		ActorUserID:    record.ActorUserID,
+		ActorEmail:     record.ActorEmail,
+		Action:         record.Action,
+		TargetType:     record.TargetType,
+		TargetID:       record.TargetID,
+		OrganizationID: record.OrganizationID,
+		IP:             record.IP,
+		UserAgent:      record.UserAgent,
+		RequestID:      record.RequestID,
+		CreatedAt:      record.CreatedAt,
+	}
+	if err := json.Unmarshal([]byte(record.Changes), &entry.Changes); err != nil {
+		return Entry{}, fmt.Errorf("decode changes of audit event %s: %w", record.ID, err)
+	}
+
+	return entry, nil
+}
@@ -0,0 +1,188 @@
+// Package auditlog lets site admins, and the owners and admins of each organization, search
+// and export the audit trail.
+package auditlog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/audit"
+	"github.com/brian-nunez/bbaas-api/internal/authorization"
+	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/users"
+)
+
+const PageSize = 50
+
+var ErrForbidden = errors.New("forbidden")
+
+// Query filters the trail. Empty fields match everything; Until is exclusive.
+type Query struct {
+	OrganizationID string
+	ActorEmail     string
+	// Action matches actions starting with it, so "api_key." finds every key event.
+	Action   string
+	TargetID string
+	Since    *time.Time
+	Until    *time.Time
+	// Page starts at 1.
+	Page int
+}
+
+// Organization is one organization whose events the actor may read.
+type Organization struct {
+	ID       string
+	Name     string
+	Personal bool
+}
+
+type Result struct {
+	Query   Query
+	Total   int
+	Entries []audit.Entry
+	// Organizations lists what the actor can filter by; SiteWide is set for site admins, who
+	// also see events outside any organization.
+	Organizations []Organization
+	SiteWide      bool
+}
+
+func (r Result) HasPrevious() bool {
+	return r.Query.Page > 1
+}
+
+func (r Result) HasNext() bool {
+	return r.Query.Page*PageSize < r.Total
+}
+
+type Service struct {
+	store         *data.Store
+	recorder      *audit.Recorder
+	webAuthorizer *authorization.WebAuthorizer
+}
+
+func NewService(store *data.Store, recorder *audit.Recorder, webAuthorizer *authorization.WebAuthorizer) *Service {
+	return &Service{
+		store:         store,
+		recorder:      recorder,
+		webAuthorizer: webAuthorizer,
+	}
+}
+
+// Search returns one page of the events the actor may read that match query, newest first.
+func (s *Service) Search(ctx context.Context, actor users.User, query Query) (Result, error) {
+	scope, err := s.scope(ctx, actor)
+	if err != nil {
+		return Result{}, err
+	}
+	filter, err := scope.filter(query)
+	if err != nil {
+		return Result{}, err
+	}
+	if query.Page < 1 {
+		query.Page = 1
+	}
+
+	entries, total, err := s.recorder.Search(ctx, filter, PageSize, (query.Page-1)*PageSize)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		Query:         query,
+		Total:         total,
+		Entries:       entries,
+		Organizations: scope.organizations,
+		SiteWide:      scope.siteWide,
+	}, nil
+}
+
+// Export writes every event the actor may read that matches query to w as JSON Lines,
+// oldest first. Nothing is written when the actor may not read the trail.
+func (s *Service) Export(ctx context.Context, actor users.User, query Query, w io.Writer) (int, error) {
+	scope, err := s.scope(ctx, actor)
+	if err != nil {
+		return 0, err
+	}
+	filter, err := scope.filter(query)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.recorder.Export(ctx, filter, w)
+}
+
+type scope struct {
+	siteWide      bool
+	organizations []Organization
+}
+
+func (sc scope) filter(query Query) (audit.Filter, error) {
+	filter := audit.Filter{
+		ActorEmail: strings.TrimSpace(query.ActorEmail),
+		Action:     strings.TrimSpace(query.Action),
+		TargetID:   strings.TrimSpace(query.TargetID),
+		Since:      query.Since,
+		Until:      query.Until,
+	}
+
+	organizationIDs := make([]string, 0, len(sc.organizations))
+	for _, organization := range sc.organizations {
+		organizationIDs = append(organizationIDs, organization.ID)
+	}
+
+	organizationID := strings.TrimSpace(query.OrganizationID)
+	switch {
+	case organizationID != "":
+		if !sc.siteWide && !slices.Contains(organizationIDs, organizationID) {
+			return audit.Filter{}, ErrForbidden
+		}
+		filter.OrganizationIDs = []string{organizationID}
+	case !sc.siteWide:
+		filter.OrganizationIDs = organizationIDs
+	}
+
+	return filter, nil
+}
+
+// scope works out which events the actor may read. Actors who may read none get ErrForbidden.
+func (s *Service) scope(ctx context.Context, actor users.User) (scope, error) {
+	roles := []string{"user"}
+	if actor.IsAdmin() {
+		roles = append(roles, "admin")
+	}
+
+	memberships, err := s.store.ListOrganizationsByUserID(ctx, actor.ID)
+	if err != nil {
+		return scope{}, fmt.Errorf("list organizations for user: %w", err)
+	}
+
+	result := scope{
+		siteWide: s.webAuthorizer.Can(authorization.WebSubject{UserID: actor.ID, Roles: roles}, authorization.OrganizationResource{}, "admin.audit.read"),
+	}
+	for _, membership := range memberships {
+		subject := authorization.WebSubject{
+			UserID:            actor.ID,
+			Roles:             roles,
+			OrganizationRoles: map[string]string{membership.Organization.ID: membership.Role},
+		}
+		if !s.webAuthorizer.Can(subject, authorization.OrganizationResource{OrganizationID: membership.Organization.ID}, "audit.read") {
+			continue
+		}
+		result.organizations = append(result.organizations, Organization{
+			ID:       membership.Organization.ID,
+			Name:     membership.Organization.Name,
+			Personal: membership.Organization.PersonalUserID != "",
+		})
+	}
+
+	if !result.siteWide && len(result.organizations) == 0 {
+		return scope{}, ErrForbidden
+	}
+
+	return result, nil
+}
@@ -0,0 +1,141 @@
+package auditlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/brian-nunez/bbaas-api/internal/applications"
+	"github.com/brian-nunez/bbaas-api/internal/audit"
+	"github.com/brian-nunez/bbaas-api/internal/authorization"
+	"github.com/brian-nunez/bbaas-api/internal/data/datatest"
+	"github.com/brian-nunez/bbaas-api/internal/organizations"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+	"github.com/brian-nunez/bbaas-api/internal/users"
+)
+
+func TestSearchAndExportAreScopedToOrganizations(t *testing.T) {
+	t.Parallel()
+
+	ctx := audit.WithRequest(context.Background(), audit.Request{IP: "198.51.100.4", UserAgent: "test", RequestID: "req-7"})
+	store := datatest.NewStore(t)
+	recorder := audit.NewRecorder(store)
+	webAuthorizer := authorization.NewWebAuthorizer()
+	usersService := users.NewService(store, security.NewTokenHasher("test-pepper"), users.SessionPolicy{})
+	usersService.UseAuditRecorder(recorder)
+	appsService := applications.NewService(store, webAuthorizer, security.NewTokenHasher("test-pepper"))
+	appsService.UseAuditRecorder(recorder)
+	orgsService := organizations.NewService(store, webAuthorizer)
+	orgsService.UseAuditRecorder(recorder)
+	service := NewService(store, recorder, webAuthorizer)
+
+	root, err := usersService.Register(ctx, "root@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register admin: %v", err)
+	}
+	owner, err := usersService.Register(ctx, "owner@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	developer, err := usersService.Register(ctx, "developer@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+
+	team, err := orgsService.Create(ctx, owner, "Team")
+	if err != nil {
+		t.Fatalf("create organization: %v", err)
+	}
+	if _, err := orgsService.AddMember(ctx, owner, team.ID, developer.Email, authorization.OrganizationRoleDeveloper); err != nil {
+		t.Fatalf("add member: %v", err)
+	}
+	application, err := appsService.RegisterApplication(ctx, developer, applications.RegisterApplicationInput{
+		Name:           "Runner",
+		GitHubLink:     "https://github.com/example-org",
+		Domain:         "example.com",
+		OrganizationID: team.ID,
+	})
+	if err != nil {
+		t.Fatalf("register application: %v", err)
+	}
+	createdKey, err := appsService.CreateAPIKey(ctx, developer, application.ID, applications.CreateAPIKeyInput{
+		Name:   "Primary",
+		Scopes: authorization.AllScopes,
+	})
+	if err != nil {
+		t.Fatalf("create API key: %v", err)
+	}
+	if err := appsService.RevokeAPIKey(ctx, developer, application.ID, createdKey.APIKey.ID); err != nil {
+		t.Fatalf("revoke API key: %v", err)
+	}
+	if _, err := usersService.RevokeAllSessions(ctx, developer); err != nil {
+		t.Fatalf("revoke sessions: %v", err)
+	}
+
+	if _, err := service.Search(ctx, developer, Query{OrganizationID: team.ID}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected developers to be kept out of the organization's trail, got %v", err)
+	}
+	own, err := service.Search(ctx, developer, Query{})
+	if err != nil {
+		t.Fatalf("search own events: %v", err)
+	}
+	if own.Total != 1 || own.Entries[0].Action != "user.sessions_revoked" || own.SiteWide {
+		t.Fatalf("expected only the developer's own account events, got %+v", own.Entries)
+	}
+
+	keyEvents, err := service.Search(ctx, owner, Query{OrganizationID: team.ID, Action: "api_key."})
+	if err != nil {
+		t.Fatalf("search key events: %v", err)
+	}
+	if keyEvents.Total != 2 || keyEvents.Entries[0].Action != "api_key.revoked" || keyEvents.Entries[1].Action != "api_key.created" {
+		t.Fatalf("unexpected key events %+v", keyEvents.Entries)
+	}
+	revoked := keyEvents.Entries[0]
+	if revoked.ActorEmail != developer.Email || revoked.TargetID != createdKey.APIKey.ID || revoked.OrganizationID != team.ID {
+		t.Fatalf("unexpected revocation entry %+v", revoked)
+	}
+	if revoked.IP != "198.51.100.4" || revoked.RequestID != "req-7" || revoked.Changes["revoked"].To != true {
+		t.Fatalf("expected the request and diff to be recorded, got %+v", revoked)
+	}
+
+	ownerView, err := service.Search(ctx, owner, Query{})
+	if err != nil {
+		t.Fatalf("search owner events: %v", err)
+	}
+	for _, entry := range ownerView.Entries {
+		if entry.OrganizationID != team.ID {
+			t.Fatalf("expected the owner to only see the team's events, got %+v", entry)
+		}
+	}
+	if ownerView.Total != 5 {
+		t.Fatalf("expected 5 team events, got %d", ownerView.Total)
+	}
+
+	var exported bytes.Buffer
+	count, err := service.Export(ctx, owner, Query{OrganizationID: team.ID}, &exported)
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(exported.String()), "\n")
+	if count != 5 || len(lines) != 5 {
+		t.Fatalf("expected 5 exported events, got %d (%d lines)", count, len(lines))
+	}
+	var first audit.Entry
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("decode exported line: %v", err)
+	}
+	if first.Action != "organization.created" || first.ActorEmail != owner.Email {
+		t.Fatalf("expected the export to start with the oldest event, got %+v", first)
+	}
+
+	everything, err := service.Search(ctx, root, Query{})
+	if err != nil {
+		t.Fatalf("search as site admin: %v", err)
+	}
+	if !everything.SiteWide || everything.Total != 6 {
+		t.Fatalf("expected site admins to see every event, got %d", everything.Total)
+	}
+}
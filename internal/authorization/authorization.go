@@ -1,58 +1,190 @@
 package authorization
 
-import baccess "github.com/brian-nunez/baccess"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	baccess "github.com/brian-nunez/baccess"
+)
+
+const (
+	OrganizationRoleOwner     = "owner"
+	OrganizationRoleAdmin     = "admin"
+	OrganizationRoleDeveloper = "developer"
+	OrganizationRoleViewer    = "viewer"
+)
+
+// OrganizationRoles lists every organization membership role, most privileged first.
+var OrganizationRoles = []string{
+	OrganizationRoleOwner,
+	OrganizationRoleAdmin,
+	OrganizationRoleDeveloper,
+	OrganizationRoleViewer,
+}
+
+func IsOrganizationRole(role string) bool {
+	return slices.Contains(OrganizationRoles, role)
+}
 
 type WebSubject struct {
 	UserID string
 	Roles  []string
+	// OrganizationRoles maps organization IDs to the subject's membership role. It only needs
+	// to hold the organization of the resource being checked.
+	OrganizationRoles map[string]string
 }
 
 func (s WebSubject) GetRoles() []string {
 	return s.Roles
 }
 
-type OwnedResource struct {
-	OwnerUserID string
+// MemberRoleLookup returns a user's membership role in an organization, if they have one.
+type MemberRoleLookup func(ctx context.Context, organizationID string, userID string) (string, bool, error)
+
+// NewOrganizationSubject describes a user together with their role in organizationID, if any.
+// Site admins also get the "admin" role.
+func NewOrganizationSubject(ctx context.Context, userID string, isAdmin bool, organizationID string, lookupRole MemberRoleLookup) (WebSubject, error) {
+	roles := []string{"user"}
+	if isAdmin {
+		roles = append(roles, "admin")
+	}
+
+	subject := WebSubject{
+		UserID:            userID,
+		Roles:             roles,
+		OrganizationRoles: map[string]string{},
+	}
+
+	role, found, err := lookupRole(ctx, organizationID, userID)
+	if err != nil {
+		return WebSubject{}, fmt.Errorf("lookup organization role: %w", err)
+	}
+	if found {
+		subject.OrganizationRoles[organizationID] = role
+	}
+
+	return subject, nil
+}
+
+type OrganizationResource struct {
+	OrganizationID string
 }
 
 type WebAuthorizer struct {
-	evaluator *baccess.Evaluator[WebSubject, OwnedResource]
+	evaluator *baccess.Evaluator[WebSubject, OrganizationResource]
 }
 
 func NewWebAuthorizer() *WebAuthorizer {
-	evaluator := baccess.NewEvaluator[WebSubject, OwnedResource]()
-	rbac := baccess.NewRBAC[WebSubject, OwnedResource]()
+	evaluator := baccess.NewEvaluator[WebSubject, OrganizationResource]()
+	rbac := baccess.NewRBAC[WebSubject, OrganizationResource]()
 
 	adminRole := rbac.HasRole("admin")
 	userRole := rbac.HasRole("user")
-	ownerOnly := baccess.FieldEquals(
-		func(subject WebSubject) string { return subject.UserID },
-		func(resource OwnedResource) string { return resource.OwnerUserID },
-	)
-
-	evaluator.AddPolicy("applications.create", adminRole.Or(userRole))
-	evaluator.AddPolicy("applications.read", adminRole.Or(userRole.And(ownerOnly)))
-	evaluator.AddPolicy("api_keys.create", adminRole.Or(userRole.And(ownerOnly)))
-	evaluator.AddPolicy("api_keys.delete", adminRole.Or(userRole.And(ownerOnly)))
+	anyMember := memberRole(OrganizationRoles...)
+	developers := memberRole(OrganizationRoleOwner, OrganizationRoleAdmin, OrganizationRoleDeveloper)
+	managers := memberRole(OrganizationRoleOwner, OrganizationRoleAdmin)
+	owners := memberRole(OrganizationRoleOwner)
+
+	evaluator.AddPolicy("applications.create", adminRole.Or(userRole.And(developers)))
+	evaluator.AddPolicy("applications.read", adminRole.Or(userRole.And(anyMember)))
+	evaluator.AddPolicy("applications.update", adminRole.Or(userRole.And(developers)))
+	evaluator.AddPolicy("applications.archive", adminRole.Or(userRole.And(developers)))
+	// Deleting an application throws away its keys and history, so it is left to managers.
+	evaluator.AddPolicy("applications.delete", adminRole.Or(userRole.And(managers)))
+	// Transferring hands the application and its keys to someone else, so it is left to managers.
+	evaluator.AddPolicy("applications.transfer", adminRole.Or(userRole.And(managers)))
+	evaluator.AddPolicy("api_keys.create", adminRole.Or(userRole.And(developers)))
+	evaluator.AddPolicy("api_keys.update", adminRole.Or(userRole.And(developers)))
+	evaluator.AddPolicy("api_keys.rotate", adminRole.Or(userRole.And(developers)))
+	evaluator.AddPolicy("api_keys.delete", adminRole.Or(userRole.And(developers)))
+	evaluator.AddPolicy("organizations.read", adminRole.Or(userRole.And(anyMember)))
+	evaluator.AddPolicy("organizations.members.manage", adminRole.Or(userRole.And(managers)))
+	// Only owners may grant or take away ownership.
+	evaluator.AddPolicy("organizations.owners.manage", adminRole.Or(userRole.And(owners)))
 	evaluator.AddPolicy("users.read", adminRole.Or(userRole))
+	evaluator.AddPolicy("admin.users.read", adminRole)
+	evaluator.AddPolicy("admin.users.update_role", adminRole)
+	evaluator.AddPolicy("admin.users.disable", adminRole)
+	evaluator.AddPolicy("admin.users.logout", adminRole)
+	evaluator.AddPolicy("admin.applications.read", adminRole)
+	evaluator.AddPolicy("admin.applications.transfer", adminRole)
+	evaluator.AddPolicy("admin.browsers.read", adminRole)
+	evaluator.AddPolicy("admin.browsers.close", adminRole)
+	// Site admins read the whole trail; owners and admins read their organization's events.
+	evaluator.AddPolicy("audit.read", adminRole.Or(userRole.And(managers)))
+	evaluator.AddPolicy("admin.audit.read", adminRole)
 
 	return &WebAuthorizer{evaluator: evaluator}
 }
 
-func (a *WebAuthorizer) Can(subject WebSubject, resource OwnedResource, action string) bool {
-	return a.evaluator.Evaluate(baccess.AccessRequest[WebSubject, OwnedResource]{
+func (a *WebAuthorizer) Can(subject WebSubject, resource OrganizationResource, action string) bool {
+	return a.evaluator.Evaluate(baccess.AccessRequest[WebSubject, OrganizationResource]{
 		Subject:  subject,
 		Resource: resource,
 		Action:   action,
 	})
 }
 
+func memberRole(roles ...string) baccess.Predicate[baccess.AccessRequest[WebSubject, OrganizationResource]] {
+	return func(request baccess.AccessRequest[WebSubject, OrganizationResource]) bool {
+		role, found := request.Subject.OrganizationRoles[request.Resource.OrganizationID]
+		return found && slices.Contains(roles, role)
+	}
+}
+
+const (
+	ScopeBrowsersSpawn     = "browsers:spawn"
+	ScopeBrowsersKeepAlive = "browsers:keepalive"
+	ScopeBrowsersRead      = "browsers:read"
+	ScopeBrowsersClose     = "browsers:close"
+	ScopeSessionsHistory   = "sessions:history"
+	ScopeUsageRead         = "usage:read"
+	ScopeAPIKeysRotate     = "api_keys:rotate"
+)
+
+// AllScopes lists every API key scope in display order.
+var AllScopes = []string{
+	ScopeBrowsersSpawn,
+	ScopeBrowsersKeepAlive,
+	ScopeBrowsersRead,
+	ScopeBrowsersClose,
+	ScopeSessionsHistory,
+	ScopeUsageRead,
+	ScopeAPIKeysRotate,
+}
+
+func IsKnownScope(scope string) bool {
+	return slices.Contains(AllScopes, scope)
+}
+
+// ErrForbidden is the error services report for denied API key requests. A
+// MissingScopesError matches it with errors.Is.
+var ErrForbidden = errors.New("forbidden")
+
+// MissingScopesError reports the scopes an API key lacks for the requested operation.
+type MissingScopesError struct {
+	Scopes []string
+}
+
+func (e *MissingScopesError) Error() string {
+	if len(e.Scopes) == 0 {
+		return ErrForbidden.Error()
+	}
+
+	return fmt.Sprintf("API key is missing required scopes: %s", strings.Join(e.Scopes, ", "))
+}
+
+func (e *MissingScopesError) Is(target error) bool {
+	return target == ErrForbidden
+}
+
 type APIKeySubject struct {
-	AppID     string
-	Roles     []string
-	CanRead   bool
-	CanWrite  bool
-	CanDelete bool
+	AppID  string
+	Roles  []string
+	Scopes []string
 }
 
 func (s APIKeySubject) GetRoles() []string {
@@ -63,6 +195,8 @@ type BrowserResource struct {
 	AppID string
 }
 
+// APIAuthorizer authorizes API key requests. Every action is named after the single
+// scope it requires, e.g. "browsers:spawn".
 type APIAuthorizer struct {
 	evaluator *baccess.Evaluator[APIKeySubject, BrowserResource]
 }
@@ -76,22 +210,10 @@ func NewAPIAuthorizer() *APIAuthorizer {
 		func(subject APIKeySubject) string { return subject.AppID },
 		func(resource BrowserResource) string { return resource.AppID },
 	)
-	canRead := baccess.SubjectMatches[APIKeySubject, BrowserResource, bool](
-		func(subject APIKeySubject) bool { return subject.CanRead },
-		true,
-	)
-	canWrite := baccess.SubjectMatches[APIKeySubject, BrowserResource, bool](
-		func(subject APIKeySubject) bool { return subject.CanWrite },
-		true,
-	)
-	canDelete := baccess.SubjectMatches[APIKeySubject, BrowserResource, bool](
-		func(subject APIKeySubject) bool { return subject.CanDelete },
-		true,
-	)
 
-	evaluator.AddPolicy("browsers.read", apiKeyRole.And(sameApp).And(canRead))
-	evaluator.AddPolicy("browsers.write", apiKeyRole.And(sameApp).And(canWrite))
-	evaluator.AddPolicy("browsers.delete", apiKeyRole.And(sameApp).And(canDelete))
+	for _, scope := range AllScopes {
+		evaluator.AddPolicy(scope, apiKeyRole.And(sameApp).And(hasScope(scope)))
+	}
 
 	return &APIAuthorizer{evaluator: evaluator}
 }
@@ -103,3 +225,28 @@ func (a *APIAuthorizer) Can(subject APIKeySubject, resource BrowserResource, act
 		Action:   action,
 	})
 }
+
+// Authorize returns nil when the subject may perform action on resource and a
+// *MissingScopesError otherwise.
+func (a *APIAuthorizer) Authorize(subject APIKeySubject, resource BrowserResource, action string) error {
+	if a.Can(subject, resource, action) {
+		return nil
+	}
+
+	return &MissingScopesError{Scopes: a.MissingScopes(subject, action)}
+}
+
+// MissingScopes returns the scopes the subject lacks for action.
+func (a *APIAuthorizer) MissingScopes(subject APIKeySubject, action string) []string {
+	if !IsKnownScope(action) || slices.Contains(subject.Scopes, action) {
+		return []string{}
+	}
+
+	return []string{action}
+}
+
+func hasScope(scope string) baccess.Predicate[baccess.AccessRequest[APIKeySubject, BrowserResource]] {
+	return func(request baccess.AccessRequest[APIKeySubject, BrowserResource]) bool {
+		return slices.Contains(request.Subject.Scopes, scope)
+	}
+}
@@ -63,6 +63,18 @@ func NewHTTPManagerClient(baseURL string, httpClient *http.Client) (*HTTPManager
 	}, nil
 }
 
+// NewManagerClient picks a ManagerClient implementation from the configured base URL.
+// memory:// selects the in-process MemoryManagerClient; anything else is treated as the
+// HTTP address of a bbaas-cdp-manager instance.
+func NewManagerClient(baseURL string, httpClient *http.Client) (ManagerClient, error) {
+	parsed, err := url.Parse(strings.TrimSpace(baseURL))
+	if err == nil && strings.EqualFold(parsed.Scheme, MemoryManagerScheme) {
+		return NewMemoryManagerClient(), nil
+	}
+
+	return NewHTTPManagerClient(baseURL, httpClient)
+}
+
 func (c *HTTPManagerClient) Spawn(ctx context.Context, request SpawnRequest) (SpawnResponse, error) {
 	payload, err := json.Marshal(request)
 	if err != nil {
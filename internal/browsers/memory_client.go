@@ -0,0 +1,225 @@
+package browsers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/security"
+)
+
+const MemoryManagerScheme = "memory"
+
+// MemoryManagerClient is an in-process ManagerClient that simulates the CDP manager.
+// It is used for local development (CDP_MANAGER_BASE_URL=memory://) and as a test
+// helper: browsers expire after their idle timeout according to the injected clock,
+// so behaviour is fully deterministic.
+type MemoryManagerClient struct {
+	mu                 sync.Mutex
+	now                func() time.Time
+	defaultIdleTimeout int
+	maxBrowsers        int
+	nextSequence       int
+	browsers           map[string]Browser
+}
+
+type MemoryManagerOption func(*MemoryManagerClient)
+
+// WithMemoryClock replaces time.Now as the source of time for spawn, keepalive and expiry.
+func WithMemoryClock(now func() time.Time) MemoryManagerOption {
+	return func(c *MemoryManagerClient) {
+		if now != nil {
+			c.now = now
+		}
+	}
+}
+
+// WithMemoryMaxBrowsers limits how many browsers may run at once. Zero means unlimited.
+func WithMemoryMaxBrowsers(maxBrowsers int) MemoryManagerOption {
+	return func(c *MemoryManagerClient) {
+		if maxBrowsers >= 0 {
+			c.maxBrowsers = maxBrowsers
+		}
+	}
+}
+
+// WithMemoryDefaultIdleTimeout sets the idle timeout used when a spawn request omits one.
+func WithMemoryDefaultIdleTimeout(timeout time.Duration) MemoryManagerOption {
+	return func(c *MemoryManagerClient) {
+		if timeout > 0 {
+			c.defaultIdleTimeout = int(timeout / time.Second)
+		}
+	}
+}
+
+func NewMemoryManagerClient(options ...MemoryManagerOption) *MemoryManagerClient {
+	client := &MemoryManagerClient{
+		now:                time.Now,
+		defaultIdleTimeout: 60,
+		browsers:           make(map[string]Browser),
+	}
+
+	for _, option := range options {
+		option(client)
+	}
+
+	return client
+}
+
+func (c *MemoryManagerClient) Spawn(ctx context.Context, request SpawnRequest) (SpawnResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now().UTC()
+	c.evictExpiredLocked(now)
+
+	if c.maxBrowsers > 0 && len(c.browsers) >= c.maxBrowsers {
+		return SpawnResponse{}, &UpstreamError{
+			StatusCode: http.StatusTooManyRequests,
+			Message:    fmt.Sprintf("browser capacity of %d reached", c.maxBrowsers),
+		}
+	}
+
+	headless := true
+	if request.Headless != nil {
+		headless = *request.Headless
+	}
+
+	idleTimeout := c.defaultIdleTimeout
+	if request.IdleTimeoutSeconds != nil && *request.IdleTimeoutSeconds > 0 {
+		idleTimeout = *request.IdleTimeoutSeconds
+	}
+
+	// IDs are random rather than sequential so they stay unique in the database across
+	// restarts of the process.
+	browserID, err := security.GeneratePrefixedToken("brw_mem", 12)
+	if err != nil {
+		return SpawnResponse{}, fmt.Errorf("generate browser id: %w", err)
+	}
+	c.nextSequence++
+	port := 20000 + c.nextSequence
+	browser := Browser{
+		ID:                 browserID,
+		CDPURL:             fmt.Sprintf("ws://127.0.0.1:%d/devtools/browser/%s", port, browserID),
+		CDPHTTPURL:         fmt.Sprintf("http://127.0.0.1:%d", port),
+		Headless:           headless,
+		CreatedAt:          now,
+		LastActiveAt:       now,
+		IdleTimeoutSeconds: idleTimeout,
+		ExpiresAt:          now.Add(time.Duration(idleTimeout) * time.Second),
+	}
+	c.browsers[browserID] = browser
+
+	return SpawnResponse{
+		Browser:            browser,
+		SpawnTaskProcessID: fmt.Sprintf("memory-%d", c.nextSequence),
+		SpawnedByWorkerID:  1,
+	}, nil
+}
+
+func (c *MemoryManagerClient) List(ctx context.Context) ([]Browser, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.evictExpiredLocked(c.now().UTC())
+
+	browsers := make([]Browser, 0, len(c.browsers))
+	for _, browser := range c.browsers {
+		browsers = append(browsers, browser)
+	}
+
+	sort.Slice(browsers, func(i int, j int) bool {
+		return browsers[i].ID < browsers[j].ID
+	})
+
+	return browsers, nil
+}
+
+func (c *MemoryManagerClient) Get(ctx context.Context, browserID string) (Browser, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.evictExpiredLocked(c.now().UTC())
+
+	browser, found := c.browsers[browserID]
+	if !found {
+		return Browser{}, memoryNotFound(browserID)
+	}
+
+	return browser, nil
+}
+
+func (c *MemoryManagerClient) KeepAlive(ctx context.Context, browserID string) (Browser, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now().UTC()
+	c.evictExpiredLocked(now)
+
+	browser, found := c.browsers[browserID]
+	if !found {
+		return Browser{}, memoryNotFound(browserID)
+	}
+
+	browser.LastActiveAt = now
+	browser.ExpiresAt = now.Add(time.Duration(browser.IdleTimeoutSeconds) * time.Second)
+	c.browsers[browserID] = browser
+
+	return browser, nil
+}
+
+func (c *MemoryManagerClient) Close(ctx context.Context, browserID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.evictExpiredLocked(c.now().UTC())
+
+	if _, found := c.browsers[browserID]; !found {
+		return memoryNotFound(browserID)
+	}
+
+	delete(c.browsers, browserID)
+	return nil
+}
+
+func (c *MemoryManagerClient) evictExpiredLocked(now time.Time) {
+	for browserID, browser := range c.browsers {
+		if !browser.ExpiresAt.After(now) {
+			delete(c.browsers, browserID)
+		}
+	}
+}
+
+func memoryNotFound(browserID string) error {
+	return &UpstreamError{
+		StatusCode: http.StatusNotFound,
+		Message:    fmt.Sprintf("browser %s not found", browserID),
+	}
+}
+
+// ManualClock is a settable clock for driving MemoryManagerClient and services in tests.
+type ManualClock struct {
+	mu      sync.Mutex
+	current time.Time
+}
+
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{current: start}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.current
+}
+
+func (c *ManualClock) Advance(duration time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.current = c.current.Add(duration)
+}
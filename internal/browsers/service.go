@@ -17,7 +17,7 @@ import (
 
 var (
 	ErrBrowserNotFound = errors.New("browser not found")
-	ErrForbidden       = errors.New("forbidden")
+	ErrForbidden       = authorization.ErrForbidden
 )
 
 type Service struct {
@@ -39,10 +39,26 @@ func NewService(client ManagerClient, store *data.Store, authorizer *authorizati
 }
 
 func (s *Service) SpawnForAPIKey(ctx context.Context, principal applications.APIKeyPrincipal, request SpawnRequest) (SpawnResponse, error) {
-	if !s.can(principal, "browsers.write") {
+	if err := s.authorize(principal, authorization.ScopeBrowsersSpawn); err != nil {
+		return SpawnResponse{}, err
+	}
+	if principal.BrowserID != "" {
+		// A token bound to one browser cannot create others.
 		return SpawnResponse{}, ErrForbidden
 	}
 
+	// Access tokens outlive changes to their application, so check it is still in use.
+	application, found, err := s.store.GetApplicationByID(ctx, principal.ApplicationID)
+	if err != nil {
+		return SpawnResponse{}, fmt.Errorf("lookup application by id: %w", err)
+	}
+	if !found {
+		return SpawnResponse{}, ErrForbidden
+	}
+	if application.ArchivedAt != nil {
+		return SpawnResponse{}, applications.ErrApplicationArchived
+	}
+
 	spawnedBrowser, err := s.client.Spawn(ctx, request)
 	if err != nil {
 		return SpawnResponse{}, err
@@ -81,8 +97,8 @@ func (s *Service) SpawnForAPIKey(ctx context.Context, principal applications.API
 }
 
 func (s *Service) ListForAPIKey(ctx context.Context, principal applications.APIKeyPrincipal) ([]Browser, error) {
-	if !s.can(principal, "browsers.read") {
-		return nil, ErrForbidden
+	if err := s.authorize(principal, authorization.ScopeBrowsersRead); err != nil {
+		return nil, err
 	}
 
 	recordedSessions, err := s.store.ListBrowserSessionsByApplicationID(ctx, principal.ApplicationID)
@@ -106,6 +122,9 @@ func (s *Service) ListForAPIKey(ctx context.Context, principal applications.APIK
 		if session.Status == "COMPLETED" {
 			continue
 		}
+		if principal.BrowserID != "" && session.ExternalBrowserID != principal.BrowserID {
+			continue
+		}
 
 		upstreamBrowser, found := upstreamByID[session.ExternalBrowserID]
 		if !found {
@@ -124,12 +143,42 @@ func (s *Service) ListForAPIKey(ctx context.Context, principal applications.APIK
 	return ownedBrowsers, nil
 }
 
+// HistoryForAPIKey lists every browser the application has spawned, newest first, including
+// closed ones. A browser-bound token only sees its own browser.
+func (s *Service) HistoryForAPIKey(ctx context.Context, principal applications.APIKeyPrincipal) ([]Session, error) {
+	if err := s.authorize(principal, authorization.ScopeSessionsHistory); err != nil {
+		return nil, err
+	}
+
+	recordedSessions, err := s.store.ListBrowserSessionsByApplicationID(ctx, principal.ApplicationID)
+	if err != nil {
+		return nil, fmt.Errorf("list tracked browser sessions: %w", err)
+	}
+
+	sessions := make([]Session, 0, len(recordedSessions))
+	for _, session := range recordedSessions {
+		if principal.BrowserID != "" && session.ExternalBrowserID != principal.BrowserID {
+			continue
+		}
+		sessions = append(sessions, Session{
+			ID:           session.ExternalBrowserID,
+			Status:       session.Status,
+			Headless:     session.Headless,
+			CreatedAt:    session.CreatedAt,
+			LastActiveAt: session.LastActiveAt,
+			ClosedAt:     session.ClosedAt,
+		})
+	}
+
+	return sessions, nil
+}
+
 func (s *Service) GetForAPIKey(ctx context.Context, principal applications.APIKeyPrincipal, browserID string) (Browser, error) {
-	if !s.can(principal, "browsers.read") {
-		return Browser{}, ErrForbidden
+	if err := s.authorize(principal, authorization.ScopeBrowsersRead); err != nil {
+		return Browser{}, err
 	}
 
-	if _, err := s.getTrackedSession(ctx, principal.ApplicationID, browserID); err != nil {
+	if _, err := s.getTrackedSession(ctx, principal, browserID); err != nil {
 		return Browser{}, err
 	}
 
@@ -151,11 +200,11 @@ func (s *Service) GetForAPIKey(ctx context.Context, principal applications.APIKe
 }
 
 func (s *Service) KeepAliveForAPIKey(ctx context.Context, principal applications.APIKeyPrincipal, browserID string) (Browser, error) {
-	if !s.can(principal, "browsers.write") {
-		return Browser{}, ErrForbidden
+	if err := s.authorize(principal, authorization.ScopeBrowsersKeepAlive); err != nil {
+		return Browser{}, err
 	}
 
-	if _, err := s.getTrackedSession(ctx, principal.ApplicationID, browserID); err != nil {
+	if _, err := s.getTrackedSession(ctx, principal, browserID); err != nil {
 		return Browser{}, err
 	}
 
@@ -177,11 +226,11 @@ func (s *Service) KeepAliveForAPIKey(ctx context.Context, principal applications
 }
 
 func (s *Service) CloseForAPIKey(ctx context.Context, principal applications.APIKeyPrincipal, browserID string) error {
-	if !s.can(principal, "browsers.delete") {
-		return ErrForbidden
+	if err := s.authorize(principal, authorization.ScopeBrowsersClose); err != nil {
+		return err
 	}
 
-	if _, err := s.getTrackedSession(ctx, principal.ApplicationID, browserID); err != nil {
+	if _, err := s.getTrackedSession(ctx, principal, browserID); err != nil {
 		return err
 	}
 
@@ -200,22 +249,90 @@ func (s *Service) CloseForAPIKey(ctx context.Context, principal applications.API
 	return nil
 }
 
-func (s *Service) can(principal applications.APIKeyPrincipal, action string) bool {
-	return s.authorization.Can(
-		authorization.APIKeySubject{
-			AppID:     principal.ApplicationID,
-			Roles:     []string{"api_key"},
-			CanRead:   principal.Permissions.CanRead,
-			CanWrite:  principal.Permissions.CanWrite,
-			CanDelete: principal.Permissions.CanDelete,
-		},
-		authorization.BrowserResource{AppID: principal.ApplicationID},
-		action,
-	)
+// ForceClose closes a running browser of any application. Callers authorize the request; the
+// admin console uses it to stop browsers on behalf of their owners.
+func (s *Service) ForceClose(ctx context.Context, applicationID string, browserID string) error {
+	session, found, err := s.store.GetBrowserSessionByExternalID(ctx, applicationID, browserID)
+	if err != nil {
+		return fmt.Errorf("lookup tracked browser session: %w", err)
+	}
+	if !found || session.Status == "COMPLETED" {
+		return ErrBrowserNotFound
+	}
+
+	if err := s.client.Close(ctx, browserID); err != nil && !isNotFoundError(err) {
+		return err
+	}
+
+	if err := s.store.MarkBrowserSessionCompleted(ctx, applicationID, browserID, s.now().UTC()); err != nil {
+		return fmt.Errorf("mark browser session completed: %w", err)
+	}
+
+	return nil
 }
 
-func (s *Service) getTrackedSession(ctx context.Context, applicationID string, browserID string) (data.BrowserSessionRecord, error) {
-	session, found, err := s.store.GetBrowserSessionByExternalID(ctx, applicationID, browserID)
+// CloseForDeletedUser closes the running browsers of every application that is deleted along
+// with the user's account. It stops at the first browser the manager fails to close, so the
+// account is not deleted while its browsers keep running.
+func (s *Service) CloseForDeletedUser(ctx context.Context, userID string) (int, error) {
+	sessions, err := s.store.ListRunningBrowserSessionsDeletedWithUser(ctx, userID)
+	if err != nil {
+		return 0, fmt.Errorf("list browsers of deleted user: %w", err)
+	}
+
+	closed := 0
+	for _, session := range sessions {
+		if err := s.client.Close(ctx, session.ExternalBrowserID); err != nil && !isNotFoundError(err) {
+			return closed, fmt.Errorf("close browser %s: %w", session.ExternalBrowserID, err)
+		}
+		if err := s.store.MarkBrowserSessionCompleted(ctx, session.ApplicationID, session.ExternalBrowserID, s.now().UTC()); err != nil {
+			return closed, fmt.Errorf("mark browser session completed: %w", err)
+		}
+		closed++
+	}
+
+	return closed, nil
+}
+
+// CloseForApplication closes the running browsers of an application that is being deleted. Like
+// CloseForDeletedUser it stops at the first browser the manager fails to close.
+func (s *Service) CloseForApplication(ctx context.Context, applicationID string) (int, error) {
+	sessions, err := s.store.ListBrowserSessionsByApplicationID(ctx, applicationID)
+	if err != nil {
+		return 0, fmt.Errorf("list browsers of application: %w", err)
+	}
+
+	closed := 0
+	for _, session := range sessions {
+		if session.Status == "COMPLETED" {
+			continue
+		}
+		if err := s.client.Close(ctx, session.ExternalBrowserID); err != nil && !isNotFoundError(err) {
+			return closed, fmt.Errorf("close browser %s: %w", session.ExternalBrowserID, err)
+		}
+		if err := s.store.MarkBrowserSessionCompleted(ctx, session.ApplicationID, session.ExternalBrowserID, s.now().UTC()); err != nil {
+			return closed, fmt.Errorf("mark browser session completed: %w", err)
+		}
+		closed++
+	}
+
+	return closed, nil
+}
+
+// authorize checks that the API key holds the scope an operation requires and returns an
+// *authorization.MissingScopesError otherwise.
+func (s *Service) authorize(principal applications.APIKeyPrincipal, scope string) error {
+	return s.authorization.Authorize(principal.Subject(), authorization.BrowserResource{AppID: principal.ApplicationID}, scope)
+}
+
+// getTrackedSession returns the running session of a browser the principal may access.
+// Browsers outside a browser-bound token's scope are reported as not found.
+func (s *Service) getTrackedSession(ctx context.Context, principal applications.APIKeyPrincipal, browserID string) (data.BrowserSessionRecord, error) {
+	if principal.BrowserID != "" && principal.BrowserID != browserID {
+		return data.BrowserSessionRecord{}, ErrBrowserNotFound
+	}
+
+	session, found, err := s.store.GetBrowserSessionByExternalID(ctx, principal.ApplicationID, browserID)
 	if err != nil {
 		return data.BrowserSessionRecord{}, fmt.Errorf("lookup tracked browser session: %w", err)
 	}
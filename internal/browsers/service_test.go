@@ -0,0 +1,384 @@
+package browsers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/applications"
+	"github.com/brian-nunez/bbaas-api/internal/authorization"
+	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/data/datatest"
+)
+
+func TestServiceBrowserLifecycleWithMemoryManager(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	clock := NewManualClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	service, store, _ := setupService(t, clock)
+	principal := createPrincipal(t, store, "app_lifecycle", allScopes())
+
+	spawned, err := service.SpawnForAPIKey(ctx, principal, SpawnRequest{})
+	if err != nil {
+		t.Fatalf("spawn browser: %v", err)
+	}
+
+	clock.Advance(45 * time.Second)
+	kept, err := service.KeepAliveForAPIKey(ctx, principal, spawned.Browser.ID)
+	if err != nil {
+		t.Fatalf("keepalive browser: %v", err)
+	}
+	if want := clock.Now().Add(60 * time.Second); !kept.ExpiresAt.Equal(want) {
+		t.Fatalf("expected expiry %s after keepalive, got %s", want, kept.ExpiresAt)
+	}
+
+	clock.Advance(45 * time.Second)
+	listed, err := service.ListForAPIKey(ctx, principal)
+	if err != nil {
+		t.Fatalf("list browsers: %v", err)
+	}
+	if len(listed) != 1 || listed[0].ID != spawned.Browser.ID {
+		t.Fatalf("expected kept-alive browser to still be listed, got %+v", listed)
+	}
+
+	if err := service.CloseForAPIKey(ctx, principal, spawned.Browser.ID); err != nil {
+		t.Fatalf("close browser: %v", err)
+	}
+
+	session, found, err := store.GetBrowserSessionByExternalID(ctx, principal.ApplicationID, spawned.Browser.ID)
+	if err != nil || !found {
+		t.Fatalf("lookup browser session: found=%v err=%v", found, err)
+	}
+	if session.Status != "COMPLETED" {
+		t.Fatalf("expected closed browser to be COMPLETED, got %s", session.Status)
+	}
+}
+
+func TestServiceSpawnRespectsManagerCapacity(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	clock := NewManualClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	service, store, _ := setupService(t, clock, WithMemoryMaxBrowsers(1))
+	principal := createPrincipal(t, store, "app_quota", allScopes())
+
+	if _, err := service.SpawnForAPIKey(ctx, principal, SpawnRequest{}); err != nil {
+		t.Fatalf("spawn first browser: %v", err)
+	}
+
+	_, err := service.SpawnForAPIKey(ctx, principal, SpawnRequest{})
+	var upstreamError *UpstreamError
+	if !errors.As(err, &upstreamError) || upstreamError.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected capacity error, got %v", err)
+	}
+
+	sessions, err := store.ListBrowserSessionsByApplicationID(ctx, principal.ApplicationID)
+	if err != nil {
+		t.Fatalf("list browser sessions: %v", err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("expected rejected spawn not to be tracked, got %d sessions", len(sessions))
+	}
+
+	clock.Advance(61 * time.Second)
+	if _, err := service.SpawnForAPIKey(ctx, principal, SpawnRequest{}); err != nil {
+		t.Fatalf("expected capacity to free up after idle expiry: %v", err)
+	}
+}
+
+func TestServiceListReconcilesExpiredBrowsers(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	clock := NewManualClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	service, store, _ := setupService(t, clock)
+	principal := createPrincipal(t, store, "app_reconcile", allScopes())
+
+	shortTimeout := 10
+	shortLived, err := service.SpawnForAPIKey(ctx, principal, SpawnRequest{IdleTimeoutSeconds: &shortTimeout})
+	if err != nil {
+		t.Fatalf("spawn short-lived browser: %v", err)
+	}
+	longLived, err := service.SpawnForAPIKey(ctx, principal, SpawnRequest{})
+	if err != nil {
+		t.Fatalf("spawn long-lived browser: %v", err)
+	}
+
+	clock.Advance(30 * time.Second)
+	listed, err := service.ListForAPIKey(ctx, principal)
+	if err != nil {
+		t.Fatalf("list browsers: %v", err)
+	}
+	if len(listed) != 1 || listed[0].ID != longLived.Browser.ID {
+		t.Fatalf("expected only %s to be running, got %+v", longLived.Browser.ID, listed)
+	}
+
+	session, _, err := store.GetBrowserSessionByExternalID(ctx, principal.ApplicationID, shortLived.Browser.ID)
+	if err != nil {
+		t.Fatalf("lookup expired session: %v", err)
+	}
+	if session.Status != "COMPLETED" || session.ClosedAt == nil {
+		t.Fatalf("expected expired session to be reconciled to COMPLETED, got %s", session.Status)
+	}
+}
+
+func TestServiceNotFoundPaths(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	clock := NewManualClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	service, store, manager := setupService(t, clock)
+	owner := createPrincipal(t, store, "app_owner", allScopes())
+	other := createPrincipal(t, store, "app_other", allScopes())
+
+	if _, err := service.GetForAPIKey(ctx, owner, "brw_unknown"); !errors.Is(err, ErrBrowserNotFound) {
+		t.Fatalf("expected unknown browser to be not found, got %v", err)
+	}
+
+	spawned, err := service.SpawnForAPIKey(ctx, owner, SpawnRequest{})
+	if err != nil {
+		t.Fatalf("spawn browser: %v", err)
+	}
+
+	if _, err := service.GetForAPIKey(ctx, other, spawned.Browser.ID); !errors.Is(err, ErrBrowserNotFound) {
+		t.Fatalf("expected browser of another application to be not found, got %v", err)
+	}
+	if err := service.CloseForAPIKey(ctx, other, spawned.Browser.ID); !errors.Is(err, ErrBrowserNotFound) {
+		t.Fatalf("expected close from another application to be not found, got %v", err)
+	}
+
+	if err := manager.Close(ctx, spawned.Browser.ID); err != nil {
+		t.Fatalf("close browser upstream: %v", err)
+	}
+	if _, err := service.KeepAliveForAPIKey(ctx, owner, spawned.Browser.ID); !errors.Is(err, ErrBrowserNotFound) {
+		t.Fatalf("expected browser closed upstream to be not found, got %v", err)
+	}
+	if _, err := service.GetForAPIKey(ctx, owner, spawned.Browser.ID); !errors.Is(err, ErrBrowserNotFound) {
+		t.Fatalf("expected completed browser to stay not found, got %v", err)
+	}
+}
+
+func TestServiceEnforcesAPIKeyScopes(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	clock := NewManualClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	service, store, _ := setupService(t, clock)
+	readOnly := createPrincipal(t, store, "app_read_only", []string{authorization.ScopeBrowsersRead})
+
+	_, err := service.SpawnForAPIKey(ctx, readOnly, SpawnRequest{})
+	if !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected read-only key to be forbidden from spawning, got %v", err)
+	}
+	var missingScopesError *authorization.MissingScopesError
+	if !errors.As(err, &missingScopesError) || !slices.Equal(missingScopesError.Scopes, []string{authorization.ScopeBrowsersSpawn}) {
+		t.Fatalf("expected error to name the missing spawn scope, got %v", err)
+	}
+	if _, err := service.ListForAPIKey(ctx, readOnly); err != nil {
+		t.Fatalf("expected read-only key to list browsers: %v", err)
+	}
+
+	spawnOnly := createPrincipal(t, store, "app_spawn_only", []string{authorization.ScopeBrowsersSpawn})
+	spawned, err := service.SpawnForAPIKey(ctx, spawnOnly, SpawnRequest{})
+	if err != nil {
+		t.Fatalf("spawn browser: %v", err)
+	}
+	if _, err := service.KeepAliveForAPIKey(ctx, spawnOnly, spawned.Browser.ID); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected spawn-only key to be forbidden from keepalive, got %v", err)
+	}
+	if err := service.CloseForAPIKey(ctx, spawnOnly, spawned.Browser.ID); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected spawn-only key to be forbidden from closing, got %v", err)
+	}
+}
+
+func TestServiceListsSessionHistory(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	clock := NewManualClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	service, store, _ := setupService(t, clock)
+	principal := createPrincipal(t, store, "app_history", allScopes())
+
+	closed, err := service.SpawnForAPIKey(ctx, principal, SpawnRequest{})
+	if err != nil {
+		t.Fatalf("spawn browser: %v", err)
+	}
+	if err := service.CloseForAPIKey(ctx, principal, closed.Browser.ID); err != nil {
+		t.Fatalf("close browser: %v", err)
+	}
+	clock.Advance(time.Minute)
+	running, err := service.SpawnForAPIKey(ctx, principal, SpawnRequest{})
+	if err != nil {
+		t.Fatalf("spawn browser: %v", err)
+	}
+
+	sessions, err := service.HistoryForAPIKey(ctx, principal)
+	if err != nil {
+		t.Fatalf("list session history: %v", err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != running.Browser.ID || sessions[1].ID != closed.Browser.ID {
+		t.Fatalf("expected both sessions newest first, got %+v", sessions)
+	}
+	if sessions[1].Status != "COMPLETED" || sessions[1].ClosedAt == nil {
+		t.Fatalf("expected the closed session to be completed, got %+v", sessions[1])
+	}
+	if sessions[0].ClosedAt != nil {
+		t.Fatalf("expected the running session to have no close time, got %+v", sessions[0])
+	}
+
+	withoutHistory := createPrincipal(t, store, "app_no_history", []string{authorization.ScopeBrowsersRead})
+	_, err = service.HistoryForAPIKey(ctx, withoutHistory)
+	var missingScopesError *authorization.MissingScopesError
+	if !errors.As(err, &missingScopesError) || !slices.Equal(missingScopesError.Scopes, []string{authorization.ScopeSessionsHistory}) {
+		t.Fatalf("expected history to require the sessions:history scope, got %v", err)
+	}
+}
+
+func TestServiceRestrictsBrowserBoundTokens(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	clock := NewManualClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	service, store, _ := setupService(t, clock)
+	principal := createPrincipal(t, store, "app_bound", allScopes())
+
+	bound, err := service.SpawnForAPIKey(ctx, principal, SpawnRequest{})
+	if err != nil {
+		t.Fatalf("spawn bound browser: %v", err)
+	}
+	other, err := service.SpawnForAPIKey(ctx, principal, SpawnRequest{})
+	if err != nil {
+		t.Fatalf("spawn other browser: %v", err)
+	}
+
+	tokenPrincipal := principal
+	tokenPrincipal.AccessTokenID = "tok_test"
+	tokenPrincipal.BrowserID = bound.Browser.ID
+
+	if _, err := service.SpawnForAPIKey(ctx, tokenPrincipal, SpawnRequest{}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected browser-bound token to be forbidden from spawning, got %v", err)
+	}
+
+	listed, err := service.ListForAPIKey(ctx, tokenPrincipal)
+	if err != nil {
+		t.Fatalf("list browsers: %v", err)
+	}
+	if len(listed) != 1 || listed[0].ID != bound.Browser.ID {
+		t.Fatalf("expected only the bound browser to be listed, got %+v", listed)
+	}
+
+	if _, err := service.GetForAPIKey(ctx, tokenPrincipal, other.Browser.ID); !errors.Is(err, ErrBrowserNotFound) {
+		t.Fatalf("expected other browser to be hidden, got %v", err)
+	}
+	if err := service.CloseForAPIKey(ctx, tokenPrincipal, other.Browser.ID); !errors.Is(err, ErrBrowserNotFound) {
+		t.Fatalf("expected closing other browser to fail, got %v", err)
+	}
+	if _, err := service.KeepAliveForAPIKey(ctx, tokenPrincipal, bound.Browser.ID); err != nil {
+		t.Fatalf("keep alive bound browser: %v", err)
+	}
+}
+
+func TestServiceArchivedAndDeletedApplications(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	clock := NewManualClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	service, store, manager := setupService(t, clock)
+	principal := createPrincipal(t, store, "app_archived", allScopes())
+
+	first, err := service.SpawnForAPIKey(ctx, principal, SpawnRequest{})
+	if err != nil {
+		t.Fatalf("spawn browser: %v", err)
+	}
+	second, err := service.SpawnForAPIKey(ctx, principal, SpawnRequest{})
+	if err != nil {
+		t.Fatalf("spawn browser: %v", err)
+	}
+	if err := service.CloseForAPIKey(ctx, principal, first.Browser.ID); err != nil {
+		t.Fatalf("close browser: %v", err)
+	}
+
+	archivedAt := clock.Now()
+	if err := store.SetApplicationArchivedAt(ctx, principal.ApplicationID, &archivedAt, archivedAt); err != nil {
+		t.Fatalf("archive application: %v", err)
+	}
+	if _, err := service.SpawnForAPIKey(ctx, principal, SpawnRequest{}); !errors.Is(err, applications.ErrApplicationArchived) {
+		t.Fatalf("expected archived applications not to spawn, got %v", err)
+	}
+	if _, err := service.GetForAPIKey(ctx, principal, second.Browser.ID); err != nil {
+		t.Fatalf("expected running browsers of archived applications to stay reachable, got %v", err)
+	}
+
+	closed, err := service.CloseForApplication(ctx, principal.ApplicationID)
+	if err != nil {
+		t.Fatalf("close browsers of application: %v", err)
+	}
+	if closed != 1 {
+		t.Fatalf("expected only the running browser to be closed, closed %d", closed)
+	}
+	if _, err := manager.Get(ctx, second.Browser.ID); err == nil {
+		t.Fatalf("expected the running browser to be closed")
+	}
+
+	if _, err := store.DeleteApplication(ctx, principal.ApplicationID); err != nil {
+		t.Fatalf("delete application: %v", err)
+	}
+	if _, err := service.SpawnForAPIKey(ctx, principal, SpawnRequest{}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected deleted applications not to spawn, got %v", err)
+	}
+}
+
+func setupService(t *testing.T, clock *ManualClock, options ...MemoryManagerOption) (*Service, *data.Store, *MemoryManagerClient) {
+	t.Helper()
+
+	store := datatest.NewStore(t)
+	manager := NewMemoryManagerClient(append([]MemoryManagerOption{WithMemoryClock(clock.Now)}, options...)...)
+	service := NewService(manager, store, authorization.NewAPIAuthorizer(), "")
+	service.now = clock.Now
+
+	return service, store, manager
+}
+
+func createPrincipal(t *testing.T, store *data.Store, applicationID string, scopes []string) applications.APIKeyPrincipal {
+	t.Helper()
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+	userID := "usr_" + applicationID
+	if err := store.CreateUser(ctx, data.UserRecord{
+		ID:           userID,
+		Email:        applicationID + "@example.com",
+		PasswordHash: "unused",
+		Role:         "user",
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if err := store.CreateApplication(ctx, data.ApplicationRecord{
+		ID:             applicationID,
+		OwnerUserID:    userID,
+		OrganizationID: data.PersonalOrganizationID(userID),
+		Name:           applicationID,
+		GitHubLink:     "https://github.com/example-org",
+		Domain:         "example.com",
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}); err != nil {
+		t.Fatalf("create application: %v", err)
+	}
+
+	return applications.APIKeyPrincipal{
+		KeyID:         "key_" + applicationID,
+		ApplicationID: applicationID,
+		Scopes:        scopes,
+	}
+}
+
+func allScopes() []string {
+	return authorization.AllScopes
+}
@@ -23,3 +23,13 @@ type SpawnResponse struct {
 	SpawnTaskProcessID string  `json:"spawnTaskProcessId"`
 	SpawnedByWorkerID  int     `json:"spawnedByWorkerId"`
 }
+
+// Session is a tracked browser, running or closed, as listed in an application's history.
+type Session struct {
+	ID           string     `json:"id"`
+	Status       string     `json:"status"`
+	Headless     bool       `json:"headless"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	LastActiveAt time.Time  `json:"lastActiveAt"`
+	ClosedAt     *time.Time `json:"closedAt,omitempty"`
+}
@@ -10,6 +10,8 @@ import (
 	"github.com/brian-nunez/bbaas-api/internal/applications"
 	"github.com/brian-nunez/bbaas-api/internal/browsers"
 	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/lockout"
+	"github.com/brian-nunez/bbaas-api/internal/organizations"
 	"github.com/brian-nunez/bbaas-api/internal/users"
 )
 
@@ -28,32 +30,58 @@ type BrowserSession struct {
 type ApplicationWithKeys struct {
 	Application applications.Application
 	APIKeys     []applications.APIKey
+	IPDenials   []applications.IPDenial
+	// GitHubOIDCTrust is nil when GitHub Actions authentication is disabled.
+	GitHubOIDCTrust *applications.GitHubOIDCTrust
+	// Organization is the viewer's membership in the organization that owns the application.
+	Organization organizations.Membership
+	// PendingTransfer is set while the application is offered to another user.
+	PendingTransfer *applications.Transfer
 }
 
 type ViewData struct {
-	CurrentUser       users.User
-	VisibleUsers      []users.User
-	Applications      []ApplicationWithKeys
+	Now time.Time
+	// StaleKeyAfter is how long an active key may go unused before it is highlighted; zero
+	// disables highlighting.
+	StaleKeyAfter time.Duration
+	// VerificationRequired is set when unverified users cannot create applications or keys.
+	VerificationRequired bool
+	CurrentUser          users.User
+	VisibleUsers         []users.User
+	Organizations        []organizations.Membership
+	Applications         []ApplicationWithKeys
+	// ArchivedApplications are hidden from Applications until they are restored.
+	ArchivedApplications []ApplicationWithKeys
+	// IncomingTransfers are applications other users have offered to the viewer.
+	IncomingTransfers []applications.Transfer
 	RunningBrowsers   []BrowserSession
 	CompletedBrowsers []BrowserSession
+	// Lockouts lists recent failed logins and invalid API keys; it is only filled for admins.
+	Lockouts []lockout.Entry
 }
 
 type Service struct {
 	store               *data.Store
 	usersService        *users.Service
 	applicationsService *applications.Service
+	organizations       *organizations.Service
 	browserManager      browsers.ManagerClient
+	lockout             *lockout.Guard
 	publicCDPBase       string
+	staleKeyAfter       time.Duration
 	now                 func() time.Time
 }
 
-func NewService(store *data.Store, usersService *users.Service, applicationsService *applications.Service, browserManager browsers.ManagerClient, publicCDPBase string) *Service {
+func NewService(store *data.Store, usersService *users.Service, applicationsService *applications.Service, organizationsService *organizations.Service, browserManager browsers.ManagerClient, lockoutGuard *lockout.Guard, publicCDPBase string, staleKeyAfter time.Duration) *Service {
 	return &Service{
 		store:               store,
 		usersService:        usersService,
 		applicationsService: applicationsService,
+		organizations:       organizationsService,
 		browserManager:      browserManager,
+		lockout:             lockoutGuard,
 		publicCDPBase:       strings.TrimSpace(publicCDPBase),
+		staleKeyAfter:       staleKeyAfter,
 		now:                 time.Now,
 	}
 }
@@ -68,24 +96,82 @@ func (s *Service) BuildViewData(ctx context.Context, viewer users.User) (ViewDat
 		return ViewData{}, fmt.Errorf("list visible users: %w", err)
 	}
 
+	var lockouts []lockout.Entry
+	if viewer.IsAdmin() {
+		lockouts, err = s.lockout.List(ctx, 50)
+		if err != nil {
+			return ViewData{}, fmt.Errorf("list lockouts: %w", err)
+		}
+	}
+
+	memberships, err := s.organizations.ListForUser(ctx, viewer)
+	if err != nil {
+		return ViewData{}, fmt.Errorf("list organizations: %w", err)
+	}
+	membershipByID := make(map[string]organizations.Membership, len(memberships))
+	for _, membership := range memberships {
+		membershipByID[membership.Organization.ID] = membership
+	}
+
 	ownedApplications, err := s.applicationsService.ListApplicationsForViewer(ctx, viewer)
 	if err != nil {
 		return ViewData{}, fmt.Errorf("list applications: %w", err)
 	}
 
+	transfers, err := s.applicationsService.ListTransfersForViewer(ctx, viewer)
+	if err != nil {
+		return ViewData{}, fmt.Errorf("list application transfers: %w", err)
+	}
+	incomingTransfers := make([]applications.Transfer, 0)
+	transferByApplicationID := make(map[string]*applications.Transfer, len(transfers))
+	for index, transfer := range transfers {
+		if transfer.RecipientUserID == viewer.ID {
+			incomingTransfers = append(incomingTransfers, transfer)
+		}
+		transferByApplicationID[transfer.ApplicationID] = &transfers[index]
+	}
+
 	applicationsWithKeys := make([]ApplicationWithKeys, 0, len(ownedApplications))
+	archivedApplications := make([]ApplicationWithKeys, 0)
 	appNameByID := make(map[string]string, len(ownedApplications))
 	for _, application := range ownedApplications {
+		appNameByID[application.ID] = application.Name
+		if application.IsArchived() {
+			archivedApplications = append(archivedApplications, ApplicationWithKeys{
+				Application:     application,
+				Organization:    membershipByID[application.OrganizationID],
+				PendingTransfer: transferByApplicationID[application.ID],
+			})
+			continue
+		}
+
 		keys, err := s.applicationsService.ListAPIKeysForApplication(ctx, viewer, application.ID)
 		if err != nil {
 			return ViewData{}, fmt.Errorf("list API keys for application %s: %w", application.ID, err)
 		}
 
-		applicationsWithKeys = append(applicationsWithKeys, ApplicationWithKeys{
-			Application: application,
-			APIKeys:     keys,
-		})
-		appNameByID[application.ID] = application.Name
+		ipDenials, err := s.applicationsService.ListIPDenialsForApplication(ctx, viewer, application.ID, 5)
+		if err != nil {
+			return ViewData{}, fmt.Errorf("list IP denials for application %s: %w", application.ID, err)
+		}
+
+		applicationWithKeys := ApplicationWithKeys{
+			Application:     application,
+			APIKeys:         keys,
+			IPDenials:       ipDenials,
+			Organization:    membershipByID[application.OrganizationID],
+			PendingTransfer: transferByApplicationID[application.ID],
+		}
+
+		trust, found, err := s.applicationsService.GetGitHubOIDCTrust(ctx, viewer, application.ID)
+		if err != nil {
+			return ViewData{}, fmt.Errorf("get GitHub OIDC trust for application %s: %w", application.ID, err)
+		}
+		if found {
+			applicationWithKeys.GitHubOIDCTrust = &trust
+		}
+
+		applicationsWithKeys = append(applicationsWithKeys, applicationWithKeys)
 	}
 
 	browserRecords, err := s.store.ListBrowserSessionsByUserID(ctx, viewer.ID, 250)
@@ -135,11 +221,18 @@ func (s *Service) BuildViewData(ctx context.Context, viewer users.User) (ViewDat
 	})
 
 	return ViewData{
-		CurrentUser:       viewer,
-		VisibleUsers:      visibleUsers,
-		Applications:      applicationsWithKeys,
-		RunningBrowsers:   runningBrowsers,
-		CompletedBrowsers: completedBrowsers,
+		Now:                  s.now().UTC(),
+		StaleKeyAfter:        s.staleKeyAfter,
+		VerificationRequired: s.applicationsService.RequiresVerifiedEmail(),
+		CurrentUser:          viewer,
+		VisibleUsers:         visibleUsers,
+		Lockouts:             lockouts,
+		Organizations:        memberships,
+		Applications:         applicationsWithKeys,
+		ArchivedApplications: archivedApplications,
+		IncomingTransfers:    incomingTransfers,
+		RunningBrowsers:      runningBrowsers,
+		CompletedBrowsers:    completedBrowsers,
 	}, nil
 }
 
@@ -0,0 +1,37 @@
+// Package datatest provides in-memory stores for tests.
+package datatest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/brian-nunez/bbaas-api/internal/data"
+)
+
+// DSN names the in-memory database that belongs to t. Opening it again reaches the same database as NewStore.
+func DSN(t testing.TB) string {
+	return fmt.Sprintf("file:%s?mode=memory&cache=shared&_pragma=foreign_keys(1)", strings.ReplaceAll(t.Name(), "/", "_"))
+}
+
+// NewStore returns a migrated store backed by an in-memory database of its own,
+// so tests cannot see each other's rows. The database is closed when t finishes.
+func NewStore(t testing.TB) *data.Store {
+	t.Helper()
+
+	db, _, err := data.Open(data.Config{
+		Driver: "sqlite",
+		DSN:    DSN(t),
+	})
+	if err != nil {
+		t.Fatalf("open test database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	if err := data.RunMigrations(context.Background(), db); err != nil {
+		t.Fatalf("run migrations: %v", err)
+	}
+
+	return data.NewStore(db)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"time"
 )
 
 var schemaMigrations = []string{
@@ -73,14 +74,284 @@ var schemaMigrations = []string{
 	`CREATE INDEX IF NOT EXISTS idx_api_keys_key_hash ON api_keys(key_hash)`,
 	`CREATE INDEX IF NOT EXISTS idx_browser_sessions_application_id ON browser_sessions(application_id)`,
 	`CREATE INDEX IF NOT EXISTS idx_browser_sessions_status ON browser_sessions(status)`,
+	`ALTER TABLE api_keys ADD COLUMN expires_at TIMESTAMP`,
+	`ALTER TABLE api_keys ADD COLUMN expiry_notified_at TIMESTAMP`,
+	`ALTER TABLE applications ADD COLUMN max_api_key_lifetime_days INTEGER NOT NULL DEFAULT 0`,
+	`CREATE INDEX IF NOT EXISTS idx_api_keys_expires_at ON api_keys(expires_at)`,
+	`ALTER TABLE api_keys ADD COLUMN rotated_from_key_id TEXT`,
+	`ALTER TABLE api_keys ADD COLUMN replaced_by_key_id TEXT`,
+	`ALTER TABLE api_keys ADD COLUMN rotated_at TIMESTAMP`,
+	`ALTER TABLE api_keys ADD COLUMN grace_expires_at TIMESTAMP`,
+	`CREATE INDEX IF NOT EXISTS idx_api_keys_grace_expires_at ON api_keys(grace_expires_at)`,
+	`ALTER TABLE api_keys ADD COLUMN scopes TEXT NOT NULL DEFAULT ''`,
+	`UPDATE api_keys SET scopes = TRIM(
+		(CASE WHEN can_write = 1 THEN 'browsers:spawn browsers:keepalive ' ELSE '' END) ||
+		(CASE WHEN can_read = 1 THEN 'browsers:read ' ELSE '' END) ||
+		(CASE WHEN can_delete = 1 THEN 'browsers:close' ELSE '' END)
+	)`,
+	`ALTER TABLE applications ADD COLUMN allowed_cidrs TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE api_keys ADD COLUMN allowed_cidrs TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS api_key_ip_denials (
+		id TEXT PRIMARY KEY,
+		api_key_id TEXT NOT NULL,
+		application_id TEXT NOT NULL,
+		source_ip TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE CASCADE,
+		FOREIGN KEY (application_id) REFERENCES applications(id) ON DELETE CASCADE
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_api_key_ip_denials_application_id ON api_key_ip_denials(application_id, created_at)`,
+	`ALTER TABLE applications ADD COLUMN rate_limit_per_minute INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE api_keys ADD COLUMN rate_limit_per_minute INTEGER NOT NULL DEFAULT 0`,
+	`CREATE TABLE IF NOT EXISTS token_signing_keys (
+		id TEXT PRIMARY KEY,
+		secret TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS github_oidc_trusts (
+		application_id TEXT PRIMARY KEY,
+		api_key_id TEXT NOT NULL,
+		allowed_ref TEXT NOT NULL DEFAULT '',
+		allowed_environment TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		FOREIGN KEY (application_id) REFERENCES applications(id) ON DELETE CASCADE,
+		FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+	)`,
+	`ALTER TABLE api_keys ADD COLUMN last_used_ip TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE api_keys ADD COLUMN last_used_user_agent TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS api_key_endpoint_usage (
+		api_key_id TEXT NOT NULL,
+		endpoint TEXT NOT NULL,
+		request_count INTEGER NOT NULL DEFAULT 0,
+		last_used_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (api_key_id, endpoint),
+		FOREIGN KEY (api_key_id) REFERENCES api_keys(id) ON DELETE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS api_key_revocation_version (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		version INTEGER NOT NULL
+	)`,
+	`INSERT INTO api_key_revocation_version (id, version) VALUES (1, 0)`,
+	`ALTER TABLE api_keys ADD COLUMN hash_version INTEGER NOT NULL DEFAULT 1`,
+	`ALTER TABLE sessions ADD COLUMN hash_version INTEGER NOT NULL DEFAULT 1`,
+	`ALTER TABLE sessions ADD COLUMN last_seen_at TIMESTAMP`,
+	`ALTER TABLE sessions ADD COLUMN ip TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE sessions ADD COLUMN user_agent TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE sessions ADD COLUMN role TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS password_reset_tokens (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		expires_at TIMESTAMP NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		used_at TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_password_reset_tokens_user_id ON password_reset_tokens(user_id)`,
+	`ALTER TABLE users ADD COLUMN verified_at TIMESTAMP`,
+	// Accounts created before verification existed are treated as verified.
+	`UPDATE users SET verified_at = created_at WHERE verified_at IS NULL`,
+	`CREATE TABLE IF NOT EXISTS email_verification_tokens (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		expires_at TIMESTAMP NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_email_verification_tokens_user_id ON email_verification_tokens(user_id)`,
+	`ALTER TABLE users ADD COLUMN totp_secret TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE users ADD COLUMN totp_enabled_at TIMESTAMP`,
+	`ALTER TABLE users ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0`,
+	`CREATE TABLE IF NOT EXISTS user_recovery_codes (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		code_hash TEXT NOT NULL,
+		used_at TIMESTAMP,
+		created_at TIMESTAMP NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_user_recovery_codes_user_id ON user_recovery_codes(user_id)`,
+	`CREATE TABLE IF NOT EXISTS login_challenges (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		expires_at TIMESTAMP NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS settings (
+		name TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_identities (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		issuer TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_user_identities_issuer_subject ON user_identities(issuer, subject)`,
+	`CREATE TABLE IF NOT EXISTS auth_failures (
+		scope TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		failures INTEGER NOT NULL,
+		last_failure_at TIMESTAMP NOT NULL,
+		locked_until TIMESTAMP,
+		PRIMARY KEY (scope, subject)
+	)`,
+	`CREATE TABLE IF NOT EXISTS organizations (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		personal_user_id TEXT,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		FOREIGN KEY (personal_user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_organizations_personal_user_id ON organizations(personal_user_id)`,
+	`CREATE TABLE IF NOT EXISTS organization_members (
+		organization_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		role TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (organization_id, user_id),
+		FOREIGN KEY (organization_id) REFERENCES organizations(id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+		CHECK(role IN ('owner', 'admin', 'developer', 'viewer'))
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_organization_members_user_id ON organization_members(user_id)`,
+	// Every existing user gets a personal organization that takes over the applications they own.
+	`INSERT INTO organizations (id, name, personal_user_id, created_at, updated_at)
+	 SELECT 'org_' || id, 'Personal', id, created_at, created_at FROM users`,
+	`INSERT INTO organization_members (organization_id, user_id, role, created_at)
+	 SELECT 'org_' || id, id, 'owner', created_at FROM users`,
+	`ALTER TABLE applications ADD COLUMN organization_id TEXT NOT NULL DEFAULT ''`,
+	`UPDATE applications SET organization_id = 'org_' || owner_user_id`,
+	`CREATE INDEX IF NOT EXISTS idx_applications_organization_id ON applications(organization_id)`,
+	`CREATE TABLE IF NOT EXISTS invitations (
+		id TEXT PRIMARY KEY,
+		organization_id TEXT NOT NULL,
+		email TEXT NOT NULL,
+		role TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		invited_by_user_id TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		sent_at TIMESTAMP NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		FOREIGN KEY (organization_id) REFERENCES organizations(id) ON DELETE CASCADE,
+		FOREIGN KEY (invited_by_user_id) REFERENCES users(id) ON DELETE CASCADE,
+		CHECK(role IN ('owner', 'admin', 'developer', 'viewer'))
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_invitations_organization_email ON invitations(organization_id, email)`,
+	`ALTER TABLE users ADD COLUMN disabled_at TIMESTAMP`,
+	`CREATE TABLE IF NOT EXISTS audit_events (
+		id TEXT PRIMARY KEY,
+		actor_user_id TEXT NOT NULL,
+		action TEXT NOT NULL,
+		target_type TEXT NOT NULL,
+		target_id TEXT NOT NULL,
+		ip TEXT NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		request_id TEXT NOT NULL DEFAULT '',
+		changes TEXT NOT NULL DEFAULT '{}',
+		created_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at)`,
+	`CREATE TABLE IF NOT EXISTS email_changes (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL UNIQUE,
+		new_email TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		expires_at TIMESTAMP NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`,
+	`ALTER TABLE audit_events ADD COLUMN organization_id TEXT NOT NULL DEFAULT ''`,
+	`CREATE INDEX IF NOT EXISTS idx_audit_events_organization_id ON audit_events(organization_id, created_at)`,
+	`ALTER TABLE applications ADD COLUMN archived_at TIMESTAMP`,
+	`CREATE TABLE IF NOT EXISTS application_transfers (
+		id TEXT PRIMARY KEY,
+		application_id TEXT NOT NULL UNIQUE,
+		from_organization_id TEXT NOT NULL,
+		initiated_by_user_id TEXT NOT NULL,
+		recipient_user_id TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		FOREIGN KEY (application_id) REFERENCES applications(id) ON DELETE CASCADE,
+		FOREIGN KEY (initiated_by_user_id) REFERENCES users(id) ON DELETE CASCADE,
+		FOREIGN KEY (recipient_user_id) REFERENCES users(id) ON DELETE CASCADE
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_application_transfers_recipient_user_id ON application_transfers(recipient_user_id)`,
+	`ALTER TABLE api_key_ip_denials ADD COLUMN attempt_count INTEGER NOT NULL DEFAULT 1`,
+	`ALTER TABLE api_key_ip_denials ADD COLUMN last_denied_at TIMESTAMP`,
+	`UPDATE api_key_ip_denials SET last_denied_at = created_at`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_api_key_ip_denials_bucket ON api_key_ip_denials(api_key_id, source_ip, created_at)`,
+	// Existing trusts pin their repository ID on the next exchange.
+	`ALTER TABLE github_oidc_trusts ADD COLUMN repository_id TEXT NOT NULL DEFAULT ''`,
 }
 
+// RunMigrations applies every schema migration that has not been recorded in
+// schema_migrations yet. Migrations are append-only: never edit or reorder an
+// existing entry, add a new one instead.
 func RunMigrations(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("create schema_migrations table: %w", err)
+	}
+
+	appliedVersions, err := listAppliedMigrationVersions(ctx, db)
+	if err != nil {
+		return err
+	}
+
 	for migrationIndex, statement := range schemaMigrations {
+		version := migrationIndex + 1
+		if appliedVersions[version] {
+			continue
+		}
+
 		if _, err := db.ExecContext(ctx, statement); err != nil {
-			return fmt.Errorf("execute migration %d: %w", migrationIndex+1, err)
+			return fmt.Errorf("execute migration %d: %w", version, err)
+		}
+
+		if _, err := db.ExecContext(
+			ctx,
+			`INSERT INTO schema_migrations (version, applied_at) VALUES ($1, $2)`,
+			version,
+			time.Now().UTC(),
+		); err != nil {
+			return fmt.Errorf("record migration %d: %w", version, err)
 		}
 	}
 
 	return nil
 }
+
+func listAppliedMigrationVersions(ctx context.Context, db *sql.DB) (map[int]bool, error) {
+	rows, err := db.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("list applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	versions := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, fmt.Errorf("scan applied migration: %w", err)
+		}
+		versions[version] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate applied migrations: %w", err)
+	}
+
+	return versions, nil
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -23,44 +24,288 @@ type UserRecord struct {
 	Role         string
 	CreatedAt    time.Time
 	UpdatedAt    time.Time
+	VerifiedAt   *time.Time
+	// TOTPSecret is the encrypted authenticator secret. It is set during enrollment, before
+	// TOTPEnabledAt, and cleared when two-factor authentication is turned off.
+	TOTPSecret    string
+	TOTPEnabledAt *time.Time
+	// TOTPLastStep is the time step of the last accepted code, so a code cannot be replayed.
+	TOTPLastStep int64
+	// DisabledAt is when an admin disabled the account; nil while it is active.
+	DisabledAt *time.Time
 }
 
 type SessionRecord struct {
 	ID        string
 	UserID    string
 	TokenHash string
+	// HashVersion is the security.HashVersion* TokenHash was computed with.
+	HashVersion int
+	ExpiresAt   time.Time
+	CreatedAt   time.Time
+	LastSeenAt  time.Time
+	IP          string
+	UserAgent   string
+	// Role is the user's role when the token was issued; a different role means the
+	// user's privileges changed and the token must be rotated.
+	Role string
+}
+
+type PasswordResetTokenRecord struct {
+	ID        string
+	UserID    string
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+type EmailVerificationTokenRecord struct {
+	ID        string
+	UserID    string
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// EmailChangeRecord is a pending change of address, confirmed from a link sent to NewEmail.
+type EmailChangeRecord struct {
+	ID        string
+	UserID    string
+	NewEmail  string
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// UserIdentityRecord links a user to their account at an external identity provider.
+type UserIdentityRecord struct {
+	ID        string
+	UserID    string
+	Issuer    string
+	Subject   string
+	CreatedAt time.Time
+}
+
+type RecoveryCodeRecord struct {
+	ID        string
+	UserID    string
+	CodeHash  string
+	CreatedAt time.Time
+}
+
+type LoginChallengeRecord struct {
+	ID        string
+	UserID    string
+	TokenHash string
+	Attempts  int
 	ExpiresAt time.Time
 	CreatedAt time.Time
 }
 
+// AuthFailureRecord counts recent failed authentication attempts for one subject (an email
+// address or a source IP) within a scope.
+type AuthFailureRecord struct {
+	Scope         string
+	Subject       string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+type OrganizationRecord struct {
+	ID   string
+	Name string
+	// PersonalUserID is set on the organization every user gets on sign-up; nobody else can join it.
+	PersonalUserID string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+type OrganizationMemberRecord struct {
+	OrganizationID string
+	UserID         string
+	Role           string
+	CreatedAt      time.Time
+	// Email is only populated when listing an organization's members.
+	Email string
+}
+
+type InvitationRecord struct {
+	ID              string
+	OrganizationID  string
+	Email           string
+	Role            string
+	TokenHash       string
+	InvitedByUserID string
+	ExpiresAt       time.Time
+	SentAt          time.Time
+	CreatedAt       time.Time
+}
+
+// ApplicationTransferRecord is a pending move of an application to another user, waiting for
+// the recipient to accept it.
+type ApplicationTransferRecord struct {
+	ID                 string
+	ApplicationID      string
+	FromOrganizationID string
+	InitiatedByUserID  string
+	RecipientUserID    string
+	ExpiresAt          time.Time
+	CreatedAt          time.Time
+	// ApplicationName, InitiatedByEmail and RecipientEmail are populated when reading transfers,
+	// not when accepting one.
+	ApplicationName  string
+	InitiatedByEmail string
+	RecipientEmail   string
+}
+
+// OrganizationMembershipRecord is an organization together with the role a user holds in it.
+type OrganizationMembershipRecord struct {
+	Organization OrganizationRecord
+	Role         string
+}
+
 type ApplicationRecord struct {
+	ID string
+	// OwnerUserID is the user who created the application; access is granted through OrganizationID.
+	OwnerUserID           string
+	OrganizationID        string
+	Name                  string
+	Description           string
+	GitHubLink            string
+	Domain                string
+	MaxAPIKeyLifetimeDays int
+	AllowedCIDRs          []string
+	RateLimitPerMinute    int
+	CreatedAt             time.Time
+	UpdatedAt             time.Time
+	// ArchivedAt is when the application was archived; nil while it is in use.
+	ArchivedAt *time.Time
+}
+
+type APIKeyRecord struct {
+	ID                 string
+	ApplicationID      string
+	Name               string
+	KeyPrefix          string
+	KeyHash            string
+	HashVersion        int
+	Scopes             []string
+	CreatedAt          time.Time
+	LastUsedAt         *time.Time
+	RevokedAt          *time.Time
+	ExpiresAt          *time.Time
+	RotatedFromKeyID   string
+	ReplacedByKeyID    string
+	RotatedAt          *time.Time
+	GraceExpiresAt     *time.Time
+	AllowedCIDRs       []string
+	RateLimitPerMinute int
+	LastUsedIP         string
+	LastUsedUserAgent  string
+}
+
+type APIKeyAuthRecord struct {
+	Key         APIKeyRecord
+	Application ApplicationRecord
+}
+
+// AdminApplicationRecord is an application with the details the admin console lists.
+type AdminApplicationRecord struct {
+	Application      ApplicationRecord
+	OrganizationName string
+	CreatorEmail     string
+	ActiveAPIKeys    int
+	RunningBrowsers  int
+}
+
+type RunningBrowserSessionRecord struct {
+	Session          BrowserSessionRecord
+	ApplicationName  string
+	OrganizationName string
+}
+
+// AuditEventRecord is one entry of the append-only audit trail. Changes holds a JSON object.
+type AuditEventRecord struct {
 	ID          string
-	OwnerUserID string
-	Name        string
-	Description string
-	GitHubLink  string
-	Domain      string
+	ActorUserID string
+	Action      string
+	TargetType  string
+	TargetID    string
+	IP          string
+	UserAgent   string
+	RequestID   string
+	Changes     string
 	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	// OrganizationID is the organization the target belongs to; empty for site-wide events.
+	OrganizationID string
+	// ActorEmail is only set when listing, and is empty once the actor's account is gone.
+	ActorEmail string
 }
 
-type APIKeyRecord struct {
+// AuditEventFilter narrows audit event listings. Zero values match everything.
+type AuditEventFilter struct {
+	// OrganizationIDs restricts events to these organizations when non-nil. An empty,
+	// non-nil slice matches nothing.
+	OrganizationIDs []string
+	ActorEmail      string
+	// Action matches events whose action starts with it, e.g. "api_key." for every key event.
+	Action   string
+	TargetID string
+	Since    *time.Time
+	Until    *time.Time
+}
+
+type APIKeyExpiryRecord struct {
+	Key             APIKeyRecord
+	ApplicationName string
+	OwnerEmail      string
+}
+
+type APIKeyLastUseRecord struct {
+	APIKeyID  string
+	UsedAt    time.Time
+	IP        string
+	UserAgent string
+}
+
+type APIKeyEndpointUsageRecord struct {
+	APIKeyID     string
+	Endpoint     string
+	RequestCount int
+	LastUsedAt   time.Time
+}
+
+// APIKeyIPDenialRecord counts the requests of one key denied from one source IP within a
+// bucket of time starting at CreatedAt.
+type APIKeyIPDenialRecord struct {
 	ID            string
+	APIKeyID      string
 	ApplicationID string
-	Name          string
-	KeyPrefix     string
-	KeyHash       string
-	CanRead       bool
-	CanWrite      bool
-	CanDelete     bool
+	SourceIP      string
+	AttemptCount  int
+	LastDeniedAt  time.Time
 	CreatedAt     time.Time
-	LastUsedAt    *time.Time
-	RevokedAt     *time.Time
 }
 
-type APIKeyAuthRecord struct {
-	Key         APIKeyRecord
-	Application ApplicationRecord
+type TokenSigningKeyRecord struct {
+	ID        string
+	Secret    string
+	CreatedAt time.Time
+}
+
+// GitHubOIDCTrustRecord lets GitHub Actions workflows of the application's repository
+// exchange their OIDC token for an access token acting as APIKeyID.
+type GitHubOIDCTrustRecord struct {
+	ApplicationID      string
+	APIKeyID           string
+	AllowedRef         string
+	AllowedEnvironment string
+	// RepositoryID is GitHub's numeric ID of the trusted repository, pinned by the first
+	// exchange so a deleted and recreated repository with the same name is not trusted.
+	RepositoryID string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
 }
 
 type BrowserSessionRecord struct {
@@ -80,23 +325,120 @@ type BrowserSessionRecord struct {
 	ClosedAt          *time.Time
 }
 
+const applicationColumns = `id, owner_user_id, name, description, github_link, domain, max_api_key_lifetime_days, created_at, updated_at, allowed_cidrs,
+	rate_limit_per_minute, organization_id, archived_at`
+
+const apiKeyColumns = `id, application_id, name, key_prefix, key_hash, scopes, created_at, last_used_at, revoked_at, expires_at,
+	rotated_from_key_id, replaced_by_key_id, rotated_at, grace_expires_at, allowed_cidrs, rate_limit_per_minute, last_used_ip, last_used_user_agent,
+	hash_version`
+
+const qualifiedAPIKeyColumns = `k.id, k.application_id, k.name, k.key_prefix, k.key_hash, k.scopes, k.created_at, k.last_used_at, k.revoked_at, k.expires_at,
+	k.rotated_from_key_id, k.replaced_by_key_id, k.rotated_at, k.grace_expires_at, k.allowed_cidrs, k.rate_limit_per_minute, k.last_used_ip,
+	k.last_used_user_agent, k.hash_version`
+
+const userColumns = `id, email, password_hash, role, created_at, updated_at, verified_at, totp_secret, totp_enabled_at, totp_last_step,
+	disabled_at`
+
+const qualifiedUserColumns = `u.id, u.email, u.password_hash, u.role, u.created_at, u.updated_at, u.verified_at, u.totp_secret,
+	u.totp_enabled_at, u.totp_last_step, u.disabled_at`
+
+const sessionColumns = `id, user_id, token_hash, hash_version, expires_at, created_at, last_seen_at, ip, user_agent, role`
+
+const qualifiedSessionColumns = `s.id, s.user_id, s.token_hash, s.hash_version, s.expires_at, s.created_at, s.last_seen_at, s.ip, s.user_agent,
+	s.role`
+
+const qualifiedApplicationColumns = `a.id, a.owner_user_id, a.name, a.description, a.github_link, a.domain, a.max_api_key_lifetime_days, a.created_at, a.updated_at,
+	a.allowed_cidrs, a.rate_limit_per_minute, a.organization_id, a.archived_at`
+
+const authFailureColumns = `scope, subject, failures, last_failure_at, locked_until`
+
+const organizationColumns = `id, name, personal_user_id, created_at, updated_at`
+
+const qualifiedOrganizationColumns = `o.id, o.name, o.personal_user_id, o.created_at, o.updated_at`
+
+const auditEventColumns = `id, actor_user_id, action, target_type, target_id, ip, user_agent, request_id, changes, created_at,
+	organization_id`
+
+const qualifiedAuditEventColumns = `e.id, e.actor_user_id, e.action, e.target_type, e.target_id, e.ip, e.user_agent, e.request_id, e.changes,
+	e.created_at, e.organization_id`
+
+const emailChangeColumns = `id, user_id, new_email, token_hash, expires_at, created_at`
+
+// accountOrganizationsQuery selects the organizations that are deleted along with user $1: their
+// personal organization and shared organizations nobody else belongs to.
+const accountOrganizationsQuery = `SELECT o.id FROM organizations o
+	WHERE o.personal_user_id = $1
+	   OR (o.personal_user_id IS NULL
+	       AND EXISTS (SELECT 1 FROM organization_members m WHERE m.organization_id = o.id AND m.user_id = $1)
+	       AND NOT EXISTS (SELECT 1 FROM organization_members m WHERE m.organization_id = o.id AND m.user_id <> $1))`
+
+const invitationColumns = `id, organization_id, email, role, token_hash, invited_by_user_id, expires_at, sent_at, created_at`
+
+const applicationTransferColumns = `id, application_id, from_organization_id, initiated_by_user_id, recipient_user_id, expires_at, created_at`
+
+const selectApplicationTransfers = `SELECT t.id, t.application_id, t.from_organization_id, t.initiated_by_user_id, t.recipient_user_id, t.expires_at, t.created_at,
+		a.name, ui.email, ur.email
+	 FROM application_transfers t
+	 INNER JOIN applications a ON a.id = t.application_id
+	 INNER JOIN users ui ON ui.id = t.initiated_by_user_id
+	 INNER JOIN users ur ON ur.id = t.recipient_user_id`
+
+// PersonalOrganizationID is the ID of the organization created alongside a user.
+func PersonalOrganizationID(userID string) string {
+	return "org_" + userID
+}
+
+// CreateUser inserts a user together with their personal organization.
 func (s *Store) CreateUser(ctx context.Context, record UserRecord) error {
-	_, err := s.db.ExecContext(
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin user creation: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := insertUser(ctx, tx, record); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit user creation: %w", err)
+	}
+
+	return nil
+}
+
+func insertUser(ctx context.Context, tx *sql.Tx, record UserRecord) error {
+	if _, err := tx.ExecContext(
 		ctx,
-		`INSERT INTO users (id, email, password_hash, role, created_at, updated_at)
-		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		`INSERT INTO users (`+userColumns+`)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
 		record.ID,
 		record.Email,
 		record.PasswordHash,
 		record.Role,
 		record.CreatedAt,
 		record.UpdatedAt,
-	)
-	if err != nil {
+		record.VerifiedAt,
+		record.TOTPSecret,
+		record.TOTPEnabledAt,
+		record.TOTPLastStep,
+		record.DisabledAt,
+	); err != nil {
 		return fmt.Errorf("insert user: %w", err)
 	}
 
-	return nil
+	return insertOrganization(ctx, tx, OrganizationRecord{
+		ID:             PersonalOrganizationID(record.ID),
+		Name:           "Personal",
+		PersonalUserID: record.ID,
+		CreatedAt:      record.CreatedAt,
+		UpdatedAt:      record.CreatedAt,
+	}, OrganizationMemberRecord{
+		OrganizationID: PersonalOrganizationID(record.ID),
+		UserID:         record.ID,
+		Role:           "owner",
+		CreatedAt:      record.CreatedAt,
+	})
 }
 
 func (s *Store) CountUsers(ctx context.Context) (int, error) {
@@ -109,21 +451,14 @@ func (s *Store) CountUsers(ctx context.Context) (int, error) {
 }
 
 func (s *Store) GetUserByEmail(ctx context.Context, email string) (UserRecord, bool, error) {
-	var record UserRecord
+	var row userRow
 	err := s.db.QueryRowContext(
 		ctx,
-		`SELECT id, email, password_hash, role, created_at, updated_at
+		`SELECT `+userColumns+`
 		 FROM users
 		 WHERE email = $1`,
 		email,
-	).Scan(
-		&record.ID,
-		&record.Email,
-		&record.PasswordHash,
-		&record.Role,
-		&record.CreatedAt,
-		&record.UpdatedAt,
-	)
+	).Scan(row.targets()...)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return UserRecord{}, false, nil
@@ -132,25 +467,18 @@ func (s *Store) GetUserByEmail(ctx context.Context, email string) (UserRecord, b
 		return UserRecord{}, false, fmt.Errorf("query user by email: %w", err)
 	}
 
-	return record, true, nil
+	return row.record(), true, nil
 }
 
 func (s *Store) GetUserByID(ctx context.Context, userID string) (UserRecord, bool, error) {
-	var record UserRecord
+	var row userRow
 	err := s.db.QueryRowContext(
 		ctx,
-		`SELECT id, email, password_hash, role, created_at, updated_at
+		`SELECT `+userColumns+`
 		 FROM users
 		 WHERE id = $1`,
 		userID,
-	).Scan(
-		&record.ID,
-		&record.Email,
-		&record.PasswordHash,
-		&record.Role,
-		&record.CreatedAt,
-		&record.UpdatedAt,
-	)
+	).Scan(row.targets()...)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return UserRecord{}, false, nil
@@ -159,7 +487,7 @@ func (s *Store) GetUserByID(ctx context.Context, userID string) (UserRecord, boo
 		return UserRecord{}, false, fmt.Errorf("query user by id: %w", err)
 	}
 
-	return record, true, nil
+	return row.record(), true, nil
 }
 
 func (s *Store) ListUsers(ctx context.Context, limit int) ([]UserRecord, error) {
@@ -169,7 +497,7 @@ func (s *Store) ListUsers(ctx context.Context, limit int) ([]UserRecord, error)
 
 	rows, err := s.db.QueryContext(
 		ctx,
-		`SELECT id, email, password_hash, role, created_at, updated_at
+		`SELECT `+userColumns+`
 		 FROM users
 		 ORDER BY created_at ASC
 		 LIMIT $1`,
@@ -182,18 +510,11 @@ func (s *Store) ListUsers(ctx context.Context, limit int) ([]UserRecord, error)
 
 	users := make([]UserRecord, 0, limit)
 	for rows.Next() {
-		var user UserRecord
-		if err := rows.Scan(
-			&user.ID,
-			&user.Email,
-			&user.PasswordHash,
-			&user.Role,
-			&user.CreatedAt,
-			&user.UpdatedAt,
-		); err != nil {
+		var row userRow
+		if err := rows.Scan(row.targets()...); err != nil {
 			return nil, fmt.Errorf("scan listed user: %w", err)
 		}
-		users = append(users, user)
+		users = append(users, row.record())
 	}
 
 	if err := rows.Err(); err != nil {
@@ -203,311 +524,3179 @@ func (s *Store) ListUsers(ctx context.Context, limit int) ([]UserRecord, error)
 	return users, nil
 }
 
-func (s *Store) CreateSession(ctx context.Context, record SessionRecord) error {
-	_, err := s.db.ExecContext(
+// SearchUsers pages through users whose email contains query, oldest first, and returns the
+// total number of matches.
+func (s *Store) SearchUsers(ctx context.Context, query string, limit int, offset int) ([]UserRecord, int, error) {
+	pattern := "%" + likeEscaper.Replace(strings.ToLower(query)) + "%"
+
+	var total int
+	if err := s.db.QueryRowContext(
 		ctx,
-		`INSERT INTO sessions (id, user_id, token_hash, expires_at, created_at)
-		 VALUES ($1, $2, $3, $4, $5)`,
-		record.ID,
-		record.UserID,
-		record.TokenHash,
-		record.ExpiresAt,
-		record.CreatedAt,
+		`SELECT COUNT(*) FROM users WHERE email LIKE $1 ESCAPE '\'`,
+		pattern,
+	).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count matching users: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT `+userColumns+`
+		 FROM users
+		 WHERE email LIKE $1 ESCAPE '\'
+		 ORDER BY created_at ASC, id ASC
+		 LIMIT $2 OFFSET $3`,
+		pattern,
+		limit,
+		offset,
 	)
 	if err != nil {
-		return fmt.Errorf("insert session: %w", err)
+		return nil, 0, fmt.Errorf("search users: %w", err)
+	}
+	defer rows.Close()
+
+	users := make([]UserRecord, 0, limit)
+	for rows.Next() {
+		var row userRow
+		if err := rows.Scan(row.targets()...); err != nil {
+			return nil, 0, fmt.Errorf("scan matching user: %w", err)
+		}
+		users = append(users, row.record())
 	}
 
-	return nil
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("iterate matching users: %w", err)
+	}
+
+	return users, total, nil
 }
 
-func (s *Store) DeleteSessionByTokenHash(ctx context.Context, tokenHash string) error {
-	_, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE token_hash = $1`, tokenHash)
+// DisableUser marks the user disabled and ends all of their sessions. It reports false if
+// the user does not exist or is already disabled.
+func (s *Store) DisableUser(ctx context.Context, userID string, disabledAt time.Time) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("delete session: %w", err)
+		return false, fmt.Errorf("begin user disable: %w", err)
 	}
+	defer func() { _ = tx.Rollback() }()
 
-	return nil
+	result, err := tx.ExecContext(
+		ctx,
+		`UPDATE users SET disabled_at = $1, updated_at = $1 WHERE id = $2 AND disabled_at IS NULL`,
+		disabledAt,
+		userID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("disable user: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("read disabled user rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return false, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = $1`, userID); err != nil {
+		return false, fmt.Errorf("delete disabled user sessions: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM login_challenges WHERE user_id = $1`, userID); err != nil {
+		return false, fmt.Errorf("delete disabled user login challenges: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit user disable: %w", err)
+	}
+
+	return true, nil
 }
 
-func (s *Store) DeleteExpiredSessions(ctx context.Context, now time.Time) error {
-	_, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at <= $1`, now)
+// EnableUser reports false if the user does not exist or is not disabled.
+func (s *Store) EnableUser(ctx context.Context, userID string, updatedAt time.Time) (bool, error) {
+	result, err := s.db.ExecContext(
+		ctx,
+		`UPDATE users SET disabled_at = NULL, updated_at = $1 WHERE id = $2 AND disabled_at IS NOT NULL`,
+		updatedAt,
+		userID,
+	)
 	if err != nil {
-		return fmt.Errorf("delete expired sessions: %w", err)
+		return false, fmt.Errorf("enable user: %w", err)
 	}
 
-	return nil
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("read enabled user rows: %w", err)
+	}
+
+	return rowsAffected > 0, nil
 }
 
-func (s *Store) GetSessionWithUserByTokenHash(ctx context.Context, tokenHash string) (SessionRecord, UserRecord, bool, error) {
-	var session SessionRecord
-	var user UserRecord
-	query := `SELECT
-		s.id, s.user_id, s.token_hash, s.expires_at, s.created_at,
-		u.id, u.email, u.password_hash, u.role, u.created_at, u.updated_at
-	FROM sessions s
-	INNER JOIN users u ON u.id = s.user_id
-	WHERE s.token_hash = $1`
-	err := s.db.QueryRowContext(ctx, query, tokenHash).Scan(
-		&session.ID,
-		&session.UserID,
-		&session.TokenHash,
-		&session.ExpiresAt,
-		&session.CreatedAt,
-		&user.ID,
-		&user.Email,
-		&user.PasswordHash,
-		&user.Role,
-		&user.CreatedAt,
-		&user.UpdatedAt,
+// ListOrganizationsBlockingDeletion returns the shared organizations where the user is the only
+// owner but not the only member. Deleting the user would leave them without an owner.
+func (s *Store) ListOrganizationsBlockingDeletion(ctx context.Context, userID string) ([]OrganizationRecord, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT `+qualifiedOrganizationColumns+`
+		 FROM organizations o
+		 INNER JOIN organization_members m ON m.organization_id = o.id AND m.user_id = $1 AND m.role = 'owner'
+		 WHERE o.personal_user_id IS NULL
+		   AND NOT EXISTS (SELECT 1 FROM organization_members other WHERE other.organization_id = o.id AND other.user_id <> $1 AND other.role = 'owner')
+		   AND EXISTS (SELECT 1 FROM organization_members other WHERE other.organization_id = o.id AND other.user_id <> $1)
+		 ORDER BY o.name`,
+		userID,
 	)
+	if err != nil {
+		return nil, fmt.Errorf("list organizations blocking deletion: %w", err)
+	}
+	defer rows.Close()
+
+	records := make([]OrganizationRecord, 0)
+	for rows.Next() {
+		var row organizationRow
+		if err := rows.Scan(row.targets()...); err != nil {
+			return nil, fmt.Errorf("scan organization: %w", err)
+		}
+		records = append(records, row.record())
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate organizations: %w", err)
+	}
+
+	return records, nil
+}
+
+// DeleteUser removes a user and their data. Their personal organization and shared
+// organizations nobody else belongs to are deleted with their applications. Applications the
+// user created in organizations that live on are handed to the most senior remaining member.
+// Everything else that belongs to the user goes through ON DELETE CASCADE.
+func (s *Store) DeleteUser(ctx context.Context, userID string, updatedAt time.Time) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("begin user deletion: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`DELETE FROM applications WHERE organization_id IN (`+accountOrganizationsQuery+`)`,
+		userID,
+	); err != nil {
+		return false, fmt.Errorf("delete user applications: %w", err)
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`DELETE FROM organizations WHERE id IN (`+accountOrganizationsQuery+`)`,
+		userID,
+	); err != nil {
+		return false, fmt.Errorf("delete user organizations: %w", err)
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`UPDATE applications
+		 SET owner_user_id = (
+			SELECT m.user_id FROM organization_members m
+			WHERE m.organization_id = applications.organization_id AND m.user_id <> $1
+			ORDER BY CASE m.role WHEN 'owner' THEN 0 WHEN 'admin' THEN 1 WHEN 'developer' THEN 2 ELSE 3 END, m.created_at
+			LIMIT 1
+		 ), updated_at = $2
+		 WHERE owner_user_id = $1`,
+		userID,
+		updatedAt,
+	); err != nil {
+		return false, fmt.Errorf("hand over user applications: %w", err)
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, userID)
+	if err != nil {
+		return false, fmt.Errorf("delete user: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("read deleted user rows: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit user deletion: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func (s *Store) GetUserByIdentity(ctx context.Context, issuer string, subject string) (UserRecord, bool, error) {
+	var row userRow
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT `+qualifiedUserColumns+`
+		 FROM user_identities i
+		 INNER JOIN users u ON u.id = i.user_id
+		 WHERE i.issuer = $1 AND i.subject = $2`,
+		issuer,
+		subject,
+	).Scan(row.targets()...)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return SessionRecord{}, UserRecord{}, false, nil
+			return UserRecord{}, false, nil
 		}
 
-		return SessionRecord{}, UserRecord{}, false, fmt.Errorf("query session by token hash: %w", err)
+		return UserRecord{}, false, fmt.Errorf("query user by identity: %w", err)
 	}
 
-	return session, user, true, nil
+	return row.record(), true, nil
 }
 
-func (s *Store) CreateApplication(ctx context.Context, record ApplicationRecord) error {
-	_, err := s.db.ExecContext(
+// CreateUserWithIdentity provisions a user and their identity provider link together.
+func (s *Store) CreateUserWithIdentity(ctx context.Context, user UserRecord, identity UserIdentityRecord) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin user provisioning: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := insertUser(ctx, tx, user); err != nil {
+		return err
+	}
+
+	if err := insertUserIdentity(ctx, tx, identity); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit user provisioning: %w", err)
+	}
+
+	return nil
+}
+
+// LinkUserIdentity attaches an identity provider account to an existing user and marks
+// their email verified, since the provider vouched for it.
+func (s *Store) LinkUserIdentity(ctx context.Context, identity UserIdentityRecord) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin identity link: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := insertUserIdentity(ctx, tx, identity); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(
 		ctx,
-		`INSERT INTO applications (id, owner_user_id, name, description, github_link, domain, created_at, updated_at)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
-		record.ID,
-		record.OwnerUserID,
-		record.Name,
-		record.Description,
-		record.GitHubLink,
-		record.Domain,
-		record.CreatedAt,
-		record.UpdatedAt,
+		`UPDATE users SET verified_at = $1 WHERE id = $2 AND verified_at IS NULL`,
+		identity.CreatedAt,
+		identity.UserID,
+	); err != nil {
+		return fmt.Errorf("mark linked user verified: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit identity link: %w", err)
+	}
+
+	return nil
+}
+
+func insertUserIdentity(ctx context.Context, tx *sql.Tx, identity UserIdentityRecord) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO user_identities (id, user_id, issuer, subject, created_at)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		identity.ID,
+		identity.UserID,
+		identity.Issuer,
+		identity.Subject,
+		identity.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("insert user identity: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) UpdateUserRole(ctx context.Context, userID string, role string, updatedAt time.Time) (bool, error) {
+	result, err := s.db.ExecContext(
+		ctx,
+		`UPDATE users SET role = $1, updated_at = $2 WHERE id = $3`,
+		role,
+		updatedAt,
+		userID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("update user role: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("read updated user rows: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func (s *Store) CreateSession(ctx context.Context, record SessionRecord) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO sessions (id, user_id, token_hash, hash_version, expires_at, created_at, last_seen_at, ip, user_agent, role)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		record.ID,
+		record.UserID,
+		record.TokenHash,
+		record.HashVersion,
+		record.ExpiresAt,
+		record.CreatedAt,
+		record.LastSeenAt,
+		record.IP,
+		record.UserAgent,
+		record.Role,
+	)
+	if err != nil {
+		return fmt.Errorf("insert session: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteSessionByTokenHash deletes the session stored under any of the given hashes.
+func (s *Store) DeleteSessionByTokenHash(ctx context.Context, tokenHashes ...string) error {
+	if len(tokenHashes) == 0 {
+		return nil
+	}
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE token_hash IN (`+placeholders(1, len(tokenHashes))+`)`, stringArgs(tokenHashes)...)
+	if err != nil {
+		return fmt.Errorf("delete session: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteExpiredSessions deletes sessions past their absolute expiry or not seen since idleCutoff.
+func (s *Store) DeleteExpiredSessions(ctx context.Context, now time.Time, idleCutoff time.Time) (int, error) {
+	result, err := s.db.ExecContext(
+		ctx,
+		`DELETE FROM sessions WHERE expires_at <= $1 OR COALESCE(last_seen_at, created_at) <= $2`,
+		now,
+		idleCutoff,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("delete expired sessions: %w", err)
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("get expired sessions affected rows: %w", err)
+	}
+
+	return int(affectedRows), nil
+}
+
+// TouchSession records that a session was just used.
+func (s *Store) TouchSession(ctx context.Context, sessionID string, seenAt time.Time, ip string, userAgent string) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`UPDATE sessions SET last_seen_at = $1, ip = $2, user_agent = $3 WHERE id = $4`,
+		seenAt,
+		ip,
+		userAgent,
+		sessionID,
+	)
+	if err != nil {
+		return fmt.Errorf("touch session: %w", err)
+	}
+
+	return nil
+}
+
+// RotateSessionToken replaces a session's token hash. It reports false when the session's
+// token already changed, e.g. because a concurrent request rotated it first.
+func (s *Store) RotateSessionToken(ctx context.Context, sessionID string, oldHash string, newHash string, hashVersion int, role string) (bool, error) {
+	result, err := s.db.ExecContext(
+		ctx,
+		`UPDATE sessions SET token_hash = $1, hash_version = $2, role = $3 WHERE id = $4 AND token_hash = $5`,
+		newHash,
+		hashVersion,
+		role,
+		sessionID,
+		oldHash,
+	)
+	if err != nil {
+		return false, fmt.Errorf("rotate session token: %w", err)
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("get session rotation affected rows: %w", err)
+	}
+
+	return affectedRows > 0, nil
+}
+
+// ListSessionsByUserID returns the user's sessions that have not expired, most recently seen first.
+func (s *Store) ListSessionsByUserID(ctx context.Context, userID string, now time.Time) ([]SessionRecord, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT `+sessionColumns+`
+		 FROM sessions
+		 WHERE user_id = $1 AND expires_at > $2
+		 ORDER BY COALESCE(last_seen_at, created_at) DESC`,
+		userID,
+		now,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := make([]SessionRecord, 0)
+	for rows.Next() {
+		var row sessionRow
+		if err := rows.Scan(row.targets()...); err != nil {
+			return nil, fmt.Errorf("scan session: %w", err)
+		}
+		sessions = append(sessions, row.record())
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+func (s *Store) DeleteSessionForUser(ctx context.Context, userID string, sessionID string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE id = $1 AND user_id = $2`, sessionID, userID)
+	if err != nil {
+		return false, fmt.Errorf("delete session: %w", err)
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("get session delete affected rows: %w", err)
+	}
+
+	return affectedRows > 0, nil
+}
+
+func (s *Store) DeleteSessionsByUserID(ctx context.Context, userID string) (int, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = $1`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("delete user sessions: %w", err)
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("get user sessions delete affected rows: %w", err)
+	}
+
+	return int(affectedRows), nil
+}
+
+// GetSessionWithUserByTokenHash looks a session up by any of the given hashes, so sessions
+// stored under an older hash version are still found.
+// CreatePasswordResetToken stores a reset token and discards any earlier unused tokens for
+// the same user, so only the most recent link works.
+func (s *Store) CreatePasswordResetToken(ctx context.Context, record PasswordResetTokenRecord) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin password reset token creation: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`DELETE FROM password_reset_tokens WHERE user_id = $1 AND used_at IS NULL`,
+		record.UserID,
+	); err != nil {
+		return fmt.Errorf("delete previous password reset tokens: %w", err)
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO password_reset_tokens (id, user_id, token_hash, expires_at, created_at)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		record.ID,
+		record.UserID,
+		record.TokenHash,
+		record.ExpiresAt,
+		record.CreatedAt,
+	); err != nil {
+		return fmt.Errorf("insert password reset token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit password reset token: %w", err)
+	}
+
+	return nil
+}
+
+// ResetPasswordWithToken consumes an unused, unexpired reset token, sets the user's new
+// password hash and deletes all of the user's sessions in one transaction. It returns the
+// user ID, or false when the token is unknown, used or expired.
+func (s *Store) ResetPasswordWithToken(ctx context.Context, tokenHash string, passwordHash string, now time.Time) (string, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", false, fmt.Errorf("begin password reset: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var userID string
+	err = tx.QueryRowContext(
+		ctx,
+		`UPDATE password_reset_tokens
+		 SET used_at = $1
+		 WHERE token_hash = $2 AND used_at IS NULL AND expires_at > $1
+		 RETURNING user_id`,
+		now,
+		tokenHash,
+	).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, nil
+		}
+
+		return "", false, fmt.Errorf("consume password reset token: %w", err)
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`UPDATE users SET password_hash = $1, updated_at = $2 WHERE id = $3`,
+		passwordHash,
+		now,
+		userID,
+	); err != nil {
+		return "", false, fmt.Errorf("update user password: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = $1`, userID); err != nil {
+		return "", false, fmt.Errorf("delete user sessions: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", false, fmt.Errorf("commit password reset: %w", err)
+	}
+
+	return userID, true, nil
+}
+
+// DeleteStalePasswordResetTokens removes reset tokens that have expired or been used.
+func (s *Store) DeleteStalePasswordResetTokens(ctx context.Context, now time.Time) (int, error) {
+	result, err := s.db.ExecContext(
+		ctx,
+		`DELETE FROM password_reset_tokens WHERE expires_at <= $1 OR used_at IS NOT NULL`,
+		now,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("delete stale password reset tokens: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("read deleted password reset token rows: %w", err)
+	}
+
+	return int(rowsAffected), nil
+}
+
+// CreateEmailVerificationToken stores a verification token and discards any earlier tokens
+// for the same user, so only the most recent link works.
+func (s *Store) CreateEmailVerificationToken(ctx context.Context, record EmailVerificationTokenRecord) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin email verification token creation: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM email_verification_tokens WHERE user_id = $1`, record.UserID); err != nil {
+		return fmt.Errorf("delete previous email verification tokens: %w", err)
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO email_verification_tokens (id, user_id, token_hash, expires_at, created_at)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		record.ID,
+		record.UserID,
+		record.TokenHash,
+		record.ExpiresAt,
+		record.CreatedAt,
+	); err != nil {
+		return fmt.Errorf("insert email verification token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit email verification token: %w", err)
+	}
+
+	return nil
+}
+
+// GetEmailVerificationSentAt returns when the user's outstanding verification token was created.
+func (s *Store) GetEmailVerificationSentAt(ctx context.Context, userID string) (time.Time, bool, error) {
+	var sentAt time.Time
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT created_at FROM email_verification_tokens WHERE user_id = $1 ORDER BY created_at DESC LIMIT 1`,
+		userID,
+	).Scan(&sentAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, false, nil
+		}
+
+		return time.Time{}, false, fmt.Errorf("query email verification sent at: %w", err)
+	}
+
+	return sentAt, true, nil
+}
+
+// VerifyEmailWithToken consumes an unexpired verification token and marks its user verified.
+// It returns the user ID, or false when the token is unknown or expired.
+func (s *Store) VerifyEmailWithToken(ctx context.Context, tokenHash string, now time.Time) (string, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", false, fmt.Errorf("begin email verification: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var userID string
+	err = tx.QueryRowContext(
+		ctx,
+		`DELETE FROM email_verification_tokens
+		 WHERE token_hash = $1 AND expires_at > $2
+		 RETURNING user_id`,
+		tokenHash,
+		now,
+	).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, nil
+		}
+
+		return "", false, fmt.Errorf("consume email verification token: %w", err)
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`UPDATE users SET verified_at = $1, updated_at = $1 WHERE id = $2 AND verified_at IS NULL`,
+		now,
+		userID,
+	); err != nil {
+		return "", false, fmt.Errorf("mark user verified: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", false, fmt.Errorf("commit email verification: %w", err)
+	}
+
+	return userID, true, nil
+}
+
+func (s *Store) DeleteExpiredEmailVerificationTokens(ctx context.Context, now time.Time) (int, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM email_verification_tokens WHERE expires_at <= $1`, now)
+	if err != nil {
+		return 0, fmt.Errorf("delete expired email verification tokens: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("read deleted email verification token rows: %w", err)
+	}
+
+	return int(rowsAffected), nil
+}
+
+// UpdateUserPassword sets a new password hash and deletes the user's other sessions, keeping
+// keepSessionID. It returns how many sessions were deleted.
+func (s *Store) UpdateUserPassword(ctx context.Context, userID string, passwordHash string, keepSessionID string, updatedAt time.Time) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin password change: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`UPDATE users SET password_hash = $1, updated_at = $2 WHERE id = $3`,
+		passwordHash,
+		updatedAt,
+		userID,
+	); err != nil {
+		return 0, fmt.Errorf("update user password: %w", err)
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = $1 AND id <> $2`, userID, keepSessionID)
+	if err != nil {
+		return 0, fmt.Errorf("delete other user sessions: %w", err)
+	}
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("read deleted session rows: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit password change: %w", err)
+	}
+
+	return int(revoked), nil
+}
+
+// CreateEmailChange stores a pending email change and discards any earlier one for the same
+// user, so only the most recent link works.
+func (s *Store) CreateEmailChange(ctx context.Context, record EmailChangeRecord) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin email change creation: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM email_changes WHERE user_id = $1`, record.UserID); err != nil {
+		return fmt.Errorf("delete previous email changes: %w", err)
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO email_changes (`+emailChangeColumns+`)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		record.ID,
+		record.UserID,
+		record.NewEmail,
+		record.TokenHash,
+		record.ExpiresAt,
+		record.CreatedAt,
+	); err != nil {
+		return fmt.Errorf("insert email change: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit email change: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) GetEmailChangeByUserID(ctx context.Context, userID string) (EmailChangeRecord, bool, error) {
+	return s.getEmailChange(ctx, `user_id = $1`, userID)
+}
+
+func (s *Store) GetEmailChangeByTokenHash(ctx context.Context, tokenHash string) (EmailChangeRecord, bool, error) {
+	return s.getEmailChange(ctx, `token_hash = $1`, tokenHash)
+}
+
+func (s *Store) getEmailChange(ctx context.Context, condition string, value string) (EmailChangeRecord, bool, error) {
+	var record EmailChangeRecord
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT `+emailChangeColumns+` FROM email_changes WHERE `+condition,
+		value,
+	).Scan(&record.ID, &record.UserID, &record.NewEmail, &record.TokenHash, &record.ExpiresAt, &record.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return EmailChangeRecord{}, false, nil
+		}
+
+		return EmailChangeRecord{}, false, fmt.Errorf("query email change: %w", err)
+	}
+
+	return record, true, nil
+}
+
+func (s *Store) DeleteEmailChange(ctx context.Context, userID string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM email_changes WHERE user_id = $1`, userID)
+	if err != nil {
+		return false, fmt.Errorf("delete email change: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("read deleted email change rows: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// ConfirmEmailChange consumes an unexpired email change and moves its user to the new address,
+// which counts as verified. It returns the consumed change, or false when the token is unknown
+// or expired.
+func (s *Store) ConfirmEmailChange(ctx context.Context, tokenHash string, now time.Time) (EmailChangeRecord, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return EmailChangeRecord{}, false, fmt.Errorf("begin email change confirmation: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var record EmailChangeRecord
+	err = tx.QueryRowContext(
+		ctx,
+		`DELETE FROM email_changes
+		 WHERE token_hash = $1 AND expires_at > $2
+		 RETURNING `+emailChangeColumns,
+		tokenHash,
+		now,
+	).Scan(&record.ID, &record.UserID, &record.NewEmail, &record.TokenHash, &record.ExpiresAt, &record.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return EmailChangeRecord{}, false, nil
+		}
+
+		return EmailChangeRecord{}, false, fmt.Errorf("consume email change: %w", err)
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`UPDATE users SET email = $1, verified_at = $2, updated_at = $2 WHERE id = $3`,
+		record.NewEmail,
+		now,
+		record.UserID,
+	); err != nil {
+		return EmailChangeRecord{}, false, fmt.Errorf("update user email: %w", err)
+	}
+
+	// Links sent to the old address must not verify the new one.
+	if _, err := tx.ExecContext(ctx, `DELETE FROM email_verification_tokens WHERE user_id = $1`, record.UserID); err != nil {
+		return EmailChangeRecord{}, false, fmt.Errorf("delete email verification tokens: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return EmailChangeRecord{}, false, fmt.Errorf("commit email change confirmation: %w", err)
+	}
+
+	return record, true, nil
+}
+
+func (s *Store) DeleteExpiredEmailChanges(ctx context.Context, now time.Time) (int, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM email_changes WHERE expires_at <= $1`, now)
+	if err != nil {
+		return 0, fmt.Errorf("delete expired email changes: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("read deleted email change rows: %w", err)
+	}
+
+	return int(rowsAffected), nil
+}
+
+// SetPendingTOTPSecret stores an encrypted secret for an enrollment that has not been
+// confirmed yet. It does nothing once two-factor authentication is enabled.
+func (s *Store) SetPendingTOTPSecret(ctx context.Context, userID string, secret string, updatedAt time.Time) (bool, error) {
+	result, err := s.db.ExecContext(
+		ctx,
+		`UPDATE users SET totp_secret = $1, updated_at = $2 WHERE id = $3 AND totp_enabled_at IS NULL`,
+		secret,
+		updatedAt,
+		userID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("set pending TOTP secret: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("read pending TOTP secret rows: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// EnableTOTP confirms a pending enrollment and replaces the user's recovery codes.
+func (s *Store) EnableTOTP(ctx context.Context, userID string, secret string, step int64, recoveryCodes []RecoveryCodeRecord, enabledAt time.Time) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("begin TOTP enrollment: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.ExecContext(
+		ctx,
+		`UPDATE users
+		 SET totp_enabled_at = $1, totp_last_step = $2, updated_at = $1
+		 WHERE id = $3 AND totp_secret = $4 AND totp_enabled_at IS NULL`,
+		enabledAt,
+		step,
+		userID,
+		secret,
+	)
+	if err != nil {
+		return false, fmt.Errorf("enable TOTP: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("read enabled TOTP rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return false, nil
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodes); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit TOTP enrollment: %w", err)
+	}
+
+	return true, nil
+}
+
+// DisableTOTP clears the user's secret and recovery codes.
+func (s *Store) DisableTOTP(ctx context.Context, userID string, updatedAt time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin disabling TOTP: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`UPDATE users SET totp_secret = '', totp_enabled_at = NULL, totp_last_step = 0, updated_at = $1 WHERE id = $2`,
+		updatedAt,
+		userID,
+	); err != nil {
+		return fmt.Errorf("disable TOTP: %w", err)
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, nil); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit disabling TOTP: %w", err)
+	}
+
+	return nil
+}
+
+// AdvanceTOTPStep records step as the last accepted code and reports false if a code from
+// that step or a later one was already accepted.
+func (s *Store) AdvanceTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	result, err := s.db.ExecContext(
+		ctx,
+		`UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1`,
+		step,
+		userID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("advance TOTP step: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("read advanced TOTP step rows: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func (s *Store) ReplaceRecoveryCodes(ctx context.Context, userID string, recoveryCodes []RecoveryCodeRecord) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin recovery code replacement: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit recovery code replacement: %w", err)
+	}
+
+	return nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID string, recoveryCodes []RecoveryCodeRecord) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("delete recovery codes: %w", err)
+	}
+
+	for _, recoveryCode := range recoveryCodes {
+		if _, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO user_recovery_codes (id, user_id, code_hash, created_at)
+			 VALUES ($1, $2, $3, $4)`,
+			recoveryCode.ID,
+			userID,
+			recoveryCode.CodeHash,
+			recoveryCode.CreatedAt,
+		); err != nil {
+			return fmt.Errorf("insert recovery code: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used and reports whether one matched.
+func (s *Store) UseRecoveryCode(ctx context.Context, userID string, codeHash string, usedAt time.Time) (bool, error) {
+	result, err := s.db.ExecContext(
+		ctx,
+		`UPDATE user_recovery_codes SET used_at = $1 WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL`,
+		usedAt,
+		userID,
+		codeHash,
+	)
+	if err != nil {
+		return false, fmt.Errorf("use recovery code: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("read used recovery code rows: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func (s *Store) CountUnusedRecoveryCodes(ctx context.Context, userID string) (int, error) {
+	var count int
+	if err := s.db.QueryRowContext(
+		ctx,
+		`SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = $1 AND used_at IS NULL`,
+		userID,
+	).Scan(&count); err != nil {
+		return 0, fmt.Errorf("count unused recovery codes: %w", err)
+	}
+
+	return count, nil
+}
+
+func (s *Store) CreateLoginChallenge(ctx context.Context, record LoginChallengeRecord) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO login_challenges (id, user_id, token_hash, attempts, expires_at, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		record.ID,
+		record.UserID,
+		record.TokenHash,
+		record.Attempts,
+		record.ExpiresAt,
+		record.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("insert login challenge: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) GetLoginChallengeWithUserByTokenHash(ctx context.Context, tokenHash string) (LoginChallengeRecord, UserRecord, bool, error) {
+	var challenge LoginChallengeRecord
+	var user userRow
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT c.id, c.user_id, c.token_hash, c.attempts, c.expires_at, c.created_at, `+qualifiedUserColumns+`
+		 FROM login_challenges c
+		 INNER JOIN users u ON u.id = c.user_id
+		 WHERE c.token_hash = $1`,
+		tokenHash,
+	).Scan(append([]any{
+		&challenge.ID,
+		&challenge.UserID,
+		&challenge.TokenHash,
+		&challenge.Attempts,
+		&challenge.ExpiresAt,
+		&challenge.CreatedAt,
+	}, user.targets()...)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return LoginChallengeRecord{}, UserRecord{}, false, nil
+		}
+
+		return LoginChallengeRecord{}, UserRecord{}, false, fmt.Errorf("query login challenge: %w", err)
+	}
+
+	return challenge, user.record(), true, nil
+}
+
+// RecordLoginChallengeAttempt counts a failed attempt and returns the new total.
+func (s *Store) RecordLoginChallengeAttempt(ctx context.Context, challengeID string) (int, error) {
+	var attempts int
+	err := s.db.QueryRowContext(
+		ctx,
+		`UPDATE login_challenges SET attempts = attempts + 1 WHERE id = $1 RETURNING attempts`,
+		challengeID,
+	).Scan(&attempts)
+	if err != nil {
+		return 0, fmt.Errorf("record login challenge attempt: %w", err)
+	}
+
+	return attempts, nil
+}
+
+// DeleteLoginChallenge removes a challenge and reports whether it still existed, so only one
+// request can complete it.
+func (s *Store) DeleteLoginChallenge(ctx context.Context, challengeID string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM login_challenges WHERE id = $1`, challengeID)
+	if err != nil {
+		return false, fmt.Errorf("delete login challenge: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("read deleted login challenge rows: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func (s *Store) DeleteExpiredLoginChallenges(ctx context.Context, now time.Time) (int, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM login_challenges WHERE expires_at <= $1`, now)
+	if err != nil {
+		return 0, fmt.Errorf("delete expired login challenges: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("read deleted login challenge rows: %w", err)
+	}
+
+	return int(rowsAffected), nil
+}
+
+func (s *Store) GetSetting(ctx context.Context, name string) (string, bool, error) {
+	var value string
+	err := s.db.QueryRowContext(ctx, `SELECT value FROM settings WHERE name = $1`, name).Scan(&value)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, nil
+		}
+
+		return "", false, fmt.Errorf("query setting %s: %w", name, err)
+	}
+
+	return value, true, nil
+}
+
+func (s *Store) SetSetting(ctx context.Context, name string, value string, updatedAt time.Time) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO settings (name, value, updated_at)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (name) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`,
+		name,
+		value,
+		updatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("upsert setting %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// RecordAuthFailure counts a failed attempt and returns the new total. Counts whose last
+// failure is before resetBefore start over.
+func (s *Store) RecordAuthFailure(ctx context.Context, scope string, subject string, failedAt time.Time, resetBefore time.Time) (int, error) {
+	var failures int
+	err := s.db.QueryRowContext(
+		ctx,
+		`INSERT INTO auth_failures (scope, subject, failures, last_failure_at)
+		 VALUES ($1, $2, 1, $3)
+		 ON CONFLICT (scope, subject) DO UPDATE SET
+		   failures = CASE WHEN auth_failures.last_failure_at < $4 THEN 1 ELSE auth_failures.failures + 1 END,
+		   last_failure_at = excluded.last_failure_at
+		 RETURNING failures`,
+		scope,
+		subject,
+		failedAt,
+		resetBefore,
+	).Scan(&failures)
+	if err != nil {
+		return 0, fmt.Errorf("record auth failure: %w", err)
+	}
+
+	return failures, nil
+}
+
+func (s *Store) LockAuthSubject(ctx context.Context, scope string, subject string, lockedUntil time.Time) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`UPDATE auth_failures SET locked_until = $1 WHERE scope = $2 AND subject = $3`,
+		lockedUntil,
+		scope,
+		subject,
+	)
+	if err != nil {
+		return fmt.Errorf("lock auth subject: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) GetAuthFailure(ctx context.Context, scope string, subject string) (AuthFailureRecord, bool, error) {
+	var row authFailureRow
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT `+authFailureColumns+`
+		 FROM auth_failures
+		 WHERE scope = $1 AND subject = $2`,
+		scope,
+		subject,
+	).Scan(row.targets()...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return AuthFailureRecord{}, false, nil
+		}
+
+		return AuthFailureRecord{}, false, fmt.Errorf("query auth failure: %w", err)
+	}
+
+	return row.record(), true, nil
+}
+
+// ListAuthFailures returns subjects that are locked at now or failed since the given time,
+// most recent first.
+func (s *Store) ListAuthFailures(ctx context.Context, now time.Time, since time.Time, limit int) ([]AuthFailureRecord, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT `+authFailureColumns+`
+		 FROM auth_failures
+		 WHERE locked_until > $1 OR last_failure_at >= $2
+		 ORDER BY last_failure_at DESC
+		 LIMIT $3`,
+		now,
+		since,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list auth failures: %w", err)
+	}
+	defer rows.Close()
+
+	records := make([]AuthFailureRecord, 0)
+	for rows.Next() {
+		var row authFailureRow
+		if err := rows.Scan(row.targets()...); err != nil {
+			return nil, fmt.Errorf("scan auth failure: %w", err)
+		}
+		records = append(records, row.record())
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate auth failures: %w", err)
+	}
+
+	return records, nil
+}
+
+func (s *Store) DeleteAuthFailure(ctx context.Context, scope string, subject string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM auth_failures WHERE scope = $1 AND subject = $2`, scope, subject)
+	if err != nil {
+		return false, fmt.Errorf("delete auth failure: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("read deleted auth failure rows: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// DeleteStaleAuthFailures removes counts that are no longer locked and whose last failure is
+// before lastFailureBefore.
+func (s *Store) DeleteStaleAuthFailures(ctx context.Context, now time.Time, lastFailureBefore time.Time) (int, error) {
+	result, err := s.db.ExecContext(
+		ctx,
+		`DELETE FROM auth_failures
+		 WHERE last_failure_at < $1 AND (locked_until IS NULL OR locked_until <= $2)`,
+		lastFailureBefore,
+		now,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("delete stale auth failures: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("read deleted auth failure rows: %w", err)
+	}
+
+	return int(rowsAffected), nil
+}
+
+func (s *Store) GetSessionWithUserByTokenHash(ctx context.Context, tokenHashes ...string) (SessionRecord, UserRecord, bool, error) {
+	if len(tokenHashes) == 0 {
+		return SessionRecord{}, UserRecord{}, false, nil
+	}
+
+	var session sessionRow
+	var user userRow
+	query := `SELECT ` + qualifiedSessionColumns + `, ` + qualifiedUserColumns + `
+	FROM sessions s
+	INNER JOIN users u ON u.id = s.user_id
+	WHERE s.token_hash IN (` + placeholders(1, len(tokenHashes)) + `)`
+	err := s.db.QueryRowContext(ctx, query, stringArgs(tokenHashes)...).Scan(append(session.targets(), user.targets()...)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return SessionRecord{}, UserRecord{}, false, nil
+		}
+
+		return SessionRecord{}, UserRecord{}, false, fmt.Errorf("query session by token hash: %w", err)
+	}
+
+	return session.record(), user.record(), true, nil
+}
+
+// CreateOrganization inserts an organization together with its first owner.
+func (s *Store) CreateOrganization(ctx context.Context, organization OrganizationRecord, owner OrganizationMemberRecord) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin organization creation: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := insertOrganization(ctx, tx, organization, owner); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit organization creation: %w", err)
+	}
+
+	return nil
+}
+
+func insertOrganization(ctx context.Context, tx *sql.Tx, organization OrganizationRecord, owner OrganizationMemberRecord) error {
+	if _, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO organizations (`+organizationColumns+`)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		organization.ID,
+		organization.Name,
+		nullableString(organization.PersonalUserID),
+		organization.CreatedAt,
+		organization.UpdatedAt,
+	); err != nil {
+		return fmt.Errorf("insert organization: %w", err)
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO organization_members (organization_id, user_id, role, created_at)
+		 VALUES ($1, $2, $3, $4)`,
+		owner.OrganizationID,
+		owner.UserID,
+		owner.Role,
+		owner.CreatedAt,
+	); err != nil {
+		return fmt.Errorf("insert organization owner: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) GetOrganizationByID(ctx context.Context, organizationID string) (OrganizationRecord, bool, error) {
+	var row organizationRow
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT `+organizationColumns+`
+		 FROM organizations
+		 WHERE id = $1`,
+		organizationID,
+	).Scan(row.targets()...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return OrganizationRecord{}, false, nil
+		}
+
+		return OrganizationRecord{}, false, fmt.Errorf("query organization by id: %w", err)
+	}
+
+	return row.record(), true, nil
+}
+
+// ListOrganizationsByUserID returns the organizations the user belongs to, personal one first.
+func (s *Store) ListOrganizationsByUserID(ctx context.Context, userID string) ([]OrganizationMembershipRecord, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT `+qualifiedOrganizationColumns+`, m.role
+		 FROM organization_members m
+		 INNER JOIN organizations o ON o.id = m.organization_id
+		 WHERE m.user_id = $1
+		 ORDER BY CASE WHEN o.personal_user_id IS NULL THEN 1 ELSE 0 END, o.name ASC, o.created_at ASC`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list organizations: %w", err)
+	}
+	defer rows.Close()
+
+	memberships := make([]OrganizationMembershipRecord, 0)
+	for rows.Next() {
+		var row organizationRow
+		var role string
+		if err := rows.Scan(append(row.targets(), &role)...); err != nil {
+			return nil, fmt.Errorf("scan organization: %w", err)
+		}
+		memberships = append(memberships, OrganizationMembershipRecord{Organization: row.record(), Role: role})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate organizations: %w", err)
+	}
+
+	return memberships, nil
+}
+
+func (s *Store) GetOrganizationMemberRole(ctx context.Context, organizationID string, userID string) (string, bool, error) {
+	var role string
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT role FROM organization_members WHERE organization_id = $1 AND user_id = $2`,
+		organizationID,
+		userID,
+	).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, nil
+		}
+
+		return "", false, fmt.Errorf("query organization member role: %w", err)
+	}
+
+	return role, true, nil
+}
+
+func (s *Store) ListOrganizationMembers(ctx context.Context, organizationID string) ([]OrganizationMemberRecord, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT m.organization_id, m.user_id, m.role, m.created_at, u.email
+		 FROM organization_members m
+		 INNER JOIN users u ON u.id = m.user_id
+		 WHERE m.organization_id = $1
+		 ORDER BY m.created_at ASC`,
+		organizationID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list organization members: %w", err)
+	}
+	defer rows.Close()
+
+	members := make([]OrganizationMemberRecord, 0)
+	for rows.Next() {
+		var member OrganizationMemberRecord
+		if err := rows.Scan(&member.OrganizationID, &member.UserID, &member.Role, &member.CreatedAt, &member.Email); err != nil {
+			return nil, fmt.Errorf("scan organization member: %w", err)
+		}
+		members = append(members, member)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate organization members: %w", err)
+	}
+
+	return members, nil
+}
+
+// AddOrganizationMember reports false if the user is already a member.
+func (s *Store) AddOrganizationMember(ctx context.Context, member OrganizationMemberRecord) (bool, error) {
+	result, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO organization_members (organization_id, user_id, role, created_at)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (organization_id, user_id) DO NOTHING`,
+		member.OrganizationID,
+		member.UserID,
+		member.Role,
+		member.CreatedAt,
+	)
+	if err != nil {
+		return false, fmt.Errorf("insert organization member: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("read inserted organization member rows: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// UpdateOrganizationMemberRole changes a member's role. It reports false if the member does
+// not exist or is the organization's last owner being demoted.
+func (s *Store) UpdateOrganizationMemberRole(ctx context.Context, organizationID string, userID string, role string) (bool, error) {
+	result, err := s.db.ExecContext(
+		ctx,
+		`UPDATE organization_members
+		 SET role = $1
+		 WHERE organization_id = $2 AND user_id = $3
+		   AND (role <> 'owner' OR $1 = 'owner'
+		        OR (SELECT COUNT(*) FROM organization_members WHERE organization_id = $2 AND role = 'owner') > 1)`,
+		role,
+		organizationID,
+		userID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("update organization member role: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("read updated organization member rows: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// DeleteOrganizationMember removes a member. It reports false if the member does not exist or
+// is the organization's last owner.
+func (s *Store) DeleteOrganizationMember(ctx context.Context, organizationID string, userID string) (bool, error) {
+	result, err := s.db.ExecContext(
+		ctx,
+		`DELETE FROM organization_members
+		 WHERE organization_id = $1 AND user_id = $2
+		   AND (role <> 'owner'
+		        OR (SELECT COUNT(*) FROM organization_members WHERE organization_id = $1 AND role = 'owner') > 1)`,
+		organizationID,
+		userID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("delete organization member: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("read deleted organization member rows: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func (s *Store) CreateAuditEvent(ctx context.Context, record AuditEventRecord) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO audit_events (`+auditEventColumns+`)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		record.ID,
+		record.ActorUserID,
+		record.Action,
+		record.TargetType,
+		record.TargetID,
+		record.IP,
+		record.UserAgent,
+		record.RequestID,
+		record.Changes,
+		record.CreatedAt,
+		record.OrganizationID,
+	)
+	if err != nil {
+		return fmt.Errorf("insert audit event: %w", err)
+	}
+
+	return nil
+}
+
+// ListAuditEvents returns the most recent audit events, newest first.
+func (s *Store) ListAuditEvents(ctx context.Context, limit int) ([]AuditEventRecord, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT `+qualifiedAuditEventColumns+`, COALESCE(u.email, '')
+		 FROM audit_events e
+		 LEFT JOIN users u ON u.id = e.actor_user_id
+		 ORDER BY e.created_at DESC, e.id DESC
+		 LIMIT $1`,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list audit events: %w", err)
+	}
+	defer rows.Close()
+
+	events := make([]AuditEventRecord, 0, limit)
+	for rows.Next() {
+		var event AuditEventRecord
+		if err := rows.Scan(append(auditEventTargets(&event), &event.ActorEmail)...); err != nil {
+			return nil, fmt.Errorf("scan audit event: %w", err)
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate audit events: %w", err)
+	}
+
+	return events, nil
+}
+
+// SearchAuditEvents returns one page of the events matching filter, newest first, together
+// with how many match in total.
+func (s *Store) SearchAuditEvents(ctx context.Context, filter AuditEventFilter, limit int, offset int) ([]AuditEventRecord, int, error) {
+	where, args := auditEventConditions(filter)
+
+	var total int
+	if err := s.db.QueryRowContext(
+		ctx,
+		`SELECT COUNT(*)
+		 FROM audit_events e
+		 LEFT JOIN users u ON u.id = e.actor_user_id
+		 WHERE `+where,
+		args...,
+	).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count audit events: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT `+qualifiedAuditEventColumns+`, COALESCE(u.email, '')
+		 FROM audit_events e
+		 LEFT JOIN users u ON u.id = e.actor_user_id
+		 WHERE `+where+`
+		 ORDER BY e.created_at DESC, e.id DESC
+		 LIMIT `+fmt.Sprintf("$%d OFFSET $%d", len(args)+1, len(args)+2),
+		append(args, limit, offset)...,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("search audit events: %w", err)
+	}
+	defer rows.Close()
+
+	events := make([]AuditEventRecord, 0, limit)
+	for rows.Next() {
+		var event AuditEventRecord
+		if err := rows.Scan(append(auditEventTargets(&event), &event.ActorEmail)...); err != nil {
+			return nil, 0, fmt.Errorf("scan audit event: %w", err)
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("iterate audit events: %w", err)
+	}
+
+	return events, total, nil
+}
+
+// EachAuditEvent calls fn for every event matching filter, oldest first, without loading
+// them all at once. It stops at the first error fn returns.
+func (s *Store) EachAuditEvent(ctx context.Context, filter AuditEventFilter, fn func(AuditEventRecord) error) error {
+	where, args := auditEventConditions(filter)
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT `+qualifiedAuditEventColumns+`, COALESCE(u.email, '')
+		 FROM audit_events e
+		 LEFT JOIN users u ON u.id = e.actor_user_id
+		 WHERE `+where+`
+		 ORDER BY e.created_at ASC, e.id ASC`,
+		args...,
+	)
+	if err != nil {
+		return fmt.Errorf("export audit events: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event AuditEventRecord
+		if err := rows.Scan(append(auditEventTargets(&event), &event.ActorEmail)...); err != nil {
+			return fmt.Errorf("scan audit event: %w", err)
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate audit events: %w", err)
+	}
+
+	return nil
+}
+
+// auditEventConditions builds the WHERE clause for filter over audit_events e joined with
+// users u.
+func auditEventConditions(filter AuditEventFilter) (string, []any) {
+	conditions := []string{"1 = 1"}
+	args := []any{}
+
+	if filter.OrganizationIDs != nil {
+		if len(filter.OrganizationIDs) == 0 {
+			return "1 = 0", nil
+		}
+		conditions = append(conditions, `e.organization_id IN (`+placeholders(len(args)+1, len(filter.OrganizationIDs))+`)`)
+		args = append(args, stringArgs(filter.OrganizationIDs)...)
+	}
+	if filter.ActorEmail != "" {
+		args = append(args, "%"+likeEscaper.Replace(strings.ToLower(filter.ActorEmail))+"%")
+		conditions = append(conditions, fmt.Sprintf(`u.email LIKE $%d ESCAPE '\'`, len(args)))
+	}
+	if filter.Action != "" {
+		args = append(args, likeEscaper.Replace(filter.Action)+"%")
+		conditions = append(conditions, fmt.Sprintf(`e.action LIKE $%d ESCAPE '\'`, len(args)))
+	}
+	if filter.TargetID != "" {
+		args = append(args, filter.TargetID)
+		conditions = append(conditions, fmt.Sprintf(`e.target_id = $%d`, len(args)))
+	}
+	if filter.Since != nil {
+		args = append(args, *filter.Since)
+		conditions = append(conditions, fmt.Sprintf(`e.created_at >= $%d`, len(args)))
+	}
+	if filter.Until != nil {
+		args = append(args, *filter.Until)
+		conditions = append(conditions, fmt.Sprintf(`e.created_at < $%d`, len(args)))
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// CreateInvitation reports false if the email address already has an invitation to the
+// organization.
+func (s *Store) CreateInvitation(ctx context.Context, record InvitationRecord) (bool, error) {
+	result, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO invitations (`+invitationColumns+`)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		 ON CONFLICT (organization_id, email) DO NOTHING`,
+		record.ID,
+		record.OrganizationID,
+		record.Email,
+		record.Role,
+		record.TokenHash,
+		record.InvitedByUserID,
+		record.ExpiresAt,
+		record.SentAt,
+		record.CreatedAt,
+	)
+	if err != nil {
+		return false, fmt.Errorf("insert invitation: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("read inserted invitation rows: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func (s *Store) ListInvitationsByOrganizationID(ctx context.Context, organizationID string) ([]InvitationRecord, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT `+invitationColumns+`
+		 FROM invitations
+		 WHERE organization_id = $1
+		 ORDER BY created_at DESC`,
+		organizationID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list invitations: %w", err)
+	}
+	defer rows.Close()
+
+	invitations := make([]InvitationRecord, 0)
+	for rows.Next() {
+		var invitation InvitationRecord
+		if err := rows.Scan(invitationTargets(&invitation)...); err != nil {
+			return nil, fmt.Errorf("scan invitation: %w", err)
+		}
+		invitations = append(invitations, invitation)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate invitations: %w", err)
+	}
+
+	return invitations, nil
+}
+
+func (s *Store) GetInvitationByTokenHash(ctx context.Context, tokenHash string) (InvitationRecord, bool, error) {
+	var invitation InvitationRecord
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT `+invitationColumns+`
+		 FROM invitations
+		 WHERE token_hash = $1`,
+		tokenHash,
+	).Scan(invitationTargets(&invitation)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return InvitationRecord{}, false, nil
+		}
+
+		return InvitationRecord{}, false, fmt.Errorf("query invitation by token hash: %w", err)
+	}
+
+	return invitation, true, nil
+}
+
+func (s *Store) GetInvitationByID(ctx context.Context, organizationID string, invitationID string) (InvitationRecord, bool, error) {
+	var invitation InvitationRecord
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT `+invitationColumns+`
+		 FROM invitations
+		 WHERE organization_id = $1 AND id = $2`,
+		organizationID,
+		invitationID,
+	).Scan(invitationTargets(&invitation)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return InvitationRecord{}, false, nil
+		}
+
+		return InvitationRecord{}, false, fmt.Errorf("query invitation by id: %w", err)
+	}
+
+	return invitation, true, nil
+}
+
+// RenewInvitation replaces an invitation's token and expiry, invalidating the link sent before.
+func (s *Store) RenewInvitation(ctx context.Context, organizationID string, invitationID string, tokenHash string, expiresAt time.Time, sentAt time.Time) (InvitationRecord, bool, error) {
+	var invitation InvitationRecord
+	err := s.db.QueryRowContext(
+		ctx,
+		`UPDATE invitations
+		 SET token_hash = $1, expires_at = $2, sent_at = $3
+		 WHERE organization_id = $4 AND id = $5
+		 RETURNING `+invitationColumns,
+		tokenHash,
+		expiresAt,
+		sentAt,
+		organizationID,
+		invitationID,
+	).Scan(invitationTargets(&invitation)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return InvitationRecord{}, false, nil
+		}
+
+		return InvitationRecord{}, false, fmt.Errorf("renew invitation: %w", err)
+	}
+
+	return invitation, true, nil
+}
+
+func (s *Store) DeleteInvitation(ctx context.Context, organizationID string, invitationID string) (bool, error) {
+	result, err := s.db.ExecContext(
+		ctx,
+		`DELETE FROM invitations WHERE organization_id = $1 AND id = $2`,
+		organizationID,
+		invitationID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("delete invitation: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("read deleted invitation rows: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// AcceptInvitation consumes an unexpired invitation and makes the user a member with the
+// invited role. Users who already belong to the organization keep their current role. The
+// user's email is marked verified, since the invitation link reached their inbox.
+func (s *Store) AcceptInvitation(ctx context.Context, tokenHash string, userID string, now time.Time) (InvitationRecord, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return InvitationRecord{}, false, fmt.Errorf("begin invitation acceptance: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var invitation InvitationRecord
+	err = tx.QueryRowContext(
+		ctx,
+		`DELETE FROM invitations
+		 WHERE token_hash = $1 AND expires_at > $2
+		 RETURNING `+invitationColumns,
+		tokenHash,
+		now,
+	).Scan(invitationTargets(&invitation)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return InvitationRecord{}, false, nil
+		}
+
+		return InvitationRecord{}, false, fmt.Errorf("consume invitation: %w", err)
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO organization_members (organization_id, user_id, role, created_at)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (organization_id, user_id) DO NOTHING`,
+		invitation.OrganizationID,
+		userID,
+		invitation.Role,
+		now,
+	); err != nil {
+		return InvitationRecord{}, false, fmt.Errorf("insert invited member: %w", err)
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`UPDATE users SET verified_at = $1 WHERE id = $2 AND verified_at IS NULL`,
+		now,
+		userID,
+	); err != nil {
+		return InvitationRecord{}, false, fmt.Errorf("mark invited user verified: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return InvitationRecord{}, false, fmt.Errorf("commit invitation acceptance: %w", err)
+	}
+
+	return invitation, true, nil
+}
+
+// DeleteExpiredInvitations removes invitations that expired before cutoff.
+func (s *Store) DeleteExpiredInvitations(ctx context.Context, cutoff time.Time) (int, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM invitations WHERE expires_at <= $1`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("delete expired invitations: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("read deleted invitation rows: %w", err)
+	}
+
+	return int(rowsAffected), nil
+}
+
+func (s *Store) CreateApplication(ctx context.Context, record ApplicationRecord) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO applications (`+applicationColumns+`)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+		record.ID,
+		record.OwnerUserID,
+		record.Name,
+		record.Description,
+		record.GitHubLink,
+		record.Domain,
+		record.MaxAPIKeyLifetimeDays,
+		record.CreatedAt,
+		record.UpdatedAt,
+		strings.Join(record.AllowedCIDRs, " "),
+		record.RateLimitPerMinute,
+		record.OrganizationID,
+		record.ArchivedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("insert application: %w", err)
+	}
+
+	return nil
+}
+
+// ListApplicationsByMemberUserID returns the applications of every organization the user belongs to.
+func (s *Store) ListApplicationsByMemberUserID(ctx context.Context, userID string) ([]ApplicationRecord, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT `+applicationColumns+`
+		 FROM applications
+		 WHERE organization_id IN (SELECT organization_id FROM organization_members WHERE user_id = $1)
+		 ORDER BY created_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list applications: %w", err)
+	}
+	defer rows.Close()
+
+	applications := make([]ApplicationRecord, 0)
+	for rows.Next() {
+		application, err := scanApplication(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan application: %w", err)
+		}
+
+		applications = append(applications, application)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate applications: %w", err)
+	}
+
+	return applications, nil
+}
+
+func (s *Store) GetApplicationByID(ctx context.Context, applicationID string) (ApplicationRecord, bool, error) {
+	record, err := scanApplication(s.db.QueryRowContext(
+		ctx,
+		`SELECT `+applicationColumns+`
+		 FROM applications
+		 WHERE id = $1`,
+		applicationID,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ApplicationRecord{}, false, nil
+		}
+
+		return ApplicationRecord{}, false, fmt.Errorf("query application by id: %w", err)
+	}
+
+	return record, true, nil
+}
+
+func (s *Store) UpdateApplicationDetails(ctx context.Context, applicationID string, name string, description string, gitHubLink string, domain string, updatedAt time.Time) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`UPDATE applications
+		 SET name = $1,
+			 description = $2,
+			 github_link = $3,
+			 domain = $4,
+			 updated_at = $5
+		 WHERE id = $6`,
+		name,
+		description,
+		gitHubLink,
+		domain,
+		updatedAt,
+		applicationID,
+	)
+	if err != nil {
+		return fmt.Errorf("update application details: %w", err)
+	}
+
+	return nil
+}
+
+// SetApplicationArchivedAt archives the application, or restores it when archivedAt is nil.
+func (s *Store) SetApplicationArchivedAt(ctx context.Context, applicationID string, archivedAt *time.Time, updatedAt time.Time) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`UPDATE applications
+		 SET archived_at = $1,
+			 updated_at = $2
+		 WHERE id = $3`,
+		archivedAt,
+		updatedAt,
+		applicationID,
+	)
+	if err != nil {
+		return fmt.Errorf("update application archived at: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteApplication removes an application; its keys, usage and browser sessions go through
+// ON DELETE CASCADE.
+func (s *Store) DeleteApplication(ctx context.Context, applicationID string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM applications WHERE id = $1`, applicationID)
+	if err != nil {
+		return false, fmt.Errorf("delete application: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("read deleted application rows: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// CountApplicationsByOrganizationID counts an organization's applications, archived ones included.
+func (s *Store) CountApplicationsByOrganizationID(ctx context.Context, organizationID string) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT COUNT(*) FROM applications WHERE organization_id = $1`,
+		organizationID,
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("count applications: %w", err)
+	}
+
+	return count, nil
+}
+
+// SaveApplicationTransfer starts a transfer, replacing any transfer of the same application
+// that is still pending.
+func (s *Store) SaveApplicationTransfer(ctx context.Context, record ApplicationTransferRecord) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO application_transfers (`+applicationTransferColumns+`)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 ON CONFLICT (application_id) DO UPDATE
+		 SET id = excluded.id,
+			 from_organization_id = excluded.from_organization_id,
+			 initiated_by_user_id = excluded.initiated_by_user_id,
+			 recipient_user_id = excluded.recipient_user_id,
+			 expires_at = excluded.expires_at,
+			 created_at = excluded.created_at`,
+		record.ID,
+		record.ApplicationID,
+		record.FromOrganizationID,
+		record.InitiatedByUserID,
+		record.RecipientUserID,
+		record.ExpiresAt,
+		record.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("save application transfer: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) GetApplicationTransferByID(ctx context.Context, transferID string) (ApplicationTransferRecord, bool, error) {
+	var transfer ApplicationTransferRecord
+	err := s.db.QueryRowContext(
+		ctx,
+		selectApplicationTransfers+`
+		 WHERE t.id = $1`,
+		transferID,
+	).Scan(applicationTransferTargets(&transfer, true)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ApplicationTransferRecord{}, false, nil
+		}
+
+		return ApplicationTransferRecord{}, false, fmt.Errorf("query application transfer by id: %w", err)
+	}
+
+	return transfer, true, nil
+}
+
+// ListApplicationTransfersForUser returns unexpired transfers sent to the user or of
+// applications in the organizations the user belongs to.
+func (s *Store) ListApplicationTransfersForUser(ctx context.Context, userID string, now time.Time) ([]ApplicationTransferRecord, error) {
+	return s.listApplicationTransfers(
+		ctx,
+		`WHERE t.expires_at > $1
+		   AND (t.recipient_user_id = $2
+		        OR a.organization_id IN (SELECT organization_id FROM organization_members WHERE user_id = $2))`,
+		now,
+		userID,
+	)
+}
+
+// ListApplicationTransfersByApplicationIDs returns the unexpired transfers of the given applications.
+func (s *Store) ListApplicationTransfersByApplicationIDs(ctx context.Context, applicationIDs []string, now time.Time) ([]ApplicationTransferRecord, error) {
+	if len(applicationIDs) == 0 {
+		return []ApplicationTransferRecord{}, nil
+	}
+
+	return s.listApplicationTransfers(
+		ctx,
+		`WHERE t.expires_at > $1 AND t.application_id IN (`+placeholders(2, len(applicationIDs))+`)`,
+		append([]any{now}, stringArgs(applicationIDs)...)...,
+	)
+}
+
+func (s *Store) listApplicationTransfers(ctx context.Context, where string, args ...any) ([]ApplicationTransferRecord, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		selectApplicationTransfers+`
+		 `+where+`
+		 ORDER BY t.created_at DESC`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list application transfers: %w", err)
+	}
+	defer rows.Close()
+
+	transfers := make([]ApplicationTransferRecord, 0)
+	for rows.Next() {
+		var transfer ApplicationTransferRecord
+		if err := rows.Scan(applicationTransferTargets(&transfer, true)...); err != nil {
+			return nil, fmt.Errorf("scan application transfer: %w", err)
+		}
+		transfers = append(transfers, transfer)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate application transfers: %w", err)
+	}
+
+	return transfers, nil
+}
+
+func (s *Store) DeleteApplicationTransfer(ctx context.Context, transferID string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM application_transfers WHERE id = $1`, transferID)
+	if err != nil {
+		return false, fmt.Errorf("delete application transfer: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("read deleted application transfer rows: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// AcceptApplicationTransfer consumes an unexpired transfer addressed to recipientUserID and, in
+// the same transaction, moves the application into organizationID with the recipient as its
+// owner. Keys, usage and browser sessions stay attached to the application. It reports false,
+// changing nothing, if the transfer is gone, the application has left the organization it was
+// offered from, or moving it would take organizationID past maxApplications (zero means no
+// limit).
+func (s *Store) AcceptApplicationTransfer(ctx context.Context, transferID string, recipientUserID string, organizationID string, maxApplications int, now time.Time) (ApplicationTransferRecord, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ApplicationTransferRecord{}, false, fmt.Errorf("begin application transfer: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var transfer ApplicationTransferRecord
+	err = tx.QueryRowContext(
+		ctx,
+		`DELETE FROM application_transfers
+		 WHERE id = $1 AND recipient_user_id = $2 AND expires_at > $3
+		 RETURNING `+applicationTransferColumns,
+		transferID,
+		recipientUserID,
+		now,
+	).Scan(applicationTransferTargets(&transfer, false)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ApplicationTransferRecord{}, false, nil
+		}
+
+		return ApplicationTransferRecord{}, false, fmt.Errorf("consume application transfer: %w", err)
+	}
+
+	result, err := tx.ExecContext(
+		ctx,
+		`UPDATE applications
+		 SET organization_id = $1,
+			 owner_user_id = $2,
+			 updated_at = $3
+		 WHERE id = $4 AND organization_id = $5
+		   AND ($6 = 0 OR organization_id = $1
+		        OR (SELECT COUNT(*) FROM applications WHERE organization_id = $1) < $6)`,
+		organizationID,
+		recipientUserID,
+		now,
+		transfer.ApplicationID,
+		transfer.FromOrganizationID,
+		maxApplications,
+	)
+	if err != nil {
+		return ApplicationTransferRecord{}, false, fmt.Errorf("move transferred application: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return ApplicationTransferRecord{}, false, fmt.Errorf("read moved application rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ApplicationTransferRecord{}, false, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ApplicationTransferRecord{}, false, fmt.Errorf("commit application transfer: %w", err)
+	}
+
+	return transfer, true, nil
+}
+
+// DeleteExpiredApplicationTransfers removes transfers that expired before cutoff.
+func (s *Store) DeleteExpiredApplicationTransfers(ctx context.Context, cutoff time.Time) (int, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM application_transfers WHERE expires_at <= $1`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("delete expired application transfers: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("read deleted application transfer rows: %w", err)
+	}
+
+	return int(rowsAffected), nil
+}
+
+func (s *Store) UpdateApplicationKeyPolicy(ctx context.Context, applicationID string, maxAPIKeyLifetimeDays int, updatedAt time.Time) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`UPDATE applications
+		 SET max_api_key_lifetime_days = $1,
+			 updated_at = $2
+		 WHERE id = $3`,
+		maxAPIKeyLifetimeDays,
+		updatedAt,
+		applicationID,
+	)
+	if err != nil {
+		return fmt.Errorf("update application key policy: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) UpdateApplicationRateLimit(ctx context.Context, applicationID string, rateLimitPerMinute int, updatedAt time.Time) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`UPDATE applications
+		 SET rate_limit_per_minute = $1,
+			 updated_at = $2
+		 WHERE id = $3`,
+		rateLimitPerMinute,
+		updatedAt,
+		applicationID,
+	)
+	if err != nil {
+		return fmt.Errorf("update application rate limit: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) UpdateApplicationAllowedCIDRs(ctx context.Context, applicationID string, allowedCIDRs []string, updatedAt time.Time) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`UPDATE applications
+		 SET allowed_cidrs = $1,
+			 updated_at = $2
+		 WHERE id = $3`,
+		strings.Join(allowedCIDRs, " "),
+		updatedAt,
+		applicationID,
+	)
+	if err != nil {
+		return fmt.Errorf("update application allowed CIDRs: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) CreateAPIKey(ctx context.Context, record APIKeyRecord) error {
+	return insertAPIKey(ctx, s.db, record)
+}
+
+func (s *Store) ListAPIKeysByApplicationID(ctx context.Context, applicationID string) ([]APIKeyRecord, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT `+apiKeyColumns+`
+		 FROM api_keys
+		 WHERE application_id = $1
+		 ORDER BY created_at DESC`,
+		applicationID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list API keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := make([]APIKeyRecord, 0)
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate API keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+func (s *Store) GetAPIKeyByID(ctx context.Context, applicationID string, keyID string) (APIKeyRecord, bool, error) {
+	record, err := scanAPIKey(s.db.QueryRowContext(
+		ctx,
+		`SELECT `+apiKeyColumns+`
+		 FROM api_keys
+		 WHERE id = $1 AND application_id = $2`,
+		keyID,
+		applicationID,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKeyRecord{}, false, nil
+		}
+
+		return APIKeyRecord{}, false, fmt.Errorf("query API key by id: %w", err)
+	}
+
+	return record, true, nil
+}
+
+func (s *Store) RevokeAPIKey(ctx context.Context, applicationID string, keyID string, revokedAt time.Time) (bool, error) {
+	result, err := s.db.ExecContext(
+		ctx,
+		`UPDATE api_keys
+		 SET revoked_at = $1
+		 WHERE id = $2 AND application_id = $3 AND revoked_at IS NULL`,
+		revokedAt,
+		keyID,
+		applicationID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("revoke API key: %w", err)
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("get API key revoke affected rows: %w", err)
+	}
+
+	return affectedRows > 0, nil
+}
+
+// RevokeAPIKeysByApplicationID revokes every active key of the application, including
+// rotated keys that are still in their grace period.
+func (s *Store) RevokeAPIKeysByApplicationID(ctx context.Context, applicationID string, revokedAt time.Time) (int, error) {
+	result, err := s.db.ExecContext(
+		ctx,
+		`UPDATE api_keys
+		 SET revoked_at = $1
+		 WHERE application_id = $2 AND revoked_at IS NULL`,
+		revokedAt,
+		applicationID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("revoke application API keys: %w", err)
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("get API key revoke affected rows: %w", err)
+	}
+
+	return int(affectedRows), nil
+}
+
+func (s *Store) UpdateAPIKeyAllowedCIDRs(ctx context.Context, applicationID string, keyID string, allowedCIDRs []string) (bool, error) {
+	result, err := s.db.ExecContext(
+		ctx,
+		`UPDATE api_keys
+		 SET allowed_cidrs = $1
+		 WHERE id = $2 AND application_id = $3 AND revoked_at IS NULL`,
+		strings.Join(allowedCIDRs, " "),
+		keyID,
+		applicationID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("update API key allowed CIDRs: %w", err)
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("get API key allowed CIDRs affected rows: %w", err)
+	}
+
+	return affectedRows > 0, nil
+}
+
+// RotateAPIKey inserts the replacement key and links it to the old key in one transaction,
+// moving a GitHub OIDC trust that acts as the old key over to the replacement. The old key
+// stays valid until graceExpiresAt. It returns false without inserting anything
+// when the old key is revoked or has already been rotated.
+func (s *Store) RotateAPIKey(ctx context.Context, oldKey APIKeyRecord, replacement APIKeyRecord, rotatedAt time.Time, graceExpiresAt time.Time) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("begin API key rotation: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.ExecContext(
+		ctx,
+		`UPDATE api_keys
+		 SET replaced_by_key_id = $1,
+			 rotated_at = $2,
+			 grace_expires_at = $3
+		 WHERE id = $4 AND application_id = $5 AND revoked_at IS NULL AND replaced_by_key_id IS NULL`,
+		replacement.ID,
+		rotatedAt,
+		graceExpiresAt,
+		oldKey.ID,
+		oldKey.ApplicationID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("mark API key rotated: %w", err)
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("get API key rotation affected rows: %w", err)
+	}
+	if affectedRows == 0 {
+		return false, nil
+	}
+
+	if err := insertAPIKey(ctx, tx, replacement); err != nil {
+		return false, err
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`UPDATE github_oidc_trusts
+		 SET api_key_id = $1, updated_at = $2
+		 WHERE api_key_id = $3`,
+		replacement.ID,
+		rotatedAt,
+		oldKey.ID,
+	); err != nil {
+		return false, fmt.Errorf("move GitHub OIDC trust to replacement key: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit API key rotation: %w", err)
+	}
+
+	return true, nil
+}
+
+// RetireRotatedAPIKeys revokes rotated keys whose grace period has ended and returns how
+// many keys were retired.
+func (s *Store) RetireRotatedAPIKeys(ctx context.Context, now time.Time) (int, error) {
+	result, err := s.db.ExecContext(
+		ctx,
+		`UPDATE api_keys
+		 SET revoked_at = grace_expires_at
+		 WHERE grace_expires_at IS NOT NULL AND grace_expires_at <= $1 AND revoked_at IS NULL`,
+		now,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("retire rotated API keys: %w", err)
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("get retired API keys affected rows: %w", err)
+	}
+
+	return int(affectedRows), nil
+}
+
+// RecordAPIKeyUsage applies a batch of aggregated usage in one transaction. Last-use details
+// only move forward in time, so batches flushed out of order cannot roll them back. Usage of
+// keys deleted since it was recorded is dropped.
+func (s *Store) RecordAPIKeyUsage(ctx context.Context, lastUses []APIKeyLastUseRecord, endpointUsage []APIKeyEndpointUsageRecord) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin API key usage batch: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, lastUse := range lastUses {
+		if _, err := tx.ExecContext(
+			ctx,
+			`UPDATE api_keys
+			 SET last_used_at = $1, last_used_ip = $2, last_used_user_agent = $3
+			 WHERE id = $4 AND (last_used_at IS NULL OR last_used_at < $1)`,
+			lastUse.UsedAt,
+			lastUse.IP,
+			lastUse.UserAgent,
+			lastUse.APIKeyID,
+		); err != nil {
+			return fmt.Errorf("update API key last use: %w", err)
+		}
+	}
+
+	for _, usage := range endpointUsage {
+		if _, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO api_key_endpoint_usage (api_key_id, endpoint, request_count, last_used_at)
+			 SELECT $1, $2, $3, $4
+			 WHERE EXISTS (SELECT 1 FROM api_keys WHERE id = $1)
+			 ON CONFLICT (api_key_id, endpoint) DO UPDATE SET
+				 request_count = api_key_endpoint_usage.request_count + excluded.request_count,
+				 last_used_at = CASE
+					 WHEN excluded.last_used_at > api_key_endpoint_usage.last_used_at THEN excluded.last_used_at
+					 ELSE api_key_endpoint_usage.last_used_at
+				 END`,
+			usage.APIKeyID,
+			usage.Endpoint,
+			usage.RequestCount,
+			usage.LastUsedAt,
+		); err != nil {
+			return fmt.Errorf("upsert API key endpoint usage: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit API key usage batch: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) ListAPIKeyEndpointUsageByApplicationID(ctx context.Context, applicationID string) ([]APIKeyEndpointUsageRecord, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT u.api_key_id, u.endpoint, u.request_count, u.last_used_at
+		 FROM api_key_endpoint_usage u
+		 INNER JOIN api_keys k ON k.id = u.api_key_id
+		 WHERE k.application_id = $1
+		 ORDER BY u.request_count DESC, u.endpoint ASC`,
+		applicationID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list API key endpoint usage: %w", err)
+	}
+	defer rows.Close()
+
+	records := make([]APIKeyEndpointUsageRecord, 0)
+	for rows.Next() {
+		var record APIKeyEndpointUsageRecord
+		if err := rows.Scan(&record.APIKeyID, &record.Endpoint, &record.RequestCount, &record.LastUsedAt); err != nil {
+			return nil, fmt.Errorf("scan API key endpoint usage: %w", err)
+		}
+		records = append(records, record)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate API key endpoint usage: %w", err)
+	}
+
+	return records, nil
+}
+
+func (s *Store) ListAPIKeyEndpointUsageByKeyID(ctx context.Context, keyID string) ([]APIKeyEndpointUsageRecord, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT api_key_id, endpoint, request_count, last_used_at
+		 FROM api_key_endpoint_usage
+		 WHERE api_key_id = $1
+		 ORDER BY request_count DESC, endpoint ASC`,
+		keyID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list API key endpoint usage: %w", err)
+	}
+	defer rows.Close()
+
+	records := make([]APIKeyEndpointUsageRecord, 0)
+	for rows.Next() {
+		var record APIKeyEndpointUsageRecord
+		if err := rows.Scan(&record.APIKeyID, &record.Endpoint, &record.RequestCount, &record.LastUsedAt); err != nil {
+			return nil, fmt.Errorf("scan API key endpoint usage: %w", err)
+		}
+		records = append(records, record)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate API key endpoint usage: %w", err)
+	}
+
+	return records, nil
+}
+
+// UpdateAPIKeyHash replaces a key's stored hash, e.g. to upgrade a legacy digest. It does
+// nothing if the hash changed concurrently.
+func (s *Store) UpdateAPIKeyHash(ctx context.Context, keyID string, oldHash string, newHash string, hashVersion int) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`UPDATE api_keys SET key_hash = $1, hash_version = $2 WHERE id = $3 AND key_hash = $4`,
+		newHash,
+		hashVersion,
+		keyID,
+		oldHash,
+	)
+	if err != nil {
+		return fmt.Errorf("update API key hash: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) UpdateSessionTokenHash(ctx context.Context, sessionID string, oldHash string, newHash string, hashVersion int) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`UPDATE sessions SET token_hash = $1, hash_version = $2 WHERE id = $3 AND token_hash = $4`,
+		newHash,
+		hashVersion,
+		sessionID,
+		oldHash,
+	)
+	if err != nil {
+		return fmt.Errorf("update session token hash: %w", err)
+	}
+
+	return nil
+}
+
+type LegacyTokenHashCounts struct {
+	APIKeys  int
+	Sessions int
+}
+
+// CountLegacyTokenHashes counts live API keys and sessions whose hash is older than hashVersion.
+func (s *Store) CountLegacyTokenHashes(ctx context.Context, hashVersion int, now time.Time) (LegacyTokenHashCounts, error) {
+	var counts LegacyTokenHashCounts
+	if err := s.db.QueryRowContext(
+		ctx,
+		`SELECT COUNT(*) FROM api_keys WHERE hash_version < $1 AND revoked_at IS NULL`,
+		hashVersion,
+	).Scan(&counts.APIKeys); err != nil {
+		return LegacyTokenHashCounts{}, fmt.Errorf("count legacy API key hashes: %w", err)
+	}
+	if err := s.db.QueryRowContext(
+		ctx,
+		`SELECT COUNT(*) FROM sessions WHERE hash_version < $1 AND expires_at > $2`,
+		hashVersion,
+		now,
+	).Scan(&counts.Sessions); err != nil {
+		return LegacyTokenHashCounts{}, fmt.Errorf("count legacy session hashes: %w", err)
+	}
+
+	return counts, nil
+}
+
+// GetAPIKeyRevocationVersion returns a counter that is incremented whenever a change makes
+// cached API key authentication results stale.
+func (s *Store) GetAPIKeyRevocationVersion(ctx context.Context) (int64, error) {
+	var version int64
+	if err := s.db.QueryRowContext(ctx, `SELECT version FROM api_key_revocation_version WHERE id = 1`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("query API key revocation version: %w", err)
+	}
+
+	return version, nil
+}
+
+func (s *Store) IncrementAPIKeyRevocationVersion(ctx context.Context) (int64, error) {
+	var version int64
+	if err := s.db.QueryRowContext(
+		ctx,
+		`UPDATE api_key_revocation_version SET version = version + 1 WHERE id = 1 RETURNING version`,
+	).Scan(&version); err != nil {
+		return 0, fmt.Errorf("increment API key revocation version: %w", err)
+	}
+
+	return version, nil
+}
+
+// GetActiveAPIKeyAuthByHash returns the non-revoked key stored under any of the given hashes. Expired keys
+// are still returned so callers can tell an expired key apart from an unknown one.
+func (s *Store) GetActiveAPIKeyAuthByHash(ctx context.Context, keyHashes ...string) (APIKeyAuthRecord, bool, error) {
+	if len(keyHashes) == 0 {
+		return APIKeyAuthRecord{}, false, nil
+	}
+
+	// Keys stop working once every member of the application's organization is disabled, so
+	// disabling a user stops their personal applications but not the ones they share with a team.
+	query := `SELECT ` + qualifiedAPIKeyColumns + `, ` + qualifiedApplicationColumns + `
+	FROM api_keys k
+	INNER JOIN applications a ON a.id = k.application_id
+	WHERE k.key_hash IN (` + placeholders(1, len(keyHashes)) + `) AND k.revoked_at IS NULL
+		AND EXISTS (
+			SELECT 1 FROM organization_members m
+			INNER JOIN users u ON u.id = m.user_id
+			WHERE m.organization_id = a.organization_id AND u.disabled_at IS NULL
+		)`
+
+	var key apiKeyRow
+	var application applicationRow
+	err := s.db.QueryRowContext(ctx, query, stringArgs(keyHashes)...).Scan(append(key.targets(), application.targets()...)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKeyAuthRecord{}, false, nil
+		}
+
+		return APIKeyAuthRecord{}, false, fmt.Errorf("query API key by hash: %w", err)
+	}
+
+	return APIKeyAuthRecord{Key: key.record(), Application: application.record()}, true, nil
+}
+
+// ListAPIKeysExpiringBefore returns active keys that expire before the cutoff and whose
+// owner has not been notified about the upcoming expiry yet.
+func (s *Store) ListAPIKeysExpiringBefore(ctx context.Context, cutoff time.Time, now time.Time) ([]APIKeyExpiryRecord, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT `+qualifiedAPIKeyColumns+`, a.name, u.email
+		 FROM api_keys k
+		 INNER JOIN applications a ON a.id = k.application_id
+		 INNER JOIN users u ON u.id = a.owner_user_id
+		 WHERE k.revoked_at IS NULL
+			 AND k.expiry_notified_at IS NULL
+			 AND k.expires_at IS NOT NULL
+			 AND k.expires_at > $1
+			 AND k.expires_at <= $2
+		 ORDER BY k.expires_at ASC`,
+		now,
+		cutoff,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list expiring API keys: %w", err)
+	}
+	defer rows.Close()
+
+	records := make([]APIKeyExpiryRecord, 0)
+	for rows.Next() {
+		var key apiKeyRow
+		var record APIKeyExpiryRecord
+		if err := rows.Scan(append(key.targets(), &record.ApplicationName, &record.OwnerEmail)...); err != nil {
+			return nil, fmt.Errorf("scan expiring API key: %w", err)
+		}
+
+		record.Key = key.record()
+		records = append(records, record)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate expiring API keys: %w", err)
+	}
+
+	return records, nil
+}
+
+func (s *Store) MarkAPIKeyExpiryNotified(ctx context.Context, keyID string, notifiedAt time.Time) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`UPDATE api_keys
+		 SET expiry_notified_at = $1
+		 WHERE id = $2`,
+		notifiedAt,
+		keyID,
+	)
+	if err != nil {
+		return fmt.Errorf("mark API key expiry notified: %w", err)
+	}
+
+	return nil
+}
+
+// RecordAPIKeyIPDenial counts a denied request in the row for its key, source IP and bucket
+// (record.CreatedAt), creating the row on the bucket's first denial.
+func (s *Store) RecordAPIKeyIPDenial(ctx context.Context, record APIKeyIPDenialRecord) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO api_key_ip_denials (id, api_key_id, application_id, source_ip, attempt_count, last_denied_at, created_at)
+		 VALUES ($1, $2, $3, $4, 1, $5, $6)
+		 ON CONFLICT (api_key_id, source_ip, created_at) DO UPDATE SET
+		   attempt_count = api_key_ip_denials.attempt_count + 1,
+		   last_denied_at = excluded.last_denied_at`,
+		record.ID,
+		record.APIKeyID,
+		record.ApplicationID,
+		record.SourceIP,
+		record.LastDeniedAt,
+		record.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("record API key IP denial: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) ListAPIKeyIPDenialsByApplicationID(ctx context.Context, applicationID string, limit int) ([]APIKeyIPDenialRecord, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, api_key_id, application_id, source_ip, attempt_count, last_denied_at, created_at
+		 FROM api_key_ip_denials
+		 WHERE application_id = $1
+		 ORDER BY last_denied_at DESC
+		 LIMIT $2`,
+		applicationID,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list API key IP denials: %w", err)
+	}
+	defer rows.Close()
+
+	records := make([]APIKeyIPDenialRecord, 0)
+	for rows.Next() {
+		var record APIKeyIPDenialRecord
+		if err := rows.Scan(
+			&record.ID,
+			&record.APIKeyID,
+			&record.ApplicationID,
+			&record.SourceIP,
+			&record.AttemptCount,
+			&record.LastDeniedAt,
+			&record.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("scan API key IP denial: %w", err)
+		}
+		records = append(records, record)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate API key IP denials: %w", err)
+	}
+
+	return records, nil
+}
+
+// DeleteAPIKeyIPDenialsBefore removes denials last seen before cutoff and returns how many
+// rows were deleted.
+func (s *Store) DeleteAPIKeyIPDenialsBefore(ctx context.Context, cutoff time.Time) (int, error) {
+	result, err := s.db.ExecContext(
+		ctx,
+		`DELETE FROM api_key_ip_denials
+		 WHERE last_denied_at < $1`,
+		cutoff,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("delete API key IP denials: %w", err)
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("get deleted API key IP denials affected rows: %w", err)
+	}
+
+	return int(affectedRows), nil
+}
+
+func (s *Store) CreateTokenSigningKey(ctx context.Context, record TokenSigningKeyRecord) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO token_signing_keys (id, secret, created_at)
+		 VALUES ($1, $2, $3)`,
+		record.ID,
+		record.Secret,
+		record.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("insert token signing key: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateTokenSigningKeySecret replaces the stored secret of a signing key, e.g. with its
+// encrypted form.
+func (s *Store) UpdateTokenSigningKeySecret(ctx context.Context, keyID string, secret string) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`UPDATE token_signing_keys
+		 SET secret = $2
+		 WHERE id = $1`,
+		keyID,
+		secret,
 	)
 	if err != nil {
-		return fmt.Errorf("insert application: %w", err)
+		return fmt.Errorf("update token signing key secret: %w", err)
 	}
 
 	return nil
 }
 
-func (s *Store) ListApplicationsByUserID(ctx context.Context, userID string) ([]ApplicationRecord, error) {
+// ListTokenSigningKeys returns every stored signing key, newest first.
+func (s *Store) ListTokenSigningKeys(ctx context.Context) ([]TokenSigningKeyRecord, error) {
 	rows, err := s.db.QueryContext(
 		ctx,
-		`SELECT id, owner_user_id, name, description, github_link, domain, created_at, updated_at
-		 FROM applications
-		 WHERE owner_user_id = $1
+		`SELECT id, secret, created_at
+		 FROM token_signing_keys
 		 ORDER BY created_at DESC`,
-		userID,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("list applications: %w", err)
+		return nil, fmt.Errorf("list token signing keys: %w", err)
 	}
 	defer rows.Close()
 
-	applications := make([]ApplicationRecord, 0)
+	records := make([]TokenSigningKeyRecord, 0)
 	for rows.Next() {
-		var application ApplicationRecord
-		if err := rows.Scan(
-			&application.ID,
-			&application.OwnerUserID,
-			&application.Name,
-			&application.Description,
-			&application.GitHubLink,
-			&application.Domain,
-			&application.CreatedAt,
-			&application.UpdatedAt,
-		); err != nil {
-			return nil, fmt.Errorf("scan application: %w", err)
+		var record TokenSigningKeyRecord
+		if err := rows.Scan(&record.ID, &record.Secret, &record.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan token signing key: %w", err)
 		}
-
-		applications = append(applications, application)
+		records = append(records, record)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("iterate applications: %w", err)
+		return nil, fmt.Errorf("iterate token signing keys: %w", err)
 	}
 
-	return applications, nil
+	return records, nil
 }
 
-func (s *Store) GetApplicationByID(ctx context.Context, applicationID string) (ApplicationRecord, bool, error) {
-	var record ApplicationRecord
+// DeleteTokenSigningKeysCreatedBefore removes signing keys created before the cutoff, except
+// keepID, and returns how many keys were deleted.
+func (s *Store) DeleteTokenSigningKeysCreatedBefore(ctx context.Context, cutoff time.Time, keepID string) (int, error) {
+	result, err := s.db.ExecContext(
+		ctx,
+		`DELETE FROM token_signing_keys
+		 WHERE created_at < $1 AND id <> $2`,
+		cutoff,
+		keepID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("delete token signing keys: %w", err)
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("get deleted token signing keys affected rows: %w", err)
+	}
+
+	return int(affectedRows), nil
+}
+
+func (s *Store) GetGitHubOIDCTrust(ctx context.Context, applicationID string) (GitHubOIDCTrustRecord, bool, error) {
+	var record GitHubOIDCTrustRecord
 	err := s.db.QueryRowContext(
 		ctx,
-		`SELECT id, owner_user_id, name, description, github_link, domain, created_at, updated_at
-		 FROM applications
-		 WHERE id = $1`,
+		`SELECT application_id, api_key_id, allowed_ref, allowed_environment, repository_id, created_at, updated_at
+		 FROM github_oidc_trusts
+		 WHERE application_id = $1`,
 		applicationID,
-	).Scan(
-		&record.ID,
-		&record.OwnerUserID,
-		&record.Name,
-		&record.Description,
-		&record.GitHubLink,
-		&record.Domain,
-		&record.CreatedAt,
-		&record.UpdatedAt,
-	)
+	).Scan(&record.ApplicationID, &record.APIKeyID, &record.AllowedRef, &record.AllowedEnvironment, &record.RepositoryID, &record.CreatedAt, &record.UpdatedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return ApplicationRecord{}, false, nil
+			return GitHubOIDCTrustRecord{}, false, nil
 		}
 
-		return ApplicationRecord{}, false, fmt.Errorf("query application by id: %w", err)
+		return GitHubOIDCTrustRecord{}, false, fmt.Errorf("query GitHub OIDC trust: %w", err)
 	}
 
 	return record, true, nil
 }
 
-func (s *Store) CreateAPIKey(ctx context.Context, record APIKeyRecord) error {
+// UpsertGitHubOIDCTrust creates or replaces the application's trust. CreatedAt is only
+// used when the trust is created, and an updated trust keeps its pinned repository ID.
+func (s *Store) UpsertGitHubOIDCTrust(ctx context.Context, record GitHubOIDCTrustRecord) error {
 	_, err := s.db.ExecContext(
 		ctx,
-		`INSERT INTO api_keys (
-			id, application_id, name, key_prefix, key_hash, can_read, can_write, can_delete, created_at, last_used_at, revoked_at
-		 ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
-		record.ID,
+		`INSERT INTO github_oidc_trusts (application_id, api_key_id, allowed_ref, allowed_environment, created_at, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 ON CONFLICT (application_id) DO UPDATE SET
+			 api_key_id = excluded.api_key_id,
+			 allowed_ref = excluded.allowed_ref,
+			 allowed_environment = excluded.allowed_environment,
+			 updated_at = excluded.updated_at`,
 		record.ApplicationID,
-		record.Name,
-		record.KeyPrefix,
-		record.KeyHash,
-		boolToInt(record.CanRead),
-		boolToInt(record.CanWrite),
-		boolToInt(record.CanDelete),
+		record.APIKeyID,
+		record.AllowedRef,
+		record.AllowedEnvironment,
 		record.CreatedAt,
-		record.LastUsedAt,
-		record.RevokedAt,
+		record.UpdatedAt,
 	)
 	if err != nil {
-		return fmt.Errorf("insert API key: %w", err)
+		return fmt.Errorf("upsert GitHub OIDC trust: %w", err)
 	}
 
 	return nil
 }
 
-func (s *Store) ListAPIKeysByApplicationID(ctx context.Context, applicationID string) ([]APIKeyRecord, error) {
-	rows, err := s.db.QueryContext(
+// PinGitHubOIDCTrustRepositoryID records the repository ID of a trust that has none yet. It
+// reports false when the trust already had one or no longer exists.
+func (s *Store) PinGitHubOIDCTrustRepositoryID(ctx context.Context, applicationID string, repositoryID string) (bool, error) {
+	result, err := s.db.ExecContext(
 		ctx,
-		`SELECT id, application_id, name, key_prefix, key_hash, can_read, can_write, can_delete, created_at, last_used_at, revoked_at
-		 FROM api_keys
-		 WHERE application_id = $1
-		 ORDER BY created_at DESC`,
+		`UPDATE github_oidc_trusts SET repository_id = $2
+		 WHERE application_id = $1 AND repository_id = ''`,
 		applicationID,
+		repositoryID,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("list API keys: %w", err)
-	}
-	defer rows.Close()
-
-	keys := make([]APIKeyRecord, 0)
-	for rows.Next() {
-		key, err := scanAPIKey(rows)
-		if err != nil {
-			return nil, err
-		}
-		keys = append(keys, key)
+		return false, fmt.Errorf("pin GitHub OIDC trust repository: %w", err)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("iterate API keys: %w", err)
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("get pinned GitHub OIDC trust affected rows: %w", err)
 	}
 
-	return keys, nil
+	return affectedRows > 0, nil
 }
 
-func (s *Store) RevokeAPIKey(ctx context.Context, applicationID string, keyID string, revokedAt time.Time) (bool, error) {
-	result, err := s.db.ExecContext(
+func (s *Store) DeleteGitHubOIDCTrust(ctx context.Context, applicationID string) error {
+	_, err := s.db.ExecContext(
 		ctx,
-		`UPDATE api_keys
-		 SET revoked_at = $1
-		 WHERE id = $2 AND application_id = $3 AND revoked_at IS NULL`,
-		revokedAt,
-		keyID,
+		`DELETE FROM github_oidc_trusts
+		 WHERE application_id = $1`,
 		applicationID,
 	)
 	if err != nil {
-		return false, fmt.Errorf("revoke API key: %w", err)
-	}
-
-	affectedRows, err := result.RowsAffected()
-	if err != nil {
-		return false, fmt.Errorf("get API key revoke affected rows: %w", err)
+		return fmt.Errorf("delete GitHub OIDC trust: %w", err)
 	}
 
-	return affectedRows > 0, nil
+	return nil
 }
 
-func (s *Store) TouchAPIKeyLastUsed(ctx context.Context, keyID string, usedAt time.Time) error {
-	_, err := s.db.ExecContext(
+// ListAllApplications pages through every application, newest first, and returns the total.
+func (s *Store) ListAllApplications(ctx context.Context, limit int, offset int) ([]AdminApplicationRecord, int, error) {
+	var total int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM applications`).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count applications: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(
 		ctx,
-		`UPDATE api_keys
-		 SET last_used_at = $1
-		 WHERE id = $2`,
-		usedAt,
-		keyID,
+		`SELECT `+qualifiedApplicationColumns+`, o.name, u.email,
+			(SELECT COUNT(*) FROM api_keys k WHERE k.application_id = a.id AND k.revoked_at IS NULL),
+			(SELECT COUNT(*) FROM browser_sessions b WHERE b.application_id = a.id AND b.status = 'RUNNING')
+		 FROM applications a
+		 INNER JOIN organizations o ON o.id = a.organization_id
+		 INNER JOIN users u ON u.id = a.owner_user_id
+		 ORDER BY a.created_at DESC, a.id ASC
+		 LIMIT $1 OFFSET $2`,
+		limit,
+		offset,
 	)
 	if err != nil {
-		return fmt.Errorf("update API key last_used_at: %w", err)
+		return nil, 0, fmt.Errorf("list all applications: %w", err)
 	}
+	defer rows.Close()
 
-	return nil
+	applications := make([]AdminApplicationRecord, 0, limit)
+	for rows.Next() {
+		var row applicationRow
+		var record AdminApplicationRecord
+		if err := rows.Scan(append(row.targets(), &record.OrganizationName, &record.CreatorEmail, &record.ActiveAPIKeys, &record.RunningBrowsers)...); err != nil {
+			return nil, 0, fmt.Errorf("scan application: %w", err)
+		}
+		record.Application = row.record()
+		applications = append(applications, record)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("iterate all applications: %w", err)
+	}
+
+	return applications, total, nil
 }
 
-func (s *Store) GetActiveAPIKeyAuthByHash(ctx context.Context, keyHash string) (APIKeyAuthRecord, bool, error) {
-	query := `SELECT
-		k.id, k.application_id, k.name, k.key_prefix, k.key_hash, k.can_read, k.can_write, k.can_delete, k.created_at, k.last_used_at, k.revoked_at,
-		a.id, a.owner_user_id, a.name, a.description, a.github_link, a.domain, a.created_at, a.updated_at
-	FROM api_keys k
-	INNER JOIN applications a ON a.id = k.application_id
-	WHERE k.key_hash = $1 AND k.revoked_at IS NULL`
-
-	row := s.db.QueryRowContext(ctx, query, keyHash)
-
-	var keyRecord APIKeyRecord
-	var appRecord ApplicationRecord
-	var canRead int
-	var canWrite int
-	var canDelete int
-	var keyLastUsedAt sql.NullTime
-	var keyRevokedAt sql.NullTime
-	err := row.Scan(
-		&keyRecord.ID,
-		&keyRecord.ApplicationID,
-		&keyRecord.Name,
-		&keyRecord.KeyPrefix,
-		&keyRecord.KeyHash,
-		&canRead,
-		&canWrite,
-		&canDelete,
-		&keyRecord.CreatedAt,
-		&keyLastUsedAt,
-		&keyRevokedAt,
-		&appRecord.ID,
-		&appRecord.OwnerUserID,
-		&appRecord.Name,
-		&appRecord.Description,
-		&appRecord.GitHubLink,
-		&appRecord.Domain,
-		&appRecord.CreatedAt,
-		&appRecord.UpdatedAt,
+// ListRunningBrowserSessions returns running browsers across every application, newest first.
+func (s *Store) ListRunningBrowserSessions(ctx context.Context, limit int) ([]RunningBrowserSessionRecord, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT
+			b.id, b.application_id, b.external_browser_id, b.status, b.cdp_url, b.cdp_http_url, b.headless,
+			b.spawn_task_process_id, b.spawned_by_worker_id, b.created_at, b.last_active_at, b.idle_timeout_seconds, b.expires_at, b.closed_at,
+			a.name, o.name
+		 FROM browser_sessions b
+		 INNER JOIN applications a ON a.id = b.application_id
+		 INNER JOIN organizations o ON o.id = a.organization_id
+		 WHERE b.status = 'RUNNING'
+		 ORDER BY b.created_at DESC
+		 LIMIT $1`,
+		limit,
 	)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return APIKeyAuthRecord{}, false, nil
-		}
+		return nil, fmt.Errorf("list running browser sessions: %w", err)
+	}
+	defer rows.Close()
 
-		return APIKeyAuthRecord{}, false, fmt.Errorf("query API key by hash: %w", err)
+	records := make([]RunningBrowserSessionRecord, 0)
+	for rows.Next() {
+		var record RunningBrowserSessionRecord
+		session, err := scanBrowserSession(trailingScanner{scanner: rows, trailing: []any{&record.ApplicationName, &record.OrganizationName}})
+		if err != nil {
+			return nil, fmt.Errorf("scan running browser session: %w", err)
+		}
+		record.Session = session
+		records = append(records, record)
 	}
 
-	keyRecord.CanRead = canRead != 0
-	keyRecord.CanWrite = canWrite != 0
-	keyRecord.CanDelete = canDelete != 0
-	keyRecord.LastUsedAt = nullableTimePtr(keyLastUsedAt)
-	keyRecord.RevokedAt = nullableTimePtr(keyRevokedAt)
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate running browser sessions: %w", err)
+	}
 
-	return APIKeyAuthRecord{Key: keyRecord, Application: appRecord}, true, nil
+	return records, nil
 }
 
 func (s *Store) CreateBrowserSession(ctx context.Context, record BrowserSessionRecord) error {
@@ -629,7 +3818,7 @@ func (s *Store) ListBrowserSessionsByUserID(ctx context.Context, userID string,
 			b.spawn_task_process_id, b.spawned_by_worker_id, b.created_at, b.last_active_at, b.idle_timeout_seconds, b.expires_at, b.closed_at
 		 FROM browser_sessions b
 		 INNER JOIN applications a ON a.id = b.application_id
-		 WHERE a.owner_user_id = $1
+		 WHERE a.organization_id IN (SELECT organization_id FROM organization_members WHERE user_id = $1)
 		 ORDER BY b.created_at DESC
 		 LIMIT $2`,
 		userID,
@@ -656,6 +3845,41 @@ func (s *Store) ListBrowserSessionsByUserID(ctx context.Context, userID string,
 	return records, nil
 }
 
+// ListRunningBrowserSessionsDeletedWithUser returns the running browsers of applications that
+// DeleteUser would delete.
+func (s *Store) ListRunningBrowserSessionsDeletedWithUser(ctx context.Context, userID string) ([]BrowserSessionRecord, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT
+			b.id, b.application_id, b.external_browser_id, b.status, b.cdp_url, b.cdp_http_url, b.headless,
+			b.spawn_task_process_id, b.spawned_by_worker_id, b.created_at, b.last_active_at, b.idle_timeout_seconds, b.expires_at, b.closed_at
+		 FROM browser_sessions b
+		 INNER JOIN applications a ON a.id = b.application_id
+		 WHERE b.status <> 'COMPLETED' AND a.organization_id IN (`+accountOrganizationsQuery+`)
+		 ORDER BY b.created_at`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list running browser sessions: %w", err)
+	}
+	defer rows.Close()
+
+	records := make([]BrowserSessionRecord, 0)
+	for rows.Next() {
+		record, err := scanBrowserSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate browser sessions: %w", err)
+	}
+
+	return records, nil
+}
+
 func (s *Store) GetBrowserSessionByExternalID(ctx context.Context, applicationID string, externalBrowserID string) (BrowserSessionRecord, bool, error) {
 	row := s.db.QueryRowContext(
 		ctx,
@@ -684,38 +3908,335 @@ type scanner interface {
 	Scan(dest ...any) error
 }
 
-func scanAPIKey(scanTarget scanner) (APIKeyRecord, error) {
-	var key APIKeyRecord
-	var canRead int
-	var canWrite int
-	var canDelete int
-	var lastUsedAt sql.NullTime
-	var revokedAt sql.NullTime
+// placeholders returns "$start, $start+1, ..." for count query arguments.
+// likeEscaper escapes LIKE wildcards in user input; queries declare ESCAPE '\'.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
 
-	err := scanTarget.Scan(
-		&key.ID,
-		&key.ApplicationID,
-		&key.Name,
-		&key.KeyPrefix,
-		&key.KeyHash,
-		&canRead,
-		&canWrite,
-		&canDelete,
-		&key.CreatedAt,
-		&lastUsedAt,
-		&revokedAt,
+func placeholders(start int, count int) string {
+	parts := make([]string, count)
+	for i := range parts {
+		parts[i] = fmt.Sprintf("$%d", start+i)
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func stringArgs(values []string) []any {
+	args := make([]any, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+
+	return args
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func insertAPIKey(ctx context.Context, db execer, record APIKeyRecord) error {
+	_, err := db.ExecContext(
+		ctx,
+		// can_read, can_write and can_delete are superseded by scopes and only kept
+		// populated because the columns are NOT NULL.
+		`INSERT INTO api_keys (`+apiKeyColumns+`, can_read, can_write, can_delete)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, 0, 0, 0)`,
+		record.ID,
+		record.ApplicationID,
+		record.Name,
+		record.KeyPrefix,
+		record.KeyHash,
+		strings.Join(record.Scopes, " "),
+		record.CreatedAt,
+		record.LastUsedAt,
+		record.RevokedAt,
+		record.ExpiresAt,
+		nullableString(record.RotatedFromKeyID),
+		nullableString(record.ReplacedByKeyID),
+		record.RotatedAt,
+		record.GraceExpiresAt,
+		strings.Join(record.AllowedCIDRs, " "),
+		record.RateLimitPerMinute,
+		record.LastUsedIP,
+		record.LastUsedUserAgent,
+		record.HashVersion,
 	)
 	if err != nil {
+		return fmt.Errorf("insert API key: %w", err)
+	}
+
+	return nil
+}
+
+// apiKeyRow holds the intermediate scan values for apiKeyColumns.
+type apiKeyRow struct {
+	key              APIKeyRecord
+	scopes           string
+	lastUsedAt       sql.NullTime
+	revokedAt        sql.NullTime
+	expiresAt        sql.NullTime
+	rotatedFromKeyID sql.NullString
+	replacedByKeyID  sql.NullString
+	rotatedAt        sql.NullTime
+	graceExpiresAt   sql.NullTime
+	allowedCIDRs     string
+}
+
+func (r *apiKeyRow) targets() []any {
+	return []any{
+		&r.key.ID,
+		&r.key.ApplicationID,
+		&r.key.Name,
+		&r.key.KeyPrefix,
+		&r.key.KeyHash,
+		&r.scopes,
+		&r.key.CreatedAt,
+		&r.lastUsedAt,
+		&r.revokedAt,
+		&r.expiresAt,
+		&r.rotatedFromKeyID,
+		&r.replacedByKeyID,
+		&r.rotatedAt,
+		&r.graceExpiresAt,
+		&r.allowedCIDRs,
+		&r.key.RateLimitPerMinute,
+		&r.key.LastUsedIP,
+		&r.key.LastUsedUserAgent,
+		&r.key.HashVersion,
+	}
+}
+
+func (r *apiKeyRow) record() APIKeyRecord {
+	key := r.key
+	key.Scopes = strings.Fields(r.scopes)
+	key.LastUsedAt = nullableTimePtr(r.lastUsedAt)
+	key.RevokedAt = nullableTimePtr(r.revokedAt)
+	key.ExpiresAt = nullableTimePtr(r.expiresAt)
+	key.RotatedFromKeyID = r.rotatedFromKeyID.String
+	key.ReplacedByKeyID = r.replacedByKeyID.String
+	key.RotatedAt = nullableTimePtr(r.rotatedAt)
+	key.GraceExpiresAt = nullableTimePtr(r.graceExpiresAt)
+	key.AllowedCIDRs = strings.Fields(r.allowedCIDRs)
+
+	return key
+}
+
+func scanAPIKey(scanTarget scanner) (APIKeyRecord, error) {
+	var row apiKeyRow
+	if err := scanTarget.Scan(row.targets()...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKeyRecord{}, err
+		}
 		return APIKeyRecord{}, fmt.Errorf("scan API key: %w", err)
 	}
 
-	key.CanRead = canRead != 0
-	key.CanWrite = canWrite != 0
-	key.CanDelete = canDelete != 0
-	key.LastUsedAt = nullableTimePtr(lastUsedAt)
-	key.RevokedAt = nullableTimePtr(revokedAt)
+	return row.record(), nil
+}
+
+// sessionRow holds the intermediate scan values for sessionColumns.
+type userRow struct {
+	user          UserRecord
+	verifiedAt    sql.NullTime
+	totpEnabledAt sql.NullTime
+	disabledAt    sql.NullTime
+}
+
+func (r *userRow) targets() []any {
+	return []any{
+		&r.user.ID,
+		&r.user.Email,
+		&r.user.PasswordHash,
+		&r.user.Role,
+		&r.user.CreatedAt,
+		&r.user.UpdatedAt,
+		&r.verifiedAt,
+		&r.user.TOTPSecret,
+		&r.totpEnabledAt,
+		&r.user.TOTPLastStep,
+		&r.disabledAt,
+	}
+}
+
+func (r *userRow) record() UserRecord {
+	user := r.user
+	user.VerifiedAt = nullableTimePtr(r.verifiedAt)
+	user.TOTPEnabledAt = nullableTimePtr(r.totpEnabledAt)
+	user.DisabledAt = nullableTimePtr(r.disabledAt)
+	return user
+}
+
+func auditEventTargets(event *AuditEventRecord) []any {
+	return []any{
+		&event.ID,
+		&event.ActorUserID,
+		&event.Action,
+		&event.TargetType,
+		&event.TargetID,
+		&event.IP,
+		&event.UserAgent,
+		&event.RequestID,
+		&event.Changes,
+		&event.CreatedAt,
+		&event.OrganizationID,
+	}
+}
+
+func invitationTargets(invitation *InvitationRecord) []any {
+	return []any{
+		&invitation.ID,
+		&invitation.OrganizationID,
+		&invitation.Email,
+		&invitation.Role,
+		&invitation.TokenHash,
+		&invitation.InvitedByUserID,
+		&invitation.ExpiresAt,
+		&invitation.SentAt,
+		&invitation.CreatedAt,
+	}
+}
+
+// applicationTransferTargets scans the transfer columns, followed by the application name and
+// the initiator's and recipient's emails when withNames is set.
+func applicationTransferTargets(transfer *ApplicationTransferRecord, withNames bool) []any {
+	targets := []any{
+		&transfer.ID,
+		&transfer.ApplicationID,
+		&transfer.FromOrganizationID,
+		&transfer.InitiatedByUserID,
+		&transfer.RecipientUserID,
+		&transfer.ExpiresAt,
+		&transfer.CreatedAt,
+	}
+	if withNames {
+		targets = append(targets, &transfer.ApplicationName, &transfer.InitiatedByEmail, &transfer.RecipientEmail)
+	}
+
+	return targets
+}
+
+type organizationRow struct {
+	organization   OrganizationRecord
+	personalUserID sql.NullString
+}
+
+func (r *organizationRow) targets() []any {
+	return []any{
+		&r.organization.ID,
+		&r.organization.Name,
+		&r.personalUserID,
+		&r.organization.CreatedAt,
+		&r.organization.UpdatedAt,
+	}
+}
+
+func (r *organizationRow) record() OrganizationRecord {
+	organization := r.organization
+	organization.PersonalUserID = r.personalUserID.String
+	return organization
+}
+
+type authFailureRow struct {
+	failure     AuthFailureRecord
+	lockedUntil sql.NullTime
+}
+
+func (r *authFailureRow) targets() []any {
+	return []any{
+		&r.failure.Scope,
+		&r.failure.Subject,
+		&r.failure.Failures,
+		&r.failure.LastFailureAt,
+		&r.lockedUntil,
+	}
+}
+
+func (r *authFailureRow) record() AuthFailureRecord {
+	failure := r.failure
+	failure.LockedUntil = nullableTimePtr(r.lockedUntil)
+	return failure
+}
+
+type sessionRow struct {
+	session    SessionRecord
+	lastSeenAt sql.NullTime
+}
+
+func (r *sessionRow) targets() []any {
+	return []any{
+		&r.session.ID,
+		&r.session.UserID,
+		&r.session.TokenHash,
+		&r.session.HashVersion,
+		&r.session.ExpiresAt,
+		&r.session.CreatedAt,
+		&r.lastSeenAt,
+		&r.session.IP,
+		&r.session.UserAgent,
+		&r.session.Role,
+	}
+}
+
+func (r *sessionRow) record() SessionRecord {
+	session := r.session
+	// Sessions created before last_seen_at existed count as seen when they were created.
+	session.LastSeenAt = session.CreatedAt
+	if r.lastSeenAt.Valid {
+		session.LastSeenAt = r.lastSeenAt.Time
+	}
+
+	return session
+}
+
+// applicationRow holds the intermediate scan values for applicationColumns.
+type applicationRow struct {
+	application  ApplicationRecord
+	allowedCIDRs string
+	archivedAt   sql.NullTime
+}
+
+func (r *applicationRow) targets() []any {
+	return []any{
+		&r.application.ID,
+		&r.application.OwnerUserID,
+		&r.application.Name,
+		&r.application.Description,
+		&r.application.GitHubLink,
+		&r.application.Domain,
+		&r.application.MaxAPIKeyLifetimeDays,
+		&r.application.CreatedAt,
+		&r.application.UpdatedAt,
+		&r.allowedCIDRs,
+		&r.application.RateLimitPerMinute,
+		&r.application.OrganizationID,
+		&r.archivedAt,
+	}
+}
+
+func (r *applicationRow) record() ApplicationRecord {
+	application := r.application
+	application.AllowedCIDRs = strings.Fields(r.allowedCIDRs)
+	application.ArchivedAt = nullableTimePtr(r.archivedAt)
+
+	return application
+}
+
+func scanApplication(scanTarget scanner) (ApplicationRecord, error) {
+	var row applicationRow
+	if err := scanTarget.Scan(row.targets()...); err != nil {
+		return ApplicationRecord{}, err
+	}
+
+	return row.record(), nil
+}
+
+// trailingScanner scans columns selected after a row helper's own into trailing.
+type trailingScanner struct {
+	scanner
+	trailing []any
+}
 
-	return key, nil
+func (t trailingScanner) Scan(dest ...any) error {
+	return t.scanner.Scan(append(dest, t.trailing...)...)
 }
 
 func scanBrowserSession(scanTarget scanner) (BrowserSessionRecord, error) {
@@ -0,0 +1,172 @@
+// Package githuboidc exchanges GitHub Actions OIDC tokens for short-lived access tokens, so
+// CI workflows can call the API without storing an API key.
+package githuboidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/accesstokens"
+	"github.com/brian-nunez/bbaas-api/internal/applications"
+	"github.com/brian-nunez/bbaas-api/internal/jwt"
+)
+
+const (
+	DefaultIssuer   = "https://token.actions.githubusercontent.com"
+	DefaultJWKSURL  = DefaultIssuer + "/.well-known/jwks"
+	DefaultAudience = "bbaas"
+
+	clockSkewLeeway = time.Minute
+)
+
+var (
+	ErrInvalidToken          = errors.New("invalid GitHub Actions OIDC token")
+	ErrRepositoryMismatch    = errors.New("workflow repository does not match the application's GitHub repository")
+	ErrRefNotAllowed         = errors.New("workflow ref is not allowed to authenticate for this application")
+	ErrEnvironmentNotAllowed = errors.New("workflow environment is not allowed to authenticate for this application")
+)
+
+type Config struct {
+	JWKSURL  string
+	Issuer   string
+	Audience string
+	// HTTPClient fetches the JWKS; nil uses a client with a 10 second timeout.
+	HTTPClient *http.Client
+}
+
+type ExchangeRequest struct {
+	ApplicationID string
+	// IDToken is the OIDC token the workflow requested from GitHub.
+	IDToken  string
+	ClientIP string
+	TTL      time.Duration
+	Scopes   []string
+}
+
+// Claims are the GitHub Actions specific claims checked during an exchange.
+type Claims struct {
+	jwt.RegisteredClaims
+	Repository   string `json:"repository"`
+	RepositoryID string `json:"repository_id"`
+	Ref          string `json:"ref"`
+	Environment  string `json:"environment"`
+	Workflow     string `json:"workflow"`
+	RunID        string `json:"run_id"`
+}
+
+type Service struct {
+	keySet              *jwt.KeySet
+	issuer              string
+	audience            string
+	applicationsService *applications.Service
+	accessTokensService *accesstokens.Service
+	now                 func() time.Time
+}
+
+func NewService(config Config, applicationsService *applications.Service, accessTokensService *accesstokens.Service) *Service {
+	jwksURL := strings.TrimSpace(config.JWKSURL)
+	if jwksURL == "" {
+		jwksURL = DefaultJWKSURL
+	}
+	issuer := strings.TrimSpace(config.Issuer)
+	if issuer == "" {
+		issuer = DefaultIssuer
+	}
+	audience := strings.TrimSpace(config.Audience)
+	if audience == "" {
+		audience = DefaultAudience
+	}
+
+	return &Service{
+		keySet:              jwt.NewKeySet(jwksURL, config.HTTPClient),
+		issuer:              issuer,
+		audience:            audience,
+		applicationsService: applicationsService,
+		accessTokensService: accessTokensService,
+		now:                 time.Now,
+	}
+}
+
+// Exchange verifies a workflow's OIDC token against the application's trust and mints an
+// access token acting as the trusted API key.
+func (s *Service) Exchange(ctx context.Context, request ExchangeRequest) (accesstokens.Token, error) {
+	claims, err := s.verify(ctx, request.IDToken)
+	if err != nil {
+		return accesstokens.Token{}, err
+	}
+
+	resolution, err := s.applicationsService.ResolveGitHubOIDCTrust(ctx, request.ApplicationID)
+	if err != nil {
+		return accesstokens.Token{}, err
+	}
+
+	if !strings.EqualFold(claims.Repository, resolution.Repository) {
+		return accesstokens.Token{}, ErrRepositoryMismatch
+	}
+	if resolution.Trust.AllowedRef != "" && claims.Ref != resolution.Trust.AllowedRef {
+		return accesstokens.Token{}, ErrRefNotAllowed
+	}
+	if resolution.Trust.AllowedEnvironment != "" && claims.Environment != resolution.Trust.AllowedEnvironment {
+		return accesstokens.Token{}, ErrEnvironmentNotAllowed
+	}
+
+	// The name alone would also match a repository recreated under it, so the trust is pinned
+	// to the repository ID seen on its first exchange.
+	repositoryID := resolution.Trust.RepositoryID
+	if repositoryID == "" {
+		repositoryID, err = s.applicationsService.PinGitHubOIDCRepositoryID(ctx, resolution.Trust.ApplicationID, claims.RepositoryID)
+		if err != nil {
+			return accesstokens.Token{}, err
+		}
+	}
+	if claims.RepositoryID != repositoryID {
+		return accesstokens.Token{}, ErrRepositoryMismatch
+	}
+
+	if err := s.applicationsService.AuthorizeClientIP(ctx, resolution.Principal, request.ClientIP); err != nil {
+		return accesstokens.Token{}, err
+	}
+
+	return s.accessTokensService.Mint(ctx, resolution.Principal, accesstokens.MintRequest{
+		TTL:    request.TTL,
+		Scopes: request.Scopes,
+	})
+}
+
+func (s *Service) verify(ctx context.Context, rawToken string) (Claims, error) {
+	token, err := jwt.Parse(rawToken)
+	if err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+	if token.Header.Algorithm != jwt.AlgorithmRS256 {
+		return Claims{}, ErrInvalidToken
+	}
+
+	publicKey, err := s.keySet.Key(ctx, token.Header.KeyID)
+	if err != nil {
+		if errors.Is(err, jwt.ErrUnknownKey) {
+			return Claims{}, ErrInvalidToken
+		}
+		return Claims{}, fmt.Errorf("load GitHub OIDC signing key: %w", err)
+	}
+	if err := token.VerifyRS256(publicKey); err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := token.DecodeClaims(&claims); err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+	if claims.Issuer != s.issuer || !claims.HasAudience(s.audience) || claims.ExpiresAt == 0 || claims.Repository == "" || claims.RepositoryID == "" {
+		return Claims{}, ErrInvalidToken
+	}
+	if err := claims.ValidateTimes(s.now(), clockSkewLeeway); err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+
+	return claims, nil
+}
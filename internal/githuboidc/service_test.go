@@ -0,0 +1,236 @@
+package githuboidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/accesstokens"
+	"github.com/brian-nunez/bbaas-api/internal/applications"
+	"github.com/brian-nunez/bbaas-api/internal/authorization"
+	"github.com/brian-nunez/bbaas-api/internal/data/datatest"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+	"github.com/brian-nunez/bbaas-api/internal/users"
+)
+
+func TestExchange(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	signer := newTestSigner(t)
+	store := datatest.NewStore(t)
+	appsService := applications.NewService(store, authorization.NewWebAuthorizer(), security.NewTokenHasher("test-pepper"))
+	accessTokensService := accesstokens.NewService(store)
+	service := NewService(Config{JWKSURL: signer.jwksURL, Issuer: DefaultIssuer, Audience: "bbaas-test"}, appsService, accessTokensService)
+
+	user, err := users.NewService(store, security.NewTokenHasher("test-pepper"), users.SessionPolicy{}).Register(ctx, "ci@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	application, err := appsService.RegisterApplication(ctx, user, applications.RegisterApplicationInput{
+		Name:       "CI",
+		GitHubLink: "https://github.com/example-org/browser-tests",
+		Domain:     "example.com",
+	})
+	if err != nil {
+		t.Fatalf("register application: %v", err)
+	}
+	created, err := appsService.CreateAPIKey(ctx, user, application.ID, applications.CreateAPIKeyInput{
+		Name:   "CI",
+		Scopes: []string{authorization.ScopeBrowsersSpawn, authorization.ScopeBrowsersRead},
+	})
+	if err != nil {
+		t.Fatalf("create API key: %v", err)
+	}
+
+	claims := map[string]any{
+		"iss":           DefaultIssuer,
+		"aud":           "bbaas-test",
+		"exp":           time.Now().Add(5 * time.Minute).Unix(),
+		"iat":           time.Now().Unix(),
+		"repository":    "example-org/browser-tests",
+		"repository_id": "123456",
+		"ref":           "refs/heads/main",
+		"environment":   "ci",
+	}
+	exchange := func(overrides map[string]any) (accesstokens.Token, error) {
+		tokenClaims := make(map[string]any, len(claims))
+		for name, value := range claims {
+			tokenClaims[name] = value
+		}
+		for name, value := range overrides {
+			tokenClaims[name] = value
+		}
+
+		return service.Exchange(ctx, ExchangeRequest{
+			ApplicationID: application.ID,
+			IDToken:       signer.sign(t, tokenClaims),
+			ClientIP:      "192.0.2.10",
+		})
+	}
+
+	if _, err := exchange(nil); !errors.Is(err, applications.ErrGitHubOIDCNotConfigured) {
+		t.Fatalf("expected exchange to fail before a trust is configured, got %v", err)
+	}
+
+	if _, err := appsService.UpdateGitHubOIDCTrust(ctx, user, application.ID, applications.GitHubOIDCTrustInput{
+		APIKeyID:           created.APIKey.ID,
+		AllowedRef:         "main",
+		AllowedEnvironment: "ci",
+	}); err != nil {
+		t.Fatalf("configure GitHub OIDC trust: %v", err)
+	}
+
+	token, err := exchange(nil)
+	if err != nil {
+		t.Fatalf("exchange token: %v", err)
+	}
+	principal, err := accessTokensService.Authenticate(ctx, token.Token)
+	if err != nil {
+		t.Fatalf("authenticate exchanged token: %v", err)
+	}
+	if principal.KeyID != created.APIKey.ID || principal.ApplicationID != application.ID {
+		t.Fatalf("expected exchanged token to act as the trusted key, got %+v", principal)
+	}
+
+	failures := []struct {
+		name      string
+		overrides map[string]any
+		expected  error
+	}{
+		{name: "other repository", overrides: map[string]any{"repository": "example-org/other"}, expected: ErrRepositoryMismatch},
+		{name: "recreated repository", overrides: map[string]any{"repository_id": "654321"}, expected: ErrRepositoryMismatch},
+		{name: "missing repository ID", overrides: map[string]any{"repository_id": ""}, expected: ErrInvalidToken},
+		{name: "other branch", overrides: map[string]any{"ref": "refs/heads/feature"}, expected: ErrRefNotAllowed},
+		{name: "other environment", overrides: map[string]any{"environment": "production"}, expected: ErrEnvironmentNotAllowed},
+		{name: "wrong audience", overrides: map[string]any{"aud": "someone-else"}, expected: ErrInvalidToken},
+		{name: "wrong issuer", overrides: map[string]any{"iss": "https://issuer.example"}, expected: ErrInvalidToken},
+		{name: "expired", overrides: map[string]any{"exp": time.Now().Add(-5 * time.Minute).Unix()}, expected: ErrInvalidToken},
+	}
+	for _, failure := range failures {
+		if _, err := exchange(failure.overrides); !errors.Is(err, failure.expected) {
+			t.Fatalf("%s: expected %v, got %v", failure.name, failure.expected, err)
+		}
+	}
+
+	forged := signer.sign(t, claims)
+	forged = forged[:strings.LastIndex(forged, ".")] + "." + base64.RawURLEncoding.EncodeToString(make([]byte, 256))
+	if _, err := service.Exchange(ctx, ExchangeRequest{ApplicationID: application.ID, IDToken: forged}); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected forged signature to be rejected, got %v", err)
+	}
+
+	rotated, err := appsService.RotateAPIKey(ctx, user, application.ID, created.APIKey.ID, 0)
+	if err != nil {
+		t.Fatalf("rotate trusted key: %v", err)
+	}
+	token, err = exchange(nil)
+	if err != nil {
+		t.Fatalf("exchange token after rotation: %v", err)
+	}
+	principal, err = accessTokensService.Authenticate(ctx, token.Token)
+	if err != nil {
+		t.Fatalf("authenticate exchanged token: %v", err)
+	}
+	if principal.KeyID != rotated.APIKey.ID {
+		t.Fatalf("expected trust to follow the rotated key, got %s", principal.KeyID)
+	}
+
+	if _, err := appsService.UpdateApplication(ctx, user, application.ID, applications.UpdateApplicationInput{
+		Name:       "CI",
+		GitHubLink: "https://github.com/example-org/other-tests",
+		Domain:     "example.com",
+	}); err != nil {
+		t.Fatalf("update application: %v", err)
+	}
+	if _, found, err := appsService.GetGitHubOIDCTrust(ctx, user, application.ID); err != nil || found {
+		t.Fatalf("expected moving the GitHub link to disable the trust, found %v, err %v", found, err)
+	}
+	if _, err := exchange(map[string]any{"repository": "example-org/other-tests"}); !errors.Is(err, applications.ErrGitHubOIDCNotConfigured) {
+		t.Fatalf("expected the new repository not to be trusted, got %v", err)
+	}
+}
+
+func TestGitHubRepository(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]string{
+		"https://github.com/example-org/repo":      "example-org/repo",
+		"https://github.com/example-org/repo.git":  "example-org/repo",
+		"https://github.com/example-org/repo/tree": "example-org/repo",
+		"https://github.com/example-org":           "",
+		"https://gitlab.com/example-org/repo":      "",
+	}
+	for link, expected := range cases {
+		repository, ok := applications.GitHubRepository(link)
+		if repository != expected || ok != (expected != "") {
+			t.Fatalf("GitHubRepository(%q) = %q, %v; expected %q", link, repository, ok, expected)
+		}
+	}
+}
+
+type testSigner struct {
+	key     *rsa.PrivateKey
+	keyID   string
+	jwksURL string
+}
+
+func newTestSigner(t *testing.T) *testSigner {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+
+	signer := &testSigner{key: key, keyID: "test-key"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": signer.keyID,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	t.Cleanup(server.Close)
+	signer.jwksURL = server.URL
+
+	return signer
+}
+
+func (s *testSigner) sign(t *testing.T, claims map[string]any) string {
+	t.Helper()
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": s.keyID})
+	if err != nil {
+		t.Fatalf("encode header: %v", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("encode claims: %v", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
@@ -5,17 +5,26 @@ import "net/http"
 type ErrorType string
 
 const (
-	ErrInvalidRequest      ErrorType = "INVALID_REQUEST"
-	ErrUnauthorized        ErrorType = "UNAUTHORIZED"
-	ErrNotFound            ErrorType = "NOT_FOUND"
-	ErrNotAllowed          ErrorType = "NOT_ALLOWED"
-	ErrInternalServerError ErrorType = "INTERNAL_SERVER_ERROR"
-	ErrServiceUnavailable  ErrorType = "SERVICE_UNAVAILABLE"
+	ErrInvalidRequest        ErrorType = "INVALID_REQUEST"
+	ErrUnauthorized          ErrorType = "UNAUTHORIZED"
+	ErrForbidden             ErrorType = "FORBIDDEN"
+	ErrNotFound              ErrorType = "NOT_FOUND"
+	ErrNotAllowed            ErrorType = "NOT_ALLOWED"
+	ErrInternalServerError   ErrorType = "INTERNAL_SERVER_ERROR"
+	ErrServiceUnavailable    ErrorType = "SERVICE_UNAVAILABLE"
+	ErrAPIKeyExpired         ErrorType = "API_KEY_EXPIRED"
+	ErrInsufficientScope     ErrorType = "INSUFFICIENT_SCOPE"
+	ErrIPNotAllowed          ErrorType = "IP_NOT_ALLOWED"
+	ErrRateLimited           ErrorType = "RATE_LIMITED"
+	ErrAccessTokenExpired    ErrorType = "ACCESS_TOKEN_EXPIRED"
+	ErrTooManyFailedAttempts ErrorType = "TOO_MANY_FAILED_ATTEMPTS"
+	ErrApplicationArchived   ErrorType = "APPLICATION_ARCHIVED"
 )
 
 type ErrorMessage struct {
-	ErrorCode    string `json:"error_code"`
-	ErrorMessage string `json:"error_message"`
+	ErrorCode     string   `json:"error_code"`
+	ErrorMessage  string   `json:"error_message"`
+	MissingScopes []string `json:"missing_scopes,omitempty"`
 }
 
 type ErrorResponse struct {
@@ -27,6 +36,7 @@ type errorBuilder struct {
 	httpStatusCode int
 	errorCode      string
 	message        string
+	missingScopes  []string
 }
 
 func (b *errorBuilder) WithStatusCode(code int) *errorBuilder {
@@ -44,12 +54,18 @@ func (b *errorBuilder) WithErrorCode(errorCode string) *errorBuilder {
 	return b
 }
 
+func (b *errorBuilder) WithMissingScopes(scopes []string) *errorBuilder {
+	b.missingScopes = scopes
+	return b
+}
+
 func (b *errorBuilder) Build() *ErrorResponse {
 	return &ErrorResponse{
 		HTTPStatusCode: b.httpStatusCode,
 		ErrorMessage: ErrorMessage{
-			ErrorCode:    b.errorCode,
-			ErrorMessage: b.message,
+			ErrorCode:     b.errorCode,
+			ErrorMessage:  b.message,
+			MissingScopes: b.missingScopes,
 		},
 	}
 }
@@ -74,6 +90,14 @@ func Unauthorized() *errorBuilder {
 	}
 }
 
+func Forbidden() *errorBuilder {
+	return &errorBuilder{
+		httpStatusCode: http.StatusForbidden,
+		errorCode:      string(ErrForbidden),
+		message:        "Forbidden",
+	}
+}
+
 func NotFound() *errorBuilder {
 	return &errorBuilder{
 		httpStatusCode: http.StatusNotFound,
@@ -112,6 +136,8 @@ func GenerateByStatusCode(code int) *errorBuilder {
 		return InvalidRequest()
 	case http.StatusUnauthorized:
 		return Unauthorized()
+	case http.StatusForbidden:
+		return Forbidden()
 	case http.StatusNotFound:
 		return NotFound()
 	case http.StatusMethodNotAllowed:
@@ -0,0 +1,196 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/applications"
+	"github.com/brian-nunez/bbaas-api/internal/authorization"
+	handlererrors "github.com/brian-nunez/bbaas-api/internal/handlers/errors"
+	"github.com/labstack/echo/v4"
+)
+
+type APIKeysHandler struct {
+	applicationsService *applications.Service
+}
+
+func NewAPIKeysHandler(applicationsService *applications.Service) *APIKeysHandler {
+	return &APIKeysHandler{
+		applicationsService: applicationsService,
+	}
+}
+
+type rotateAPIKeyRequest struct {
+	GracePeriodSeconds int `json:"gracePeriodSeconds"`
+}
+
+type apiKeyResponse struct {
+	ID               string     `json:"id"`
+	Name             string     `json:"name"`
+	KeyPrefix        string     `json:"keyPrefix"`
+	Scopes           []string   `json:"scopes"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	LastUsedAt       *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt        *time.Time `json:"revokedAt,omitempty"`
+	ExpiresAt        *time.Time `json:"expiresAt,omitempty"`
+	RotatedFromKeyID string     `json:"rotatedFromKeyId,omitempty"`
+	ReplacedByKeyID  string     `json:"replacedByKeyId,omitempty"`
+	RotatedAt        *time.Time `json:"rotatedAt,omitempty"`
+	GraceExpiresAt   *time.Time `json:"graceExpiresAt,omitempty"`
+	UsedDuringGrace  bool       `json:"usedDuringGrace"`
+	AllowedCIDRs     []string   `json:"allowedCidrs,omitempty"`
+}
+
+// RotateCurrentAPIKey rotates the API key used to authenticate the request.
+func (h *APIKeysHandler) RotateCurrentAPIKey(c echo.Context) error {
+	principal, ok := getAPIKeyPrincipal(c)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "missing API key principal")
+	}
+
+	request, err := decodeRotateRequest(c)
+	if err != nil {
+		response := handlererrors.InvalidRequest().WithMessage("Invalid JSON body").Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	rotated, err := h.applicationsService.RotateAPIKeyForPrincipal(
+		c.Request().Context(),
+		principal,
+		time.Duration(request.GracePeriodSeconds)*time.Second,
+	)
+	if err != nil {
+		return mapAPIKeyServiceError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, map[string]any{
+		"apiKey":     mapAPIKeyResponse(rotated.APIKey),
+		"token":      rotated.Token,
+		"rotatedKey": mapAPIKeyResponse(rotated.RotatedKey),
+	})
+}
+
+// GetAPIKey returns the calling key or the key it replaced, including whether a rotated key
+// is still being used during its grace period.
+func (h *APIKeysHandler) GetAPIKey(c echo.Context) error {
+	principal, ok := getAPIKeyPrincipal(c)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "missing API key principal")
+	}
+
+	keyID := strings.TrimSpace(c.Param("id"))
+	if keyID == "current" {
+		keyID = principal.KeyID
+	}
+
+	key, err := h.applicationsService.GetAPIKeyForPrincipal(c.Request().Context(), principal, keyID)
+	if err != nil {
+		return mapAPIKeyServiceError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]apiKeyResponse{
+		"apiKey": mapAPIKeyResponse(key),
+	})
+}
+
+type endpointUsageResponse struct {
+	Endpoint     string    `json:"endpoint"`
+	RequestCount int       `json:"requestCount"`
+	LastUsedAt   time.Time `json:"lastUsedAt"`
+}
+
+// GetCurrentUsage returns the calling key's request counts per endpoint. Counts are flushed
+// in batches, so the latest few seconds of requests may be missing.
+func (h *APIKeysHandler) GetCurrentUsage(c echo.Context) error {
+	principal, ok := getAPIKeyPrincipal(c)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "missing API key principal")
+	}
+
+	endpointUsage, err := h.applicationsService.GetUsageForPrincipal(c.Request().Context(), principal)
+	if err != nil {
+		return mapAPIKeyServiceError(c, err)
+	}
+
+	endpoints := make([]endpointUsageResponse, 0, len(endpointUsage))
+	for _, usage := range endpointUsage {
+		endpoints = append(endpoints, endpointUsageResponse{
+			Endpoint:     usage.Endpoint,
+			RequestCount: usage.RequestCount,
+			LastUsedAt:   usage.LastUsedAt,
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"apiKeyId":  principal.KeyID,
+		"endpoints": endpoints,
+	})
+}
+
+func decodeRotateRequest(c echo.Context) (rotateAPIKeyRequest, error) {
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, 1<<20))
+	if err != nil {
+		return rotateAPIKeyRequest{}, fmt.Errorf("read request body: %w", err)
+	}
+	if len(strings.TrimSpace(string(body))) == 0 {
+		return rotateAPIKeyRequest{}, nil
+	}
+
+	var request rotateAPIKeyRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return rotateAPIKeyRequest{}, fmt.Errorf("decode request body: %w", err)
+	}
+
+	return request, nil
+}
+
+func mapAPIKeyResponse(key applications.APIKey) apiKeyResponse {
+	return apiKeyResponse{
+		ID:               key.ID,
+		Name:             key.Name,
+		KeyPrefix:        key.KeyPrefix,
+		Scopes:           key.Scopes,
+		CreatedAt:        key.CreatedAt,
+		LastUsedAt:       key.LastUsedAt,
+		RevokedAt:        key.RevokedAt,
+		ExpiresAt:        key.ExpiresAt,
+		RotatedFromKeyID: key.RotatedFromKeyID,
+		ReplacedByKeyID:  key.ReplacedByKeyID,
+		RotatedAt:        key.RotatedAt,
+		GraceExpiresAt:   key.GraceExpiresAt,
+		UsedDuringGrace:  key.UsedDuringGrace(),
+		AllowedCIDRs:     key.AllowedCIDRs,
+	}
+}
+
+func mapAPIKeyServiceError(c echo.Context, err error) error {
+	var missingScopesError *authorization.MissingScopesError
+	if errors.As(err, &missingScopesError) {
+		return insufficientScope(c, missingScopesError)
+	}
+
+	switch {
+	case errors.Is(err, applications.ErrApplicationArchived):
+		response := handlererrors.Custom().
+			WithStatusCode(http.StatusConflict).
+			WithErrorCode(string(handlererrors.ErrApplicationArchived)).
+			WithMessage("This application is archived and its keys cannot be rotated").
+			Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	case errors.Is(err, applications.ErrAPIKeyNotFound), errors.Is(err, applications.ErrApplicationNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, applications.ErrAPIKeyAlreadyRotated), errors.Is(err, applications.ErrAPIKeyExpired):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.Is(err, applications.ErrInvalidGracePeriod):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, applications.ErrForbidden):
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+
+	return err
+}
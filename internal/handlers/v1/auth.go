@@ -2,14 +2,24 @@ package v1
 
 import (
 	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
 	"strings"
 
+	"github.com/brian-nunez/bbaas-api/internal/accesstokens"
 	"github.com/brian-nunez/bbaas-api/internal/applications"
+	"github.com/brian-nunez/bbaas-api/internal/authorization"
 	handlererrors "github.com/brian-nunez/bbaas-api/internal/handlers/errors"
+	"github.com/brian-nunez/bbaas-api/internal/jwt"
+	"github.com/brian-nunez/bbaas-api/internal/lockout"
 	"github.com/labstack/echo/v4"
 )
 
-func APIKeyAuthMiddleware(applicationsService *applications.Service) echo.MiddlewareFunc {
+// APIKeyAuthMiddleware authenticates requests with either a raw API key or an access token
+// minted from one. Source IPs that keep presenting invalid credentials are locked out by guard.
+func APIKeyAuthMiddleware(applicationsService *applications.Service, accessTokensService *accesstokens.Service, guard *lockout.Guard) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			rawAPIKey := extractAPIToken(c)
@@ -20,12 +30,57 @@ func APIKeyAuthMiddleware(applicationsService *applications.Service) echo.Middle
 				return c.JSON(response.HTTPStatusCode, response)
 			}
 
-			principal, err := applicationsService.AuthenticateAPIKey(c.Request().Context(), rawAPIKey)
+			clientIP := c.RealIP()
+			var locked *lockout.LockedError
+			if err := guard.Check(c.Request().Context(), lockout.APIKeyIP(clientIP)); errors.As(err, &locked) {
+				retryAfterSeconds := int(math.Ceil(locked.RetryAfter.Seconds()))
+				c.Response().Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds))
+				response := handlererrors.Custom().
+					WithStatusCode(http.StatusTooManyRequests).
+					WithErrorCode(string(handlererrors.ErrTooManyFailedAttempts)).
+					WithMessage(fmt.Sprintf("Too many invalid API keys from this address, retry in %d seconds", retryAfterSeconds)).
+					Build()
+				return c.JSON(response.HTTPStatusCode, response)
+			} else if err != nil {
+				// Fail open like the rate limiter: a lockout lookup error must not take the API down.
+				c.Logger().Errorf("check API key lockout: %v", err)
+			}
+
+			var principal applications.APIKeyPrincipal
+			var err error
+			if jwt.LooksLikeToken(rawAPIKey) {
+				principal, err = accessTokensService.Authenticate(c.Request().Context(), rawAPIKey)
+			} else {
+				principal, err = applicationsService.AuthenticateAPIKey(c.Request().Context(), rawAPIKey)
+			}
 			if err != nil {
+				if errors.Is(err, accesstokens.ErrInvalidToken) || errors.Is(err, applications.ErrInvalidAPIKey) {
+					if recordErr := guard.RecordFailure(c.Request().Context(), lockout.APIKeyIP(clientIP)); recordErr != nil {
+						c.Logger().Errorf("record invalid API key: %v", recordErr)
+					}
+				}
+				if errors.Is(err, accesstokens.ErrInvalidToken) {
+					response := handlererrors.Unauthorized().WithMessage("Invalid access token").Build()
+					return c.JSON(response.HTTPStatusCode, response)
+				}
+				if errors.Is(err, accesstokens.ErrTokenExpired) {
+					response := handlererrors.Unauthorized().
+						WithErrorCode(string(handlererrors.ErrAccessTokenExpired)).
+						WithMessage("Access token has expired").
+						Build()
+					return c.JSON(response.HTTPStatusCode, response)
+				}
 				if errors.Is(err, applications.ErrInvalidAPIKey) {
 					response := handlererrors.Unauthorized().WithMessage("Invalid API key").Build()
 					return c.JSON(response.HTTPStatusCode, response)
 				}
+				if errors.Is(err, applications.ErrAPIKeyExpired) {
+					response := handlererrors.Unauthorized().
+						WithErrorCode(string(handlererrors.ErrAPIKeyExpired)).
+						WithMessage("API key has expired").
+						Build()
+					return c.JSON(response.HTTPStatusCode, response)
+				}
 				return err
 			}
 
@@ -35,6 +90,35 @@ func APIKeyAuthMiddleware(applicationsService *applications.Service) echo.Middle
 	}
 }
 
+// ClientIPMiddleware rejects requests from source IPs outside the key's allowlists. It must
+// run after RateLimitMiddleware, so a key hammered from a blocked address is throttled before
+// each denial is recorded.
+func ClientIPMiddleware(applicationsService *applications.Service) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			principal, ok := getAPIKeyPrincipal(c)
+			if !ok {
+				return next(c)
+			}
+
+			clientIP := c.RealIP()
+			if err := applicationsService.AuthorizeClientIP(c.Request().Context(), principal, clientIP); err != nil {
+				if errors.Is(err, applications.ErrIPNotAllowed) {
+					c.Logger().Warnf("API key %s denied for source IP %s", principal.KeyID, clientIP)
+					response := handlererrors.Forbidden().
+						WithErrorCode(string(handlererrors.ErrIPNotAllowed)).
+						WithMessage(fmt.Sprintf("Requests from %s are not allowed for this API key", clientIP)).
+						Build()
+					return c.JSON(response.HTTPStatusCode, response)
+				}
+				return err
+			}
+
+			return next(c)
+		}
+	}
+}
+
 func extractAPIToken(c echo.Context) string {
 	authorizationHeader := strings.TrimSpace(c.Request().Header.Get(echo.HeaderAuthorization))
 	if strings.HasPrefix(strings.ToLower(authorizationHeader), "bearer ") {
@@ -43,3 +127,13 @@ func extractAPIToken(c echo.Context) string {
 
 	return strings.TrimSpace(c.Request().Header.Get("X-API-Key"))
 }
+
+// insufficientScope answers a request whose API key lacks the scopes the operation needs.
+func insufficientScope(c echo.Context, err *authorization.MissingScopesError) error {
+	response := handlererrors.Forbidden().
+		WithErrorCode(string(handlererrors.ErrInsufficientScope)).
+		WithMessage(err.Error()).
+		WithMissingScopes(err.Scopes).
+		Build()
+	return c.JSON(response.HTTPStatusCode, response)
+}
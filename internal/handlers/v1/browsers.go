@@ -8,6 +8,8 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/brian-nunez/bbaas-api/internal/applications"
+	"github.com/brian-nunez/bbaas-api/internal/authorization"
 	"github.com/brian-nunez/bbaas-api/internal/browsers"
 	handlererrors "github.com/brian-nunez/bbaas-api/internal/handlers/errors"
 	"github.com/labstack/echo/v4"
@@ -37,7 +39,7 @@ func (h *BrowsersHandler) SpawnBrowser(c echo.Context) error {
 
 	spawnedBrowser, err := h.browserService.SpawnForAPIKey(c.Request().Context(), principal, request)
 	if err != nil {
-		return mapBrowserServiceError(err)
+		return mapBrowserServiceError(c, err)
 	}
 
 	return c.JSON(http.StatusCreated, spawnedBrowser)
@@ -51,7 +53,7 @@ func (h *BrowsersHandler) ListBrowsers(c echo.Context) error {
 
 	availableBrowsers, err := h.browserService.ListForAPIKey(c.Request().Context(), principal)
 	if err != nil {
-		return mapBrowserServiceError(err)
+		return mapBrowserServiceError(c, err)
 	}
 
 	return c.JSON(http.StatusOK, map[string][]browsers.Browser{
@@ -59,6 +61,23 @@ func (h *BrowsersHandler) ListBrowsers(c echo.Context) error {
 	})
 }
 
+// ListSessions returns the application's browser history, including closed browsers.
+func (h *BrowsersHandler) ListSessions(c echo.Context) error {
+	principal, ok := getAPIKeyPrincipal(c)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "missing API key principal")
+	}
+
+	sessions, err := h.browserService.HistoryForAPIKey(c.Request().Context(), principal)
+	if err != nil {
+		return mapBrowserServiceError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string][]browsers.Session{
+		"sessions": sessions,
+	})
+}
+
 func (h *BrowsersHandler) GetBrowser(c echo.Context) error {
 	principal, ok := getAPIKeyPrincipal(c)
 	if !ok {
@@ -68,7 +87,7 @@ func (h *BrowsersHandler) GetBrowser(c echo.Context) error {
 	browserID := strings.TrimSpace(c.Param("id"))
 	browser, err := h.browserService.GetForAPIKey(c.Request().Context(), principal, browserID)
 	if err != nil {
-		return mapBrowserServiceError(err)
+		return mapBrowserServiceError(c, err)
 	}
 
 	return c.JSON(http.StatusOK, map[string]browsers.Browser{
@@ -85,7 +104,7 @@ func (h *BrowsersHandler) KeepAliveBrowser(c echo.Context) error {
 	browserID := strings.TrimSpace(c.Param("id"))
 	browser, err := h.browserService.KeepAliveForAPIKey(c.Request().Context(), principal, browserID)
 	if err != nil {
-		return mapBrowserServiceError(err)
+		return mapBrowserServiceError(c, err)
 	}
 
 	return c.JSON(http.StatusOK, map[string]browsers.Browser{
@@ -102,7 +121,7 @@ func (h *BrowsersHandler) CloseBrowser(c echo.Context) error {
 	browserID := strings.TrimSpace(c.Param("id"))
 	err := h.browserService.CloseForAPIKey(c.Request().Context(), principal, browserID)
 	if err != nil {
-		return mapBrowserServiceError(err)
+		return mapBrowserServiceError(c, err)
 	}
 
 	return c.NoContent(http.StatusNoContent)
@@ -125,13 +144,26 @@ func decodeSpawnRequest(c echo.Context) (browsers.SpawnRequest, error) {
 	return request, nil
 }
 
-func mapBrowserServiceError(err error) error {
+func mapBrowserServiceError(c echo.Context, err error) error {
 	if errors.Is(err, browsers.ErrBrowserNotFound) {
 		return echo.NewHTTPError(http.StatusNotFound, err.Error())
 	}
+
+	var missingScopesError *authorization.MissingScopesError
+	if errors.As(err, &missingScopesError) {
+		return insufficientScope(c, missingScopesError)
+	}
 	if errors.Is(err, browsers.ErrForbidden) {
 		return echo.NewHTTPError(http.StatusForbidden, err.Error())
 	}
+	if errors.Is(err, applications.ErrApplicationArchived) {
+		response := handlererrors.Custom().
+			WithStatusCode(http.StatusConflict).
+			WithErrorCode(string(handlererrors.ErrApplicationArchived)).
+			WithMessage("This application is archived and cannot spawn browsers").
+			Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
 
 	var upstreamError *browsers.UpstreamError
 	if errors.As(err, &upstreamError) {
@@ -0,0 +1,99 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/applications"
+	"github.com/brian-nunez/bbaas-api/internal/githuboidc"
+	handlererrors "github.com/brian-nunez/bbaas-api/internal/handlers/errors"
+	"github.com/labstack/echo/v4"
+)
+
+type GitHubOIDCHandler struct {
+	githubOIDCService *githuboidc.Service
+}
+
+func NewGitHubOIDCHandler(githubOIDCService *githuboidc.Service) *GitHubOIDCHandler {
+	return &GitHubOIDCHandler{
+		githubOIDCService: githubOIDCService,
+	}
+}
+
+type githubOIDCExchangeRequest struct {
+	ApplicationID string   `json:"applicationId"`
+	Token         string   `json:"token"`
+	TTLSeconds    int      `json:"ttlSeconds"`
+	Scopes        []string `json:"scopes"`
+}
+
+// ExchangeToken trades a GitHub Actions OIDC token for a short-lived access token. It is
+// unauthenticated: the OIDC token is the credential.
+func (h *GitHubOIDCHandler) ExchangeToken(c echo.Context) error {
+	request, err := decodeGitHubOIDCExchangeRequest(c)
+	if err != nil || strings.TrimSpace(request.Token) == "" || strings.TrimSpace(request.ApplicationID) == "" {
+		response := handlererrors.InvalidRequest().WithMessage("Body must be JSON with applicationId and token").Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	clientIP := c.RealIP()
+	token, err := h.githubOIDCService.Exchange(c.Request().Context(), githuboidc.ExchangeRequest{
+		ApplicationID: request.ApplicationID,
+		IDToken:       request.Token,
+		ClientIP:      clientIP,
+		TTL:           time.Duration(request.TTLSeconds) * time.Second,
+		Scopes:        request.Scopes,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, githuboidc.ErrInvalidToken):
+			response := handlererrors.Unauthorized().WithMessage(err.Error()).Build()
+			return c.JSON(response.HTTPStatusCode, response)
+		case errors.Is(err, githuboidc.ErrRepositoryMismatch),
+			errors.Is(err, githuboidc.ErrRefNotAllowed),
+			errors.Is(err, githuboidc.ErrEnvironmentNotAllowed),
+			errors.Is(err, applications.ErrGitHubOIDCNotConfigured):
+			response := handlererrors.Forbidden().WithMessage(err.Error()).Build()
+			return c.JSON(response.HTTPStatusCode, response)
+		case errors.Is(err, applications.ErrInvalidAPIKey), errors.Is(err, applications.ErrAPIKeyExpired):
+			response := handlererrors.Forbidden().
+				WithMessage("The API key trusted for GitHub Actions is no longer active").
+				Build()
+			return c.JSON(response.HTTPStatusCode, response)
+		case errors.Is(err, applications.ErrIPNotAllowed):
+			c.Logger().Warnf("GitHub Actions exchange for application %s denied for source IP %s", request.ApplicationID, clientIP)
+			response := handlererrors.Forbidden().
+				WithErrorCode(string(handlererrors.ErrIPNotAllowed)).
+				WithMessage(fmt.Sprintf("Requests from %s are not allowed for this API key", clientIP)).
+				Build()
+			return c.JSON(response.HTTPStatusCode, response)
+		}
+		return mapAccessTokenServiceError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, accessTokenResponse{
+		Token:     token.Token,
+		TokenType: "Bearer",
+		ExpiresAt: token.ExpiresAt,
+		Scopes:    token.Scopes,
+	})
+}
+
+func decodeGitHubOIDCExchangeRequest(c echo.Context) (githubOIDCExchangeRequest, error) {
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, 1<<20))
+	if err != nil {
+		return githubOIDCExchangeRequest{}, fmt.Errorf("read request body: %w", err)
+	}
+
+	var request githubOIDCExchangeRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return githubOIDCExchangeRequest{}, fmt.Errorf("decode request body: %w", err)
+	}
+
+	return request, nil
+}
@@ -0,0 +1,74 @@
+package v1
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	handlererrors "github.com/brian-nunez/bbaas-api/internal/handlers/errors"
+	"github.com/brian-nunez/bbaas-api/internal/ratelimit"
+	"github.com/labstack/echo/v4"
+)
+
+// RateLimitMiddleware throttles requests per API key. It must run after
+// APIKeyAuthMiddleware. Keys without their own or an application limit use defaultLimit.
+func RateLimitMiddleware(limiter ratelimit.Limiter, defaultLimit ratelimit.Limit) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			principal, ok := getAPIKeyPrincipal(c)
+			if !ok || limiter == nil {
+				return next(c)
+			}
+
+			limit := defaultLimit
+			if principal.RateLimitPerMinute > 0 {
+				limit = ratelimit.PerMinute(principal.RateLimitPerMinute)
+			}
+			if limit.IsZero() {
+				return next(c)
+			}
+
+			return takeRateLimit(c, next, limiter, "api_key:"+principal.KeyID, limit)
+		}
+	}
+}
+
+// IPRateLimitMiddleware throttles unauthenticated API requests per source IP.
+func IPRateLimitMiddleware(limiter ratelimit.Limiter, limit ratelimit.Limit) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if limiter == nil || limit.IsZero() {
+				return next(c)
+			}
+
+			return takeRateLimit(c, next, limiter, "ip:"+c.Path()+":"+c.RealIP(), limit)
+		}
+	}
+}
+
+func takeRateLimit(c echo.Context, next echo.HandlerFunc, limiter ratelimit.Limiter, key string, limit ratelimit.Limit) error {
+	decision, err := limiter.Take(c.Request().Context(), key, limit)
+	if err != nil {
+		// Fail open: an unavailable limiter backend must not take the API down.
+		c.Logger().Errorf("rate limiter: %v", err)
+		return next(c)
+	}
+
+	header := c.Response().Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	if !decision.Allowed {
+		retryAfterSeconds := int(math.Ceil(decision.RetryAfter.Seconds()))
+		header.Set("Retry-After", strconv.Itoa(retryAfterSeconds))
+
+		response := handlererrors.Custom().
+			WithStatusCode(http.StatusTooManyRequests).
+			WithErrorCode(string(handlererrors.ErrRateLimited)).
+			WithMessage(fmt.Sprintf("Rate limit of %d requests exceeded, retry in %d seconds", decision.Limit, retryAfterSeconds)).
+			Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	return next(c)
+}
@@ -1,10 +1,23 @@
 package v1
 
 import (
+	"net/http"
+
+	"github.com/brian-nunez/bbaas-api/internal/accesstokens"
+	"github.com/brian-nunez/bbaas-api/internal/accounts"
+	"github.com/brian-nunez/bbaas-api/internal/admin"
 	"github.com/brian-nunez/bbaas-api/internal/applications"
+	"github.com/brian-nunez/bbaas-api/internal/audit"
+	"github.com/brian-nunez/bbaas-api/internal/auditlog"
 	"github.com/brian-nunez/bbaas-api/internal/browsers"
 	"github.com/brian-nunez/bbaas-api/internal/dashboard"
+	"github.com/brian-nunez/bbaas-api/internal/githuboidc"
 	uihandlers "github.com/brian-nunez/bbaas-api/internal/handlers/v1/ui"
+	"github.com/brian-nunez/bbaas-api/internal/lockout"
+	"github.com/brian-nunez/bbaas-api/internal/organizations"
+	"github.com/brian-nunez/bbaas-api/internal/ratelimit"
+	"github.com/brian-nunez/bbaas-api/internal/sso"
+	"github.com/brian-nunez/bbaas-api/internal/usage"
 	"github.com/brian-nunez/bbaas-api/internal/users"
 	"github.com/labstack/echo/v4"
 )
@@ -12,41 +25,168 @@ import (
 type Dependencies struct {
 	UsersService        *users.Service
 	ApplicationsService *applications.Service
+	Organizations       *organizations.Service
+	Invitations         *organizations.Invitations
+	Admin               *admin.Service
+	Audit               *audit.Recorder
+	AuditLog            *auditlog.Service
+	AccessTokensService *accesstokens.Service
+	GitHubOIDCService   *githuboidc.Service
 	BrowserService      *browsers.Service
 	DashboardService    *dashboard.Service
+	PasswordResetter    *users.PasswordResetter
+	EmailVerifier       *users.EmailVerifier
+	EmailChanger        *users.EmailChanger
+	Accounts            *accounts.Service
+	TwoFactor           *users.TwoFactor
+	SingleSignOn        *sso.Service
+	Lockout             *lockout.Guard
+	RateLimiter         ratelimit.Limiter
+	DefaultRateLimit    ratelimit.Limit
+	ExchangeRateLimit   ratelimit.Limit
+	UsageAggregator     *usage.Aggregator
+	CookieSecurity      uihandlers.CookieSecurity
 }
 
 func RegisterRoutes(e *echo.Echo, dependencies Dependencies) {
-	e.Use(uihandlers.SessionMiddleware(dependencies.UsersService))
+	e.Use(uihandlers.SessionMiddleware(dependencies.UsersService, dependencies.CookieSecurity))
+	e.Use(uihandlers.CSRFMiddleware(dependencies.CookieSecurity))
+	e.Use(uihandlers.RequireTwoFactorEnrollment(dependencies.TwoFactor))
+	e.Use(uihandlers.AuditRequestMiddleware)
 
 	uiHandler := uihandlers.NewHandler(
 		dependencies.UsersService,
 		dependencies.ApplicationsService,
+		dependencies.Organizations,
+		dependencies.Invitations,
+		dependencies.Admin,
+		dependencies.Audit,
+		dependencies.AuditLog,
 		dependencies.DashboardService,
+		dependencies.PasswordResetter,
+		dependencies.EmailVerifier,
+		dependencies.EmailChanger,
+		dependencies.Accounts,
+		dependencies.TwoFactor,
+		dependencies.SingleSignOn,
+		dependencies.Lockout,
+		dependencies.CookieSecurity,
 	)
 
 	browsersHandler := NewBrowsersHandler(dependencies.BrowserService)
-	apiKeyMiddleware := APIKeyAuthMiddleware(dependencies.ApplicationsService)
+	apiKeysHandler := NewAPIKeysHandler(dependencies.ApplicationsService)
+	tokensHandler := NewTokensHandler(dependencies.AccessTokensService)
+	githubOIDCHandler := NewGitHubOIDCHandler(dependencies.GitHubOIDCService)
+	apiKeyMiddleware := APIKeyAuthMiddleware(dependencies.ApplicationsService, dependencies.AccessTokensService, dependencies.Lockout)
+	rateLimitMiddleware := RateLimitMiddleware(dependencies.RateLimiter, dependencies.DefaultRateLimit)
+	usageMiddleware := UsageMiddleware(dependencies.UsageAggregator)
+	clientIPMiddleware := ClientIPMiddleware(dependencies.ApplicationsService)
+	// Throttled and IP-denied requests are rejected before they count as usage.
+	apiKeyMiddlewares := []echo.MiddlewareFunc{apiKeyMiddleware, rateLimitMiddleware, clientIPMiddleware, usageMiddleware}
+
+	requirePasswordLogin := uihandlers.RequirePasswordLogin(dependencies.UsersService)
 
 	e.GET("/", uiHandler.Home)
-	e.GET("/register", uiHandler.ShowRegister, uihandlers.RequireGuest)
-	e.POST("/register", uiHandler.Register, uihandlers.RequireGuest)
+	e.GET("/register", uiHandler.ShowRegister, uihandlers.RequireGuest, requirePasswordLogin)
+	e.POST("/register", uiHandler.Register, uihandlers.RequireGuest, requirePasswordLogin)
 	e.GET("/login", uiHandler.ShowLogin, uihandlers.RequireGuest)
-	e.POST("/login", uiHandler.Login, uihandlers.RequireGuest)
+	e.POST("/login", uiHandler.Login, uihandlers.RequireGuest, requirePasswordLogin)
+	e.GET("/login/sso", uiHandler.StartSingleSignOn, uihandlers.RequireGuest)
+	e.GET("/login/sso/callback", uiHandler.CompleteSingleSignOn, uihandlers.RequireGuest)
+	e.GET("/login/2fa", uiHandler.ShowTwoFactorLogin, uihandlers.RequireGuest)
+	e.POST("/login/2fa", uiHandler.CompleteTwoFactorLogin, uihandlers.RequireGuest)
 	e.POST("/logout", uiHandler.Logout, uihandlers.RequireAuth)
+	e.GET("/password-reset", uiHandler.ShowPasswordResetRequest, requirePasswordLogin)
+	e.POST("/password-reset", uiHandler.RequestPasswordReset, requirePasswordLogin)
+	e.GET("/password-reset/confirm", uiHandler.ShowPasswordResetConfirm, requirePasswordLogin)
+	e.POST("/password-reset/confirm", uiHandler.ConfirmPasswordReset, requirePasswordLogin)
+	e.GET("/verify-email", uiHandler.VerifyEmail)
+	e.POST("/verify-email/resend", uiHandler.ResendVerificationEmail, uihandlers.RequireAuth)
+
+	e.GET("/settings/account", uiHandler.AccountSettings, uihandlers.RequireAuth)
+	e.POST("/settings/account/password", uiHandler.ChangePassword, uihandlers.RequireAuth)
+	e.POST("/settings/account/email", uiHandler.RequestEmailChange, uihandlers.RequireAuth)
+	e.POST("/settings/account/email/cancel", uiHandler.CancelEmailChange, uihandlers.RequireAuth)
+	e.GET("/settings/account/email/confirm", uiHandler.ConfirmEmailChange)
+	e.POST("/settings/account/delete", uiHandler.DeleteAccount, uihandlers.RequireAuth)
+
+	e.GET("/account/security", uiHandler.AccountSecurity, uihandlers.RequireAuth)
+	e.POST("/account/security/2fa/confirm", uiHandler.ConfirmTwoFactor, uihandlers.RequireAuth)
+	e.POST("/account/security/2fa/recovery-codes", uiHandler.RegenerateRecoveryCodes, uihandlers.RequireAuth)
+	e.POST("/account/security/2fa/disable", uiHandler.DisableTwoFactor, uihandlers.RequireAuth)
+	e.POST("/account/security/2fa/requirement", uiHandler.UpdateTwoFactorRequirement, uihandlers.RequireAuth)
+
+	e.GET("/account/sessions", uiHandler.Sessions, uihandlers.RequireAuth)
+	e.POST("/account/sessions/revoke-all", uiHandler.RevokeAllSessions, uihandlers.RequireAuth)
+	e.POST("/account/sessions/:sessionId/revoke", uiHandler.RevokeSession, uihandlers.RequireAuth)
 
 	e.GET("/dashboard", uiHandler.Dashboard, uihandlers.RequireAuth)
 	e.POST("/dashboard/applications", uiHandler.CreateApplication, uihandlers.RequireAuth)
+	e.POST("/dashboard/lockouts/clear", uiHandler.ClearLockout, uihandlers.RequireAuth)
+	e.POST("/dashboard/applications/:applicationId", uiHandler.UpdateApplication, uihandlers.RequireAuth)
+	e.POST("/dashboard/applications/:applicationId/archive", uiHandler.ArchiveApplication, uihandlers.RequireAuth)
+	e.POST("/dashboard/applications/:applicationId/restore", uiHandler.RestoreApplication, uihandlers.RequireAuth)
+	e.POST("/dashboard/applications/:applicationId/delete", uiHandler.DeleteApplication, uihandlers.RequireAuth)
+	e.POST("/dashboard/applications/:applicationId/transfer", uiHandler.StartApplicationTransfer, uihandlers.RequireAuth)
+	e.POST("/dashboard/transfers/:transferId/cancel", uiHandler.CancelApplicationTransfer, uihandlers.RequireAuth)
+	e.POST("/dashboard/transfers/:transferId/accept", uiHandler.AcceptApplicationTransfer, uihandlers.RequireAuth)
+	e.POST("/dashboard/transfers/:transferId/decline", uiHandler.DeclineApplicationTransfer, uihandlers.RequireAuth)
+	e.POST("/dashboard/applications/:applicationId/key-policy", uiHandler.UpdateAPIKeyPolicy, uihandlers.RequireAuth)
+	e.POST("/dashboard/applications/:applicationId/rate-limit", uiHandler.UpdateRateLimit, uihandlers.RequireAuth)
+	e.POST("/dashboard/applications/:applicationId/allowed-ips", uiHandler.UpdateApplicationAllowedIPs, uihandlers.RequireAuth)
+	e.POST("/dashboard/applications/:applicationId/github-actions", uiHandler.UpdateGitHubOIDCTrust, uihandlers.RequireAuth)
 	e.POST("/dashboard/applications/:applicationId/api-keys", uiHandler.CreateAPIKey, uihandlers.RequireAuth)
+	e.POST("/dashboard/applications/:applicationId/api-keys/:keyId/rotate", uiHandler.RotateAPIKey, uihandlers.RequireAuth)
+	e.POST("/dashboard/applications/:applicationId/api-keys/:keyId/allowed-ips", uiHandler.UpdateAPIKeyAllowedIPs, uihandlers.RequireAuth)
 	e.POST("/dashboard/applications/:applicationId/api-keys/:keyId/revoke", uiHandler.RevokeAPIKey, uihandlers.RequireAuth)
 
+	e.POST("/organizations", uiHandler.CreateOrganization, uihandlers.RequireAuth)
+	e.GET("/organizations/:organizationId", uiHandler.Organization, uihandlers.RequireAuth)
+	e.POST("/organizations/:organizationId/members", uiHandler.AddOrganizationMember, uihandlers.RequireAuth)
+	e.POST("/organizations/:organizationId/members/:userId/role", uiHandler.UpdateOrganizationMemberRole, uihandlers.RequireAuth)
+	e.POST("/organizations/:organizationId/members/:userId/remove", uiHandler.RemoveOrganizationMember, uihandlers.RequireAuth)
+	e.POST("/organizations/:organizationId/invitations", uiHandler.InviteOrganizationMember, uihandlers.RequireAuth)
+	e.POST("/organizations/:organizationId/invitations/:invitationId/resend", uiHandler.ResendInvitation, uihandlers.RequireAuth)
+	e.POST("/organizations/:organizationId/invitations/:invitationId/revoke", uiHandler.RevokeInvitation, uihandlers.RequireAuth)
+	e.GET("/invitations/accept", uiHandler.ShowInvitation)
+	e.POST("/invitations/accept", uiHandler.AcceptInvitation, uihandlers.RequireAuth)
+
+	e.GET("/audit", uiHandler.AuditLog, uihandlers.RequireAuth)
+	e.GET("/audit/export", uiHandler.ExportAuditLog, uihandlers.RequireAuth)
+
+	e.GET("/admin", func(c echo.Context) error { return c.Redirect(http.StatusSeeOther, "/admin/users") }, uihandlers.RequireAuth)
+	e.GET("/admin/users", uiHandler.AdminUsers, uihandlers.RequireAuth)
+	e.POST("/admin/users/:userId/role", uiHandler.AdminUpdateUserRole, uihandlers.RequireAuth)
+	e.POST("/admin/users/:userId/disable", uiHandler.AdminDisableUser, uihandlers.RequireAuth)
+	e.POST("/admin/users/:userId/enable", uiHandler.AdminEnableUser, uihandlers.RequireAuth)
+	e.POST("/admin/users/:userId/logout", uiHandler.AdminLogoutUser, uihandlers.RequireAuth)
+	e.GET("/admin/applications", uiHandler.AdminApplications, uihandlers.RequireAuth)
+	e.POST("/admin/applications/:applicationId/transfer", uiHandler.AdminStartApplicationTransfer, uihandlers.RequireAuth)
+	e.POST("/admin/transfers/:transferId/cancel", uiHandler.AdminCancelApplicationTransfer, uihandlers.RequireAuth)
+	e.GET("/admin/browsers", uiHandler.AdminBrowsers, uihandlers.RequireAuth)
+	e.POST("/admin/browsers/:applicationId/:browserId/close", uiHandler.AdminCloseBrowser, uihandlers.RequireAuth)
+
 	v1Group := e.Group("/api/v1")
 	v1Group.GET("/health", HealthHandler)
 
-	browsersGroup := v1Group.Group("/browsers", apiKeyMiddleware)
+	browsersGroup := v1Group.Group("/browsers", apiKeyMiddlewares...)
 	browsersGroup.POST("", browsersHandler.SpawnBrowser)
 	browsersGroup.GET("", browsersHandler.ListBrowsers)
 	browsersGroup.GET("/:id", browsersHandler.GetBrowser)
 	browsersGroup.POST("/:id/keepalive", browsersHandler.KeepAliveBrowser)
 	browsersGroup.DELETE("/:id", browsersHandler.CloseBrowser)
+
+	apiKeysGroup := v1Group.Group("/api-keys", apiKeyMiddlewares...)
+	apiKeysGroup.POST("/current/rotate", apiKeysHandler.RotateCurrentAPIKey)
+	apiKeysGroup.GET("/current/usage", apiKeysHandler.GetCurrentUsage)
+	apiKeysGroup.GET("/:id", apiKeysHandler.GetAPIKey)
+
+	sessionsGroup := v1Group.Group("/sessions", apiKeyMiddlewares...)
+	sessionsGroup.GET("", browsersHandler.ListSessions)
+
+	tokensGroup := v1Group.Group("/tokens", apiKeyMiddlewares...)
+	tokensGroup.POST("", tokensHandler.MintToken)
+
+	exchangeRateLimitMiddleware := IPRateLimitMiddleware(dependencies.RateLimiter, dependencies.ExchangeRateLimit)
+	v1Group.POST("/oidc/github-actions/token", githubOIDCHandler.ExchangeToken, exchangeRateLimitMiddleware)
 }
@@ -0,0 +1,108 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/accesstokens"
+	handlererrors "github.com/brian-nunez/bbaas-api/internal/handlers/errors"
+	"github.com/labstack/echo/v4"
+)
+
+type TokensHandler struct {
+	accessTokensService *accesstokens.Service
+}
+
+func NewTokensHandler(accessTokensService *accesstokens.Service) *TokensHandler {
+	return &TokensHandler{
+		accessTokensService: accessTokensService,
+	}
+}
+
+type mintTokenRequest struct {
+	TTLSeconds int      `json:"ttlSeconds"`
+	Scopes     []string `json:"scopes"`
+	BrowserID  string   `json:"browserId"`
+}
+
+type accessTokenResponse struct {
+	Token     string    `json:"token"`
+	TokenType string    `json:"tokenType"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Scopes    []string  `json:"scopes"`
+	BrowserID string    `json:"browserId,omitempty"`
+}
+
+// MintToken issues a short-lived access token for the calling API key.
+func (h *TokensHandler) MintToken(c echo.Context) error {
+	principal, ok := getAPIKeyPrincipal(c)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "missing API key principal")
+	}
+
+	request, err := decodeMintTokenRequest(c)
+	if err != nil {
+		response := handlererrors.InvalidRequest().WithMessage("Invalid JSON body").Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	}
+
+	token, err := h.accessTokensService.Mint(c.Request().Context(), principal, accesstokens.MintRequest{
+		TTL:       time.Duration(request.TTLSeconds) * time.Second,
+		Scopes:    request.Scopes,
+		BrowserID: request.BrowserID,
+	})
+	if err != nil {
+		return mapAccessTokenServiceError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, accessTokenResponse{
+		Token:     token.Token,
+		TokenType: "Bearer",
+		ExpiresAt: token.ExpiresAt,
+		Scopes:    token.Scopes,
+		BrowserID: token.BrowserID,
+	})
+}
+
+func decodeMintTokenRequest(c echo.Context) (mintTokenRequest, error) {
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, 1<<20))
+	if err != nil {
+		return mintTokenRequest{}, fmt.Errorf("read request body: %w", err)
+	}
+	if len(strings.TrimSpace(string(body))) == 0 {
+		return mintTokenRequest{}, nil
+	}
+
+	var request mintTokenRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return mintTokenRequest{}, fmt.Errorf("decode request body: %w", err)
+	}
+
+	return request, nil
+}
+
+func mapAccessTokenServiceError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, accesstokens.ErrInvalidTTL), errors.Is(err, accesstokens.ErrUnknownScope):
+		response := handlererrors.InvalidRequest().WithMessage(err.Error()).Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	case errors.Is(err, accesstokens.ErrScopeNotGranted):
+		response := handlererrors.Forbidden().
+			WithErrorCode(string(handlererrors.ErrInsufficientScope)).
+			WithMessage(err.Error()).
+			Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	case errors.Is(err, accesstokens.ErrMintWithToken):
+		response := handlererrors.Forbidden().WithMessage(err.Error()).Build()
+		return c.JSON(response.HTTPStatusCode, response)
+	case errors.Is(err, accesstokens.ErrBrowserNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	return err
+}
@@ -0,0 +1,179 @@
+package uihandlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/brian-nunez/bbaas-api/internal/accounts"
+	"github.com/brian-nunez/bbaas-api/internal/lockout"
+	"github.com/brian-nunez/bbaas-api/internal/users"
+	"github.com/brian-nunez/bbaas-api/views/pages"
+	"github.com/labstack/echo/v4"
+)
+
+func (h *Handler) AccountSettings(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	pending, hasPending, err := h.emailChanger.Pending(c.Request().Context(), currentUser)
+	if err != nil {
+		return err
+	}
+
+	successMessage := strings.TrimSpace(c.QueryParam("success"))
+	errorMessage := strings.TrimSpace(c.QueryParam("error"))
+
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	return pages.AccountSettings(currentUser, pending, hasPending, successMessage, errorMessage).Render(c.Request().Context(), c.Response().Writer)
+}
+
+func (h *Handler) ChangePassword(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	newPassword := c.FormValue("new_password")
+	if newPassword != c.FormValue("confirm_password") {
+		return redirectToAccountSettings(c, "", "The new passwords do not match")
+	}
+
+	revoked, err := h.usersService.ChangePassword(
+		c.Request().Context(),
+		currentUser,
+		c.FormValue("current_password"),
+		newPassword,
+		getCurrentSessionID(c),
+		requestClient(c),
+	)
+	if err != nil {
+		return redirectToAccountSettings(c, "", accountErrorMessage(c, currentUser, err))
+	}
+
+	if revoked > 0 {
+		return redirectToAccountSettings(c, fmt.Sprintf("Password changed. %d other session(s) were logged out.", revoked), "")
+	}
+	return redirectToAccountSettings(c, "Password changed", "")
+}
+
+func (h *Handler) RequestEmailChange(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	pending, err := h.emailChanger.Request(
+		c.Request().Context(),
+		currentUser,
+		c.FormValue("new_email"),
+		c.FormValue("current_password"),
+		requestClient(c),
+	)
+	if err != nil {
+		return redirectToAccountSettings(c, "", accountErrorMessage(c, currentUser, err))
+	}
+
+	return redirectToAccountSettings(c, "Confirmation link sent to "+pending.NewEmail, "")
+}
+
+func (h *Handler) CancelEmailChange(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	if err := h.emailChanger.Cancel(c.Request().Context(), currentUser); err != nil {
+		return err
+	}
+
+	return redirectToAccountSettings(c, "Email change cancelled", "")
+}
+
+// ConfirmEmailChange works without a session so the link can be opened on any device.
+func (h *Handler) ConfirmEmailChange(c echo.Context) error {
+	changed, err := h.emailChanger.Confirm(c.Request().Context(), c.QueryParam("token"))
+	if err != nil && !errors.Is(err, users.ErrInvalidEmailChangeToken) && !errors.Is(err, users.ErrEmailAlreadyExists) {
+		return err
+	}
+
+	if _, ok := getCurrentUser(c); ok {
+		if err != nil {
+			return redirectToAccountSettings(c, "", err.Error())
+		}
+		return redirectToAccountSettings(c, "Your email address is now "+changed.Email, "")
+	}
+
+	if err != nil {
+		return renderError(c, http.StatusBadRequest, "Email change failed", err.Error()+". Log in to request a new link.")
+	}
+	return c.Redirect(http.StatusSeeOther, "/login?success="+url.QueryEscape("Email address changed. Log in with "+changed.Email+"."))
+}
+
+func (h *Handler) DeleteAccount(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	err := h.accounts.Delete(
+		c.Request().Context(),
+		currentUser,
+		c.FormValue("confirm_email"),
+		c.FormValue("current_password"),
+		requestClient(c),
+	)
+	var ownershipRequired *users.OwnershipRequiredError
+	switch {
+	case errors.Is(err, accounts.ErrConfirmationMismatch), errors.As(err, &ownershipRequired):
+		return redirectToAccountSettings(c, "", err.Error())
+	case err != nil:
+		return redirectToAccountSettings(c, "", accountErrorMessage(c, currentUser, err))
+	}
+
+	clearSessionCookie(c, h.cookieSecurity)
+	c.Logger().Infof("user %s deleted their account", currentUser.ID)
+	return c.Redirect(http.StatusSeeOther, "/login?success=Your+account+was+deleted")
+}
+
+// accountErrorMessage shows validation and re-authentication errors as they are and logs
+// anything unexpected behind a generic message.
+func accountErrorMessage(c echo.Context, currentUser users.User, err error) string {
+	var locked *lockout.LockedError
+	switch {
+	case errors.Is(err, users.ErrIncorrectPassword),
+		errors.Is(err, users.ErrNoPassword),
+		errors.Is(err, users.ErrPasswordRequired),
+		errors.Is(err, users.ErrPasswordTooShort),
+		errors.Is(err, users.ErrEmailRequired),
+		errors.Is(err, users.ErrInvalidEmail),
+		errors.Is(err, users.ErrSameEmail),
+		errors.Is(err, users.ErrEmailAlreadyExists),
+		errors.As(err, &locked):
+		return err.Error()
+	}
+
+	c.Logger().Errorf("update account of user %s: %v", currentUser.ID, err)
+	return "We could not update your account. Try again later."
+}
+
+func redirectToAccountSettings(c echo.Context, successMessage string, errorMessage string) error {
+	query := make(url.Values)
+	if successMessage != "" {
+		query.Set("success", successMessage)
+	}
+	if errorMessage != "" {
+		query.Set("error", errorMessage)
+	}
+
+	path := "/settings/account"
+	if encodedQuery := query.Encode(); encodedQuery != "" {
+		path = fmt.Sprintf("%s?%s", path, encodedQuery)
+	}
+
+	return c.Redirect(http.StatusSeeOther, path)
+}
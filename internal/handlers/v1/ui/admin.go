@@ -0,0 +1,243 @@
+package uihandlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/brian-nunez/bbaas-api/internal/admin"
+	"github.com/brian-nunez/bbaas-api/views/pages"
+	"github.com/labstack/echo/v4"
+)
+
+func (h *Handler) AdminUsers(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	userPage, err := h.admin.SearchUsers(c.Request().Context(), currentUser, strings.TrimSpace(c.QueryParam("q")), pageParam(c))
+	if err != nil {
+		return renderAdminError(c, err)
+	}
+	activity, err := h.admin.RecentActivity(c.Request().Context(), currentUser)
+	if err != nil {
+		return renderAdminError(c, err)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	return pages.AdminUsers(currentUser, userPage, activity, strings.TrimSpace(c.QueryParam("success")), strings.TrimSpace(c.QueryParam("error"))).Render(c.Request().Context(), c.Response().Writer)
+}
+
+func (h *Handler) AdminUpdateUserRole(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	if err := h.admin.UpdateUserRole(c.Request().Context(), currentUser, c.Param("userId"), c.FormValue("role")); err != nil {
+		return redirectAfterAdminUserError(c, err)
+	}
+
+	return redirectToAdminUsers(c, "Role updated", "")
+}
+
+func (h *Handler) AdminDisableUser(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	if err := h.admin.DisableUser(c.Request().Context(), currentUser, c.Param("userId")); err != nil {
+		return redirectAfterAdminUserError(c, err)
+	}
+
+	return redirectToAdminUsers(c, "Account disabled", "")
+}
+
+func (h *Handler) AdminEnableUser(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	if err := h.admin.EnableUser(c.Request().Context(), currentUser, c.Param("userId")); err != nil {
+		return redirectAfterAdminUserError(c, err)
+	}
+
+	return redirectToAdminUsers(c, "Account enabled", "")
+}
+
+func (h *Handler) AdminLogoutUser(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	userID := c.Param("userId")
+	revoked, err := h.admin.LogoutUser(c.Request().Context(), currentUser, userID)
+	if err != nil {
+		return redirectAfterAdminUserError(c, err)
+	}
+	if userID == currentUser.ID {
+		clearSessionCookie(c, h.cookieSecurity)
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	return redirectToAdminUsers(c, fmt.Sprintf("Ended %d sessions", revoked), "")
+}
+
+func (h *Handler) AdminApplications(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	applicationPage, err := h.admin.ListApplications(c.Request().Context(), currentUser, pageParam(c))
+	if err != nil {
+		return renderAdminError(c, err)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	return pages.AdminApplications(currentUser, applicationPage, strings.TrimSpace(c.QueryParam("success")), strings.TrimSpace(c.QueryParam("error"))).Render(c.Request().Context(), c.Response().Writer)
+}
+
+func (h *Handler) AdminStartApplicationTransfer(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	transfer, err := h.admin.TransferApplication(c.Request().Context(), currentUser, c.Param("applicationId"), c.FormValue("recipientEmail"))
+	if err != nil {
+		return redirectAfterAdminApplicationError(c, err)
+	}
+
+	return redirectToAdminApplications(c, "Transfer offered to "+transfer.RecipientEmail, "")
+}
+
+func (h *Handler) AdminCancelApplicationTransfer(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	if err := h.admin.CancelTransfer(c.Request().Context(), currentUser, c.Param("transferId")); err != nil {
+		return redirectAfterAdminApplicationError(c, err)
+	}
+
+	return redirectToAdminApplications(c, "Transfer cancelled", "")
+}
+
+func (h *Handler) AdminBrowsers(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	runningBrowsers, err := h.admin.ListRunningBrowsers(c.Request().Context(), currentUser)
+	if err != nil {
+		return renderAdminError(c, err)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	return pages.AdminBrowsers(currentUser, runningBrowsers, strings.TrimSpace(c.QueryParam("success")), strings.TrimSpace(c.QueryParam("error"))).Render(c.Request().Context(), c.Response().Writer)
+}
+
+func (h *Handler) AdminCloseBrowser(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	if err := h.admin.CloseBrowser(c.Request().Context(), currentUser, c.Param("applicationId"), c.Param("browserId")); err != nil {
+		if errors.Is(err, admin.ErrForbidden) {
+			return renderAdminError(c, err)
+		}
+		return c.Redirect(http.StatusSeeOther, "/admin/browsers?"+url.Values{"error": {err.Error()}}.Encode())
+	}
+
+	return c.Redirect(http.StatusSeeOther, "/admin/browsers?"+url.Values{"success": {"Browser closed"}}.Encode())
+}
+
+func renderAdminError(c echo.Context, err error) error {
+	if errors.Is(err, admin.ErrForbidden) {
+		return renderError(c, http.StatusForbidden, "Admins only", "You need the admin role to use the admin console.")
+	}
+
+	return err
+}
+
+func redirectAfterAdminUserError(c echo.Context, err error) error {
+	if errors.Is(err, admin.ErrForbidden) {
+		return renderAdminError(c, err)
+	}
+
+	return redirectToAdminUsers(c, "", err.Error())
+}
+
+// redirectToAdminUsers returns to the search the form was posted from; the forms carry the
+// query and page as hidden fields.
+func redirectToAdminUsers(c echo.Context, successMessage string, errorMessage string) error {
+	query := make(url.Values)
+	if searchQuery := strings.TrimSpace(c.FormValue("q")); searchQuery != "" {
+		query.Set("q", searchQuery)
+	}
+	if page, err := strconv.Atoi(c.FormValue("page")); err == nil && page > 1 {
+		query.Set("page", strconv.Itoa(page))
+	}
+	if successMessage != "" {
+		query.Set("success", successMessage)
+	}
+	if errorMessage != "" {
+		query.Set("error", errorMessage)
+	}
+
+	path := "/admin/users"
+	if encodedQuery := query.Encode(); encodedQuery != "" {
+		path = fmt.Sprintf("%s?%s", path, encodedQuery)
+	}
+
+	return c.Redirect(http.StatusSeeOther, path)
+}
+
+func redirectAfterAdminApplicationError(c echo.Context, err error) error {
+	if errors.Is(err, admin.ErrForbidden) {
+		return renderAdminError(c, err)
+	}
+
+	return redirectToAdminApplications(c, "", err.Error())
+}
+
+// redirectToAdminApplications returns to the page the form was posted from.
+func redirectToAdminApplications(c echo.Context, successMessage string, errorMessage string) error {
+	query := make(url.Values)
+	if page, err := strconv.Atoi(c.FormValue("page")); err == nil && page > 1 {
+		query.Set("page", strconv.Itoa(page))
+	}
+	if successMessage != "" {
+		query.Set("success", successMessage)
+	}
+	if errorMessage != "" {
+		query.Set("error", errorMessage)
+	}
+
+	path := "/admin/applications"
+	if encodedQuery := query.Encode(); encodedQuery != "" {
+		path = fmt.Sprintf("%s?%s", path, encodedQuery)
+	}
+
+	return c.Redirect(http.StatusSeeOther, path)
+}
+
+func pageParam(c echo.Context) int {
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil || page < 1 {
+		return 1
+	}
+
+	return page
+}
@@ -0,0 +1,106 @@
+package uihandlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/auditlog"
+	"github.com/brian-nunez/bbaas-api/views/pages"
+	"github.com/labstack/echo/v4"
+)
+
+// auditDateLayout is the format of the since and until filters, as sent by date inputs.
+const auditDateLayout = "2006-01-02"
+
+func (h *Handler) AuditLog(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	query, err := auditQueryFromRequest(c)
+	if err != nil {
+		return renderError(c, http.StatusBadRequest, "Invalid filter", err.Error())
+	}
+
+	result, err := h.auditLog.Search(c.Request().Context(), currentUser, query)
+	if err != nil {
+		return renderAuditError(c, err)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	return pages.AuditLog(currentUser, result, c.QueryParams()).Render(c.Request().Context(), c.Response().Writer)
+}
+
+// ExportAuditLog streams the filtered trail as JSON Lines, one event per line, oldest first.
+func (h *Handler) ExportAuditLog(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	query, err := auditQueryFromRequest(c)
+	if err != nil {
+		return renderError(c, http.StatusBadRequest, "Invalid filter", err.Error())
+	}
+
+	response := c.Response()
+	response.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+	response.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="audit-%s.jsonl"`, time.Now().UTC().Format("20060102")))
+
+	exported, err := h.auditLog.Export(c.Request().Context(), currentUser, query, response)
+	if err != nil {
+		if !response.Committed {
+			response.Header().Del(echo.HeaderContentDisposition)
+			return renderAuditError(c, err)
+		}
+		// The download has started; all that is left is to cut it short.
+		c.Logger().Errorf("export audit log for user %s after %d events: %v", currentUser.ID, exported, err)
+		return nil
+	}
+	if !response.Committed {
+		response.WriteHeader(http.StatusOK)
+	}
+
+	return nil
+}
+
+func auditQueryFromRequest(c echo.Context) (auditlog.Query, error) {
+	query := auditlog.Query{
+		OrganizationID: strings.TrimSpace(c.QueryParam("organization")),
+		ActorEmail:     strings.TrimSpace(c.QueryParam("actor")),
+		Action:         strings.TrimSpace(c.QueryParam("action")),
+		TargetID:       strings.TrimSpace(c.QueryParam("target")),
+		Page:           pageParam(c),
+	}
+
+	if since := strings.TrimSpace(c.QueryParam("since")); since != "" {
+		day, err := time.Parse(auditDateLayout, since)
+		if err != nil {
+			return auditlog.Query{}, errors.New("the from date must look like 2006-01-02")
+		}
+		query.Since = &day
+	}
+	if until := strings.TrimSpace(c.QueryParam("until")); until != "" {
+		day, err := time.Parse(auditDateLayout, until)
+		if err != nil {
+			return auditlog.Query{}, errors.New("the to date must look like 2006-01-02")
+		}
+		// The to date is inclusive, so stop at the start of the next day.
+		nextDay := day.AddDate(0, 0, 1)
+		query.Until = &nextDay
+	}
+
+	return query, nil
+}
+
+func renderAuditError(c echo.Context, err error) error {
+	if errors.Is(err, auditlog.ErrForbidden) {
+		return renderError(c, http.StatusForbidden, "Not allowed", "Only site admins and organization owners and admins can read the audit log.")
+	}
+
+	return err
+}
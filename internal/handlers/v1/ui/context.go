@@ -2,7 +2,10 @@ package uihandlers
 
 import "github.com/brian-nunez/bbaas-api/internal/users"
 
-const currentUserContextKey = "current_user"
+const (
+	currentUserContextKey      = "current_user"
+	currentSessionIDContextKey = "current_session_id"
+)
 
 func setCurrentUser(c contextSetter, user users.User) {
 	c.Set(currentUserContextKey, user)
@@ -14,6 +17,15 @@ func getCurrentUser(c contextGetter) (users.User, bool) {
 	return user, ok
 }
 
+func setCurrentSessionID(c contextSetter, sessionID string) {
+	c.Set(currentSessionIDContextKey, sessionID)
+}
+
+func getCurrentSessionID(c contextGetter) string {
+	sessionID, _ := c.Get(currentSessionIDContextKey).(string)
+	return sessionID
+}
+
 type contextSetter interface {
 	Set(key string, value interface{})
 }
@@ -0,0 +1,46 @@
+package uihandlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/brian-nunez/bbaas-api/internal/users"
+	"github.com/labstack/echo/v4"
+)
+
+func (h *Handler) VerifyEmail(c echo.Context) error {
+	err := h.emailVerifier.Verify(c.Request().Context(), c.QueryParam("token"))
+	if err != nil && !errors.Is(err, users.ErrInvalidVerificationToken) {
+		return err
+	}
+
+	if _, ok := getCurrentUser(c); ok {
+		if err != nil {
+			return redirectToDashboard(c, "", err.Error(), "")
+		}
+		return redirectToDashboard(c, "Email address verified", "", "")
+	}
+
+	if err != nil {
+		return renderError(c, http.StatusBadRequest, "Verification failed", err.Error()+". Log in to send a new link.")
+	}
+	return c.Redirect(http.StatusSeeOther, "/login?success=Email+address+verified.+Log+in+to+continue.")
+}
+
+func (h *Handler) ResendVerificationEmail(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	err := h.emailVerifier.SendVerification(c.Request().Context(), currentUser)
+	switch {
+	case errors.Is(err, users.ErrEmailAlreadyVerified), errors.Is(err, users.ErrVerificationRecentlySent):
+		return redirectToDashboard(c, "", err.Error(), "")
+	case err != nil:
+		c.Logger().Errorf("resend verification email to user %s: %v", currentUser.ID, err)
+		return redirectToDashboard(c, "", "We could not send your verification email. Try again later.", "")
+	}
+
+	return redirectToDashboard(c, "Verification email sent to "+currentUser.Email, "", "")
+}
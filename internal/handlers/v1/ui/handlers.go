@@ -1,14 +1,23 @@
 package uihandlers
 
 import (
-	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/brian-nunez/bbaas-api/internal/accounts"
+	"github.com/brian-nunez/bbaas-api/internal/admin"
 	"github.com/brian-nunez/bbaas-api/internal/applications"
+	"github.com/brian-nunez/bbaas-api/internal/audit"
+	"github.com/brian-nunez/bbaas-api/internal/auditlog"
 	"github.com/brian-nunez/bbaas-api/internal/dashboard"
+	"github.com/brian-nunez/bbaas-api/internal/lockout"
+	"github.com/brian-nunez/bbaas-api/internal/organizations"
+	"github.com/brian-nunez/bbaas-api/internal/sso"
 	"github.com/brian-nunez/bbaas-api/internal/users"
 	"github.com/brian-nunez/bbaas-api/views/pages"
 	"github.com/labstack/echo/v4"
@@ -17,14 +26,40 @@ import (
 type Handler struct {
 	usersService        *users.Service
 	applicationsService *applications.Service
+	organizations       *organizations.Service
+	invitations         *organizations.Invitations
+	admin               *admin.Service
+	audit               *audit.Recorder
+	auditLog            *auditlog.Service
 	dashboardService    *dashboard.Service
+	passwordResetter    *users.PasswordResetter
+	emailVerifier       *users.EmailVerifier
+	emailChanger        *users.EmailChanger
+	accounts            *accounts.Service
+	twoFactor           *users.TwoFactor
+	singleSignOn        *sso.Service
+	lockout             *lockout.Guard
+	cookieSecurity      CookieSecurity
 }
 
-func NewHandler(usersService *users.Service, applicationsService *applications.Service, dashboardService *dashboard.Service) *Handler {
+func NewHandler(usersService *users.Service, applicationsService *applications.Service, organizationsService *organizations.Service, invitations *organizations.Invitations, adminService *admin.Service, auditRecorder *audit.Recorder, auditLog *auditlog.Service, dashboardService *dashboard.Service, passwordResetter *users.PasswordResetter, emailVerifier *users.EmailVerifier, emailChanger *users.EmailChanger, accountsService *accounts.Service, twoFactor *users.TwoFactor, singleSignOn *sso.Service, lockoutGuard *lockout.Guard, cookieSecurity CookieSecurity) *Handler {
 	return &Handler{
 		usersService:        usersService,
 		applicationsService: applicationsService,
+		organizations:       organizationsService,
+		invitations:         invitations,
+		admin:               adminService,
+		audit:               auditRecorder,
+		auditLog:            auditLog,
 		dashboardService:    dashboardService,
+		passwordResetter:    passwordResetter,
+		emailVerifier:       emailVerifier,
+		emailChanger:        emailChanger,
+		accounts:            accountsService,
+		twoFactor:           twoFactor,
+		singleSignOn:        singleSignOn,
+		lockout:             lockoutGuard,
+		cookieSecurity:      cookieSecurity,
 	}
 }
 
@@ -37,41 +72,52 @@ func (h *Handler) Home(c echo.Context) error {
 }
 
 func (h *Handler) ShowRegister(c echo.Context) error {
-	return renderAuth(c, "Register", "Create your account", "Use email + password to get started.", "/register", "Create account", "Already have an account?", "/login", "", "")
+	return h.renderAuth(c, "Register", "Create your account", "Use email + password to get started.", "/register", "Create account", "Already have an account?", "/login", "", "", strings.TrimSpace(c.QueryParam("email")))
 }
 
 func (h *Handler) Register(c echo.Context) error {
 	email := strings.TrimSpace(c.FormValue("email"))
 	password := c.FormValue("password")
 
-	_, err := h.usersService.Register(c.Request().Context(), email, password)
+	registered, err := h.usersService.Register(c.Request().Context(), email, password)
 	if err != nil {
-		return renderAuth(c, "Register", "Create your account", "Use email + password to get started.", "/register", "Create account", "Already have an account?", "/login", err.Error(), email)
+		return h.renderAuth(c, "Register", "Create your account", "Use email + password to get started.", "/register", "Create account", "Already have an account?", "/login", "", err.Error(), email)
 	}
 
-	_, sessionToken, err := h.usersService.Login(c.Request().Context(), email, password)
+	_, session, err := h.usersService.Login(c.Request().Context(), email, password, requestClient(c))
 	if err != nil {
-		return renderAuth(c, "Register", "Create your account", "Use email + password to get started.", "/register", "Create account", "Already have an account?", "/login", err.Error(), email)
+		return h.renderAuth(c, "Register", "Create your account", "Use email + password to get started.", "/register", "Create account", "Already have an account?", "/login", "", err.Error(), email)
 	}
 
-	setSessionCookie(c, sessionToken)
-	return c.Redirect(http.StatusSeeOther, "/dashboard?success=Account+created")
+	setSessionCookie(c, h.cookieSecurity, session.Token, session.ExpiresAt)
+	if registered.IsVerified() {
+		return c.Redirect(http.StatusSeeOther, "/dashboard?success=Account+created")
+	}
+
+	if err := h.emailVerifier.SendVerification(c.Request().Context(), registered); err != nil {
+		c.Logger().Errorf("send verification email to new user %s: %v", registered.ID, err)
+		return redirectToDashboard(c, "Account created", "We could not send your verification email. Use the resend button to try again.", "")
+	}
+	return redirectToDashboard(c, "Account created. Check your email to verify your address.", "", "")
 }
 
 func (h *Handler) ShowLogin(c echo.Context) error {
-	return renderAuth(c, "Login", "Welcome back", "Login with your account credentials.", "/login", "Login", "Need an account?", "/register", "", "")
+	return h.renderAuth(c, "Login", "Welcome back", "Login with your account credentials.", "/login", "Login", "Need an account?", "/register", strings.TrimSpace(c.QueryParam("success")), "", "")
 }
 
 func (h *Handler) Login(c echo.Context) error {
 	email := strings.TrimSpace(c.FormValue("email"))
 	password := c.FormValue("password")
 
-	_, sessionToken, err := h.usersService.Login(c.Request().Context(), email, password)
+	_, session, err := h.usersService.Login(c.Request().Context(), email, password, requestClient(c))
+	if redirected, redirectErr := h.redirectToSecondFactor(c, err); redirected {
+		return redirectErr
+	}
 	if err != nil {
-		return renderAuth(c, "Login", "Welcome back", "Login with your account credentials.", "/login", "Login", "Need an account?", "/register", err.Error(), email)
+		return h.renderAuth(c, "Login", "Welcome back", "Login with your account credentials.", "/login", "Login", "Need an account?", "/register", "", err.Error(), email)
 	}
 
-	setSessionCookie(c, sessionToken)
+	setSessionCookie(c, h.cookieSecurity, session.Token, session.ExpiresAt)
 	return c.Redirect(http.StatusSeeOther, "/dashboard")
 }
 
@@ -80,7 +126,7 @@ func (h *Handler) Logout(c echo.Context) error {
 	if err == nil && sessionCookie != nil {
 		_ = h.usersService.Logout(c.Request().Context(), sessionCookie.Value)
 	}
-	clearSessionCookie(c)
+	clearSessionCookie(c, h.cookieSecurity)
 
 	return c.Redirect(http.StatusSeeOther, "/login")
 }
@@ -90,6 +136,9 @@ func (h *Handler) Dashboard(c echo.Context) error {
 	if !ok {
 		return c.Redirect(http.StatusSeeOther, "/login")
 	}
+	if redirected, err := h.resumePendingInvitation(c); redirected {
+		return err
+	}
 
 	viewData, err := h.dashboardService.BuildViewData(c.Request().Context(), currentUser)
 	if err != nil {
@@ -101,7 +150,7 @@ func (h *Handler) Dashboard(c echo.Context) error {
 	newAPIKey := strings.TrimSpace(c.QueryParam("new_key"))
 
 	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
-	return pages.Dashboard(viewData, successMessage, errorMessage, newAPIKey).Render(context.Background(), c.Response().Writer)
+	return pages.Dashboard(viewData, successMessage, errorMessage, newAPIKey).Render(c.Request().Context(), c.Response().Writer)
 }
 
 func (h *Handler) CreateApplication(c echo.Context) error {
@@ -111,6 +160,27 @@ func (h *Handler) CreateApplication(c echo.Context) error {
 	}
 
 	_, err := h.applicationsService.RegisterApplication(c.Request().Context(), currentUser, applications.RegisterApplicationInput{
+		OrganizationID: c.FormValue("organizationId"),
+		Name:           c.FormValue("name"),
+		Description:    c.FormValue("description"),
+		GitHubLink:     c.FormValue("githubLink"),
+		Domain:         c.FormValue("domain"),
+	})
+	if err != nil {
+		return redirectToDashboard(c, "", err.Error(), "")
+	}
+
+	return redirectToDashboard(c, "Application created", "", "")
+}
+
+func (h *Handler) UpdateApplication(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	applicationID := c.Param("applicationId")
+	_, err := h.applicationsService.UpdateApplication(c.Request().Context(), currentUser, applicationID, applications.UpdateApplicationInput{
 		Name:        c.FormValue("name"),
 		Description: c.FormValue("description"),
 		GitHubLink:  c.FormValue("githubLink"),
@@ -120,7 +190,50 @@ func (h *Handler) CreateApplication(c echo.Context) error {
 		return redirectToDashboard(c, "", err.Error(), "")
 	}
 
-	return redirectToDashboard(c, "Application created", "", "")
+	return redirectToDashboard(c, "Application updated", "", "")
+}
+
+func (h *Handler) ArchiveApplication(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	application, err := h.applicationsService.ArchiveApplication(c.Request().Context(), currentUser, c.Param("applicationId"))
+	if err != nil {
+		return redirectToDashboard(c, "", err.Error(), "")
+	}
+
+	return redirectToDashboard(c, application.Name+" archived", "", "")
+}
+
+func (h *Handler) RestoreApplication(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	application, err := h.applicationsService.RestoreApplication(c.Request().Context(), currentUser, c.Param("applicationId"))
+	if err != nil {
+		return redirectToDashboard(c, "", err.Error(), "")
+	}
+
+	return redirectToDashboard(c, application.Name+" restored", "", "")
+}
+
+func (h *Handler) DeleteApplication(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	applicationID := c.Param("applicationId")
+	if err := h.applicationsService.DeleteApplication(c.Request().Context(), currentUser, applicationID, c.FormValue("confirmName")); err != nil {
+		return redirectToDashboard(c, "", err.Error(), "")
+	}
+
+	c.Logger().Infof("user %s deleted application %s", currentUser.ID, applicationID)
+	return redirectToDashboard(c, "Application deleted", "", "")
 }
 
 func (h *Handler) CreateAPIKey(c echo.Context) error {
@@ -129,14 +242,27 @@ func (h *Handler) CreateAPIKey(c echo.Context) error {
 		return c.Redirect(http.StatusSeeOther, "/login")
 	}
 
+	expiresAt, err := parseAPIKeyExpiry(c.FormValue("expiresIn"), c.FormValue("expiresOn"), time.Now().UTC())
+	if err != nil {
+		return redirectToDashboard(c, "", err.Error(), "")
+	}
+
+	formParams, err := c.FormParams()
+	if err != nil {
+		return redirectToDashboard(c, "", "Invalid form submission", "")
+	}
+
+	rateLimitPerMinute, err := parseRateLimit(c.FormValue("rateLimitPerMinute"))
+	if err != nil {
+		return redirectToDashboard(c, "", err.Error(), "")
+	}
+
 	applicationID := c.Param("applicationId")
 	createdKey, err := h.applicationsService.CreateAPIKey(c.Request().Context(), currentUser, applicationID, applications.CreateAPIKeyInput{
-		Name: c.FormValue("name"),
-		Permissions: applications.APIKeyPermissions{
-			CanRead:   c.FormValue("canRead") != "",
-			CanWrite:  c.FormValue("canWrite") != "",
-			CanDelete: c.FormValue("canDelete") != "",
-		},
+		Name:               c.FormValue("name"),
+		Scopes:             formParams["scopes"],
+		ExpiresAt:          expiresAt,
+		RateLimitPerMinute: rateLimitPerMinute,
 	})
 	if err != nil {
 		return redirectToDashboard(c, "", err.Error(), "")
@@ -145,6 +271,27 @@ func (h *Handler) CreateAPIKey(c echo.Context) error {
 	return redirectToDashboard(c, fmt.Sprintf("API key %q created", createdKey.APIKey.Name), "", createdKey.Token)
 }
 
+func (h *Handler) RotateAPIKey(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	graceSeconds, err := strconv.Atoi(strings.TrimSpace(c.FormValue("graceSeconds")))
+	if err != nil {
+		return redirectToDashboard(c, "", applications.ErrInvalidGracePeriod.Error(), "")
+	}
+
+	applicationID := c.Param("applicationId")
+	keyID := c.Param("keyId")
+	rotated, err := h.applicationsService.RotateAPIKey(c.Request().Context(), currentUser, applicationID, keyID, time.Duration(graceSeconds)*time.Second)
+	if err != nil {
+		return redirectToDashboard(c, "", err.Error(), "")
+	}
+
+	return redirectToDashboard(c, fmt.Sprintf("API key %q rotated", rotated.APIKey.Name), "", rotated.Token)
+}
+
 func (h *Handler) RevokeAPIKey(c echo.Context) error {
 	currentUser, ok := getCurrentUser(c)
 	if !ok {
@@ -161,9 +308,158 @@ func (h *Handler) RevokeAPIKey(c echo.Context) error {
 	return redirectToDashboard(c, "API key revoked", "", "")
 }
 
-func renderAuth(c echo.Context, pageTitle string, heading string, subtitle string, action string, submitLabel string, secondaryLabel string, secondaryURL string, errorMessage string, email string) error {
+func (h *Handler) UpdateAPIKeyPolicy(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	maxLifetimeDays := 0
+	if rawValue := strings.TrimSpace(c.FormValue("maxKeyLifetimeDays")); rawValue != "" {
+		parsed, err := strconv.Atoi(rawValue)
+		if err != nil {
+			return redirectToDashboard(c, "", applications.ErrInvalidKeyLifetimePolicy.Error(), "")
+		}
+		maxLifetimeDays = parsed
+	}
+
+	applicationID := c.Param("applicationId")
+	_, err := h.applicationsService.UpdateAPIKeyPolicy(c.Request().Context(), currentUser, applicationID, maxLifetimeDays)
+	if err != nil {
+		return redirectToDashboard(c, "", err.Error(), "")
+	}
+
+	return redirectToDashboard(c, "API key policy updated", "", "")
+}
+
+func (h *Handler) UpdateRateLimit(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	rateLimitPerMinute, err := parseRateLimit(c.FormValue("rateLimitPerMinute"))
+	if err != nil {
+		return redirectToDashboard(c, "", err.Error(), "")
+	}
+
+	applicationID := c.Param("applicationId")
+	if _, err := h.applicationsService.UpdateRateLimit(c.Request().Context(), currentUser, applicationID, rateLimitPerMinute); err != nil {
+		return redirectToDashboard(c, "", err.Error(), "")
+	}
+
+	return redirectToDashboard(c, "Rate limit updated", "", "")
+}
+
+func (h *Handler) UpdateApplicationAllowedIPs(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	applicationID := c.Param("applicationId")
+	_, err := h.applicationsService.UpdateApplicationAllowedCIDRs(c.Request().Context(), currentUser, applicationID, c.FormValue("allowedCidrs"))
+	if err != nil {
+		return redirectToDashboard(c, "", err.Error(), "")
+	}
+
+	return redirectToDashboard(c, "Allowed IP ranges updated", "", "")
+}
+
+func (h *Handler) UpdateGitHubOIDCTrust(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	applicationID := c.Param("applicationId")
+	trust, err := h.applicationsService.UpdateGitHubOIDCTrust(c.Request().Context(), currentUser, applicationID, applications.GitHubOIDCTrustInput{
+		APIKeyID:           c.FormValue("apiKeyId"),
+		AllowedRef:         c.FormValue("allowedRef"),
+		AllowedEnvironment: c.FormValue("allowedEnvironment"),
+	})
+	if err != nil {
+		return redirectToDashboard(c, "", err.Error(), "")
+	}
+
+	if trust.APIKeyID == "" {
+		return redirectToDashboard(c, "GitHub Actions authentication disabled", "", "")
+	}
+	return redirectToDashboard(c, "GitHub Actions authentication updated", "", "")
+}
+
+func (h *Handler) UpdateAPIKeyAllowedIPs(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	applicationID := c.Param("applicationId")
+	keyID := c.Param("keyId")
+	_, err := h.applicationsService.UpdateAPIKeyAllowedCIDRs(c.Request().Context(), currentUser, applicationID, keyID, c.FormValue("allowedCidrs"))
+	if err != nil {
+		return redirectToDashboard(c, "", err.Error(), "")
+	}
+
+	return redirectToDashboard(c, "API key IP ranges updated", "", "")
+}
+
+// parseRateLimit reads an optional requests-per-minute form value; empty means zero.
+func parseRateLimit(rawValue string) (int, error) {
+	rawValue = strings.TrimSpace(rawValue)
+	if rawValue == "" {
+		return 0, nil
+	}
+
+	rateLimitPerMinute, err := strconv.Atoi(rawValue)
+	if err != nil {
+		return 0, applications.ErrInvalidRateLimit
+	}
+
+	return rateLimitPerMinute, nil
+}
+
+// parseAPIKeyExpiry maps the dashboard expiry picker to an expiry time. A custom date
+// keeps the key valid through the end of that day (UTC).
+func parseAPIKeyExpiry(choice string, customDate string, now time.Time) (*time.Time, error) {
+	switch strings.TrimSpace(choice) {
+	case "", "never":
+		return nil, nil
+	case "30", "90", "365":
+		days, _ := strconv.Atoi(choice)
+		expiresAt := now.Add(time.Duration(days) * 24 * time.Hour)
+		return &expiresAt, nil
+	case "custom":
+		date, err := time.Parse("2006-01-02", strings.TrimSpace(customDate))
+		if err != nil {
+			return nil, errors.New("custom expiry date must be a valid date")
+		}
+		expiresAt := date.UTC().Add(24 * time.Hour)
+		return &expiresAt, nil
+	default:
+		return nil, errors.New("unknown API key expiry option")
+	}
+}
+
+func (h *Handler) renderAuth(c echo.Context, pageTitle string, heading string, subtitle string, action string, submitLabel string, secondaryLabel string, secondaryURL string, notice string, errorMessage string, email string) error {
+	options := pages.AuthOptions{
+		PasswordLogin: h.usersService.PasswordLoginAllowed(),
+		SingleSignOn:  h.singleSignOn != nil,
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	return pages.AuthPage(pageTitle, heading, subtitle, action, submitLabel, secondaryLabel, secondaryURL, notice, errorMessage, email, options).Render(c.Request().Context(), c.Response().Writer)
+}
+
+func renderError(c echo.Context, statusCode int, title string, message string) error {
+	backHref := "/dashboard"
+	if _, ok := getCurrentUser(c); !ok {
+		backHref = "/login"
+	}
+
 	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
-	return pages.AuthPage(pageTitle, heading, subtitle, action, submitLabel, secondaryLabel, secondaryURL, errorMessage, email).Render(context.Background(), c.Response().Writer)
+	c.Response().WriteHeader(statusCode)
+	return pages.ErrorPage(title, message, backHref).Render(c.Request().Context(), c.Response().Writer)
 }
 
 func redirectToDashboard(c echo.Context, successMessage string, errorMessage string, newAPIKey string) error {
@@ -0,0 +1,152 @@
+package uihandlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/organizations"
+	"github.com/brian-nunez/bbaas-api/internal/users"
+	"github.com/brian-nunez/bbaas-api/views/pages"
+	"github.com/labstack/echo/v4"
+)
+
+// invitationCookieName remembers an invitation opened while logged out, so it can be picked
+// up again once the invitee has logged in or registered.
+const invitationCookieName = "bbaas_invitation"
+
+func (h *Handler) InviteOrganizationMember(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	organizationID := c.Param("organizationId")
+	invitation, err := h.invitations.Invite(c.Request().Context(), currentUser, organizationID, c.FormValue("email"), c.FormValue("role"))
+	if err != nil {
+		return h.redirectAfterInvitationError(c, organizationID, err)
+	}
+
+	return redirectToOrganization(c, organizationID, "Invitation sent to "+invitation.Email, "")
+}
+
+func (h *Handler) ResendInvitation(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	organizationID := c.Param("organizationId")
+	invitation, err := h.invitations.Resend(c.Request().Context(), currentUser, organizationID, c.Param("invitationId"))
+	if err != nil {
+		return h.redirectAfterInvitationError(c, organizationID, err)
+	}
+
+	return redirectToOrganization(c, organizationID, "Invitation resent to "+invitation.Email, "")
+}
+
+func (h *Handler) RevokeInvitation(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	organizationID := c.Param("organizationId")
+	if err := h.invitations.Revoke(c.Request().Context(), currentUser, organizationID, c.Param("invitationId")); err != nil {
+		return h.redirectAfterInvitationError(c, organizationID, err)
+	}
+
+	return redirectToOrganization(c, organizationID, "Invitation revoked", "")
+}
+
+// ShowInvitation is where emailed invitation links land. Guests are asked to log in or register
+// first; the invitation is remembered in a cookie until they do.
+func (h *Handler) ShowInvitation(c echo.Context) error {
+	invitationToken := c.QueryParam("token")
+	details, err := h.invitations.Lookup(c.Request().Context(), invitationToken)
+	if errors.Is(err, organizations.ErrInvalidInvitation) {
+		h.setInvitationCookie(c, "", time.Time{})
+		return renderError(c, http.StatusNotFound, "Invitation unavailable", err.Error()+". Ask whoever invited you to send a new one.")
+	}
+	if err != nil {
+		return err
+	}
+
+	currentUser, signedIn := getCurrentUser(c)
+	if signedIn {
+		h.setInvitationCookie(c, "", time.Time{})
+	} else {
+		h.setInvitationCookie(c, invitationToken, details.Invitation.ExpiresAt)
+	}
+
+	return renderInvitation(c, details, currentUser, signedIn, invitationToken, "")
+}
+
+func (h *Handler) AcceptInvitation(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	invitationToken := c.FormValue("token")
+	membership, err := h.invitations.Accept(c.Request().Context(), currentUser, invitationToken)
+	switch {
+	case errors.Is(err, organizations.ErrInvalidInvitation):
+		return redirectToDashboard(c, "", err.Error(), "")
+	case errors.Is(err, organizations.ErrInvitationEmailMismatch):
+		details, lookupErr := h.invitations.Lookup(c.Request().Context(), invitationToken)
+		if lookupErr != nil {
+			return redirectToDashboard(c, "", err.Error(), "")
+		}
+		return renderInvitation(c, details, currentUser, true, invitationToken, err.Error())
+	case err != nil:
+		return err
+	}
+
+	return redirectToOrganization(c, membership.Organization.ID, "Welcome to "+membership.Organization.Name, "")
+}
+
+// resumePendingInvitation sends a user who just logged in or registered back to the invitation
+// they opened as a guest.
+func (h *Handler) resumePendingInvitation(c echo.Context) (bool, error) {
+	pendingCookie, err := c.Cookie(invitationCookieName)
+	if err != nil || pendingCookie.Value == "" {
+		return false, nil
+	}
+
+	h.setInvitationCookie(c, "", time.Time{})
+	return true, c.Redirect(http.StatusSeeOther, "/invitations/accept?token="+url.QueryEscape(pendingCookie.Value))
+}
+
+func (h *Handler) redirectAfterInvitationError(c echo.Context, organizationID string, err error) error {
+	if errors.Is(err, organizations.ErrOrganizationNotFound) || errors.Is(err, organizations.ErrForbidden) {
+		return renderOrganizationError(c, err)
+	}
+
+	return redirectToOrganization(c, organizationID, "", err.Error())
+}
+
+// setInvitationCookie remembers an invitation token until it expires; an empty token clears it.
+func (h *Handler) setInvitationCookie(c echo.Context, invitationToken string, expiresAt time.Time) {
+	maxAge := -1
+	if invitationToken != "" {
+		maxAge = int(time.Until(expiresAt).Seconds())
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     invitationCookieName,
+		Value:    invitationToken,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   h.cookieSecurity.secure(c),
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   maxAge,
+	})
+}
+
+func renderInvitation(c echo.Context, details organizations.InvitationDetails, currentUser users.User, signedIn bool, invitationToken string, errorMessage string) error {
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return pages.Invitation(details, currentUser, signedIn, invitationToken, errorMessage).Render(c.Request().Context(), c.Response().Writer)
+}
@@ -0,0 +1,41 @@
+package uihandlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/brian-nunez/bbaas-api/internal/audit"
+	"github.com/brian-nunez/bbaas-api/internal/lockout"
+	"github.com/labstack/echo/v4"
+)
+
+func (h *Handler) ClearLockout(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+	if !currentUser.IsAdmin() {
+		return renderError(c, http.StatusForbidden, "Admins only", "Only admins can clear lockouts.")
+	}
+
+	key := lockout.Key{
+		Scope:   lockout.Scope(strings.TrimSpace(c.FormValue("scope"))),
+		Subject: strings.TrimSpace(c.FormValue("subject")),
+	}
+	cleared, err := h.lockout.Clear(c.Request().Context(), key)
+	if err != nil {
+		return redirectToDashboard(c, "", err.Error(), "")
+	}
+	if !cleared {
+		return redirectToDashboard(c, "", "Lockout not found", "")
+	}
+
+	c.Logger().Infof("admin %s cleared %s lockout for %s", currentUser.ID, key.Scope, key.Subject)
+	h.audit.Record(c.Request().Context(), audit.Event{
+		ActorUserID: currentUser.ID,
+		Action:      "lockout.cleared",
+		TargetType:  "lockout",
+		TargetID:    string(key.Scope) + ":" + key.Subject,
+	})
+	return redirectToDashboard(c, "Lockout cleared for "+key.Subject, "", "")
+}
@@ -2,27 +2,41 @@ package uihandlers
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/brian-nunez/bbaas-api/internal/audit"
 	"github.com/brian-nunez/bbaas-api/internal/users"
+	"github.com/brian-nunez/bbaas-api/views/pages"
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 )
 
-const sessionCookieName = "bbaas_session"
+const (
+	sessionCookieName = "bbaas_session"
+	csrfCookieName    = "bbaas_csrf"
+	csrfContextKey    = "csrf"
+)
 
-func SessionMiddleware(usersService *users.Service) echo.MiddlewareFunc {
+func SessionMiddleware(usersService *users.Service, cookieSecurity CookieSecurity) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			sessionCookie, err := c.Cookie(sessionCookieName)
 			if err == nil && sessionCookie != nil && sessionCookie.Value != "" {
-				user, authenticated, authErr := usersService.AuthenticateSession(c.Request().Context(), sessionCookie.Value)
+				session, authenticated, authErr := usersService.AuthenticateSession(c.Request().Context(), sessionCookie.Value, requestClient(c))
 				if authErr != nil && !errors.Is(authErr, users.ErrSessionExpired) {
 					return authErr
 				}
 				if authenticated {
-					setCurrentUser(c, user)
+					setCurrentUser(c, session.User)
+					setCurrentSessionID(c, session.SessionID)
+					if session.RotatedToken != "" {
+						setSessionCookie(c, cookieSecurity, session.RotatedToken, session.ExpiresAt)
+					}
 				} else {
-					clearSessionCookie(c)
+					clearSessionCookie(c, cookieSecurity)
 				}
 			}
 
@@ -31,6 +45,59 @@ func SessionMiddleware(usersService *users.Service) echo.MiddlewareFunc {
 	}
 }
 
+// CSRFMiddleware protects the web UI's form posts with a double-submit token: the token is
+// set in a cookie and must be echoed back in the csrf_token form field (or the X-CSRF-Token
+// header). The API is skipped because it authenticates with bearer tokens, not cookies.
+func CSRFMiddleware(cookieSecurity CookieSecurity) echo.MiddlewareFunc {
+	newCSRF := func(secure bool) echo.MiddlewareFunc {
+		return middleware.CSRFWithConfig(middleware.CSRFConfig{
+			Skipper:        isAPIRequest,
+			TokenLookup:    "form:" + pages.CSRFFieldName + ",header:" + echo.HeaderXCSRFToken,
+			ContextKey:     csrfContextKey,
+			CookieName:     csrfCookieName,
+			CookiePath:     "/",
+			CookieSecure:   secure,
+			CookieHTTPOnly: true,
+			CookieSameSite: http.SameSiteLaxMode,
+			ErrorHandler: func(err error, c echo.Context) error {
+				c.Logger().Warnf("rejected %s %s: %v", c.Request().Method, c.Request().URL.Path, err)
+				return renderError(
+					c,
+					http.StatusForbidden,
+					"Your session form has expired",
+					"We could not verify that this request came from this site. Go back, reload the page and try again.",
+				)
+			},
+		})
+	}
+	secureCSRF := newCSRF(true)
+	plainCSRF := newCSRF(false)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		exposeToken := func(c echo.Context) error {
+			if token, ok := c.Get(csrfContextKey).(string); ok {
+				request := c.Request()
+				c.SetRequest(request.WithContext(pages.WithCSRFToken(request.Context(), token)))
+			}
+
+			return next(c)
+		}
+		secureNext := secureCSRF(exposeToken)
+		plainNext := plainCSRF(exposeToken)
+
+		return func(c echo.Context) error {
+			if cookieSecurity.secure(c) {
+				return secureNext(c)
+			}
+			return plainNext(c)
+		}
+	}
+}
+
+func isAPIRequest(c echo.Context) bool {
+	return strings.HasPrefix(c.Request().URL.Path, "/api/")
+}
+
 func RequireAuth(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		if _, ok := getCurrentUser(c); !ok {
@@ -41,6 +108,21 @@ func RequireAuth(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
+// AuditRequestMiddleware stamps the request context with the caller's IP, user agent and
+// request ID, which audit events recorded while handling the request pick up.
+func AuditRequestMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		request := c.Request()
+		c.SetRequest(request.WithContext(audit.WithRequest(request.Context(), audit.Request{
+			IP:        c.RealIP(),
+			UserAgent: request.UserAgent(),
+			RequestID: c.Response().Header().Get(echo.HeaderXRequestID),
+		})))
+
+		return next(c)
+	}
+}
+
 func RequireGuest(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		if _, ok := getCurrentUser(c); ok {
@@ -51,26 +133,68 @@ func RequireGuest(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
-func setSessionCookie(c echo.Context, token string) {
+// CookieSecurity decides when the session and CSRF cookies get the Secure attribute.
+type CookieSecurity string
+
+const (
+	// CookieSecurityAuto marks cookies Secure when the request arrived over HTTPS, directly
+	// or through a proxy setting X-Forwarded-Proto.
+	CookieSecurityAuto   CookieSecurity = "auto"
+	CookieSecurityAlways CookieSecurity = "always"
+	CookieSecurityNever  CookieSecurity = "never"
+)
+
+func ParseCookieSecurity(value string) (CookieSecurity, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "auto":
+		return CookieSecurityAuto, nil
+	case "true", "always":
+		return CookieSecurityAlways, nil
+	case "false", "never":
+		return CookieSecurityNever, nil
+	default:
+		return "", fmt.Errorf("unknown cookie security %q (expected auto, true or false)", value)
+	}
+}
+
+func (s CookieSecurity) secure(c echo.Context) bool {
+	switch s {
+	case CookieSecurityAlways:
+		return true
+	case CookieSecurityNever:
+		return false
+	default:
+		return c.IsTLS() || c.Scheme() == "https"
+	}
+}
+
+func requestClient(c echo.Context) users.Client {
+	return users.Client{
+		IP:        c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+	}
+}
+
+func setSessionCookie(c echo.Context, cookieSecurity CookieSecurity, token string, expiresAt time.Time) {
 	cookie := &http.Cookie{
 		Name:     sessionCookieName,
 		Value:    token,
 		Path:     "/",
 		HttpOnly: true,
-		Secure:   false,
+		Secure:   cookieSecurity.secure(c),
 		SameSite: http.SameSiteLaxMode,
-		MaxAge:   int((30 * 24 * 60 * 60)),
+		MaxAge:   int(time.Until(expiresAt).Seconds()),
 	}
 	c.SetCookie(cookie)
 }
 
-func clearSessionCookie(c echo.Context) {
+func clearSessionCookie(c echo.Context, cookieSecurity CookieSecurity) {
 	cookie := &http.Cookie{
 		Name:     sessionCookieName,
 		Value:    "",
 		Path:     "/",
 		HttpOnly: true,
-		Secure:   false,
+		Secure:   cookieSecurity.secure(c),
 		SameSite: http.SameSiteLaxMode,
 		MaxAge:   -1,
 	}
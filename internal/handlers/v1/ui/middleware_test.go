@@ -0,0 +1,69 @@
+package uihandlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/brian-nunez/bbaas-api/views/pages"
+	"github.com/labstack/echo/v4"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	t.Parallel()
+
+	e := echo.New()
+	e.Use(CSRFMiddleware(CookieSecurityAuto))
+	e.GET("/form", func(c echo.Context) error {
+		return pages.CSRFField().Render(c.Request().Context(), c.Response().Writer)
+	})
+	e.POST("/form", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})
+	e.POST("/api/v1/browsers", func(c echo.Context) error {
+		return c.NoContent(http.StatusCreated)
+	})
+
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/form", nil))
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookieName || cookies[0].Value == "" {
+		t.Fatalf("expected a CSRF cookie, got %v", cookies)
+	}
+	token := cookies[0].Value
+	if !strings.Contains(recorder.Body.String(), `value="`+token+`"`) {
+		t.Fatalf("expected the form field to carry the cookie token, got %s", recorder.Body.String())
+	}
+
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		request.AddCookie(&http.Cookie{Name: csrfCookieName, Value: token})
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	if response := post("/form", url.Values{pages.CSRFFieldName: {token}}); response.Code != http.StatusNoContent {
+		t.Fatalf("expected matching token to pass, got %d", response.Code)
+	}
+
+	for name, form := range map[string]url.Values{
+		"missing token":    {},
+		"mismatched token": {pages.CSRFFieldName: {"forged"}},
+	} {
+		response := post("/form", form)
+		if response.Code != http.StatusForbidden {
+			t.Fatalf("%s: expected 403, got %d", name, response.Code)
+		}
+		if !strings.Contains(response.Header().Get(echo.HeaderContentType), "text/html") {
+			t.Fatalf("%s: expected an HTML error page, got %q", name, response.Header().Get(echo.HeaderContentType))
+		}
+	}
+
+	if response := post("/api/v1/browsers", url.Values{}); response.Code != http.StatusCreated {
+		t.Fatalf("expected API requests to skip CSRF checks, got %d", response.Code)
+	}
+}
@@ -0,0 +1,134 @@
+package uihandlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/organizations"
+	"github.com/brian-nunez/bbaas-api/views/pages"
+	"github.com/labstack/echo/v4"
+)
+
+func (h *Handler) CreateOrganization(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	organization, err := h.organizations.Create(c.Request().Context(), currentUser, c.FormValue("name"))
+	if err != nil {
+		return redirectToDashboard(c, "", err.Error(), "")
+	}
+
+	return redirectToOrganization(c, organization.ID, "Organization created", "")
+}
+
+func (h *Handler) Organization(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	organizationID := c.Param("organizationId")
+	membership, err := h.organizations.Get(c.Request().Context(), currentUser, organizationID)
+	if err != nil {
+		return renderOrganizationError(c, err)
+	}
+
+	members, err := h.organizations.ListMembers(c.Request().Context(), currentUser, organizationID)
+	if err != nil {
+		return renderOrganizationError(c, err)
+	}
+
+	var invitations []organizations.Invitation
+	if !membership.Organization.Personal && (membership.CanManageMembers() || currentUser.IsAdmin()) {
+		invitations, err = h.invitations.List(c.Request().Context(), currentUser, organizationID)
+		if err != nil {
+			return renderOrganizationError(c, err)
+		}
+	}
+
+	successMessage := strings.TrimSpace(c.QueryParam("success"))
+	errorMessage := strings.TrimSpace(c.QueryParam("error"))
+
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	return pages.Organization(currentUser, membership, members, invitations, time.Now().UTC(), successMessage, errorMessage).Render(c.Request().Context(), c.Response().Writer)
+}
+
+func (h *Handler) AddOrganizationMember(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	organizationID := c.Param("organizationId")
+	member, err := h.organizations.AddMember(c.Request().Context(), currentUser, organizationID, c.FormValue("email"), c.FormValue("role"))
+	if err != nil {
+		return redirectToOrganization(c, organizationID, "", err.Error())
+	}
+
+	return redirectToOrganization(c, organizationID, fmt.Sprintf("Added %s as %s", member.Email, member.Role), "")
+}
+
+func (h *Handler) UpdateOrganizationMemberRole(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	organizationID := c.Param("organizationId")
+	if err := h.organizations.UpdateMemberRole(c.Request().Context(), currentUser, organizationID, c.Param("userId"), c.FormValue("role")); err != nil {
+		return redirectToOrganization(c, organizationID, "", err.Error())
+	}
+
+	return redirectToOrganization(c, organizationID, "Role updated", "")
+}
+
+func (h *Handler) RemoveOrganizationMember(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	organizationID := c.Param("organizationId")
+	userID := c.Param("userId")
+	if err := h.organizations.RemoveMember(c.Request().Context(), currentUser, organizationID, userID); err != nil {
+		return redirectToOrganization(c, organizationID, "", err.Error())
+	}
+
+	if userID == currentUser.ID {
+		return redirectToDashboard(c, "You left the organization", "", "")
+	}
+	return redirectToOrganization(c, organizationID, "Member removed", "")
+}
+
+func renderOrganizationError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, organizations.ErrOrganizationNotFound), errors.Is(err, organizations.ErrForbidden):
+		// Non-members get the same answer whether or not the organization exists.
+		return renderError(c, http.StatusNotFound, "Organization not found", "It does not exist or you are not a member.")
+	default:
+		return err
+	}
+}
+
+func redirectToOrganization(c echo.Context, organizationID string, successMessage string, errorMessage string) error {
+	query := make(url.Values)
+	if successMessage != "" {
+		query.Set("success", successMessage)
+	}
+	if errorMessage != "" {
+		query.Set("error", errorMessage)
+	}
+
+	path := "/organizations/" + url.PathEscape(organizationID)
+	if encodedQuery := query.Encode(); encodedQuery != "" {
+		path = fmt.Sprintf("%s?%s", path, encodedQuery)
+	}
+
+	return c.Redirect(http.StatusSeeOther, path)
+}
@@ -0,0 +1,77 @@
+package uihandlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/brian-nunez/bbaas-api/internal/users"
+	"github.com/brian-nunez/bbaas-api/views/pages"
+	"github.com/labstack/echo/v4"
+)
+
+const passwordResetRequestedNotice = "If an account exists for that email, a reset link is on its way. It expires in one hour."
+
+func (h *Handler) ShowPasswordResetRequest(c echo.Context) error {
+	return renderPasswordResetRequest(c, "", "", "")
+}
+
+func (h *Handler) RequestPasswordReset(c echo.Context) error {
+	email := strings.TrimSpace(c.FormValue("email"))
+
+	err := h.passwordResetter.RequestReset(c.Request().Context(), email)
+	if errors.Is(err, users.ErrEmailRequired) || errors.Is(err, users.ErrInvalidEmail) {
+		return renderPasswordResetRequest(c, "", err.Error(), email)
+	}
+	if err != nil {
+		// Answer the same way as for a successful request so failures do not reveal
+		// which addresses have accounts.
+		c.Logger().Errorf("password reset request: %v", err)
+	}
+
+	return renderPasswordResetRequest(c, passwordResetRequestedNotice, "", "")
+}
+
+func (h *Handler) ShowPasswordResetConfirm(c echo.Context) error {
+	token := strings.TrimSpace(c.QueryParam("token"))
+	if token == "" {
+		return renderPasswordResetRequest(c, "", users.ErrInvalidResetToken.Error(), "")
+	}
+
+	return renderPasswordResetConfirm(c, token, "")
+}
+
+func (h *Handler) ConfirmPasswordReset(c echo.Context) error {
+	token := strings.TrimSpace(c.FormValue("token"))
+	password := c.FormValue("password")
+	if password != c.FormValue("passwordConfirmation") {
+		return renderPasswordResetConfirm(c, token, "passwords do not match")
+	}
+
+	err := h.passwordResetter.ResetPassword(c.Request().Context(), token, password)
+	if errors.Is(err, users.ErrInvalidResetToken) {
+		return renderPasswordResetRequest(c, "", err.Error(), "")
+	}
+	if errors.Is(err, users.ErrPasswordRequired) || errors.Is(err, users.ErrPasswordTooShort) {
+		return renderPasswordResetConfirm(c, token, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	// Every session, including this browser's, was deleted by the reset.
+	clearSessionCookie(c, h.cookieSecurity)
+	return c.Redirect(http.StatusSeeOther, "/login?success=Password+updated.+Log+in+with+your+new+password.")
+}
+
+func renderPasswordResetRequest(c echo.Context, notice string, errorMessage string, email string) error {
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	return pages.PasswordResetRequestPage(notice, errorMessage, email).Render(c.Request().Context(), c.Response().Writer)
+}
+
+func renderPasswordResetConfirm(c echo.Context, token string, errorMessage string) error {
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	// Keep the reset token in the URL from leaking to other sites through the Referer header.
+	c.Response().Header().Set("Referrer-Policy", "no-referrer")
+	return pages.PasswordResetConfirmPage(token, errorMessage).Render(c.Request().Context(), c.Response().Writer)
+}
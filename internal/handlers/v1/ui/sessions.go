@@ -0,0 +1,80 @@
+package uihandlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/brian-nunez/bbaas-api/views/pages"
+	"github.com/labstack/echo/v4"
+)
+
+func (h *Handler) Sessions(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	sessions, err := h.usersService.ListSessions(c.Request().Context(), currentUser, getCurrentSessionID(c))
+	if err != nil {
+		return err
+	}
+
+	successMessage := strings.TrimSpace(c.QueryParam("success"))
+	errorMessage := strings.TrimSpace(c.QueryParam("error"))
+
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	return pages.Sessions(currentUser, sessions, successMessage, errorMessage).Render(c.Request().Context(), c.Response().Writer)
+}
+
+func (h *Handler) RevokeSession(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	sessionID := c.Param("sessionId")
+	if sessionID == getCurrentSessionID(c) {
+		return redirectToSessions(c, "", "Use log out to end the session you are using")
+	}
+
+	if err := h.usersService.RevokeSession(c.Request().Context(), currentUser, sessionID); err != nil {
+		return redirectToSessions(c, "", err.Error())
+	}
+
+	return redirectToSessions(c, "Session revoked", "")
+}
+
+func (h *Handler) RevokeAllSessions(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	revoked, err := h.usersService.RevokeAllSessions(c.Request().Context(), currentUser)
+	if err != nil {
+		return redirectToSessions(c, "", err.Error())
+	}
+	clearSessionCookie(c, h.cookieSecurity)
+
+	c.Logger().Infof("user %s logged out of %d sessions", currentUser.ID, revoked)
+	return c.Redirect(http.StatusSeeOther, "/login")
+}
+
+func redirectToSessions(c echo.Context, successMessage string, errorMessage string) error {
+	query := make(url.Values)
+	if successMessage != "" {
+		query.Set("success", successMessage)
+	}
+	if errorMessage != "" {
+		query.Set("error", errorMessage)
+	}
+
+	path := "/account/sessions"
+	if encodedQuery := query.Encode(); encodedQuery != "" {
+		path = fmt.Sprintf("%s?%s", path, encodedQuery)
+	}
+
+	return c.Redirect(http.StatusSeeOther, path)
+}
@@ -0,0 +1,113 @@
+package uihandlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/brian-nunez/bbaas-api/internal/sso"
+	"github.com/brian-nunez/bbaas-api/internal/users"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	ssoCookieName = "bbaas_sso"
+	ssoCookiePath = "/login/sso"
+	// ssoCookieMaxAge bounds how long a user may spend at the identity provider.
+	ssoCookieMaxAge = 10 * 60
+)
+
+// RequirePasswordLogin hides the password forms when single sign-on is the only way in.
+func RequirePasswordLogin(usersService *users.Service) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !usersService.PasswordLoginAllowed() {
+				return c.Redirect(http.StatusSeeOther, "/login")
+			}
+
+			return next(c)
+		}
+	}
+}
+
+func (h *Handler) StartSingleSignOn(c echo.Context) error {
+	if h.singleSignOn == nil {
+		return renderError(c, http.StatusNotFound, "Single sign-on is not configured", "Log in with your email and password instead.")
+	}
+
+	request, err := h.singleSignOn.Begin(c.Request().Context())
+	if err != nil {
+		c.Logger().Errorf("start single sign-on: %v", err)
+		return h.renderLoginError(c, "Your identity provider could not be reached. Try again shortly.")
+	}
+
+	h.setSSOCookie(c, strings.Join([]string{request.State, request.Nonce, request.CodeVerifier}, "."), ssoCookieMaxAge)
+	return c.Redirect(http.StatusSeeOther, request.URL)
+}
+
+func (h *Handler) CompleteSingleSignOn(c echo.Context) error {
+	if h.singleSignOn == nil {
+		return renderError(c, http.StatusNotFound, "Single sign-on is not configured", "Log in with your email and password instead.")
+	}
+
+	pendingCookie, cookieErr := c.Cookie(ssoCookieName)
+	h.setSSOCookie(c, "", -1)
+	if providerError := c.QueryParam("error"); providerError != "" {
+		message := strings.TrimSpace(c.QueryParam("error_description"))
+		if message == "" {
+			message = providerError
+		}
+		return h.renderLoginError(c, "Single sign-on failed: "+message)
+	}
+	if cookieErr != nil {
+		return h.renderLoginError(c, sso.ErrStateMismatch.Error())
+	}
+
+	parts := strings.Split(pendingCookie.Value, ".")
+	if len(parts) != 3 {
+		return h.renderLoginError(c, sso.ErrStateMismatch.Error())
+	}
+	request := sso.AuthRequest{State: parts[0], Nonce: parts[1], CodeVerifier: parts[2]}
+
+	identity, err := h.singleSignOn.Complete(c.Request().Context(), request, c.QueryParam("state"), c.QueryParam("code"))
+	if err != nil {
+		if !errors.Is(err, sso.ErrStateMismatch) && !errors.Is(err, sso.ErrInvalidIDToken) {
+			c.Logger().Errorf("complete single sign-on: %v", err)
+			return h.renderLoginError(c, "Single sign-on failed. Try again shortly.")
+		}
+		return h.renderLoginError(c, err.Error())
+	}
+
+	_, session, err := h.usersService.LoginWithIdentity(c.Request().Context(), identity, requestClient(c))
+	if redirected, redirectErr := h.redirectToSecondFactor(c, err); redirected {
+		return redirectErr
+	}
+	if errors.Is(err, users.ErrInvalidIdentity) || errors.Is(err, users.ErrIdentityEmailUnverified) || errors.Is(err, users.ErrTwoFactorUnavailable) || errors.Is(err, users.ErrAccountDisabled) {
+		return h.renderLoginError(c, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	setSessionCookie(c, h.cookieSecurity, session.Token, session.ExpiresAt)
+	return c.Redirect(http.StatusSeeOther, "/dashboard")
+}
+
+func (h *Handler) renderLoginError(c echo.Context, errorMessage string) error {
+	return h.renderAuth(c, "Login", "Welcome back", "Login with your account credentials.", "/login", "Login", "Need an account?", "/register", "", errorMessage, "")
+}
+
+// setSSOCookie keeps the state, nonce and PKCE verifier of a login in progress; a negative
+// maxAge clears it.
+func (h *Handler) setSSOCookie(c echo.Context, value string, maxAge int) {
+	c.SetCookie(&http.Cookie{
+		Name:     ssoCookieName,
+		Value:    value,
+		Path:     ssoCookiePath,
+		HttpOnly: true,
+		Secure:   h.cookieSecurity.secure(c),
+		// Lax, not Strict: the provider's redirect back is a cross-site navigation.
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   maxAge,
+	})
+}
@@ -0,0 +1,62 @@
+package uihandlers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+func (h *Handler) StartApplicationTransfer(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	transfer, err := h.applicationsService.StartTransfer(c.Request().Context(), currentUser, c.Param("applicationId"), c.FormValue("recipientEmail"))
+	if err != nil {
+		return redirectToDashboard(c, "", err.Error(), "")
+	}
+
+	return redirectToDashboard(c, "Transfer offered to "+transfer.RecipientEmail+". The application moves once they accept.", "", "")
+}
+
+func (h *Handler) CancelApplicationTransfer(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	if err := h.applicationsService.CancelTransfer(c.Request().Context(), currentUser, c.Param("transferId")); err != nil {
+		return redirectToDashboard(c, "", err.Error(), "")
+	}
+
+	return redirectToDashboard(c, "Transfer cancelled", "", "")
+}
+
+func (h *Handler) AcceptApplicationTransfer(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	application, err := h.applicationsService.AcceptTransfer(c.Request().Context(), currentUser, c.Param("transferId"), c.FormValue("organizationId"))
+	if err != nil {
+		return redirectToDashboard(c, "", err.Error(), "")
+	}
+
+	c.Logger().Infof("user %s accepted the transfer of application %s", currentUser.ID, application.ID)
+	return redirectToDashboard(c, application.Name+" is now yours. Its API keys keep working.", "", "")
+}
+
+func (h *Handler) DeclineApplicationTransfer(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	if err := h.applicationsService.DeclineTransfer(c.Request().Context(), currentUser, c.Param("transferId")); err != nil {
+		return redirectToDashboard(c, "", err.Error(), "")
+	}
+
+	return redirectToDashboard(c, "Transfer declined", "", "")
+}
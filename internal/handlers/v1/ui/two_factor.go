@@ -0,0 +1,270 @@
+package uihandlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/users"
+	"github.com/brian-nunez/bbaas-api/views/pages"
+	"github.com/labstack/echo/v4"
+	"rsc.io/qr"
+)
+
+const (
+	twoFactorCookieName = "bbaas_2fa"
+	twoFactorCookiePath = "/login/2fa"
+)
+
+// twoFactorEnrollmentPaths stay reachable while an account is being made to enroll.
+var twoFactorEnrollmentPaths = []string{"/account/security", "/logout", "/verify-email", "/assets/", "/api/"}
+
+// RequireTwoFactorEnrollment sends signed-in users without 2FA to the security page while an
+// admin requires it for every account.
+func RequireTwoFactorEnrollment(twoFactor *users.TwoFactor) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			currentUser, ok := getCurrentUser(c)
+			if !ok || currentUser.HasTwoFactor() {
+				return next(c)
+			}
+
+			path := c.Request().URL.Path
+			for _, allowed := range twoFactorEnrollmentPaths {
+				if strings.HasPrefix(path, allowed) {
+					return next(c)
+				}
+			}
+
+			required, err := twoFactor.Required(c.Request().Context())
+			if err != nil {
+				return err
+			}
+			if !required {
+				return next(c)
+			}
+
+			return redirectToAccountSecurity(c, "", "Set up two-factor authentication to continue")
+		}
+	}
+}
+
+// redirectToSecondFactor sends the browser to the code prompt when err says the login needs
+// a second factor, and reports whether it did.
+func (h *Handler) redirectToSecondFactor(c echo.Context, err error) (bool, error) {
+	var secondFactor *users.SecondFactorRequiredError
+	if !errors.As(err, &secondFactor) {
+		return false, nil
+	}
+
+	setTwoFactorCookie(c, h.cookieSecurity, secondFactor.ChallengeToken, secondFactor.ExpiresAt)
+	return true, c.Redirect(http.StatusSeeOther, "/login/2fa")
+}
+
+func (h *Handler) ShowTwoFactorLogin(c echo.Context) error {
+	if _, err := c.Cookie(twoFactorCookieName); err != nil {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	return renderTwoFactorLogin(c, "")
+}
+
+func (h *Handler) CompleteTwoFactorLogin(c echo.Context) error {
+	challengeCookie, err := c.Cookie(twoFactorCookieName)
+	if err != nil || challengeCookie.Value == "" {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	code := strings.TrimSpace(c.FormValue("recoveryCode"))
+	if code == "" {
+		code = c.FormValue("code")
+	}
+
+	_, session, err := h.usersService.CompleteLogin(c.Request().Context(), challengeCookie.Value, code, requestClient(c))
+	if errors.Is(err, users.ErrInvalidTwoFactorCode) {
+		return renderTwoFactorLogin(c, err.Error())
+	}
+	if errors.Is(err, users.ErrLoginChallengeExpired) || errors.Is(err, users.ErrAccountDisabled) {
+		setTwoFactorCookie(c, h.cookieSecurity, "", time.Time{})
+		return h.renderLoginError(c, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	setTwoFactorCookie(c, h.cookieSecurity, "", time.Time{})
+	setSessionCookie(c, h.cookieSecurity, session.Token, session.ExpiresAt)
+	return c.Redirect(http.StatusSeeOther, "/dashboard")
+}
+
+func (h *Handler) AccountSecurity(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	return h.renderAccountSecurity(c, currentUser, nil, strings.TrimSpace(c.QueryParam("success")), strings.TrimSpace(c.QueryParam("error")))
+}
+
+func (h *Handler) ConfirmTwoFactor(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	recoveryCodes, err := h.twoFactor.ConfirmEnrollment(c.Request().Context(), currentUser, c.FormValue("code"))
+	if err != nil {
+		return redirectToAccountSecurity(c, "", err.Error())
+	}
+
+	// Render instead of redirecting so the recovery codes never appear in a URL.
+	return h.renderAccountSecurity(c, currentUser, recoveryCodes, "Two-factor authentication enabled", "")
+}
+
+func (h *Handler) RegenerateRecoveryCodes(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	recoveryCodes, err := h.twoFactor.RegenerateRecoveryCodes(c.Request().Context(), currentUser, c.FormValue("code"))
+	if err != nil {
+		return redirectToAccountSecurity(c, "", err.Error())
+	}
+
+	return h.renderAccountSecurity(c, currentUser, recoveryCodes, "New recovery codes generated; the old ones no longer work", "")
+}
+
+func (h *Handler) DisableTwoFactor(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	if err := h.twoFactor.Disable(c.Request().Context(), currentUser, c.FormValue("code")); err != nil {
+		return redirectToAccountSecurity(c, "", err.Error())
+	}
+
+	return redirectToAccountSecurity(c, "Two-factor authentication disabled", "")
+}
+
+func (h *Handler) UpdateTwoFactorRequirement(c echo.Context) error {
+	currentUser, ok := getCurrentUser(c)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	required := c.FormValue("required") == "true"
+	if err := h.twoFactor.SetRequired(c.Request().Context(), currentUser, required); err != nil {
+		return redirectToAccountSecurity(c, "", err.Error())
+	}
+
+	if required {
+		return redirectToAccountSecurity(c, "Two-factor authentication is now required for every account", "")
+	}
+	return redirectToAccountSecurity(c, "Two-factor authentication is now optional", "")
+}
+
+func (h *Handler) renderAccountSecurity(c echo.Context, currentUser users.User, recoveryCodes []string, successMessage string, errorMessage string) error {
+	ctx := c.Request().Context()
+	view := pages.AccountSecurityView{
+		CurrentUser:   currentUser,
+		Available:     h.twoFactor.Available(),
+		RecoveryCodes: recoveryCodes,
+	}
+
+	var err error
+	if view.Required, err = h.twoFactor.Required(ctx); err != nil {
+		return err
+	}
+	if view.Status, err = h.twoFactor.Status(ctx, currentUser); err != nil {
+		return err
+	}
+
+	if !view.Status.Enabled && view.Available {
+		enrollment, err := h.twoFactor.BeginEnrollment(ctx, currentUser)
+		if err != nil {
+			return err
+		}
+		qrCode, err := qrCodeSVG(enrollment.ProvisioningURI)
+		if err != nil {
+			return err
+		}
+		view.Enrollment = &enrollment
+		view.QRCodeSVG = qrCode
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return pages.AccountSecurity(view, successMessage, errorMessage).Render(ctx, c.Response().Writer)
+}
+
+func renderTwoFactorLogin(c echo.Context, errorMessage string) error {
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	return pages.TwoFactorLoginPage(errorMessage).Render(c.Request().Context(), c.Response().Writer)
+}
+
+// setTwoFactorCookie remembers a login waiting for its second factor; an empty token clears it.
+func setTwoFactorCookie(c echo.Context, cookieSecurity CookieSecurity, token string, expiresAt time.Time) {
+	maxAge := -1
+	if token != "" {
+		maxAge = int(time.Until(expiresAt).Seconds())
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     twoFactorCookieName,
+		Value:    token,
+		Path:     twoFactorCookiePath,
+		HttpOnly: true,
+		Secure:   cookieSecurity.secure(c),
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   maxAge,
+	})
+}
+
+// qrCodeSVG renders text as an inline SVG QR code, so the secret never leaves the server.
+func qrCodeSVG(text string) (string, error) {
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return "", fmt.Errorf("encode QR code: %w", err)
+	}
+
+	const quietZone = 4
+	size := code.Size + 2*quietZone
+
+	var path strings.Builder
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.Black(x, y) {
+				fmt.Fprintf(&path, "M%d %dh1v1h-1z", x+quietZone, y+quietZone)
+			}
+		}
+	}
+
+	return fmt.Sprintf(
+		`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges" role="img" aria-label="QR code for your authenticator app"><rect width="100%%" height="100%%" fill="#fff"/><path fill="#000" d="%s"/></svg>`,
+		size,
+		size,
+		path.String(),
+	), nil
+}
+
+func redirectToAccountSecurity(c echo.Context, successMessage string, errorMessage string) error {
+	query := make(url.Values)
+	if successMessage != "" {
+		query.Set("success", successMessage)
+	}
+	if errorMessage != "" {
+		query.Set("error", errorMessage)
+	}
+
+	path := "/account/security"
+	if encodedQuery := query.Encode(); encodedQuery != "" {
+		path = fmt.Sprintf("%s?%s", path, encodedQuery)
+	}
+
+	return c.Redirect(http.StatusSeeOther, path)
+}
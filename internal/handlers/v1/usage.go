@@ -0,0 +1,29 @@
+package v1
+
+import (
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/usage"
+	"github.com/labstack/echo/v4"
+)
+
+// UsageMiddleware records each authenticated request for the key's usage statistics. It
+// must run after APIKeyAuthMiddleware; recording is in-memory and flushed in batches.
+func UsageMiddleware(aggregator *usage.Aggregator) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			principal, ok := getAPIKeyPrincipal(c)
+			if ok && aggregator != nil {
+				aggregator.Record(usage.Event{
+					APIKeyID:  principal.KeyID,
+					Endpoint:  c.Request().Method + " " + c.Path(),
+					ClientIP:  c.RealIP(),
+					UserAgent: c.Request().UserAgent(),
+					At:        time.Now(),
+				})
+			}
+
+			return next(c)
+		}
+	}
+}
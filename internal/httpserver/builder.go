@@ -2,6 +2,7 @@ package httpserver
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/brian-nunez/bbaas-api/internal/handlers/errors"
 	"github.com/labstack/echo/v4"
@@ -21,12 +22,22 @@ func New() *ServerBuilder {
 func (b *ServerBuilder) WithDefaultMiddleware() *ServerBuilder {
 	b.e.Use(middleware.Recover())
 	b.e.Use(middleware.RequestID())
-	b.e.Use(middleware.CORS())
+	// Only the API is meant to be called cross-origin; the dashboard relies on its session cookie.
+	b.e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+		Skipper: func(c echo.Context) bool {
+			return !strings.HasPrefix(c.Request().URL.Path, "/api/v1")
+		},
+	}))
 	b.e.Use(middleware.Logger())
 
 	return b
 }
 
+func (b *ServerBuilder) WithIPExtractor(extractor echo.IPExtractor) *ServerBuilder {
+	b.e.IPExtractor = extractor
+	return b
+}
+
 func (b *ServerBuilder) WithRoutes(register func(e *echo.Echo)) *ServerBuilder {
 	register(b.e)
 	return b
@@ -0,0 +1,49 @@
+package httpserver
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// NewIPExtractor returns how the client IP is determined for API key allowlists and logs.
+// Without trusted proxies the TCP peer address is used and X-Forwarded-For is ignored, so
+// clients cannot spoof their address. With trusted proxies, X-Forwarded-For is walked from
+// the right and the first address outside the trusted ranges is used.
+func NewIPExtractor(trustedProxies []string) (echo.IPExtractor, error) {
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+
+	trusted := 0
+	for _, proxy := range trustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+
+		_, ipRange, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("parse trusted proxy %q: %w", proxy, err)
+		}
+		options = append(options, echo.TrustIPRange(ipRange))
+		trusted++
+	}
+
+	if trusted == 0 {
+		return echo.ExtractIPDirect(), nil
+	}
+
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
@@ -0,0 +1,48 @@
+package httpserver
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestIPExtractorIgnoresForwardedForFromUntrustedPeers(t *testing.T) {
+	t.Parallel()
+
+	direct, err := NewIPExtractor(nil)
+	if err != nil {
+		t.Fatalf("new IP extractor: %v", err)
+	}
+	proxied, err := NewIPExtractor([]string{"10.0.0.5", "172.16.0.0/12"})
+	if err != nil {
+		t.Fatalf("new IP extractor with trusted proxies: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		extractor  echo.IPExtractor
+		want       string
+	}{
+		{name: "no proxies configured", remoteAddr: "10.0.0.5:4000", forwarded: "203.0.113.9", extractor: direct, want: "10.0.0.5"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.5:4000", forwarded: "203.0.113.9", extractor: proxied, want: "203.0.113.9"},
+		{name: "spoofed hop before trusted proxy", remoteAddr: "10.0.0.5:4000", forwarded: "198.51.100.1, 203.0.113.9, 172.16.3.4", extractor: proxied, want: "203.0.113.9"},
+		{name: "untrusted peer", remoteAddr: "198.51.100.7:4000", forwarded: "203.0.113.9", extractor: proxied, want: "198.51.100.7"},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest("GET", "/api/v1/browsers", nil)
+		request.RemoteAddr = test.remoteAddr
+		request.Header.Set("X-Forwarded-For", test.forwarded)
+
+		if got := test.extractor(request); got != test.want {
+			t.Fatalf("%s: expected %s, got %s", test.name, test.want, got)
+		}
+	}
+
+	if _, err := NewIPExtractor([]string{"not-a-range"}); err == nil {
+		t.Fatalf("expected invalid trusted proxy to be rejected")
+	}
+}
@@ -3,14 +3,33 @@ package httpserver
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
+	"strings"
+	"time"
 
+	"github.com/brian-nunez/bbaas-api/internal/accesstokens"
+	"github.com/brian-nunez/bbaas-api/internal/accounts"
+	"github.com/brian-nunez/bbaas-api/internal/admin"
 	"github.com/brian-nunez/bbaas-api/internal/applications"
+	"github.com/brian-nunez/bbaas-api/internal/audit"
+	"github.com/brian-nunez/bbaas-api/internal/auditlog"
 	"github.com/brian-nunez/bbaas-api/internal/authorization"
 	"github.com/brian-nunez/bbaas-api/internal/browsers"
 	"github.com/brian-nunez/bbaas-api/internal/dashboard"
 	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/githuboidc"
 	v1 "github.com/brian-nunez/bbaas-api/internal/handlers/v1"
+	uihandlers "github.com/brian-nunez/bbaas-api/internal/handlers/v1/ui"
+	"github.com/brian-nunez/bbaas-api/internal/jobs"
+	"github.com/brian-nunez/bbaas-api/internal/lockout"
+	"github.com/brian-nunez/bbaas-api/internal/mailer"
+	"github.com/brian-nunez/bbaas-api/internal/organizations"
+	"github.com/brian-nunez/bbaas-api/internal/ratelimit"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+	"github.com/brian-nunez/bbaas-api/internal/sso"
+	"github.com/brian-nunez/bbaas-api/internal/usage"
 	"github.com/brian-nunez/bbaas-api/internal/users"
 	"github.com/labstack/echo/v4"
 )
@@ -26,11 +45,54 @@ type BootstrapConfig struct {
 	CDPPublicBaseURL  string
 	DBDriver          string
 	DBDSN             string
+	// TrustedProxies lists the proxy addresses or CIDR ranges allowed to set X-Forwarded-For.
+	TrustedProxies []string
+	// APIRateLimitPerMinute is the default per-key request limit; zero disables it.
+	APIRateLimitPerMinute int
+	// TokenExchangeRateLimitPerMinute limits GitHub Actions token exchanges per source IP; zero
+	// disables it.
+	TokenExchangeRateLimitPerMinute int
+	// MaxApplicationsPerOrganization caps how many applications an organization can create or
+	// receive by transfer; zero means no limit.
+	MaxApplicationsPerOrganization int
+	// GitHubOIDC configures the GitHub Actions token exchange; empty fields use GitHub's defaults.
+	GitHubOIDC githuboidc.Config
+	// StaleAPIKeyAfter highlights keys on the dashboard that have gone unused this long; zero disables it.
+	StaleAPIKeyAfter time.Duration
+	// TokenHashPepper keys the HMAC API keys and session tokens are stored under. Without it
+	// tokens are stored as plain SHA-256 digests.
+	TokenHashPepper string
+	// TokenSigningEncryptionKey encrypts access token signing secrets at rest. It defaults to
+	// TokenHashPepper; without either the secrets are stored in plaintext.
+	TokenSigningEncryptionKey string
+	// CookieSecure is "auto", "true" or "false"; auto marks cookies Secure on HTTPS requests.
+	CookieSecure string
+	// Sessions bounds web session lifetimes; zero values use the defaults.
+	Sessions users.SessionPolicy
+	// PublicBaseURL is the externally reachable address of the web UI, used in emailed links.
+	PublicBaseURL string
+	// SMTP delivers email when Host is set. Otherwise messages are written to MailDirectory,
+	// or to the log when that is empty too.
+	SMTP          mailer.SMTPConfig
+	MailDirectory string
+	// RequireEmailVerification blocks application and API key creation until the user has
+	// verified their email address.
+	RequireEmailVerification bool
+	// TOTPEncryptionKey encrypts two-factor secrets at rest. Without it users cannot enable
+	// two-factor authentication.
+	TOTPEncryptionKey string
+	// SSO enables OpenID Connect login when Issuer is set. An empty RedirectURL defaults to
+	// PublicBaseURL + "/login/sso/callback".
+	SSO sso.Config
+	// DisablePasswordLogin leaves single sign-on as the only way to log in or register.
+	DisablePasswordLogin bool
 }
 
 type appServer struct {
-	echo *echo.Echo
-	db   *sql.DB
+	echo      *echo.Echo
+	db        *sql.DB
+	scheduler *jobs.Scheduler
+	usage     *usage.Aggregator
 }
 
 func (s *appServer) Start(addr string) error {
@@ -39,10 +101,19 @@ func (s *appServer) Start(addr string) error {
 
 func (s *appServer) Shutdown(ctx context.Context) error {
 	echoShutdownErr := s.echo.Shutdown(ctx)
+	schedulerStopErr := s.scheduler.Stop(ctx)
+	// Flush after the server and jobs have stopped so no recorded request is lost.
+	_, usageFlushErr := s.usage.Flush(ctx)
 	dbCloseErr := s.db.Close()
 	if echoShutdownErr != nil {
 		return echoShutdownErr
 	}
+	if schedulerStopErr != nil {
+		return schedulerStopErr
+	}
+	if usageFlushErr != nil {
+		return usageFlushErr
+	}
 	if dbCloseErr != nil {
 		return dbCloseErr
 	}
@@ -51,6 +122,21 @@ func (s *appServer) Shutdown(ctx context.Context) error {
 }
 
 func Bootstrap(config BootstrapConfig) (Server, error) {
+	ipExtractor, err := NewIPExtractor(config.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("configure trusted proxies: %w", err)
+	}
+
+	cookieSecurity, err := uihandlers.ParseCookieSecurity(config.CookieSecure)
+	if err != nil {
+		return nil, fmt.Errorf("configure cookies: %w", err)
+	}
+
+	singleSignOn, err := newSingleSignOn(config)
+	if err != nil {
+		return nil, fmt.Errorf("configure single sign-on: %w", err)
+	}
+
 	db, _, err := data.Open(data.Config{
 		Driver:       config.DBDriver,
 		DSN:          config.DBDSN,
@@ -66,37 +152,256 @@ func Bootstrap(config BootstrapConfig) (Server, error) {
 		return nil, fmt.Errorf("run database migrations: %w", err)
 	}
 
+	outgoingMailer, err := newMailer(config)
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("configure mailer: %w", err)
+	}
+
 	store := data.NewStore(db)
-	usersService := users.NewService(store)
+	auditRecorder := audit.NewRecorder(store)
+	tokenHasher := security.NewTokenHasher(config.TokenHashPepper)
+	if !tokenHasher.Peppered() {
+		log.Println("TOKEN_HASH_PEPPER is not set; API keys and session tokens are stored as unkeyed SHA-256 digests")
+	}
+	var totpSecretBox *security.SecretBox
+	if config.TOTPEncryptionKey != "" {
+		totpSecretBox, err = security.NewSecretBox(config.TOTPEncryptionKey)
+		if err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("configure TOTP encryption: %w", err)
+		}
+	} else {
+		log.Println("TOTP_ENCRYPTION_KEY is not set; two-factor authentication is unavailable")
+	}
+	twoFactor := users.NewTwoFactor(store, tokenHasher, totpSecretBox, "BBAAS")
+	twoFactor.UseAuditRecorder(auditRecorder)
+	usersService := users.NewService(store, tokenHasher, config.Sessions)
+	usersService.UseTwoFactor(twoFactor)
+	usersService.UseAuditRecorder(auditRecorder)
+	lockoutGuard := lockout.NewGuard(store, nil)
+	usersService.UseLockout(lockoutGuard)
+	usersService.AllowPasswordLogin(!config.DisablePasswordLogin)
+	passwordResetter := users.NewPasswordResetter(store, tokenHasher, outgoingMailer, config.PublicBaseURL)
+	passwordResetter.UseAuditRecorder(auditRecorder)
+	emailVerifier := users.NewEmailVerifier(store, tokenHasher, outgoingMailer, config.PublicBaseURL)
+	emailChanger := users.NewEmailChanger(usersService, store, tokenHasher, outgoingMailer, config.PublicBaseURL)
 	webAuthorizer := authorization.NewWebAuthorizer()
-	applicationsService := applications.NewService(store, webAuthorizer)
-	browserManagerClient, err := browsers.NewHTTPManagerClient(config.CDPManagerBaseURL, nil)
+	applicationsService := applications.NewService(store, webAuthorizer, tokenHasher)
+	applicationsService.RequireVerifiedEmail(config.RequireEmailVerification)
+	applicationsService.UseAuditRecorder(auditRecorder)
+	applicationsService.LimitApplicationsPerOrganization(config.MaxApplicationsPerOrganization)
+	organizationsService := organizations.NewService(store, webAuthorizer)
+	organizationsService.UseAuditRecorder(auditRecorder)
+	invitations := organizations.NewInvitations(organizationsService, tokenHasher, outgoingMailer, config.PublicBaseURL)
+	accessTokensService := accesstokens.NewService(store)
+	signingEncryptionKey := config.TokenSigningEncryptionKey
+	if signingEncryptionKey == "" {
+		signingEncryptionKey = config.TokenHashPepper
+	}
+	if signingEncryptionKey != "" {
+		signingSecretBox, err := security.NewSecretBox(signingEncryptionKey)
+		if err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("configure token signing key encryption: %w", err)
+		}
+		accessTokensService.UseSecretBox(signingSecretBox)
+	} else {
+		log.Println("TOKEN_SIGNING_ENCRYPTION_KEY and TOKEN_HASH_PEPPER are not set; access token signing keys are stored in plaintext")
+	}
+	githubOIDCService := githuboidc.NewService(config.GitHubOIDC, applicationsService, accessTokensService)
+	browserManagerClient, err := browsers.NewManagerClient(config.CDPManagerBaseURL, nil)
 	if err != nil {
 		_ = db.Close()
 		return nil, fmt.Errorf("create CDP manager client: %w", err)
 	}
-	dashboardService := dashboard.NewService(store, usersService, applicationsService, browserManagerClient, config.CDPPublicBaseURL)
+	dashboardService := dashboard.NewService(store, usersService, applicationsService, organizationsService, browserManagerClient, lockoutGuard, config.CDPPublicBaseURL, config.StaleAPIKeyAfter)
+
+	usageAggregator := usage.NewAggregator(store)
+	expiryNotifier := applications.NewExpiryNotifier(store, outgoingMailer, 7*24*time.Hour)
+	scheduler := jobs.NewScheduler(nil).
+		Add(jobs.Job{
+			Name:     "api-key-expiry-notices",
+			Interval: time.Hour,
+			Run: func(ctx context.Context) error {
+				_, err := expiryNotifier.NotifyExpiringKeys(ctx)
+				return err
+			},
+		}).
+		Add(jobs.Job{
+			Name:     "api-key-rotation-retirement",
+			Interval: time.Minute,
+			Run: func(ctx context.Context) error {
+				_, err := applicationsService.RetireRotatedAPIKeys(ctx)
+				return err
+			},
+		}).
+		Add(jobs.Job{
+			Name:     "api-key-ip-denial-cleanup",
+			Interval: time.Hour,
+			Run: func(ctx context.Context) error {
+				_, err := applicationsService.DeleteOldIPDenials(ctx)
+				return err
+			},
+		}).
+		Add(jobs.Job{
+			Name:     "api-key-usage-flush",
+			Interval: 5 * time.Second,
+			Run: func(ctx context.Context) error {
+				_, err := usageAggregator.Flush(ctx)
+				return err
+			},
+		}).
+		Add(jobs.Job{
+			Name:     "api-key-auth-cache-sync",
+			Interval: applications.AuthCacheSyncInterval,
+			Run:      applicationsService.SyncAuthCache,
+		}).
+		Add(jobs.Job{
+			Name:     "session-cleanup",
+			Interval: time.Hour,
+			Run: func(ctx context.Context) error {
+				_, err := usersService.DeleteExpiredSessions(ctx)
+				return err
+			},
+		}).
+		Add(jobs.Job{
+			Name:     "password-reset-token-cleanup",
+			Interval: time.Hour,
+			Run: func(ctx context.Context) error {
+				_, err := passwordResetter.DeleteStaleTokens(ctx)
+				return err
+			},
+		}).
+		Add(jobs.Job{
+			Name:     "email-verification-token-cleanup",
+			Interval: time.Hour,
+			Run: func(ctx context.Context) error {
+				_, err := emailVerifier.DeleteExpiredTokens(ctx)
+				return err
+			},
+		}).
+		Add(jobs.Job{
+			Name:     "email-change-cleanup",
+			Interval: time.Hour,
+			Run: func(ctx context.Context) error {
+				_, err := emailChanger.DeleteExpired(ctx)
+				return err
+			},
+		}).
+		Add(jobs.Job{
+			Name:     "login-challenge-cleanup",
+			Interval: time.Hour,
+			Run: func(ctx context.Context) error {
+				_, err := twoFactor.DeleteExpiredChallenges(ctx)
+				return err
+			},
+		}).
+		Add(jobs.Job{
+			Name:     "invitation-cleanup",
+			Interval: time.Hour,
+			Run: func(ctx context.Context) error {
+				_, err := invitations.DeleteExpired(ctx)
+				return err
+			},
+		}).
+		Add(jobs.Job{
+			Name:     "application-transfer-cleanup",
+			Interval: time.Hour,
+			Run: func(ctx context.Context) error {
+				_, err := applicationsService.DeleteExpiredTransfers(ctx)
+				return err
+			},
+		}).
+		Add(jobs.Job{
+			Name:     "auth-failure-cleanup",
+			Interval: time.Hour,
+			Run: func(ctx context.Context) error {
+				_, err := lockoutGuard.DeleteStale(ctx)
+				return err
+			},
+		}).
+		Add(jobs.Job{
+			Name:     "token-signing-key-rotation",
+			Interval: time.Hour,
+			Run:      accessTokensService.RotateSigningKeys,
+		})
 
 	apiAuthorizer := authorization.NewAPIAuthorizer()
 	browserService := browsers.NewService(browserManagerClient, store, apiAuthorizer, config.CDPPublicBaseURL)
+	applicationsService.UseBrowserCloser(browserService)
+	accountsService := accounts.NewService(usersService, applicationsService, browserService)
+	adminService := admin.NewService(store, usersService, applicationsService, browserService, webAuthorizer, auditRecorder)
+	auditLog := auditlog.NewService(store, auditRecorder, webAuthorizer)
 
 	echoServer := New().
 		WithStaticAssets(config.StaticDirectories).
+		WithIPExtractor(ipExtractor).
 		WithDefaultMiddleware().
 		WithErrorHandler().
 		WithRoutes(func(e *echo.Echo) {
 			v1.RegisterRoutes(e, v1.Dependencies{
 				UsersService:        usersService,
 				ApplicationsService: applicationsService,
+				Organizations:       organizationsService,
+				Invitations:         invitations,
+				Admin:               adminService,
+				Audit:               auditRecorder,
+				AuditLog:            auditLog,
+				AccessTokensService: accessTokensService,
+				GitHubOIDCService:   githubOIDCService,
 				BrowserService:      browserService,
 				DashboardService:    dashboardService,
+				PasswordResetter:    passwordResetter,
+				EmailVerifier:       emailVerifier,
+				EmailChanger:        emailChanger,
+				Accounts:            accountsService,
+				TwoFactor:           twoFactor,
+				SingleSignOn:        singleSignOn,
+				Lockout:             lockoutGuard,
+				RateLimiter:         ratelimit.NewMemoryLimiter(),
+				DefaultRateLimit:    ratelimit.PerMinute(config.APIRateLimitPerMinute),
+				ExchangeRateLimit:   ratelimit.PerMinute(config.TokenExchangeRateLimitPerMinute),
+				UsageAggregator:     usageAggregator,
+				CookieSecurity:      cookieSecurity,
 			})
 		}).
 		WithNotFound().
 		Build()
 
+	scheduler.Start()
+
 	return &appServer{
-		echo: echoServer,
-		db:   db,
+		echo:      echoServer,
+		db:        db,
+		scheduler: scheduler,
+		usage:     usageAggregator,
 	}, nil
 }
+
+func newMailer(config BootstrapConfig) (mailer.Mailer, error) {
+	switch {
+	case config.SMTP.Host != "":
+		return mailer.NewSMTPMailer(config.SMTP)
+	case config.MailDirectory != "":
+		return mailer.NewFileMailer(config.MailDirectory, config.SMTP.From)
+	default:
+		return mailer.NewLogMailer(nil), nil
+	}
+}
+
+func newSingleSignOn(config BootstrapConfig) (*sso.Service, error) {
+	if config.SSO.Issuer == "" {
+		if config.DisablePasswordLogin {
+			return nil, errors.New("password login cannot be disabled without an OpenID Connect issuer")
+		}
+		return nil, nil
+	}
+
+	ssoConfig := config.SSO
+	if ssoConfig.RedirectURL == "" {
+		ssoConfig.RedirectURL = strings.TrimRight(config.PublicBaseURL, "/") + "/login/sso/callback"
+	}
+
+	return sso.NewService(ssoConfig)
+}
@@ -0,0 +1,93 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// Scheduler runs registered jobs on fixed intervals in background goroutines until stopped.
+type Scheduler struct {
+	jobs    []Job
+	logger  *log.Logger
+	cancel  context.CancelFunc
+	waiting sync.WaitGroup
+}
+
+func NewScheduler(logger *log.Logger) *Scheduler {
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	return &Scheduler{logger: logger}
+}
+
+func (s *Scheduler) Add(job Job) *Scheduler {
+	s.jobs = append(s.jobs, job)
+	return s
+}
+
+func (s *Scheduler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	for _, job := range s.jobs {
+		if job.Interval <= 0 || job.Run == nil {
+			continue
+		}
+
+		s.waiting.Add(1)
+		go s.loop(ctx, job)
+	}
+}
+
+// Stop cancels running jobs and waits for them to return or for ctx to be done.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	if s.cancel == nil {
+		return nil
+	}
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.waiting.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+	defer s.waiting.Done()
+
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.runOnce(ctx, job)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) runOnce(ctx context.Context, job Job) {
+	if err := job.Run(ctx); err != nil && ctx.Err() == nil {
+		s.logger.Printf("job %s failed: %v", job.Name, err)
+	}
+}
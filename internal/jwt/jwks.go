@@ -0,0 +1,196 @@
+package jwt
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrUnknownKey = errors.New("signing key not found in key set")
+
+const (
+	defaultJWKSCacheTTL      = time.Hour
+	defaultJWKSRefetchPeriod = time.Minute
+)
+
+// KeySet fetches RSA public keys from a JWKS URL and caches them. An unknown key ID
+// triggers a refetch, at most once per minute, so that key rotation upstream is picked
+// up without refetching for every forged token.
+type KeySet struct {
+	url        string
+	httpClient *http.Client
+	cacheTTL   time.Duration
+	now        func() time.Time
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+	// inflight is the JWKS fetch in progress, shared by every caller that needs it.
+	inflight *jwksFetch
+}
+
+// jwksFetch is a JWKS request made without holding the key set's lock. done is closed once
+// err is set.
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+func NewKeySet(url string, httpClient *http.Client) *KeySet {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &KeySet{
+		url:        url,
+		httpClient: httpClient,
+		cacheTTL:   defaultJWKSCacheTTL,
+		now:        time.Now,
+	}
+}
+
+func (k *KeySet) Key(ctx context.Context, keyID string) (*rsa.PublicKey, error) {
+	for {
+		k.mu.Lock()
+		now := k.now()
+		stale := k.keys == nil || now.Sub(k.fetchedAt) >= k.cacheTTL
+		if key, found := k.keys[keyID]; found && !stale {
+			k.mu.Unlock()
+			return key, nil
+		}
+		if !stale && now.Sub(k.fetchedAt) < defaultJWKSRefetchPeriod {
+			k.mu.Unlock()
+			return nil, ErrUnknownKey
+		}
+
+		// Wait for a fetch another caller started, then look the key up again.
+		if inflight := k.inflight; inflight != nil {
+			k.mu.Unlock()
+			select {
+			case <-inflight.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			if inflight.err != nil {
+				return k.cachedKey(keyID, inflight.err)
+			}
+			continue
+		}
+
+		inflight := &jwksFetch{done: make(chan struct{})}
+		k.inflight = inflight
+		k.mu.Unlock()
+
+		keys, err := k.fetch(ctx)
+
+		k.mu.Lock()
+		k.inflight = nil
+		if err == nil {
+			k.keys = keys
+			k.fetchedAt = now
+		}
+		k.mu.Unlock()
+		inflight.err = err
+		close(inflight.done)
+
+		if err != nil {
+			return k.cachedKey(keyID, err)
+		}
+
+		key, found := keys[keyID]
+		if !found {
+			return nil, ErrUnknownKey
+		}
+
+		return key, nil
+	}
+}
+
+// cachedKey keeps verifying with the cached keys while the JWKS endpoint is unavailable.
+func (k *KeySet) cachedKey(keyID string, fetchErr error) (*rsa.PublicKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if key, found := k.keys[keyID]; found {
+		return key, nil
+	}
+
+	return nil, fetchErr
+}
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+}
+
+func (k *KeySet) fetch(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("build JWKS request: %w", err)
+	}
+	request.Header.Set("Accept", "application/json")
+
+	response, err := k.httpClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("fetch JWKS: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch JWKS: unexpected status %d", response.StatusCode)
+	}
+
+	var document struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&document); err != nil {
+		return nil, fmt.Errorf("decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(document.Keys))
+	for _, jwk := range document.Keys {
+		if jwk.KeyType != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+
+		publicKey, err := rsaPublicKey(jwk)
+		if err != nil {
+			continue
+		}
+		keys[jwk.KeyID] = publicKey
+	}
+
+	return keys, nil
+}
+
+func rsaPublicKey(jwk jsonWebKey) (*rsa.PublicKey, error) {
+	modulus, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, fmt.Errorf("decode modulus: %w", err)
+	}
+	exponent, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		return nil, fmt.Errorf("decode exponent: %w", err)
+	}
+	if len(exponent) == 0 || len(exponent) > 4 {
+		return nil, errors.New("unsupported exponent")
+	}
+
+	e := 0
+	for _, b := range exponent {
+		e = e<<8 | int(b)
+	}
+
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: e}, nil
+}
@@ -0,0 +1,87 @@
+package jwt
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKeySetFetchesWithoutBlockingCachedKeys(t *testing.T) {
+	t.Parallel()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+
+	var requests atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Every fetch after the first hangs until the test releases it.
+		if requests.Add(1) > 1 {
+			<-release
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "cached",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	keySet := NewKeySet(server.URL, nil)
+	keySet.now = func() time.Time { return now }
+	if _, err := keySet.Key(ctx, "cached"); err != nil {
+		t.Fatalf("load key: %v", err)
+	}
+
+	// Unknown key IDs refetch once the refetch period has passed.
+	now = now.Add(2 * defaultJWKSRefetchPeriod)
+	var waiters sync.WaitGroup
+	for range 3 {
+		waiters.Add(1)
+		go func() {
+			defer waiters.Done()
+			if _, err := keySet.Key(ctx, "rotated"); err != ErrUnknownKey {
+				t.Errorf("expected ErrUnknownKey, got %v", err)
+			}
+		}()
+	}
+	for requests.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	lookedUp := make(chan error, 1)
+	go func() {
+		_, err := keySet.Key(ctx, "cached")
+		lookedUp <- err
+	}()
+	select {
+	case err := <-lookedUp:
+		if err != nil {
+			t.Fatalf("expected the cached key during a refetch, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("cached key lookup blocked on the JWKS fetch")
+	}
+
+	close(release)
+	waiters.Wait()
+	if fetched := requests.Load(); fetched != 2 {
+		t.Fatalf("expected concurrent lookups to share one fetch, got %d requests", fetched)
+	}
+}
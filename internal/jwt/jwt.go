@@ -0,0 +1,178 @@
+// Package jwt implements the small subset of JSON Web Tokens (RFC 7519) the API needs:
+// compact serialization, HS256 signing and verification, and RS256 verification against
+// keys published in a JWKS document.
+package jwt
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+)
+
+var (
+	ErrMalformed        = errors.New("malformed token")
+	ErrUnsupportedAlg   = errors.New("unsupported signing algorithm")
+	ErrInvalidSignature = errors.New("invalid token signature")
+	ErrExpired          = errors.New("token has expired")
+	ErrNotYetValid      = errors.New("token is not valid yet")
+)
+
+type Header struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid,omitempty"`
+	Type      string `json:"typ,omitempty"`
+}
+
+// RegisteredClaims are the standard claims checked by ValidateTimes. Embed it in
+// application specific claim structs.
+type RegisteredClaims struct {
+	Issuer    string `json:"iss,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Audience  any    `json:"aud,omitempty"`
+	ID        string `json:"jti,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+}
+
+// ValidateTimes checks exp and nbf against now, allowing leeway for clock skew.
+func (c RegisteredClaims) ValidateTimes(now time.Time, leeway time.Duration) error {
+	if c.ExpiresAt != 0 && !now.Add(-leeway).Before(time.Unix(c.ExpiresAt, 0)) {
+		return ErrExpired
+	}
+	if c.NotBefore != 0 && now.Add(leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return ErrNotYetValid
+	}
+
+	return nil
+}
+
+// HasAudience reports whether aud, which may be a string or a list, contains audience.
+func (c RegisteredClaims) HasAudience(audience string) bool {
+	switch value := c.Audience.(type) {
+	case string:
+		return value == audience
+	case []any:
+		for _, entry := range value {
+			if entry == audience {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Token is a decoded but not yet verified token.
+type Token struct {
+	Header       Header
+	Payload      []byte
+	SigningInput string
+	Signature    []byte
+}
+
+func (t Token) DecodeClaims(claims any) error {
+	if err := json.Unmarshal(t.Payload, claims); err != nil {
+		return fmt.Errorf("%w: decode claims: %v", ErrMalformed, err)
+	}
+
+	return nil
+}
+
+// VerifyHS256 checks the token's HMAC-SHA256 signature with secret.
+func (t Token) VerifyHS256(secret []byte) error {
+	if t.Header.Algorithm != AlgorithmHS256 {
+		return ErrUnsupportedAlg
+	}
+
+	if !hmac.Equal(t.Signature, hs256(t.SigningInput, secret)) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// VerifyRS256 checks the token's RSASSA-PKCS1-v1_5 SHA-256 signature with publicKey.
+func (t Token) VerifyRS256(publicKey *rsa.PublicKey) error {
+	if t.Header.Algorithm != AlgorithmRS256 {
+		return ErrUnsupportedAlg
+	}
+
+	digest := sha256.Sum256([]byte(t.SigningInput))
+	if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], t.Signature); err != nil {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// Parse splits and decodes a compact token without verifying it.
+func Parse(raw string) (Token, error) {
+	parts := strings.Split(strings.TrimSpace(raw), ".")
+	if len(parts) != 3 {
+		return Token{}, ErrMalformed
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return Token{}, fmt.Errorf("%w: decode header: %v", ErrMalformed, err)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Token{}, fmt.Errorf("%w: decode payload: %v", ErrMalformed, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Token{}, fmt.Errorf("%w: decode signature: %v", ErrMalformed, err)
+	}
+
+	var header Header
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return Token{}, fmt.Errorf("%w: decode header: %v", ErrMalformed, err)
+	}
+
+	return Token{
+		Header:       header,
+		Payload:      payload,
+		SigningInput: parts[0] + "." + parts[1],
+		Signature:    signature,
+	}, nil
+}
+
+// LooksLikeToken reports whether raw has the three-segment shape of a compact JWT.
+func LooksLikeToken(raw string) bool {
+	return strings.Count(raw, ".") == 2
+}
+
+// SignHS256 serializes claims into a compact token signed with secret under keyID.
+func SignHS256(keyID string, secret []byte, claims any) (string, error) {
+	headerJSON, err := json.Marshal(Header{Algorithm: AlgorithmHS256, KeyID: keyID, Type: "JWT"})
+	if err != nil {
+		return "", fmt.Errorf("encode header: %w", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("encode claims: %w", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(hs256(signingInput, secret)), nil
+}
+
+func hs256(signingInput string, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,55 @@
+package jwt
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerifyHS256(t *testing.T) {
+	t.Parallel()
+
+	secret := []byte("test-secret")
+	raw, err := SignHS256("key-1", secret, RegisteredClaims{Subject: "user", ExpiresAt: 2000})
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	if !LooksLikeToken(raw) {
+		t.Fatalf("expected compact token, got %q", raw)
+	}
+
+	token, err := Parse(raw)
+	if err != nil {
+		t.Fatalf("parse token: %v", err)
+	}
+	if token.Header.KeyID != "key-1" || token.Header.Algorithm != AlgorithmHS256 {
+		t.Fatalf("unexpected header %+v", token.Header)
+	}
+	if err := token.VerifyHS256(secret); err != nil {
+		t.Fatalf("verify token: %v", err)
+	}
+	if err := token.VerifyHS256([]byte("other-secret")); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected invalid signature with wrong secret, got %v", err)
+	}
+
+	var claims RegisteredClaims
+	if err := token.DecodeClaims(&claims); err != nil {
+		t.Fatalf("decode claims: %v", err)
+	}
+	if claims.Subject != "user" {
+		t.Fatalf("expected subject to round-trip, got %q", claims.Subject)
+	}
+	if err := claims.ValidateTimes(time.Unix(1999, 0), 0); err != nil {
+		t.Fatalf("expected token to be valid before exp: %v", err)
+	}
+	if err := claims.ValidateTimes(time.Unix(2000, 0), 0); !errors.Is(err, ErrExpired) {
+		t.Fatalf("expected token to be expired at exp, got %v", err)
+	}
+	if err := claims.ValidateTimes(time.Unix(2005, 0), 10*time.Second); err != nil {
+		t.Fatalf("expected leeway to accept recently expired token: %v", err)
+	}
+
+	if _, err := Parse("bka_not-a-token"); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("expected malformed error, got %v", err)
+	}
+}
@@ -0,0 +1,336 @@
+// Package lockout slows down repeated authentication failures. Each failure is counted per
+// subject (an email address or a source IP); past a few free attempts every further failure
+// locks the subject for an exponentially growing delay, up to a temporary lockout.
+package lockout
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/data"
+)
+
+type Scope string
+
+const (
+	// ScopeLoginAccount counts failed logins per email address, whether or not an account
+	// exists for it, so a lockout says nothing about which addresses are registered.
+	ScopeLoginAccount Scope = "login_account"
+	ScopeLoginIP      Scope = "login_ip"
+	ScopeAPIKeyIP     Scope = "api_key_ip"
+)
+
+// checkCacheTTL bounds how long a lookup is reused before the database is asked again, so
+// lockouts recorded or cleared by another replica take effect within a few seconds.
+const checkCacheTTL = 5 * time.Second
+
+// Key identifies what a failure is counted against.
+type Key struct {
+	Scope   Scope
+	Subject string
+}
+
+func LoginAccount(email string) Key {
+	return Key{Scope: ScopeLoginAccount, Subject: strings.ToLower(strings.TrimSpace(email))}
+}
+
+func LoginIP(ip string) Key {
+	return Key{Scope: ScopeLoginIP, Subject: strings.TrimSpace(ip)}
+}
+
+func APIKeyIP(ip string) Key {
+	return Key{Scope: ScopeAPIKeyIP, Subject: strings.TrimSpace(ip)}
+}
+
+// Policy sets how quickly failures in one scope lock a subject.
+type Policy struct {
+	// FreeFailures may happen before any delay.
+	FreeFailures int
+	// BaseDelay is the lock after the first failure past FreeFailures; it doubles with each
+	// further failure up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// ResetAfter forgets the count once a subject has not failed for this long. It should be
+	// longer than MaxDelay, or a patient attacker never reaches it.
+	ResetAfter time.Duration
+}
+
+// Delay is how long a subject is locked after its failures-th failure.
+func (p Policy) Delay(failures int) time.Duration {
+	excess := failures - p.FreeFailures
+	if excess <= 0 || p.BaseDelay <= 0 {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for step := 1; step < excess && delay < p.MaxDelay; step++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	return delay
+}
+
+func DefaultPolicies() map[Scope]Policy {
+	return map[Scope]Policy{
+		ScopeLoginAccount: {FreeFailures: 5, BaseDelay: 5 * time.Second, MaxDelay: 15 * time.Minute, ResetAfter: time.Hour},
+		// Source IPs get more slack: offices and mobile carriers share one address.
+		ScopeLoginIP:  {FreeFailures: 20, BaseDelay: 5 * time.Second, MaxDelay: 15 * time.Minute, ResetAfter: time.Hour},
+		ScopeAPIKeyIP: {FreeFailures: 20, BaseDelay: time.Second, MaxDelay: 15 * time.Minute, ResetAfter: time.Hour},
+	}
+}
+
+// LockedError is returned by Check while a subject is locked. Its message is the same for
+// every scope and subject.
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("too many failed attempts; try again in %s", formatWait(e.RetryAfter))
+}
+
+// Entry is a subject with recent failures, as shown to admins.
+type Entry struct {
+	Scope         Scope
+	Subject       string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+func (e Entry) LockedAt(now time.Time) bool {
+	return e.LockedUntil != nil && e.LockedUntil.After(now)
+}
+
+type cachedLock struct {
+	lockedUntil time.Time
+	checkedAt   time.Time
+}
+
+// Guard records failures and reports lockouts. A nil Guard allows everything, so callers need
+// no special case when brute-force protection is not wired up.
+type Guard struct {
+	store    *data.Store
+	policies map[Scope]Policy
+	now      func() time.Time
+
+	mu        sync.Mutex
+	locks     map[Key]cachedLock
+	lastSweep time.Time
+}
+
+func NewGuard(store *data.Store, policies map[Scope]Policy) *Guard {
+	if policies == nil {
+		policies = DefaultPolicies()
+	}
+
+	return &Guard{
+		store:    store,
+		policies: policies,
+		now:      time.Now,
+		locks:    make(map[Key]cachedLock),
+	}
+}
+
+// Check returns a *LockedError if any of the keys is locked. Keys with an empty subject are
+// ignored.
+func (g *Guard) Check(ctx context.Context, keys ...Key) error {
+	if g == nil {
+		return nil
+	}
+
+	now := g.now().UTC()
+	var retryAfter time.Duration
+	for _, key := range keys {
+		if key.Subject == "" {
+			continue
+		}
+
+		lockedUntil, err := g.lockedUntil(ctx, key, now)
+		if err != nil {
+			return err
+		}
+		if wait := lockedUntil.Sub(now); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+
+	if retryAfter > 0 {
+		return &LockedError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// RecordFailure counts a failed attempt against each key and locks the ones past their
+// policy's free failures.
+func (g *Guard) RecordFailure(ctx context.Context, keys ...Key) error {
+	if g == nil {
+		return nil
+	}
+
+	now := g.now().UTC()
+	for _, key := range keys {
+		if key.Subject == "" {
+			continue
+		}
+
+		policy := g.policies[key.Scope]
+		failures, err := g.store.RecordAuthFailure(ctx, string(key.Scope), key.Subject, now, now.Add(-policy.ResetAfter))
+		if err != nil {
+			return err
+		}
+
+		lockedUntil := time.Time{}
+		if delay := policy.Delay(failures); delay > 0 {
+			lockedUntil = now.Add(delay)
+			if err := g.store.LockAuthSubject(ctx, string(key.Scope), key.Subject, lockedUntil); err != nil {
+				return err
+			}
+		}
+		g.remember(key, lockedUntil, now)
+	}
+
+	return nil
+}
+
+// Reset forgets the failures of a key, for example after a successful login.
+func (g *Guard) Reset(ctx context.Context, key Key) error {
+	if key.Subject == "" {
+		return nil
+	}
+
+	_, err := g.Clear(ctx, key)
+	return err
+}
+
+// Clear lifts a lockout and reports whether there was anything to clear.
+func (g *Guard) Clear(ctx context.Context, key Key) (bool, error) {
+	if g == nil {
+		return false, nil
+	}
+
+	cleared, err := g.store.DeleteAuthFailure(ctx, string(key.Scope), key.Subject)
+	if err != nil {
+		return false, err
+	}
+	g.forget(key)
+
+	return cleared, nil
+}
+
+// List returns locked subjects and those with failures inside their scope's reset window,
+// locked ones first.
+func (g *Guard) List(ctx context.Context, limit int) ([]Entry, error) {
+	if g == nil {
+		return nil, nil
+	}
+
+	now := g.now().UTC()
+	records, err := g.store.ListAuthFailures(ctx, now, now.Add(-g.longestResetAfter()), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(records))
+	for _, record := range records {
+		if !record.LastFailureAt.After(now.Add(-g.policies[Scope(record.Scope)].ResetAfter)) && (record.LockedUntil == nil || !record.LockedUntil.After(now)) {
+			continue
+		}
+		entries = append(entries, Entry{
+			Scope:         Scope(record.Scope),
+			Subject:       record.Subject,
+			Failures:      record.Failures,
+			LastFailureAt: record.LastFailureAt,
+			LockedUntil:   record.LockedUntil,
+		})
+	}
+	sort.SliceStable(entries, func(i int, j int) bool {
+		return entries[i].LockedAt(now) && !entries[j].LockedAt(now)
+	})
+
+	return entries, nil
+}
+
+// DeleteStale removes failure counts that can no longer lock anything.
+func (g *Guard) DeleteStale(ctx context.Context) (int, error) {
+	if g == nil {
+		return 0, nil
+	}
+
+	now := g.now().UTC()
+	return g.store.DeleteStaleAuthFailures(ctx, now, now.Add(-g.longestResetAfter()))
+}
+
+func (g *Guard) lockedUntil(ctx context.Context, key Key, now time.Time) (time.Time, error) {
+	g.mu.Lock()
+	cached, found := g.locks[key]
+	g.mu.Unlock()
+	if found && now.Sub(cached.checkedAt) < checkCacheTTL {
+		return cached.lockedUntil, nil
+	}
+
+	record, found, err := g.store.GetAuthFailure(ctx, string(key.Scope), key.Subject)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	lockedUntil := time.Time{}
+	if found && record.LockedUntil != nil {
+		lockedUntil = *record.LockedUntil
+	}
+	g.remember(key, lockedUntil, now)
+
+	return lockedUntil, nil
+}
+
+func (g *Guard) remember(key Key, lockedUntil time.Time, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// Sweep at most once a cache lifetime so the map does not grow with every IP ever seen.
+	if now.Sub(g.lastSweep) >= checkCacheTTL {
+		g.lastSweep = now
+		for cachedKey, cached := range g.locks {
+			if now.Sub(cached.checkedAt) >= checkCacheTTL {
+				delete(g.locks, cachedKey)
+			}
+		}
+	}
+	g.locks[key] = cachedLock{lockedUntil: lockedUntil, checkedAt: now}
+}
+
+func (g *Guard) forget(key Key) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.locks, key)
+}
+
+func (g *Guard) longestResetAfter() time.Duration {
+	var longest time.Duration
+	for _, policy := range g.policies {
+		longest = max(longest, policy.ResetAfter)
+	}
+	return longest
+}
+
+func formatWait(wait time.Duration) string {
+	switch {
+	case wait <= time.Second:
+		return "1 second"
+	case wait < time.Minute:
+		return fmt.Sprintf("%d seconds", int((wait+time.Second-1)/time.Second))
+	default:
+		minutes := int((wait + time.Minute - 1) / time.Minute)
+		if minutes == 1 {
+			return "1 minute"
+		}
+		return fmt.Sprintf("%d minutes", minutes)
+	}
+}
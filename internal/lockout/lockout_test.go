@@ -0,0 +1,146 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/data/datatest"
+)
+
+func TestPolicyDelay(t *testing.T) {
+	t.Parallel()
+
+	policy := Policy{FreeFailures: 3, BaseDelay: 5 * time.Second, MaxDelay: time.Minute}
+	expected := map[int]time.Duration{
+		1:  0,
+		3:  0,
+		4:  5 * time.Second,
+		5:  10 * time.Second,
+		6:  20 * time.Second,
+		7:  40 * time.Second,
+		8:  time.Minute,
+		50: time.Minute,
+	}
+	for failures, delay := range expected {
+		if got := policy.Delay(failures); got != delay {
+			t.Fatalf("expected %s after %d failures, got %s", delay, failures, got)
+		}
+	}
+}
+
+func TestGuardLocksEscalatesAndResets(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	guard := NewGuard(datatest.NewStore(t), map[Scope]Policy{
+		ScopeLoginAccount: {FreeFailures: 2, BaseDelay: time.Minute, MaxDelay: 10 * time.Minute, ResetAfter: time.Hour},
+	})
+	guard.now = func() time.Time { return now }
+	account := LoginAccount(" Alice@Example.com ")
+	other := LoginAccount("bob@example.com")
+
+	for range 2 {
+		if err := guard.RecordFailure(ctx, account); err != nil {
+			t.Fatalf("record failure: %v", err)
+		}
+	}
+	if err := guard.Check(ctx, account); err != nil {
+		t.Fatalf("expected free failures not to lock, got %v", err)
+	}
+
+	if err := guard.RecordFailure(ctx, account); err != nil {
+		t.Fatalf("record failure: %v", err)
+	}
+	var locked *LockedError
+	if err := guard.Check(ctx, other, account); !errors.As(err, &locked) || locked.RetryAfter != time.Minute {
+		t.Fatalf("expected a one minute lock, got %v", err)
+	}
+	if err := guard.Check(ctx, other); err != nil {
+		t.Fatalf("expected other accounts to stay unlocked, got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	if err := guard.Check(ctx, account); err != nil {
+		t.Fatalf("expected the lock to expire, got %v", err)
+	}
+	if err := guard.RecordFailure(ctx, account); err != nil {
+		t.Fatalf("record failure: %v", err)
+	}
+	if err := guard.Check(ctx, account); !errors.As(err, &locked) || locked.RetryAfter != 2*time.Minute {
+		t.Fatalf("expected the lock to double, got %v", err)
+	}
+
+	entries, err := guard.List(ctx, 10)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Subject != "alice@example.com" || entries[0].Failures != 4 || !entries[0].LockedAt(now) {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+
+	// A quiet hour forgets the count: the next failure is free again.
+	now = now.Add(2 * time.Hour)
+	if err := guard.RecordFailure(ctx, account); err != nil {
+		t.Fatalf("record failure: %v", err)
+	}
+	if err := guard.Check(ctx, account); err != nil {
+		t.Fatalf("expected the count to reset after a quiet period, got %v", err)
+	}
+
+	cleared, err := guard.Clear(ctx, account)
+	if err != nil || !cleared {
+		t.Fatalf("expected the entry to be cleared, got cleared=%v err=%v", cleared, err)
+	}
+	if entries, err := guard.List(ctx, 10); err != nil || len(entries) != 0 {
+		t.Fatalf("expected no entries after clearing, got %+v err=%v", entries, err)
+	}
+}
+
+func TestGuardDeleteStale(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	guard := NewGuard(datatest.NewStore(t), nil)
+	guard.now = func() time.Time { return now }
+
+	if err := guard.RecordFailure(ctx, LoginIP("192.0.2.1"), APIKeyIP("192.0.2.1")); err != nil {
+		t.Fatalf("record failure: %v", err)
+	}
+	now = now.Add(30 * time.Minute)
+	if err := guard.RecordFailure(ctx, LoginIP("192.0.2.2")); err != nil {
+		t.Fatalf("record failure: %v", err)
+	}
+
+	now = now.Add(45 * time.Minute)
+	deleted, err := guard.DeleteStale(ctx)
+	if err != nil {
+		t.Fatalf("delete stale: %v", err)
+	}
+	if deleted != 2 {
+		t.Fatalf("expected the two hour-old entries to be deleted, got %d", deleted)
+	}
+
+	entries, err := guard.List(ctx, 10)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Scope != ScopeLoginIP || entries[0].Subject != "192.0.2.2" {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+}
+
+func TestNilGuardAllowsEverything(t *testing.T) {
+	t.Parallel()
+
+	var guard *Guard
+	if err := guard.RecordFailure(context.Background(), LoginIP("192.0.2.1")); err != nil {
+		t.Fatalf("record failure: %v", err)
+	}
+	if err := guard.Check(context.Background(), LoginIP("192.0.2.1")); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+}
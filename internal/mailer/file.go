@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileMailer writes each message as an .eml file in a directory, for development and tests
+// where the messages need to be opened or inspected.
+type FileMailer struct {
+	directory string
+	from      string
+	now       func() time.Time
+
+	mu       sync.Mutex
+	sequence int
+}
+
+func NewFileMailer(directory string, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(directory, 0o700); err != nil {
+		return nil, fmt.Errorf("create mail directory: %w", err)
+	}
+
+	return &FileMailer{directory: directory, from: from, now: time.Now}, nil
+}
+
+func (m *FileMailer) Send(ctx context.Context, message Message) error {
+	if err := validateMessage(message); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.sequence++
+	sequence := m.sequence
+	m.mu.Unlock()
+
+	now := m.now()
+	name := fmt.Sprintf("%s-%04d.eml", now.UTC().Format("20060102T150405.000000000"), sequence)
+	if err := os.WriteFile(filepath.Join(m.directory, name), formatMessage(m.from, message, now), 0o600); err != nil {
+		return fmt.Errorf("write mail file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+type Message struct {
+	To      []string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// LogMailer writes outgoing messages to a logger instead of delivering them.
+type LogMailer struct {
+	logger *log.Logger
+}
+
+func NewLogMailer(logger *log.Logger) *LogMailer {
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	return &LogMailer{logger: logger}
+}
+
+func (m *LogMailer) Send(ctx context.Context, message Message) error {
+	if err := validateMessage(message); err != nil {
+		return err
+	}
+
+	m.logger.Printf("mail to=%s subject=%q\n%s", strings.Join(message.To, ","), message.Subject, message.Body)
+	return nil
+}
+
+func validateMessage(message Message) error {
+	if len(message.To) == 0 {
+		return fmt.Errorf("message has no recipients")
+	}
+	for _, recipient := range message.To {
+		if strings.TrimSpace(recipient) == "" {
+			return fmt.Errorf("message has an empty recipient")
+		}
+	}
+	if strings.TrimSpace(message.Subject) == "" {
+		return fmt.Errorf("message subject is required")
+	}
+	for _, header := range append([]string{message.Subject}, message.To...) {
+		if strings.ContainsAny(header, "\r\n") {
+			return fmt.Errorf("message headers must not contain line breaks")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package mailer
+
+import (
+	"context"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSMTPMailerSendsFormattedMessage(t *testing.T) {
+	t.Parallel()
+
+	smtpMailer, err := NewSMTPMailer(SMTPConfig{
+		Host:     "smtp.example.com",
+		Username: "mailer",
+		Password: "secret",
+		From:     "BBAAS <no-reply@example.com>",
+	})
+	if err != nil {
+		t.Fatalf("create SMTP mailer: %v", err)
+	}
+
+	var sentAddr, sentFrom string
+	var sentTo []string
+	var sentMessage []byte
+	smtpMailer.send = func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+		if auth == nil {
+			t.Fatalf("expected credentials to be used")
+		}
+		sentAddr, sentFrom, sentTo, sentMessage = addr, from, to, msg
+		return nil
+	}
+
+	err = smtpMailer.Send(context.Background(), Message{
+		To:      []string{"owner@example.com"},
+		Subject: "Hello",
+		Body:    "line one\nline two\n",
+	})
+	if err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+
+	if sentAddr != "smtp.example.com:587" || sentFrom != "no-reply@example.com" {
+		t.Fatalf("unexpected envelope %s from %s", sentAddr, sentFrom)
+	}
+	if len(sentTo) != 1 || sentTo[0] != "owner@example.com" {
+		t.Fatalf("unexpected recipients %v", sentTo)
+	}
+	rendered := string(sentMessage)
+	for _, expected := range []string{
+		"From: BBAAS <no-reply@example.com>\r\n",
+		"To: owner@example.com\r\n",
+		"Subject: Hello\r\n",
+		"\r\n\r\nline one\r\nline two\r\n",
+	} {
+		if !strings.Contains(rendered, expected) {
+			t.Fatalf("expected message to contain %q, got %q", expected, rendered)
+		}
+	}
+}
+
+func TestMailersRejectHeaderInjection(t *testing.T) {
+	t.Parallel()
+
+	fileMailer, err := NewFileMailer(t.TempDir(), "no-reply@example.com")
+	if err != nil {
+		t.Fatalf("create file mailer: %v", err)
+	}
+
+	for _, message := range []Message{
+		{To: []string{"owner@example.com"}, Subject: "Hello\r\nBcc: victim@example.com"},
+		{To: []string{"owner@example.com\nBcc: victim@example.com"}, Subject: "Hello"},
+	} {
+		if err := fileMailer.Send(context.Background(), message); err == nil {
+			t.Fatalf("expected message %+v to be rejected", message)
+		}
+	}
+}
+
+func TestFileMailerWritesMessages(t *testing.T) {
+	t.Parallel()
+
+	directory := filepath.Join(t.TempDir(), "mail")
+	fileMailer, err := NewFileMailer(directory, "no-reply@example.com")
+	if err != nil {
+		t.Fatalf("create file mailer: %v", err)
+	}
+
+	for _, subject := range []string{"First", "Second"} {
+		if err := fileMailer.Send(context.Background(), Message{To: []string{"owner@example.com"}, Subject: subject, Body: "body"}); err != nil {
+			t.Fatalf("send message: %v", err)
+		}
+	}
+
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		t.Fatalf("read mail directory: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected two message files, got %d", len(entries))
+	}
+
+	contents, err := os.ReadFile(filepath.Join(directory, entries[1].Name()))
+	if err != nil {
+		t.Fatalf("read message file: %v", err)
+	}
+	if !strings.Contains(string(contents), "Subject: Second\r\n") {
+		t.Fatalf("expected files to sort in send order, got %q", contents)
+	}
+}
@@ -0,0 +1,84 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// SMTPMailer delivers messages through an SMTP relay. The connection is upgraded with
+// STARTTLS when the server offers it; credentials are only sent over TLS or to localhost.
+type SMTPMailer struct {
+	config SMTPConfig
+	// envelopeFrom is the bare address from config.From, used for the SMTP MAIL command.
+	envelopeFrom string
+	send         func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func NewSMTPMailer(config SMTPConfig) (*SMTPMailer, error) {
+	config.Host = strings.TrimSpace(config.Host)
+	config.From = strings.TrimSpace(config.From)
+	if config.Host == "" {
+		return nil, fmt.Errorf("SMTP host is required")
+	}
+	if config.From == "" {
+		return nil, fmt.Errorf("SMTP sender address is required")
+	}
+	sender, err := mail.ParseAddress(config.From)
+	if err != nil {
+		return nil, fmt.Errorf("parse SMTP sender address: %w", err)
+	}
+	if strings.TrimSpace(config.Port) == "" {
+		config.Port = "587"
+	}
+
+	return &SMTPMailer{config: config, envelopeFrom: sender.Address, send: smtp.SendMail}, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, message Message) error {
+	if err := validateMessage(message); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.config.Username != "" {
+		auth = smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)
+	}
+
+	addr := net.JoinHostPort(m.config.Host, m.config.Port)
+	if err := m.send(addr, auth, m.envelopeFrom, message.To, formatMessage(m.config.From, message, time.Now())); err != nil {
+		return fmt.Errorf("send mail via %s: %w", addr, err)
+	}
+
+	return nil
+}
+
+// formatMessage renders a plain-text RFC 5322 message.
+func formatMessage(from string, message Message, date time.Time) []byte {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "From: %s\r\n", from)
+	fmt.Fprintf(&builder, "To: %s\r\n", strings.Join(message.To, ", "))
+	fmt.Fprintf(&builder, "Subject: %s\r\n", message.Subject)
+	fmt.Fprintf(&builder, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	builder.WriteString("MIME-Version: 1.0\r\n")
+	builder.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	builder.WriteString("\r\n")
+	builder.WriteString(strings.ReplaceAll(strings.ReplaceAll(message.Body, "\r\n", "\n"), "\n", "\r\n"))
+
+	return []byte(builder.String())
+}
@@ -0,0 +1,343 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/audit"
+	"github.com/brian-nunez/bbaas-api/internal/authorization"
+	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/mailer"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+	"github.com/brian-nunez/bbaas-api/internal/users"
+)
+
+const (
+	// InvitationTTL is how long an emailed invitation link stays valid.
+	InvitationTTL = 7 * 24 * time.Hour
+	// InvitationRetention is how long expired invitations stay listed so they can be resent.
+	InvitationRetention = 30 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidEmail            = errors.New("a valid email address is required")
+	ErrAlreadyInvited          = errors.New("that email address already has a pending invitation")
+	ErrInvitationNotFound      = errors.New("invitation not found")
+	ErrInvalidInvitation       = errors.New("invitation link is invalid or has expired")
+	ErrInvitationEmailMismatch = errors.New("this invitation was sent to a different email address")
+)
+
+type Invitation struct {
+	ID        string
+	Email     string
+	Role      string
+	ExpiresAt time.Time
+	SentAt    time.Time
+	CreatedAt time.Time
+}
+
+func (i Invitation) Expired(now time.Time) bool {
+	return !now.Before(i.ExpiresAt)
+}
+
+// InvitationDetails is what an invitee sees before accepting.
+type InvitationDetails struct {
+	Invitation     Invitation
+	Organization   Organization
+	InvitedByEmail string
+}
+
+// Invitations emails signed, expiring links that add the recipient to an organization.
+// Applications have no invitations of their own: access to them follows organization roles.
+type Invitations struct {
+	service     *Service
+	tokenHasher *security.TokenHasher
+	mailer      mailer.Mailer
+	baseURL     string
+	now         func() time.Time
+}
+
+func NewInvitations(service *Service, tokenHasher *security.TokenHasher, mailer mailer.Mailer, baseURL string) *Invitations {
+	return &Invitations{
+		service:     service,
+		tokenHasher: tokenHasher,
+		mailer:      mailer,
+		baseURL:     strings.TrimRight(strings.TrimSpace(baseURL), "/"),
+		now:         time.Now,
+	}
+}
+
+// Invite emails email a link to join the organization with role. The address does not need an
+// account yet.
+func (i *Invitations) Invite(ctx context.Context, actor users.User, organizationID string, email string, role string) (Invitation, error) {
+	record, _, err := i.service.getAuthorizedOrganization(ctx, actor, organizationID, "organizations.members.manage")
+	if err != nil {
+		return Invitation{}, err
+	}
+	if record.PersonalUserID != "" {
+		return Invitation{}, ErrPersonalOrganization
+	}
+	if !authorization.IsOrganizationRole(role) {
+		return Invitation{}, ErrInvalidRole
+	}
+	if role == authorization.OrganizationRoleOwner && !i.service.can(ctx, actor, record.ID, "organizations.owners.manage") {
+		return Invitation{}, ErrForbidden
+	}
+
+	email = strings.ToLower(strings.TrimSpace(email))
+	if address, err := mail.ParseAddress(email); err != nil || address.Address != email {
+		return Invitation{}, ErrInvalidEmail
+	}
+
+	user, found, err := i.service.store.GetUserByEmail(ctx, email)
+	if err != nil {
+		return Invitation{}, fmt.Errorf("lookup user by email: %w", err)
+	}
+	if found {
+		_, isMember, err := i.service.store.GetOrganizationMemberRole(ctx, record.ID, user.ID)
+		if err != nil {
+			return Invitation{}, fmt.Errorf("lookup organization member: %w", err)
+		}
+		if isMember {
+			return Invitation{}, ErrAlreadyMember
+		}
+	}
+
+	invitationID, err := security.GeneratePrefixedToken("inv", 12)
+	if err != nil {
+		return Invitation{}, fmt.Errorf("generate invitation id: %w", err)
+	}
+	invitationToken, err := security.GeneratePrefixedToken("ivt", 24)
+	if err != nil {
+		return Invitation{}, fmt.Errorf("generate invitation token: %w", err)
+	}
+
+	now := i.now().UTC()
+	invitation := data.InvitationRecord{
+		ID:              invitationID,
+		OrganizationID:  record.ID,
+		Email:           email,
+		Role:            role,
+		TokenHash:       i.tokenHasher.Hash(invitationToken).Hash,
+		InvitedByUserID: actor.ID,
+		ExpiresAt:       now.Add(InvitationTTL),
+		SentAt:          now,
+		CreatedAt:       now,
+	}
+	created, err := i.service.store.CreateInvitation(ctx, invitation)
+	if err != nil {
+		return Invitation{}, fmt.Errorf("create invitation: %w", err)
+	}
+	if !created {
+		return Invitation{}, ErrAlreadyInvited
+	}
+
+	if err := i.mailer.Send(ctx, i.buildInvitationMessage(invitation, record.Name, actor.Email, invitationToken)); err != nil {
+		return Invitation{}, fmt.Errorf("send invitation email: %w", err)
+	}
+
+	i.service.recordEvent(ctx, actor.ID, record.ID, "invitation.sent", "invitation", invitation.ID, map[string]audit.Change{
+		"email": {From: nil, To: invitation.Email},
+		"role":  {From: nil, To: invitation.Role},
+	})
+
+	return mapInvitationRecord(invitation), nil
+}
+
+// List returns the organization's outstanding invitations, newest first, including recently
+// expired ones.
+func (i *Invitations) List(ctx context.Context, actor users.User, organizationID string) ([]Invitation, error) {
+	record, _, err := i.service.getAuthorizedOrganization(ctx, actor, organizationID, "organizations.members.manage")
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := i.service.store.ListInvitationsByOrganizationID(ctx, record.ID)
+	if err != nil {
+		return nil, fmt.Errorf("list invitations: %w", err)
+	}
+
+	invitations := make([]Invitation, 0, len(records))
+	for _, invitationRecord := range records {
+		invitations = append(invitations, mapInvitationRecord(invitationRecord))
+	}
+
+	return invitations, nil
+}
+
+// Resend emails a fresh link and restarts the expiry; the previous link stops working.
+func (i *Invitations) Resend(ctx context.Context, actor users.User, organizationID string, invitationID string) (Invitation, error) {
+	record, _, err := i.service.getAuthorizedOrganization(ctx, actor, organizationID, "organizations.members.manage")
+	if err != nil {
+		return Invitation{}, err
+	}
+
+	previous, found, err := i.service.store.GetInvitationByID(ctx, record.ID, strings.TrimSpace(invitationID))
+	if err != nil {
+		return Invitation{}, fmt.Errorf("lookup invitation: %w", err)
+	}
+	if !found {
+		return Invitation{}, ErrInvitationNotFound
+	}
+	// A fresh link for an owner invitation grants ownership just like a new invitation.
+	if previous.Role == authorization.OrganizationRoleOwner && !i.service.can(ctx, actor, record.ID, "organizations.owners.manage") {
+		return Invitation{}, ErrForbidden
+	}
+
+	invitationToken, err := security.GeneratePrefixedToken("ivt", 24)
+	if err != nil {
+		return Invitation{}, fmt.Errorf("generate invitation token: %w", err)
+	}
+
+	now := i.now().UTC()
+	invitation, found, err := i.service.store.RenewInvitation(ctx, record.ID, previous.ID, i.tokenHasher.Hash(invitationToken).Hash, now.Add(InvitationTTL), now)
+	if err != nil {
+		return Invitation{}, fmt.Errorf("renew invitation: %w", err)
+	}
+	if !found {
+		return Invitation{}, ErrInvitationNotFound
+	}
+
+	if err := i.mailer.Send(ctx, i.buildInvitationMessage(invitation, record.Name, actor.Email, invitationToken)); err != nil {
+		return Invitation{}, fmt.Errorf("send invitation email: %w", err)
+	}
+
+	i.service.recordEvent(ctx, actor.ID, record.ID, "invitation.resent", "invitation", invitation.ID, map[string]audit.Change{
+		"expires_at": {From: previous.ExpiresAt, To: invitation.ExpiresAt},
+	})
+
+	return mapInvitationRecord(invitation), nil
+}
+
+func (i *Invitations) Revoke(ctx context.Context, actor users.User, organizationID string, invitationID string) error {
+	record, _, err := i.service.getAuthorizedOrganization(ctx, actor, organizationID, "organizations.members.manage")
+	if err != nil {
+		return err
+	}
+
+	deleted, err := i.service.store.DeleteInvitation(ctx, record.ID, strings.TrimSpace(invitationID))
+	if err != nil {
+		return fmt.Errorf("revoke invitation: %w", err)
+	}
+	if !deleted {
+		return ErrInvitationNotFound
+	}
+
+	i.service.recordEvent(ctx, actor.ID, record.ID, "invitation.revoked", "invitation", strings.TrimSpace(invitationID), nil)
+
+	return nil
+}
+
+// Lookup describes the invitation behind a token so the invitee can decide whether to accept.
+func (i *Invitations) Lookup(ctx context.Context, invitationToken string) (InvitationDetails, error) {
+	invitation, err := i.getValidInvitation(ctx, invitationToken)
+	if err != nil {
+		return InvitationDetails{}, err
+	}
+
+	organization, found, err := i.service.store.GetOrganizationByID(ctx, invitation.OrganizationID)
+	if err != nil {
+		return InvitationDetails{}, fmt.Errorf("lookup invited organization: %w", err)
+	}
+	if !found {
+		return InvitationDetails{}, ErrInvalidInvitation
+	}
+
+	details := InvitationDetails{
+		Invitation:   mapInvitationRecord(invitation),
+		Organization: mapOrganizationRecord(organization),
+	}
+
+	inviter, found, err := i.service.store.GetUserByID(ctx, invitation.InvitedByUserID)
+	if err != nil {
+		return InvitationDetails{}, fmt.Errorf("lookup inviting user: %w", err)
+	}
+	if found {
+		details.InvitedByEmail = inviter.Email
+	}
+
+	return details, nil
+}
+
+// Accept adds the actor to the invited organization. The actor must be signed in with the
+// address the invitation was sent to; accepting also marks that address verified.
+func (i *Invitations) Accept(ctx context.Context, actor users.User, invitationToken string) (Membership, error) {
+	invitation, err := i.getValidInvitation(ctx, invitationToken)
+	if err != nil {
+		return Membership{}, err
+	}
+	if !strings.EqualFold(invitation.Email, actor.Email) {
+		return Membership{}, ErrInvitationEmailMismatch
+	}
+
+	_, accepted, err := i.service.store.AcceptInvitation(ctx, invitation.TokenHash, actor.ID, i.now().UTC())
+	if err != nil {
+		return Membership{}, fmt.Errorf("accept invitation: %w", err)
+	}
+	if !accepted {
+		return Membership{}, ErrInvalidInvitation
+	}
+	i.service.recordEvent(ctx, actor.ID, invitation.OrganizationID, "invitation.accepted", "invitation", invitation.ID, map[string]audit.Change{
+		"role": {From: nil, To: invitation.Role},
+	})
+
+	return i.service.Get(ctx, actor, invitation.OrganizationID)
+}
+
+func (i *Invitations) DeleteExpired(ctx context.Context) (int, error) {
+	deleted, err := i.service.store.DeleteExpiredInvitations(ctx, i.now().UTC().Add(-InvitationRetention))
+	if err != nil {
+		return 0, fmt.Errorf("delete expired invitations: %w", err)
+	}
+
+	return deleted, nil
+}
+
+func (i *Invitations) getValidInvitation(ctx context.Context, invitationToken string) (data.InvitationRecord, error) {
+	invitationToken = strings.TrimSpace(invitationToken)
+	if invitationToken == "" {
+		return data.InvitationRecord{}, ErrInvalidInvitation
+	}
+
+	invitation, found, err := i.service.store.GetInvitationByTokenHash(ctx, i.tokenHasher.Hash(invitationToken).Hash)
+	if err != nil {
+		return data.InvitationRecord{}, fmt.Errorf("lookup invitation: %w", err)
+	}
+	if !found || !i.now().UTC().Before(invitation.ExpiresAt) {
+		return data.InvitationRecord{}, ErrInvalidInvitation
+	}
+
+	return invitation, nil
+}
+
+func (i *Invitations) buildInvitationMessage(invitation data.InvitationRecord, organizationName string, inviterEmail string, invitationToken string) mailer.Message {
+	acceptURL := i.baseURL + "/invitations/accept?token=" + url.QueryEscape(invitationToken)
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s invited you to join %s on BBAAS as %s.\n\n", inviterEmail, organizationName, invitation.Role)
+	body.WriteString("Accept the invitation here; you can create an account if you do not have one yet:\n")
+	fmt.Fprintf(&body, "%s\n\n", acceptURL)
+	body.WriteString("The link expires in 7 days. If you were not expecting this, you can ignore this email.\n")
+
+	return mailer.Message{
+		To:      []string{invitation.Email},
+		Subject: fmt.Sprintf("You're invited to %s on BBAAS", organizationName),
+		Body:    body.String(),
+	}
+}
+
+func mapInvitationRecord(record data.InvitationRecord) Invitation {
+	return Invitation{
+		ID:        record.ID,
+		Email:     record.Email,
+		Role:      record.Role,
+		ExpiresAt: record.ExpiresAt,
+		SentAt:    record.SentAt,
+		CreatedAt: record.CreatedAt,
+	}
+}
@@ -0,0 +1,253 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/audit"
+	"github.com/brian-nunez/bbaas-api/internal/authorization"
+	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/data/datatest"
+	"github.com/brian-nunez/bbaas-api/internal/mailer"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+)
+
+func TestInvitations(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	service := NewService(store, authorization.NewWebAuthorizer())
+	service.UseAuditRecorder(audit.NewRecorder(store))
+	outbox := &recordingMailer{}
+	invitations := NewInvitations(service, security.NewTokenHasher("test-pepper"), outbox, "https://bbaas.example.com")
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	invitations.now = func() time.Time { return now }
+
+	register(t, store, "root@example.com")
+	owner := register(t, store, "owner@example.com")
+	member := register(t, store, "member@example.com")
+	stranger := register(t, store, "stranger@example.com")
+
+	organization, err := service.Create(ctx, owner, "Platform Team")
+	if err != nil {
+		t.Fatalf("create organization: %v", err)
+	}
+	if _, err := service.AddMember(ctx, owner, organization.ID, member.Email, authorization.OrganizationRoleViewer); err != nil {
+		t.Fatalf("add member: %v", err)
+	}
+
+	if _, err := invitations.Invite(ctx, member, organization.ID, "new@example.com", authorization.OrganizationRoleViewer); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected viewers not to invite, got %v", err)
+	}
+	if _, err := invitations.Invite(ctx, owner, organization.ID, "member@example.com", authorization.OrganizationRoleViewer); !errors.Is(err, ErrAlreadyMember) {
+		t.Fatalf("expected ErrAlreadyMember, got %v", err)
+	}
+	if _, err := invitations.Invite(ctx, owner, organization.ID, "not an email", authorization.OrganizationRoleViewer); !errors.Is(err, ErrInvalidEmail) {
+		t.Fatalf("expected ErrInvalidEmail, got %v", err)
+	}
+	personal := data.PersonalOrganizationID(owner.ID)
+	if _, err := invitations.Invite(ctx, owner, personal, "new@example.com", authorization.OrganizationRoleViewer); !errors.Is(err, ErrPersonalOrganization) {
+		t.Fatalf("expected ErrPersonalOrganization, got %v", err)
+	}
+
+	invitation, err := invitations.Invite(ctx, owner, organization.ID, " New@Example.com ", authorization.OrganizationRoleDeveloper)
+	if err != nil {
+		t.Fatalf("invite: %v", err)
+	}
+	if invitation.Email != "new@example.com" || !invitation.ExpiresAt.Equal(now.Add(InvitationTTL)) {
+		t.Fatalf("unexpected invitation %+v", invitation)
+	}
+	if _, err := invitations.Invite(ctx, owner, organization.ID, "new@example.com", authorization.OrganizationRoleViewer); !errors.Is(err, ErrAlreadyInvited) {
+		t.Fatalf("expected ErrAlreadyInvited, got %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	if _, err := invitations.Resend(ctx, owner, organization.ID, invitation.ID); err != nil {
+		t.Fatalf("resend invitation: %v", err)
+	}
+	messages := outbox.sent()
+	if len(messages) != 2 {
+		t.Fatalf("expected two invitation emails, got %d", len(messages))
+	}
+	firstToken := invitationTokenFromMessage(t, messages[0])
+	secondToken := invitationTokenFromMessage(t, messages[1])
+	if _, err := invitations.Lookup(ctx, firstToken); !errors.Is(err, ErrInvalidInvitation) {
+		t.Fatalf("expected a resent invitation to retire the old link, got %v", err)
+	}
+
+	details, err := invitations.Lookup(ctx, secondToken)
+	if err != nil {
+		t.Fatalf("lookup invitation: %v", err)
+	}
+	if details.Organization.ID != organization.ID || details.InvitedByEmail != owner.Email || details.Invitation.Role != authorization.OrganizationRoleDeveloper {
+		t.Fatalf("unexpected invitation details %+v", details)
+	}
+
+	if _, err := invitations.Accept(ctx, stranger, secondToken); !errors.Is(err, ErrInvitationEmailMismatch) {
+		t.Fatalf("expected ErrInvitationEmailMismatch, got %v", err)
+	}
+
+	invitee := register(t, store, "new@example.com")
+	if invitee.IsVerified() {
+		t.Fatalf("expected the invitee to start unverified")
+	}
+	membership, err := invitations.Accept(ctx, invitee, secondToken)
+	if err != nil {
+		t.Fatalf("accept invitation: %v", err)
+	}
+	if membership.Organization.ID != organization.ID || membership.Role != authorization.OrganizationRoleDeveloper {
+		t.Fatalf("unexpected membership %+v", membership)
+	}
+	if _, err := invitations.Accept(ctx, invitee, secondToken); !errors.Is(err, ErrInvalidInvitation) {
+		t.Fatalf("expected an accepted invitation to be single use, got %v", err)
+	}
+
+	inviteeRecord, _, err := store.GetUserByID(ctx, invitee.ID)
+	if err != nil {
+		t.Fatalf("reload invitee: %v", err)
+	}
+	if inviteeRecord.VerifiedAt == nil {
+		t.Fatalf("expected accepting an invitation to verify the email address")
+	}
+
+	pending, err := invitations.List(ctx, owner, organization.ID)
+	if err != nil {
+		t.Fatalf("list invitations: %v", err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("expected no pending invitations, got %+v", pending)
+	}
+
+	admin := register(t, store, "admin@example.com")
+	if _, err := service.AddMember(ctx, owner, organization.ID, admin.Email, authorization.OrganizationRoleAdmin); err != nil {
+		t.Fatalf("add admin: %v", err)
+	}
+	ownerInvitation, err := invitations.Invite(ctx, owner, organization.ID, "co-owner@example.com", authorization.OrganizationRoleOwner)
+	if err != nil {
+		t.Fatalf("invite owner: %v", err)
+	}
+	if _, err := invitations.Resend(ctx, admin, organization.ID, ownerInvitation.ID); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected admins not to resend owner invitations, got %v", err)
+	}
+	now = now.Add(time.Hour)
+	if _, err := invitations.Resend(ctx, owner, organization.ID, ownerInvitation.ID); err != nil {
+		t.Fatalf("resend owner invitation: %v", err)
+	}
+	events, _, err := store.SearchAuditEvents(ctx, data.AuditEventFilter{OrganizationIDs: []string{organization.ID}, Action: "invitation.resent"}, 10, 0)
+	if err != nil {
+		t.Fatalf("list audit events: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected both resends to be audited, got %+v", events)
+	}
+}
+
+func TestInvitationsExpireAndRevoke(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	service := NewService(store, authorization.NewWebAuthorizer())
+	outbox := &recordingMailer{}
+	invitations := NewInvitations(service, security.NewTokenHasher("test-pepper"), outbox, "https://bbaas.example.com")
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	invitations.now = func() time.Time { return now }
+
+	register(t, store, "root@example.com")
+	owner := register(t, store, "owner@example.com")
+	admin := register(t, store, "admin@example.com")
+	late := register(t, store, "late@example.com")
+
+	organization, err := service.Create(ctx, owner, "Platform Team")
+	if err != nil {
+		t.Fatalf("create organization: %v", err)
+	}
+	if _, err := service.AddMember(ctx, owner, organization.ID, admin.Email, authorization.OrganizationRoleAdmin); err != nil {
+		t.Fatalf("add admin: %v", err)
+	}
+	if _, err := invitations.Invite(ctx, admin, organization.ID, "boss@example.com", authorization.OrganizationRoleOwner); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected admins not to invite owners, got %v", err)
+	}
+
+	revoked, err := invitations.Invite(ctx, admin, organization.ID, "revoked@example.com", authorization.OrganizationRoleViewer)
+	if err != nil {
+		t.Fatalf("invite: %v", err)
+	}
+	if err := invitations.Revoke(ctx, admin, organization.ID, revoked.ID); err != nil {
+		t.Fatalf("revoke invitation: %v", err)
+	}
+	if err := invitations.Revoke(ctx, admin, organization.ID, revoked.ID); !errors.Is(err, ErrInvitationNotFound) {
+		t.Fatalf("expected ErrInvitationNotFound, got %v", err)
+	}
+	if _, err := invitations.Lookup(ctx, invitationTokenFromMessage(t, outbox.sent()[0])); !errors.Is(err, ErrInvalidInvitation) {
+		t.Fatalf("expected a revoked invitation to be rejected, got %v", err)
+	}
+
+	if _, err := invitations.Invite(ctx, owner, organization.ID, late.Email, authorization.OrganizationRoleViewer); err != nil {
+		t.Fatalf("invite: %v", err)
+	}
+	now = now.Add(InvitationTTL)
+	if _, err := invitations.Accept(ctx, late, invitationTokenFromMessage(t, outbox.sent()[1])); !errors.Is(err, ErrInvalidInvitation) {
+		t.Fatalf("expected an expired invitation to be rejected, got %v", err)
+	}
+
+	pending, err := invitations.List(ctx, owner, organization.ID)
+	if err != nil {
+		t.Fatalf("list invitations: %v", err)
+	}
+	if len(pending) != 1 || !pending[0].Expired(now) {
+		t.Fatalf("expected the expired invitation to stay listed, got %+v", pending)
+	}
+
+	now = now.Add(InvitationRetention)
+	deleted, err := invitations.DeleteExpired(ctx)
+	if err != nil {
+		t.Fatalf("delete expired invitations: %v", err)
+	}
+	if deleted != 1 {
+		t.Fatalf("expected the expired invitation to be deleted, deleted %d", deleted)
+	}
+}
+
+var invitationLinkPattern = regexp.MustCompile(`https://bbaas\.example\.com/invitations/accept\?token=(\S+)`)
+
+func invitationTokenFromMessage(t *testing.T, message mailer.Message) string {
+	t.Helper()
+
+	match := invitationLinkPattern.FindStringSubmatch(message.Body)
+	if match == nil {
+		t.Fatalf("expected an invitation link in %q", message.Body)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatalf("decode invitation token: %v", err)
+	}
+
+	return token
+}
+
+type recordingMailer struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+}
+
+func (m *recordingMailer) Send(_ context.Context, message mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+func (m *recordingMailer) sent() []mailer.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]mailer.Message(nil), m.messages...)
+}
@@ -0,0 +1,348 @@
+// Package organizations groups users into teams that share applications. Every user has a
+// personal organization of their own; shared organizations add members with one of the roles
+// in authorization.OrganizationRoles.
+package organizations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/audit"
+	"github.com/brian-nunez/bbaas-api/internal/authorization"
+	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+	"github.com/brian-nunez/bbaas-api/internal/users"
+)
+
+var (
+	ErrOrganizationNotFound     = errors.New("organization not found")
+	ErrOrganizationNameRequired = errors.New("organization name is required")
+	ErrOrganizationNameTooLong  = errors.New("organization name cannot be longer than 80 characters")
+	ErrInvalidRole              = errors.New("role must be owner, admin, developer or viewer")
+	ErrUserNotFound             = errors.New("no user is registered with that email address")
+	ErrAlreadyMember            = errors.New("user is already a member of this organization")
+	ErrMemberNotFound           = errors.New("member not found")
+	ErrLastOwner                = errors.New("an organization must keep at least one owner")
+	ErrPersonalOrganization     = errors.New("personal organizations cannot have other members")
+	ErrForbidden                = errors.New("forbidden")
+)
+
+type Organization struct {
+	ID        string
+	Name      string
+	Personal  bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Membership is an organization as seen by one user. Role is empty for site admins looking
+// at an organization they do not belong to.
+type Membership struct {
+	Organization Organization
+	Role         string
+}
+
+func (m Membership) CanManageMembers() bool {
+	return m.Role == authorization.OrganizationRoleOwner || m.Role == authorization.OrganizationRoleAdmin
+}
+
+type Member struct {
+	UserID   string
+	Email    string
+	Role     string
+	JoinedAt time.Time
+}
+
+type Service struct {
+	store         *data.Store
+	webAuthorizer *authorization.WebAuthorizer
+	audit         *audit.Recorder
+	now           func() time.Time
+}
+
+func NewService(store *data.Store, webAuthorizer *authorization.WebAuthorizer) *Service {
+	return &Service{
+		store:         store,
+		webAuthorizer: webAuthorizer,
+		now:           time.Now,
+	}
+}
+
+// UseAuditRecorder records membership changes and invitations in the audit trail.
+func (s *Service) UseAuditRecorder(recorder *audit.Recorder) {
+	s.audit = recorder
+}
+
+// Create starts a shared organization with the actor as its owner.
+func (s *Service) Create(ctx context.Context, actor users.User, name string) (Organization, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Organization{}, ErrOrganizationNameRequired
+	}
+	if len(name) > 80 {
+		return Organization{}, ErrOrganizationNameTooLong
+	}
+
+	organizationID, err := security.GeneratePrefixedToken("org", 14)
+	if err != nil {
+		return Organization{}, fmt.Errorf("generate organization id: %w", err)
+	}
+
+	now := s.now().UTC()
+	record := data.OrganizationRecord{
+		ID:        organizationID,
+		Name:      name,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	owner := data.OrganizationMemberRecord{
+		OrganizationID: organizationID,
+		UserID:         actor.ID,
+		Role:           authorization.OrganizationRoleOwner,
+		CreatedAt:      now,
+	}
+	if err := s.store.CreateOrganization(ctx, record, owner); err != nil {
+		return Organization{}, fmt.Errorf("create organization: %w", err)
+	}
+
+	s.recordEvent(ctx, actor.ID, record.ID, "organization.created", "organization", record.ID, map[string]audit.Change{
+		"name": {From: nil, To: record.Name},
+	})
+
+	return mapOrganizationRecord(record), nil
+}
+
+// ListForUser returns the organizations the actor belongs to, personal one first.
+func (s *Service) ListForUser(ctx context.Context, actor users.User) ([]Membership, error) {
+	records, err := s.store.ListOrganizationsByUserID(ctx, actor.ID)
+	if err != nil {
+		return nil, fmt.Errorf("list organizations for user: %w", err)
+	}
+
+	memberships := make([]Membership, 0, len(records))
+	for _, record := range records {
+		memberships = append(memberships, Membership{
+			Organization: mapOrganizationRecord(record.Organization),
+			Role:         record.Role,
+		})
+	}
+
+	return memberships, nil
+}
+
+func (s *Service) Get(ctx context.Context, actor users.User, organizationID string) (Membership, error) {
+	record, role, err := s.getAuthorizedOrganization(ctx, actor, organizationID, "organizations.read")
+	if err != nil {
+		return Membership{}, err
+	}
+
+	return Membership{Organization: mapOrganizationRecord(record), Role: role}, nil
+}
+
+func (s *Service) ListMembers(ctx context.Context, actor users.User, organizationID string) ([]Member, error) {
+	record, _, err := s.getAuthorizedOrganization(ctx, actor, organizationID, "organizations.read")
+	if err != nil {
+		return nil, err
+	}
+
+	memberRecords, err := s.store.ListOrganizationMembers(ctx, record.ID)
+	if err != nil {
+		return nil, fmt.Errorf("list organization members: %w", err)
+	}
+
+	members := make([]Member, 0, len(memberRecords))
+	for _, memberRecord := range memberRecords {
+		members = append(members, mapMemberRecord(memberRecord))
+	}
+
+	return members, nil
+}
+
+// AddMember gives an existing user a role in the organization.
+func (s *Service) AddMember(ctx context.Context, actor users.User, organizationID string, email string, role string) (Member, error) {
+	record, _, err := s.getAuthorizedOrganization(ctx, actor, organizationID, "organizations.members.manage")
+	if err != nil {
+		return Member{}, err
+	}
+	if record.PersonalUserID != "" {
+		return Member{}, ErrPersonalOrganization
+	}
+	if !authorization.IsOrganizationRole(role) {
+		return Member{}, ErrInvalidRole
+	}
+	if role == authorization.OrganizationRoleOwner && !s.can(ctx, actor, record.ID, "organizations.owners.manage") {
+		return Member{}, ErrForbidden
+	}
+
+	user, found, err := s.store.GetUserByEmail(ctx, strings.ToLower(strings.TrimSpace(email)))
+	if err != nil {
+		return Member{}, fmt.Errorf("lookup user by email: %w", err)
+	}
+	if !found {
+		return Member{}, ErrUserNotFound
+	}
+
+	member := data.OrganizationMemberRecord{
+		OrganizationID: record.ID,
+		UserID:         user.ID,
+		Role:           role,
+		CreatedAt:      s.now().UTC(),
+		Email:          user.Email,
+	}
+	added, err := s.store.AddOrganizationMember(ctx, member)
+	if err != nil {
+		return Member{}, fmt.Errorf("add organization member: %w", err)
+	}
+	if !added {
+		return Member{}, ErrAlreadyMember
+	}
+
+	s.recordEvent(ctx, actor.ID, record.ID, "organization.member_added", "user", member.UserID, map[string]audit.Change{
+		"role": {From: nil, To: role},
+	})
+
+	return mapMemberRecord(member), nil
+}
+
+func (s *Service) UpdateMemberRole(ctx context.Context, actor users.User, organizationID string, userID string, role string) error {
+	record, _, err := s.getAuthorizedOrganization(ctx, actor, organizationID, "organizations.members.manage")
+	if err != nil {
+		return err
+	}
+	if !authorization.IsOrganizationRole(role) {
+		return ErrInvalidRole
+	}
+
+	currentRole, found, err := s.store.GetOrganizationMemberRole(ctx, record.ID, strings.TrimSpace(userID))
+	if err != nil {
+		return fmt.Errorf("lookup organization member: %w", err)
+	}
+	if !found {
+		return ErrMemberNotFound
+	}
+	touchesOwner := currentRole == authorization.OrganizationRoleOwner || role == authorization.OrganizationRoleOwner
+	if touchesOwner && !s.can(ctx, actor, record.ID, "organizations.owners.manage") {
+		return ErrForbidden
+	}
+
+	updated, err := s.store.UpdateOrganizationMemberRole(ctx, record.ID, strings.TrimSpace(userID), role)
+	if err != nil {
+		return fmt.Errorf("update organization member role: %w", err)
+	}
+	if !updated {
+		return ErrLastOwner
+	}
+
+	s.recordEvent(ctx, actor.ID, record.ID, "organization.member_role_changed", "user", strings.TrimSpace(userID), map[string]audit.Change{
+		"role": {From: currentRole, To: role},
+	})
+
+	return nil
+}
+
+// RemoveMember takes a user out of the organization. Any member may remove themselves.
+func (s *Service) RemoveMember(ctx context.Context, actor users.User, organizationID string, userID string) error {
+	userID = strings.TrimSpace(userID)
+	action := "organizations.members.manage"
+	if userID == actor.ID {
+		action = "organizations.read"
+	}
+
+	record, _, err := s.getAuthorizedOrganization(ctx, actor, organizationID, action)
+	if err != nil {
+		return err
+	}
+
+	currentRole, found, err := s.store.GetOrganizationMemberRole(ctx, record.ID, userID)
+	if err != nil {
+		return fmt.Errorf("lookup organization member: %w", err)
+	}
+	if !found {
+		return ErrMemberNotFound
+	}
+	if currentRole == authorization.OrganizationRoleOwner && userID != actor.ID && !s.can(ctx, actor, record.ID, "organizations.owners.manage") {
+		return ErrForbidden
+	}
+
+	removed, err := s.store.DeleteOrganizationMember(ctx, record.ID, userID)
+	if err != nil {
+		return fmt.Errorf("remove organization member: %w", err)
+	}
+	if !removed {
+		return ErrLastOwner
+	}
+
+	s.recordEvent(ctx, actor.ID, record.ID, "organization.member_removed", "user", userID, map[string]audit.Change{
+		"role": {From: currentRole, To: nil},
+	})
+
+	return nil
+}
+
+// recordEvent adds an action within organizationID to the audit trail.
+func (s *Service) recordEvent(ctx context.Context, actorUserID string, organizationID string, action string, targetType string, targetID string, changes map[string]audit.Change) {
+	s.audit.Record(ctx, audit.Event{
+		ActorUserID:    actorUserID,
+		Action:         action,
+		TargetType:     targetType,
+		TargetID:       targetID,
+		OrganizationID: organizationID,
+		Changes:        changes,
+	})
+}
+
+func (s *Service) getAuthorizedOrganization(ctx context.Context, actor users.User, organizationID string, action string) (data.OrganizationRecord, string, error) {
+	organizationID = strings.TrimSpace(organizationID)
+	if organizationID == "" {
+		return data.OrganizationRecord{}, "", ErrOrganizationNotFound
+	}
+
+	record, found, err := s.store.GetOrganizationByID(ctx, organizationID)
+	if err != nil {
+		return data.OrganizationRecord{}, "", fmt.Errorf("lookup organization by id: %w", err)
+	}
+	if !found {
+		return data.OrganizationRecord{}, "", ErrOrganizationNotFound
+	}
+
+	subject, err := authorization.NewOrganizationSubject(ctx, actor.ID, actor.IsAdmin(), record.ID, s.store.GetOrganizationMemberRole)
+	if err != nil {
+		return data.OrganizationRecord{}, "", err
+	}
+	if !s.webAuthorizer.Can(subject, authorization.OrganizationResource{OrganizationID: record.ID}, action) {
+		return data.OrganizationRecord{}, "", ErrForbidden
+	}
+
+	return record, subject.OrganizationRoles[record.ID], nil
+}
+
+func (s *Service) can(ctx context.Context, actor users.User, organizationID string, action string) bool {
+	subject, err := authorization.NewOrganizationSubject(ctx, actor.ID, actor.IsAdmin(), organizationID, s.store.GetOrganizationMemberRole)
+	if err != nil {
+		return false
+	}
+
+	return s.webAuthorizer.Can(subject, authorization.OrganizationResource{OrganizationID: organizationID}, action)
+}
+
+func mapOrganizationRecord(record data.OrganizationRecord) Organization {
+	return Organization{
+		ID:        record.ID,
+		Name:      record.Name,
+		Personal:  record.PersonalUserID != "",
+		CreatedAt: record.CreatedAt,
+		UpdatedAt: record.UpdatedAt,
+	}
+}
+
+func mapMemberRecord(record data.OrganizationMemberRecord) Member {
+	return Member{
+		UserID:   record.UserID,
+		Email:    record.Email,
+		Role:     record.Role,
+		JoinedAt: record.CreatedAt,
+	}
+}
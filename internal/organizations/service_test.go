@@ -0,0 +1,139 @@
+package organizations
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/brian-nunez/bbaas-api/internal/authorization"
+	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/data/datatest"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+	"github.com/brian-nunez/bbaas-api/internal/users"
+)
+
+func TestMemberManagement(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	service := NewService(store, authorization.NewWebAuthorizer())
+	// The first account is the site admin, who may manage every organization.
+	register(t, store, "root@example.com")
+	owner := register(t, store, "owner@example.com")
+	manager := register(t, store, "manager@example.com")
+	viewer := register(t, store, "viewer@example.com")
+
+	organization, err := service.Create(ctx, owner, "  Platform Team ")
+	if err != nil {
+		t.Fatalf("create organization: %v", err)
+	}
+	if organization.Name != "Platform Team" || organization.Personal {
+		t.Fatalf("unexpected organization %+v", organization)
+	}
+
+	if _, err := service.AddMember(ctx, owner, organization.ID, "Manager@Example.com", authorization.OrganizationRoleAdmin); err != nil {
+		t.Fatalf("add admin: %v", err)
+	}
+	if _, err := service.AddMember(ctx, manager, organization.ID, "viewer@example.com", authorization.OrganizationRoleViewer); err != nil {
+		t.Fatalf("add viewer as organization admin: %v", err)
+	}
+	if _, err := service.AddMember(ctx, manager, organization.ID, "viewer@example.com", authorization.OrganizationRoleViewer); !errors.Is(err, ErrAlreadyMember) {
+		t.Fatalf("expected ErrAlreadyMember, got %v", err)
+	}
+	if _, err := service.AddMember(ctx, manager, organization.ID, "nobody@example.com", authorization.OrganizationRoleViewer); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("expected ErrUserNotFound, got %v", err)
+	}
+	if _, err := service.AddMember(ctx, owner, organization.ID, "viewer@example.com", "superuser"); !errors.Is(err, ErrInvalidRole) {
+		t.Fatalf("expected ErrInvalidRole, got %v", err)
+	}
+	if _, err := service.AddMember(ctx, viewer, organization.ID, "root@example.com", authorization.OrganizationRoleViewer); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected viewers not to add members, got %v", err)
+	}
+
+	// Organization admins manage members but not owners.
+	if err := service.UpdateMemberRole(ctx, manager, organization.ID, viewer.ID, authorization.OrganizationRoleOwner); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected admins not to grant ownership, got %v", err)
+	}
+	if err := service.RemoveMember(ctx, manager, organization.ID, owner.ID); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected admins not to remove owners, got %v", err)
+	}
+	if err := service.UpdateMemberRole(ctx, manager, organization.ID, viewer.ID, authorization.OrganizationRoleDeveloper); err != nil {
+		t.Fatalf("promote viewer: %v", err)
+	}
+
+	// The last owner can neither step down nor leave.
+	if err := service.UpdateMemberRole(ctx, owner, organization.ID, owner.ID, authorization.OrganizationRoleAdmin); !errors.Is(err, ErrLastOwner) {
+		t.Fatalf("expected ErrLastOwner, got %v", err)
+	}
+	if err := service.RemoveMember(ctx, owner, organization.ID, owner.ID); !errors.Is(err, ErrLastOwner) {
+		t.Fatalf("expected ErrLastOwner, got %v", err)
+	}
+	if err := service.UpdateMemberRole(ctx, owner, organization.ID, manager.ID, authorization.OrganizationRoleOwner); err != nil {
+		t.Fatalf("grant ownership: %v", err)
+	}
+	if err := service.RemoveMember(ctx, owner, organization.ID, owner.ID); err != nil {
+		t.Fatalf("expected the original owner to leave once another owner exists, got %v", err)
+	}
+
+	if err := service.RemoveMember(ctx, viewer, organization.ID, viewer.ID); err != nil {
+		t.Fatalf("expected members to leave on their own, got %v", err)
+	}
+	if _, err := service.Get(ctx, viewer, organization.ID); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected former members to lose access, got %v", err)
+	}
+
+	members, err := service.ListMembers(ctx, manager, organization.ID)
+	if err != nil {
+		t.Fatalf("list members: %v", err)
+	}
+	if len(members) != 1 || members[0].Email != "manager@example.com" || members[0].Role != authorization.OrganizationRoleOwner {
+		t.Fatalf("unexpected members %+v", members)
+	}
+}
+
+func TestPersonalOrganization(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	service := NewService(store, authorization.NewWebAuthorizer())
+	register(t, store, "root@example.com")
+	user := register(t, store, "solo@example.com")
+	register(t, store, "friend@example.com")
+
+	shared, err := service.Create(ctx, user, "Zeta Team")
+	if err != nil {
+		t.Fatalf("create organization: %v", err)
+	}
+
+	memberships, err := service.ListForUser(ctx, user)
+	if err != nil {
+		t.Fatalf("list organizations: %v", err)
+	}
+	if len(memberships) != 2 {
+		t.Fatalf("expected a personal and a shared organization, got %+v", memberships)
+	}
+	personal := memberships[0]
+	if !personal.Organization.Personal || personal.Organization.ID != data.PersonalOrganizationID(user.ID) || personal.Role != authorization.OrganizationRoleOwner {
+		t.Fatalf("expected the personal organization first, got %+v", personal)
+	}
+	if memberships[1].Organization.ID != shared.ID {
+		t.Fatalf("expected the shared organization second, got %+v", memberships[1])
+	}
+
+	if _, err := service.AddMember(ctx, user, personal.Organization.ID, "friend@example.com", authorization.OrganizationRoleViewer); !errors.Is(err, ErrPersonalOrganization) {
+		t.Fatalf("expected ErrPersonalOrganization, got %v", err)
+	}
+}
+
+func register(t *testing.T, store *data.Store, email string) users.User {
+	t.Helper()
+
+	user, err := users.NewService(store, security.NewTokenHasher("test-pepper"), users.SessionPolicy{}).Register(context.Background(), email, "password123")
+	if err != nil {
+		t.Fatalf("register %s: %v", email, err)
+	}
+
+	return user
+}
@@ -0,0 +1,118 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit allows Requests per Period, with bursts of up to Requests.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+func PerMinute(requests int) Limit {
+	return Limit{Requests: requests, Period: time.Minute}
+}
+
+func (l Limit) IsZero() bool {
+	return l.Requests <= 0 || l.Period <= 0
+}
+
+type Decision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long to wait before the next request can succeed. It is zero
+	// when Allowed is true.
+	RetryAfter time.Duration
+}
+
+// Limiter takes one token from the bucket identified by key. Implementations must be safe
+// for concurrent use; a shared backend (e.g. Redis) can replace MemoryLimiter when several
+// replicas serve the API.
+type Limiter interface {
+	Take(ctx context.Context, key string, limit Limit) (Decision, error)
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	period  time.Duration
+}
+
+// MemoryLimiter is an in-process token bucket limiter.
+type MemoryLimiter struct {
+	mu        sync.Mutex
+	now       func() time.Time
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (l *MemoryLimiter) Take(ctx context.Context, key string, limit Limit) (Decision, error) {
+	if limit.IsZero() {
+		return Decision{Allowed: true}, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweepLocked(now)
+
+	refillPerSecond := float64(limit.Requests) / limit.Period.Seconds()
+	current, found := l.buckets[key]
+	if !found {
+		current = &bucket{tokens: float64(limit.Requests), updated: now}
+		l.buckets[key] = current
+	}
+
+	elapsed := now.Sub(current.updated).Seconds()
+	if elapsed > 0 {
+		current.tokens += elapsed * refillPerSecond
+	}
+	current.updated = now
+	current.period = limit.Period
+	current.tokens = math.Min(current.tokens, float64(limit.Requests))
+
+	if current.tokens >= 1 {
+		current.tokens--
+		return Decision{
+			Allowed:   true,
+			Limit:     limit.Requests,
+			Remaining: int(current.tokens),
+		}, nil
+	}
+
+	missing := 1 - current.tokens
+	return Decision{
+		Allowed:    false,
+		Limit:      limit.Requests,
+		Remaining:  0,
+		RetryAfter: time.Duration(math.Ceil(missing / refillPerSecond * float64(time.Second))),
+	}, nil
+}
+
+// sweepLocked drops buckets that have refilled completely, at most once a minute, so the
+// map does not grow with every key ever seen.
+func (l *MemoryLimiter) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	for key, current := range l.buckets {
+		if now.Sub(current.updated) >= current.period {
+			delete(l.buckets, key)
+		}
+	}
+}
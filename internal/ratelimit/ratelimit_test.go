@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryLimiterTokenBucket(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewMemoryLimiter()
+	limiter.now = func() time.Time { return now }
+	limit := PerMinute(3)
+
+	for expectedRemaining := 2; expectedRemaining >= 0; expectedRemaining-- {
+		decision, err := limiter.Take(ctx, "key_a", limit)
+		if err != nil {
+			t.Fatalf("take: %v", err)
+		}
+		if !decision.Allowed || decision.Limit != 3 || decision.Remaining != expectedRemaining {
+			t.Fatalf("expected allowed request with %d remaining, got %+v", expectedRemaining, decision)
+		}
+	}
+
+	denied, err := limiter.Take(ctx, "key_a", limit)
+	if err != nil {
+		t.Fatalf("take: %v", err)
+	}
+	if denied.Allowed || denied.RetryAfter != 20*time.Second {
+		t.Fatalf("expected denial with 20s retry, got %+v", denied)
+	}
+
+	if other, _ := limiter.Take(ctx, "key_b", limit); !other.Allowed {
+		t.Fatalf("expected buckets to be independent per key, got %+v", other)
+	}
+
+	now = now.Add(20 * time.Second)
+	if refilled, _ := limiter.Take(ctx, "key_a", limit); !refilled.Allowed || refilled.Remaining != 0 {
+		t.Fatalf("expected one token to refill after 20s, got %+v", refilled)
+	}
+
+	now = now.Add(time.Hour)
+	if full, _ := limiter.Take(ctx, "key_a", limit); !full.Allowed || full.Remaining != 2 {
+		t.Fatalf("expected bucket to refill to capacity, got %+v", full)
+	}
+}
+
+func TestMemoryLimiterZeroLimitIsUnlimited(t *testing.T) {
+	t.Parallel()
+
+	limiter := NewMemoryLimiter()
+	for range 100 {
+		decision, err := limiter.Take(context.Background(), "key", Limit{})
+		if err != nil || !decision.Allowed {
+			t.Fatalf("expected zero limit to allow every request, got %+v %v", decision, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package security
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const sealedPrefix = "v1:"
+
+var ErrDecryptionFailed = errors.New("could not decrypt secret")
+
+// SecretBox encrypts small secrets (such as TOTP seeds) for storage with AES-256-GCM.
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// NewSecretBox derives the encryption key from passphrase, which should be a long random
+// value kept outside the database.
+func NewSecretBox(passphrase string) (*SecretBox, error) {
+	if strings.TrimSpace(passphrase) == "" {
+		return nil, errors.New("encryption key is required")
+	}
+
+	key := sha256.Sum256([]byte(passphrase))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create GCM: %w", err)
+	}
+
+	return &SecretBox{aead: aead}, nil
+}
+
+// Seal encrypts plaintext. associatedData (for example the owning row's ID) must be passed
+// to Open unchanged, so a ciphertext copied to another row does not decrypt.
+func (b *SecretBox) Seal(plaintext string, associatedData string) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("generate nonce: %w", err)
+	}
+
+	sealed := b.aead.Seal(nonce, nonce, []byte(plaintext), []byte(associatedData))
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// IsSealed reports whether value was produced by Seal, as opposed to a plaintext secret
+// stored before encryption was configured.
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+func (b *SecretBox) Open(ciphertext string, associatedData string) (string, error) {
+	encoded, ok := strings.CutPrefix(ciphertext, sealedPrefix)
+	if !ok {
+		return "", ErrDecryptionFailed
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < b.aead.NonceSize() {
+		return "", ErrDecryptionFailed
+	}
+
+	nonce, sealed := sealed[:b.aead.NonceSize()], sealed[b.aead.NonceSize():]
+	plaintext, err := b.aead.Open(nil, nonce, sealed, []byte(associatedData))
+	if err != nil {
+		return "", ErrDecryptionFailed
+	}
+
+	return string(plaintext), nil
+}
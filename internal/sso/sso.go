@@ -0,0 +1,341 @@
+// Package sso signs dashboard users in through an OpenID Connect provider using the
+// authorization code flow with PKCE.
+package sso
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/jwt"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+	"github.com/brian-nunez/bbaas-api/internal/users"
+)
+
+const (
+	DefaultGroupsClaim = "groups"
+
+	clockSkewLeeway      = time.Minute
+	maxTokenResponseSize = 1 << 20
+)
+
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+var (
+	ErrStateMismatch  = errors.New("single sign-on response did not match this login attempt")
+	ErrInvalidIDToken = errors.New("identity provider returned an invalid ID token")
+)
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback registered with the provider, ending in /login/sso/callback.
+	RedirectURL string
+	// Scopes requested from the provider; empty uses DefaultScopes. openid is always added.
+	Scopes []string
+	// GroupsClaim names the ID token claim listing the user's groups.
+	GroupsClaim string
+	// AdminGroups grants the admin role to members of any of these groups and the user role to
+	// everyone else. When empty, roles are managed in BBAAS instead.
+	AdminGroups []string
+	// HTTPClient talks to the provider; nil uses a client with a 10 second timeout.
+	HTTPClient *http.Client
+}
+
+// AuthRequest is a login in progress. The caller keeps it (for example in a cookie) until
+// the provider redirects back.
+type AuthRequest struct {
+	URL          string
+	State        string
+	Nonce        string
+	CodeVerifier string
+}
+
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce           string `json:"nonce"`
+	AuthorizedParty string `json:"azp"`
+	Email           string `json:"email"`
+	EmailVerified   any    `json:"email_verified"`
+}
+
+type Service struct {
+	config     Config
+	httpClient *http.Client
+	now        func() time.Time
+
+	mu       sync.Mutex
+	metadata *providerMetadata
+	keySet   *jwt.KeySet
+}
+
+func NewService(config Config) (*Service, error) {
+	config.Issuer = strings.TrimRight(strings.TrimSpace(config.Issuer), "/")
+	config.ClientID = strings.TrimSpace(config.ClientID)
+	if config.Issuer == "" || config.ClientID == "" {
+		return nil, errors.New("issuer and client ID are required")
+	}
+	if strings.TrimSpace(config.RedirectURL) == "" {
+		return nil, errors.New("redirect URL is required")
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = DefaultScopes
+	}
+	if !slices.Contains(config.Scopes, "openid") {
+		config.Scopes = append([]string{"openid"}, config.Scopes...)
+	}
+	if strings.TrimSpace(config.GroupsClaim) == "" {
+		config.GroupsClaim = DefaultGroupsClaim
+	}
+
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &Service{
+		config:     config,
+		httpClient: httpClient,
+		now:        time.Now,
+	}, nil
+}
+
+// Begin starts a login and returns the provider URL to send the browser to.
+func (s *Service) Begin(ctx context.Context) (AuthRequest, error) {
+	metadata, _, err := s.provider(ctx)
+	if err != nil {
+		return AuthRequest{}, err
+	}
+
+	request := AuthRequest{}
+	for _, value := range []*string{&request.State, &request.Nonce, &request.CodeVerifier} {
+		if *value, err = security.GeneratePrefixedToken("", 32); err != nil {
+			return AuthRequest{}, fmt.Errorf("generate single sign-on parameters: %w", err)
+		}
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", s.config.ClientID)
+	query.Set("redirect_uri", s.config.RedirectURL)
+	query.Set("scope", strings.Join(s.config.Scopes, " "))
+	query.Set("state", request.State)
+	query.Set("nonce", request.Nonce)
+	query.Set("code_challenge", codeChallenge(request.CodeVerifier))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	request.URL = metadata.AuthorizationEndpoint + separator + query.Encode()
+
+	return request, nil
+}
+
+// Complete exchanges the authorization code from the provider's redirect for a verified
+// identity. state is the value the provider echoed back.
+func (s *Service) Complete(ctx context.Context, request AuthRequest, state string, code string) (users.ExternalIdentity, error) {
+	if request.State == "" || subtle.ConstantTimeCompare([]byte(request.State), []byte(state)) != 1 {
+		return users.ExternalIdentity{}, ErrStateMismatch
+	}
+	if strings.TrimSpace(code) == "" {
+		return users.ExternalIdentity{}, ErrStateMismatch
+	}
+
+	metadata, keySet, err := s.provider(ctx)
+	if err != nil {
+		return users.ExternalIdentity{}, err
+	}
+
+	rawIDToken, err := s.exchangeCode(ctx, metadata.TokenEndpoint, code, request.CodeVerifier)
+	if err != nil {
+		return users.ExternalIdentity{}, err
+	}
+
+	return s.verifyIDToken(ctx, keySet, rawIDToken, request.Nonce)
+}
+
+func (s *Service) exchangeCode(ctx context.Context, tokenEndpoint string, code string, codeVerifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", s.config.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+	if s.config.ClientSecret == "" {
+		// Public clients identify themselves in the body and rely on PKCE alone.
+		form.Set("client_id", s.config.ClientID)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("build token request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if s.config.ClientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(s.config.ClientID), url.QueryEscape(s.config.ClientSecret))
+	}
+
+	response, err := s.httpClient.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("exchange authorization code: %w", err)
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxTokenResponseSize))
+	if err != nil {
+		return "", fmt.Errorf("read token response: %w", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("exchange authorization code: provider returned %s", response.Status)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil || tokens.IDToken == "" {
+		return "", ErrInvalidIDToken
+	}
+
+	return tokens.IDToken, nil
+}
+
+func (s *Service) verifyIDToken(ctx context.Context, keySet *jwt.KeySet, rawIDToken string, nonce string) (users.ExternalIdentity, error) {
+	token, err := jwt.Parse(rawIDToken)
+	if err != nil || token.Header.Algorithm != jwt.AlgorithmRS256 {
+		return users.ExternalIdentity{}, ErrInvalidIDToken
+	}
+
+	publicKey, err := keySet.Key(ctx, token.Header.KeyID)
+	if err != nil {
+		if errors.Is(err, jwt.ErrUnknownKey) {
+			return users.ExternalIdentity{}, ErrInvalidIDToken
+		}
+		return users.ExternalIdentity{}, fmt.Errorf("load provider signing key: %w", err)
+	}
+	if err := token.VerifyRS256(publicKey); err != nil {
+		return users.ExternalIdentity{}, ErrInvalidIDToken
+	}
+
+	var claims idTokenClaims
+	if err := token.DecodeClaims(&claims); err != nil {
+		return users.ExternalIdentity{}, ErrInvalidIDToken
+	}
+	if claims.Issuer != s.config.Issuer || !claims.HasAudience(s.config.ClientID) || claims.Subject == "" || claims.ExpiresAt == 0 {
+		return users.ExternalIdentity{}, ErrInvalidIDToken
+	}
+	if claims.AuthorizedParty != "" && claims.AuthorizedParty != s.config.ClientID {
+		return users.ExternalIdentity{}, ErrInvalidIDToken
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return users.ExternalIdentity{}, ErrInvalidIDToken
+	}
+	if err := claims.ValidateTimes(s.now(), clockSkewLeeway); err != nil {
+		return users.ExternalIdentity{}, ErrInvalidIDToken
+	}
+
+	identity := users.ExternalIdentity{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified == true || claims.EmailVerified == "true",
+	}
+	if len(s.config.AdminGroups) > 0 {
+		var groupClaims map[string]any
+		if err := token.DecodeClaims(&groupClaims); err != nil {
+			return users.ExternalIdentity{}, ErrInvalidIDToken
+		}
+		identity.Role = "user"
+		for _, group := range claimStrings(groupClaims[s.config.GroupsClaim]) {
+			if slices.Contains(s.config.AdminGroups, group) {
+				identity.Role = "admin"
+				break
+			}
+		}
+	}
+
+	return identity, nil
+}
+
+// provider loads the provider's discovery document once and reuses it; a failed fetch is
+// retried on the next login.
+func (s *Service) provider(ctx context.Context) (providerMetadata, *jwt.KeySet, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.metadata != nil {
+		return *s.metadata, s.keySet, nil
+	}
+
+	discoveryURL := s.config.Issuer + "/.well-known/openid-configuration"
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return providerMetadata{}, nil, fmt.Errorf("build discovery request: %w", err)
+	}
+	response, err := s.httpClient.Do(request)
+	if err != nil {
+		return providerMetadata{}, nil, fmt.Errorf("fetch provider discovery document: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return providerMetadata{}, nil, fmt.Errorf("fetch provider discovery document: %s", response.Status)
+	}
+
+	var metadata providerMetadata
+	if err := json.NewDecoder(io.LimitReader(response.Body, maxTokenResponseSize)).Decode(&metadata); err != nil {
+		return providerMetadata{}, nil, fmt.Errorf("decode provider discovery document: %w", err)
+	}
+	if strings.TrimRight(metadata.Issuer, "/") != s.config.Issuer {
+		return providerMetadata{}, nil, fmt.Errorf("provider discovery document is for issuer %q, expected %q", metadata.Issuer, s.config.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return providerMetadata{}, nil, errors.New("provider discovery document is missing endpoints")
+	}
+
+	s.metadata = &metadata
+	s.keySet = jwt.NewKeySet(metadata.JWKSURI, s.httpClient)
+	return metadata, s.keySet, nil
+}
+
+func codeChallenge(codeVerifier string) string {
+	digest := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+// claimStrings reads a claim that providers send either as a list or a single string.
+func claimStrings(value any) []string {
+	switch typed := value.(type) {
+	case string:
+		return []string{typed}
+	case []any:
+		values := make([]string, 0, len(typed))
+		for _, item := range typed {
+			if text, ok := item.(string); ok {
+				values = append(values, text)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,295 @@
+package sso
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/data/datatest"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+	"github.com/brian-nunez/bbaas-api/internal/users"
+)
+
+const (
+	testClientID     = "bbaas-dashboard"
+	testClientSecret = "client-secret"
+	testRedirectURL  = "https://bbaas.example.com/login/sso/callback"
+)
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	provider := newStubProvider(t)
+	service, err := NewService(Config{
+		Issuer:       provider.server.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+		AdminGroups:  []string{"bbaas-admins"},
+	})
+	if err != nil {
+		t.Fatalf("new service: %v", err)
+	}
+
+	store := datatest.NewStore(t)
+	usersService := users.NewService(store, security.NewTokenHasher("test-pepper"), users.SessionPolicy{})
+	usersService.AllowPasswordLogin(false)
+
+	provider.setUser("alice-subject", "Alice@Example.com", []string{"engineering", "bbaas-admins"})
+	identity := provider.login(t, service)
+	if identity.Subject != "alice-subject" || identity.Role != "admin" || !identity.EmailVerified {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+
+	alice, session, err := usersService.LoginWithIdentity(ctx, identity, users.Client{})
+	if err != nil {
+		t.Fatalf("login with identity: %v", err)
+	}
+	if alice.Email != "alice@example.com" || !alice.IsAdmin() || !alice.IsVerified() || session.Token == "" {
+		t.Fatalf("expected alice to be provisioned as a verified admin, got %+v", alice)
+	}
+	if _, _, err := usersService.Login(ctx, "alice@example.com", "", users.Client{}); !errors.Is(err, users.ErrPasswordLoginDisabled) {
+		t.Fatalf("expected password login to be disabled, got %v", err)
+	}
+
+	// Leaving the admin group demotes the same account on the next login.
+	provider.setUser("alice-subject", "alice@example.com", []string{"engineering"})
+	again, _, err := usersService.LoginWithIdentity(ctx, provider.login(t, service), users.Client{})
+	if err != nil {
+		t.Fatalf("second login with identity: %v", err)
+	}
+	if again.ID != alice.ID || again.IsAdmin() {
+		t.Fatalf("expected alice's account to be reused without admin, got %+v", again)
+	}
+
+	request, err := service.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	code, state := provider.authorize(t, request.URL)
+	if _, err := service.Complete(ctx, request, "forged-state", code); !errors.Is(err, ErrStateMismatch) {
+		t.Fatalf("expected a mismatched state to be rejected, got %v", err)
+	}
+	tampered := request
+	tampered.CodeVerifier = "not-the-verifier"
+	if _, err := service.Complete(ctx, tampered, state, code); err == nil {
+		t.Fatalf("expected the provider to reject a wrong PKCE verifier")
+	}
+
+	request, err = service.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	code, state = provider.authorize(t, request.URL)
+	tampered = request
+	tampered.Nonce = "replayed-nonce"
+	if _, err := service.Complete(ctx, tampered, state, code); !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("expected a mismatched nonce to be rejected, got %v", err)
+	}
+
+	provider.setUnverifiedEmail("mallory-subject", "alice@example.com")
+	if _, _, err := usersService.LoginWithIdentity(ctx, provider.login(t, service), users.Client{}); !errors.Is(err, users.ErrIdentityEmailUnverified) {
+		t.Fatalf("expected an unverified email not to link to alice, got %v", err)
+	}
+}
+
+type stubProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu            sync.Mutex
+	subject       string
+	email         string
+	emailVerified bool
+	groups        []string
+	grants        map[string]stubGrant
+}
+
+type stubGrant struct {
+	challenge string
+	nonce     string
+}
+
+func newStubProvider(t *testing.T) *stubProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+
+	provider := &stubProvider{t: t, key: key, grants: map[string]stubGrant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{
+			"issuer":                 provider.server.URL,
+			"authorization_endpoint": provider.server.URL + "/authorize",
+			"token_endpoint":         provider.server.URL + "/token",
+			"jwks_uri":               provider.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "stub-key",
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("GET /authorize", provider.handleAuthorize)
+	mux.HandleFunc("POST /token", provider.handleToken)
+	provider.server = httptest.NewServer(mux)
+	t.Cleanup(provider.server.Close)
+
+	return provider
+}
+
+func (p *stubProvider) setUser(subject string, email string, groups []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.subject, p.email, p.emailVerified, p.groups = subject, email, true, groups
+}
+
+func (p *stubProvider) setUnverifiedEmail(subject string, email string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.subject, p.email, p.emailVerified, p.groups = subject, email, false, nil
+}
+
+// login plays the browser: it follows the provider's redirect back to the callback and
+// completes the login with the returned code.
+func (p *stubProvider) login(t *testing.T, service *Service) users.ExternalIdentity {
+	t.Helper()
+
+	request, err := service.Begin(context.Background())
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	code, state := p.authorize(t, request.URL)
+	identity, err := service.Complete(context.Background(), request, state, code)
+	if err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+
+	return identity
+}
+
+func (p *stubProvider) authorize(t *testing.T, authorizationURL string) (string, string) {
+	t.Helper()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	response, err := client.Get(authorizationURL)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusFound {
+		t.Fatalf("expected a redirect from the provider, got %s", response.Status)
+	}
+
+	location, err := url.Parse(response.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(location.String(), testRedirectURL+"?") {
+		t.Fatalf("unexpected callback %q", response.Header.Get("Location"))
+	}
+
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func (p *stubProvider) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != testClientID || query.Get("redirect_uri") != testRedirectURL || query.Get("response_type") != "code" ||
+		query.Get("code_challenge_method") != "S256" || !strings.Contains(query.Get("scope"), "openid") {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	code, err := security.GeneratePrefixedToken("code", 16)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p.mu.Lock()
+	p.grants[code] = stubGrant{challenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+	p.mu.Unlock()
+
+	callback := url.Values{"code": {code}, "state": {query.Get("state")}}
+	http.Redirect(w, r, testRedirectURL+"?"+callback.Encode(), http.StatusFound)
+}
+
+func (p *stubProvider) handleToken(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok || clientID != testClientID || clientSecret != testClientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+	if r.FormValue("grant_type") != "authorization_code" || r.FormValue("redirect_uri") != testRedirectURL {
+		http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	grant, found := p.grants[r.FormValue("code")]
+	delete(p.grants, r.FormValue("code"))
+	subject, email, emailVerified, groups := p.subject, p.email, p.emailVerified, p.groups
+	p.mu.Unlock()
+
+	if !found || codeChallenge(r.FormValue("code_verifier")) != grant.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	writeJSON(w, map[string]any{
+		"access_token": "stub-access-token",
+		"token_type":   "Bearer",
+		"id_token": p.sign(map[string]any{
+			"iss":            p.server.URL,
+			"sub":            subject,
+			"aud":            testClientID,
+			"iat":            now.Unix(),
+			"exp":            now.Add(5 * time.Minute).Unix(),
+			"nonce":          grant.nonce,
+			"email":          email,
+			"email_verified": emailVerified,
+			"groups":         groups,
+		}),
+	})
+}
+
+func (p *stubProvider) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "stub-key"})
+	payload, _ := json.Marshal(claims)
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		p.t.Errorf("sign ID token: %v", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,82 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 test key from RFC 6238 appendix B ("12345678901234567890").
+var rfc6238Secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeMatchesRFC6238Vectors(t *testing.T) {
+	t.Parallel()
+
+	// RFC 6238 publishes 8 digit codes; the 6 digit code is the last 6 digits.
+	for unix, expected := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		code, err := Code(rfc6238Secret, Step(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatalf("code at %d: %v", unix, err)
+		}
+		if code != expected {
+			t.Fatalf("code at %d: expected %s, got %s", unix, expected, code)
+		}
+	}
+}
+
+func TestValidateAcceptsAdjacentSteps(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	for offset, accepted := range map[int64]bool{-2: false, -1: true, 0: true, 1: true, 2: false} {
+		code, err := Code(rfc6238Secret, current+offset)
+		if err != nil {
+			t.Fatalf("code: %v", err)
+		}
+
+		step, ok, err := Validate(rfc6238Secret, code, now)
+		if err != nil {
+			t.Fatalf("validate: %v", err)
+		}
+		if ok != accepted {
+			t.Fatalf("offset %d: expected accepted=%v", offset, accepted)
+		}
+		if ok && step != current+offset {
+			t.Fatalf("offset %d: expected step %d, got %d", offset, current+offset, step)
+		}
+	}
+
+	if _, ok, _ := Validate(rfc6238Secret, "12345", now); ok {
+		t.Fatalf("expected short codes to be rejected")
+	}
+}
+
+func TestGenerateSecretAndProvisioningURI(t *testing.T) {
+	t.Parallel()
+
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("generate secret: %v", err)
+	}
+	if len(secret) != 32 {
+		t.Fatalf("expected a 160 bit base32 secret, got %q", secret)
+	}
+	if _, err := Code(secret, 1); err != nil {
+		t.Fatalf("expected generated secret to decode: %v", err)
+	}
+
+	uri := ProvisioningURI("BBAAS", "owner@example.com", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/BBAAS:owner@example.com?") || !strings.Contains(uri, "secret="+secret) {
+		t.Fatalf("unexpected provisioning URI %s", uri)
+	}
+}
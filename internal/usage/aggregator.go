@@ -0,0 +1,134 @@
+// Package usage aggregates API key usage in memory and writes it to the database in
+// batches, keeping per-request database writes off the request path.
+package usage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/data"
+)
+
+const maxUserAgentLength = 512
+
+// Event is a single authenticated API request.
+type Event struct {
+	APIKeyID string
+	// Endpoint identifies the route, e.g. "POST /api/v1/browsers".
+	Endpoint  string
+	ClientIP  string
+	UserAgent string
+	At        time.Time
+}
+
+type endpointKey struct {
+	apiKeyID string
+	endpoint string
+}
+
+// Aggregator collects events until Flush writes them out. Record never touches the
+// database, so it is safe to call on every request.
+type Aggregator struct {
+	store *data.Store
+
+	mu        sync.Mutex
+	lastUses  map[string]data.APIKeyLastUseRecord
+	endpoints map[endpointKey]data.APIKeyEndpointUsageRecord
+}
+
+func NewAggregator(store *data.Store) *Aggregator {
+	return &Aggregator{
+		store:     store,
+		lastUses:  make(map[string]data.APIKeyLastUseRecord),
+		endpoints: make(map[endpointKey]data.APIKeyEndpointUsageRecord),
+	}
+}
+
+func (a *Aggregator) Record(event Event) {
+	if event.APIKeyID == "" {
+		return
+	}
+	at := event.At.UTC()
+	userAgent := event.UserAgent
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if lastUse, found := a.lastUses[event.APIKeyID]; !found || at.After(lastUse.UsedAt) {
+		a.lastUses[event.APIKeyID] = data.APIKeyLastUseRecord{
+			APIKeyID:  event.APIKeyID,
+			UsedAt:    at,
+			IP:        event.ClientIP,
+			UserAgent: userAgent,
+		}
+	}
+
+	key := endpointKey{apiKeyID: event.APIKeyID, endpoint: event.Endpoint}
+	usage := a.endpoints[key]
+	usage.APIKeyID = event.APIKeyID
+	usage.Endpoint = event.Endpoint
+	usage.RequestCount++
+	if at.After(usage.LastUsedAt) {
+		usage.LastUsedAt = at
+	}
+	a.endpoints[key] = usage
+}
+
+// Flush writes everything recorded since the last flush and returns how many requests it
+// covered. When the write fails the batch is merged back so it is retried on the next flush;
+// usage of keys deleted in the meantime is dropped by the store rather than failing the batch.
+func (a *Aggregator) Flush(ctx context.Context) (int, error) {
+	a.mu.Lock()
+	lastUses := a.lastUses
+	endpoints := a.endpoints
+	a.lastUses = make(map[string]data.APIKeyLastUseRecord)
+	a.endpoints = make(map[endpointKey]data.APIKeyEndpointUsageRecord)
+	a.mu.Unlock()
+
+	if len(endpoints) == 0 {
+		return 0, nil
+	}
+
+	lastUseBatch := make([]data.APIKeyLastUseRecord, 0, len(lastUses))
+	for _, lastUse := range lastUses {
+		lastUseBatch = append(lastUseBatch, lastUse)
+	}
+	endpointBatch := make([]data.APIKeyEndpointUsageRecord, 0, len(endpoints))
+	requests := 0
+	for _, usage := range endpoints {
+		endpointBatch = append(endpointBatch, usage)
+		requests += usage.RequestCount
+	}
+
+	if err := a.store.RecordAPIKeyUsage(ctx, lastUseBatch, endpointBatch); err != nil {
+		a.restore(lastUses, endpoints)
+		return 0, err
+	}
+
+	return requests, nil
+}
+
+func (a *Aggregator) restore(lastUses map[string]data.APIKeyLastUseRecord, endpoints map[endpointKey]data.APIKeyEndpointUsageRecord) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for keyID, lastUse := range lastUses {
+		if current, found := a.lastUses[keyID]; !found || lastUse.UsedAt.After(current.UsedAt) {
+			a.lastUses[keyID] = lastUse
+		}
+	}
+	for key, usage := range endpoints {
+		current := a.endpoints[key]
+		current.APIKeyID = usage.APIKeyID
+		current.Endpoint = usage.Endpoint
+		current.RequestCount += usage.RequestCount
+		if usage.LastUsedAt.After(current.LastUsedAt) {
+			current.LastUsedAt = usage.LastUsedAt
+		}
+		a.endpoints[key] = current
+	}
+}
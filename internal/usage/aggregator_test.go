@@ -0,0 +1,152 @@
+package usage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/applications"
+	"github.com/brian-nunez/bbaas-api/internal/authorization"
+	"github.com/brian-nunez/bbaas-api/internal/data/datatest"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+	"github.com/brian-nunez/bbaas-api/internal/users"
+)
+
+func TestAggregatorFlushesBatchedUsage(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	appsService := applications.NewService(store, authorization.NewWebAuthorizer(), security.NewTokenHasher("test-pepper"))
+
+	user, err := users.NewService(store, security.NewTokenHasher("test-pepper"), users.SessionPolicy{}).Register(ctx, "usage@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	application, err := appsService.RegisterApplication(ctx, user, applications.RegisterApplicationInput{
+		Name:       "Usage",
+		GitHubLink: "https://github.com/example-org/usage",
+		Domain:     "example.com",
+	})
+	if err != nil {
+		t.Fatalf("register application: %v", err)
+	}
+	created, err := appsService.CreateAPIKey(ctx, user, application.ID, applications.CreateAPIKeyInput{
+		Name:   "Usage",
+		Scopes: []string{authorization.ScopeBrowsersRead},
+	})
+	if err != nil {
+		t.Fatalf("create API key: %v", err)
+	}
+
+	keyID := created.APIKey.ID
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	aggregator := NewAggregator(store)
+	aggregator.Record(Event{APIKeyID: keyID, Endpoint: "GET /api/v1/browsers", ClientIP: "192.0.2.1", UserAgent: "first", At: base})
+	aggregator.Record(Event{APIKeyID: keyID, Endpoint: "GET /api/v1/browsers", ClientIP: "192.0.2.2", UserAgent: "second", At: base.Add(2 * time.Second)})
+	aggregator.Record(Event{APIKeyID: keyID, Endpoint: "POST /api/v1/browsers", ClientIP: "192.0.2.3", UserAgent: "older", At: base.Add(time.Second)})
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := aggregator.Flush(cancelled); err == nil {
+		t.Fatalf("expected flush with a cancelled context to fail")
+	}
+
+	requests, err := aggregator.Flush(ctx)
+	if err != nil {
+		t.Fatalf("flush usage: %v", err)
+	}
+	if requests != 3 {
+		t.Fatalf("expected the failed batch to be retried, flushed %d requests", requests)
+	}
+
+	aggregator.Record(Event{APIKeyID: keyID, Endpoint: "GET /api/v1/browsers", ClientIP: "192.0.2.9", At: base.Add(-time.Hour)})
+	if _, err := aggregator.Flush(ctx); err != nil {
+		t.Fatalf("flush usage: %v", err)
+	}
+	if requests, err := aggregator.Flush(ctx); err != nil || requests != 0 {
+		t.Fatalf("expected an empty flush, got %d, %v", requests, err)
+	}
+
+	keys, err := appsService.ListAPIKeysForApplication(ctx, user, application.ID)
+	if err != nil {
+		t.Fatalf("list API keys: %v", err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("expected one key, got %d", len(keys))
+	}
+
+	key := keys[0]
+	if key.LastUsedAt == nil || !key.LastUsedAt.Equal(base.Add(2*time.Second)) {
+		t.Fatalf("expected last use to keep the newest request, got %v", key.LastUsedAt)
+	}
+	if key.LastUsedIP != "192.0.2.2" || key.LastUsedUserAgent != "second" {
+		t.Fatalf("expected last use details of the newest request, got %q %q", key.LastUsedIP, key.LastUsedUserAgent)
+	}
+	if len(key.EndpointUsage) != 2 {
+		t.Fatalf("expected two endpoints, got %+v", key.EndpointUsage)
+	}
+	if key.EndpointUsage[0].Endpoint != "GET /api/v1/browsers" || key.EndpointUsage[0].RequestCount != 3 {
+		t.Fatalf("expected most used endpoint first with accumulated count, got %+v", key.EndpointUsage[0])
+	}
+	if key.EndpointUsage[1].Endpoint != "POST /api/v1/browsers" || key.EndpointUsage[1].RequestCount != 1 {
+		t.Fatalf("unexpected endpoint usage %+v", key.EndpointUsage[1])
+	}
+}
+
+func TestAggregatorDropsUsageOfDeletedKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	appsService := applications.NewService(store, authorization.NewWebAuthorizer(), security.NewTokenHasher("test-pepper"))
+
+	user, err := users.NewService(store, security.NewTokenHasher("test-pepper"), users.SessionPolicy{}).Register(ctx, "usage@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	createKey := func(name string) (applications.Application, string) {
+		application, err := appsService.RegisterApplication(ctx, user, applications.RegisterApplicationInput{
+			Name:       name,
+			GitHubLink: "https://github.com/example-org/usage",
+			Domain:     "example.com",
+		})
+		if err != nil {
+			t.Fatalf("register application: %v", err)
+		}
+		created, err := appsService.CreateAPIKey(ctx, user, application.ID, applications.CreateAPIKeyInput{
+			Name:   name,
+			Scopes: []string{authorization.ScopeBrowsersRead},
+		})
+		if err != nil {
+			t.Fatalf("create API key: %v", err)
+		}
+		return application, created.APIKey.ID
+	}
+	deletedApplication, deletedKeyID := createKey("Deleted")
+	keptApplication, keptKeyID := createKey("Kept")
+
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	aggregator := NewAggregator(store)
+	aggregator.Record(Event{APIKeyID: deletedKeyID, Endpoint: "GET /api/v1/browsers", At: at})
+	aggregator.Record(Event{APIKeyID: keptKeyID, Endpoint: "GET /api/v1/browsers", At: at})
+
+	if err := appsService.DeleteApplication(ctx, user, deletedApplication.ID, "Deleted"); err != nil {
+		t.Fatalf("delete application: %v", err)
+	}
+
+	if _, err := aggregator.Flush(ctx); err != nil {
+		t.Fatalf("expected usage of a deleted key not to fail the batch, got %v", err)
+	}
+	if requests, err := aggregator.Flush(ctx); err != nil || requests != 0 {
+		t.Fatalf("expected the dropped usage not to be retried, got %d, %v", requests, err)
+	}
+
+	keys, err := appsService.ListAPIKeysForApplication(ctx, user, keptApplication.ID)
+	if err != nil {
+		t.Fatalf("list API keys: %v", err)
+	}
+	if len(keys) != 1 || len(keys[0].EndpointUsage) != 1 || keys[0].EndpointUsage[0].RequestCount != 1 {
+		t.Fatalf("expected the remaining key's usage to be written, got %+v", keys)
+	}
+}
@@ -0,0 +1,142 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/brian-nunez/bbaas-api/internal/audit"
+	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/lockout"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrIncorrectPassword = errors.New("current password is incorrect")
+	ErrNoPassword        = errors.New("this account signs in with single sign-on and has no password")
+)
+
+// OwnershipRequiredError is returned when deleting an account would leave shared
+// organizations without an owner.
+type OwnershipRequiredError struct {
+	Organizations []string
+}
+
+func (e *OwnershipRequiredError) Error() string {
+	return fmt.Sprintf("make another member an owner of %s before deleting your account", strings.Join(e.Organizations, ", "))
+}
+
+// ChangePassword replaces the user's password after checking the current one, and logs out
+// every other session. It returns how many sessions were ended.
+func (s *Service) ChangePassword(ctx context.Context, user User, currentPassword string, newPassword string, currentSessionID string, client Client) (int, error) {
+	record, err := s.getUserRecord(ctx, user.ID)
+	if err != nil {
+		return 0, err
+	}
+	if record.PasswordHash == "" {
+		return 0, ErrNoPassword
+	}
+	if err := s.checkPassword(ctx, record.Email, record.PasswordHash, currentPassword, client); err != nil {
+		return 0, err
+	}
+	if err := validatePassword(newPassword); err != nil {
+		return 0, err
+	}
+
+	passwordHashBytes, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, fmt.Errorf("hash password: %w", err)
+	}
+
+	revoked, err := s.store.UpdateUserPassword(ctx, record.ID, string(passwordHashBytes), currentSessionID, s.now().UTC())
+	if err != nil {
+		return 0, fmt.Errorf("change password: %w", err)
+	}
+
+	recordUserEvent(ctx, s.audit, record.ID, record.ID, "user.password_changed", map[string]audit.Change{
+		"other_sessions": {From: revoked, To: 0},
+	})
+
+	return revoked, nil
+}
+
+// Reauthenticate confirms a signed-in user before a sensitive change by checking their
+// password. Failures count towards the login lockout. Accounts without a password, which
+// only sign in through single sign-on, have nothing to re-enter and always pass.
+func (s *Service) Reauthenticate(ctx context.Context, user User, password string, client Client) error {
+	record, err := s.getUserRecord(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if record.PasswordHash == "" {
+		return nil
+	}
+
+	return s.checkPassword(ctx, record.Email, record.PasswordHash, password, client)
+}
+
+// CheckDeletable reports why the user's account cannot be deleted yet, if it cannot.
+func (s *Service) CheckDeletable(ctx context.Context, user User) error {
+	blocking, err := s.store.ListOrganizationsBlockingDeletion(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("list organizations blocking deletion: %w", err)
+	}
+	if len(blocking) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(blocking))
+	for _, organization := range blocking {
+		names = append(names, organization.Name)
+	}
+
+	return &OwnershipRequiredError{Organizations: names}
+}
+
+// Delete removes the user with their personal organization, its applications and everything
+// else they own. Callers check CheckDeletable first and close the user's browsers.
+func (s *Service) Delete(ctx context.Context, userID string) error {
+	record, err := s.getUserRecord(ctx, strings.TrimSpace(userID))
+	if err != nil {
+		return err
+	}
+
+	deleted, err := s.store.DeleteUser(ctx, record.ID, s.now().UTC())
+	if err != nil {
+		return fmt.Errorf("delete user: %w", err)
+	}
+	if !deleted {
+		return ErrUserNotFound
+	}
+
+	// The trail outlives the account, so keep the address the events can no longer look up.
+	recordUserEvent(ctx, s.audit, record.ID, record.ID, "user.deleted", map[string]audit.Change{
+		"email": {From: record.Email, To: nil},
+	})
+
+	return nil
+}
+
+func (s *Service) checkPassword(ctx context.Context, email string, passwordHash string, password string, client Client) error {
+	if err := s.lockout.Check(ctx, lockout.LoginAccount(email), lockout.LoginIP(client.IP)); err != nil {
+		return err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)); err != nil {
+		return s.rejectLogin(ctx, email, client, ErrIncorrectPassword)
+	}
+
+	return nil
+}
+
+func (s *Service) getUserRecord(ctx context.Context, userID string) (data.UserRecord, error) {
+	record, found, err := s.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return data.UserRecord{}, fmt.Errorf("lookup user by id: %w", err)
+	}
+	if !found {
+		return data.UserRecord{}, ErrUserNotFound
+	}
+
+	return record, nil
+}
@@ -0,0 +1,161 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/data/datatest"
+	"github.com/brian-nunez/bbaas-api/internal/mailer"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+)
+
+func TestChangePassword(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	service := NewService(store, security.NewTokenHasher("test-pepper"), SessionPolicy{})
+
+	user, err := service.Register(ctx, "change@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	_, current, err := service.Login(ctx, user.Email, "password123", Client{})
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	_, other, err := service.Login(ctx, user.Email, "password123", Client{})
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	if _, err := service.ChangePassword(ctx, user, "wrong-password", "new-password", current.ID, Client{}); !errors.Is(err, ErrIncorrectPassword) {
+		t.Fatalf("expected ErrIncorrectPassword, got %v", err)
+	}
+	if _, err := service.ChangePassword(ctx, user, "password123", "short", current.ID, Client{}); !errors.Is(err, ErrPasswordTooShort) {
+		t.Fatalf("expected ErrPasswordTooShort, got %v", err)
+	}
+
+	revoked, err := service.ChangePassword(ctx, user, "password123", "new-password", current.ID, Client{})
+	if err != nil {
+		t.Fatalf("change password: %v", err)
+	}
+	if revoked != 1 {
+		t.Fatalf("expected the other session to be ended, ended %d", revoked)
+	}
+	if _, found, _ := service.AuthenticateSession(ctx, current.Token, Client{}); !found {
+		t.Fatalf("expected the current session to stay signed in")
+	}
+	if _, found, _ := service.AuthenticateSession(ctx, other.Token, Client{}); found {
+		t.Fatalf("expected the other session to be logged out")
+	}
+	if _, _, err := service.Login(ctx, user.Email, "password123", Client{}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected the old password to stop working, got %v", err)
+	}
+	if _, _, err := service.Login(ctx, user.Email, "new-password", Client{}); err != nil {
+		t.Fatalf("login with new password: %v", err)
+	}
+}
+
+func TestEmailChange(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	tokenHasher := security.NewTokenHasher("test-pepper")
+	service := NewService(store, tokenHasher, SessionPolicy{})
+	outbox := &recordingMailer{}
+	changer := NewEmailChanger(service, store, tokenHasher, outbox, "https://bbaas.example.com")
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	changer.now = func() time.Time { return now }
+
+	if _, err := service.Register(ctx, "root@example.com", "password123"); err != nil {
+		t.Fatalf("register admin: %v", err)
+	}
+	user, err := service.Register(ctx, "old@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+
+	if _, err := changer.Request(ctx, user, "new@example.com", "wrong-password", Client{}); !errors.Is(err, ErrIncorrectPassword) {
+		t.Fatalf("expected ErrIncorrectPassword, got %v", err)
+	}
+	if _, err := changer.Request(ctx, user, "Old@Example.com", "password123", Client{}); !errors.Is(err, ErrSameEmail) {
+		t.Fatalf("expected ErrSameEmail, got %v", err)
+	}
+	if _, err := changer.Request(ctx, user, "root@example.com", "password123", Client{}); !errors.Is(err, ErrEmailAlreadyExists) {
+		t.Fatalf("expected ErrEmailAlreadyExists, got %v", err)
+	}
+
+	if _, err := changer.Request(ctx, user, "first@example.com", "password123", Client{}); err != nil {
+		t.Fatalf("request email change: %v", err)
+	}
+	pending, err := changer.Request(ctx, user, " New@Example.com ", "password123", Client{})
+	if err != nil {
+		t.Fatalf("request email change: %v", err)
+	}
+	if pending.NewEmail != "new@example.com" {
+		t.Fatalf("unexpected pending change %+v", pending)
+	}
+	messages := outbox.sent()
+	if len(messages) != 2 || messages[1].To[0] != "new@example.com" {
+		t.Fatalf("expected the confirmation to go to the new address, got %+v", messages)
+	}
+	if _, err := changer.Confirm(ctx, emailChangeTokenFromMessage(t, messages[0])); !errors.Is(err, ErrInvalidEmailChangeToken) {
+		t.Fatalf("expected a superseded link to be rejected, got %v", err)
+	}
+
+	changed, err := changer.Confirm(ctx, emailChangeTokenFromMessage(t, messages[1]))
+	if err != nil {
+		t.Fatalf("confirm email change: %v", err)
+	}
+	if changed.Email != "new@example.com" || !changed.IsVerified() {
+		t.Fatalf("expected a verified new address, got %+v", changed)
+	}
+	notice := outbox.sent()[2]
+	if notice.To[0] != "old@example.com" {
+		t.Fatalf("expected the old address to be notified, got %v", notice.To)
+	}
+	if _, found, err := changer.Pending(ctx, changed); err != nil || found {
+		t.Fatalf("expected no pending change, found=%v err=%v", found, err)
+	}
+	if _, _, err := service.Login(ctx, "new@example.com", "password123", Client{}); err != nil {
+		t.Fatalf("login with new address: %v", err)
+	}
+
+	if _, err := changer.Request(ctx, changed, "late@example.com", "password123", Client{}); err != nil {
+		t.Fatalf("request email change: %v", err)
+	}
+	now = now.Add(EmailChangeTTL)
+	if _, err := changer.Confirm(ctx, emailChangeTokenFromMessage(t, outbox.sent()[3])); !errors.Is(err, ErrInvalidEmailChangeToken) {
+		t.Fatalf("expected an expired link to be rejected, got %v", err)
+	}
+	deleted, err := changer.DeleteExpired(ctx)
+	if err != nil {
+		t.Fatalf("delete expired email changes: %v", err)
+	}
+	if deleted != 1 {
+		t.Fatalf("expected the expired change to be deleted, deleted %d", deleted)
+	}
+}
+
+var emailChangeLinkPattern = regexp.MustCompile(`https://bbaas\.example\.com/settings/account/email/confirm\?token=(\S+)`)
+
+func emailChangeTokenFromMessage(t *testing.T, message mailer.Message) string {
+	t.Helper()
+
+	match := emailChangeLinkPattern.FindStringSubmatch(message.Body)
+	if match == nil {
+		t.Fatalf("expected an email change link in %q", message.Body)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatalf("decode email change token: %v", err)
+	}
+
+	return token
+}
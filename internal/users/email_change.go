@@ -0,0 +1,213 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/audit"
+	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/mailer"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+)
+
+// EmailChangeTTL is how long the link confirming a new email address stays valid.
+const EmailChangeTTL = 24 * time.Hour
+
+var (
+	ErrInvalidEmailChangeToken = errors.New("email change link is invalid or has expired")
+	ErrSameEmail               = errors.New("that is already your email address")
+)
+
+// PendingEmailChange is an address change waiting to be confirmed from the new address.
+type PendingEmailChange struct {
+	NewEmail  string
+	ExpiresAt time.Time
+}
+
+// EmailChanger moves users to a new email address once they follow a link sent to it. The
+// old address keeps working until then and is told about the change afterwards.
+type EmailChanger struct {
+	service     *Service
+	store       *data.Store
+	tokenHasher *security.TokenHasher
+	mailer      mailer.Mailer
+	baseURL     string
+	now         func() time.Time
+}
+
+func NewEmailChanger(service *Service, store *data.Store, tokenHasher *security.TokenHasher, mailer mailer.Mailer, baseURL string) *EmailChanger {
+	return &EmailChanger{
+		service:     service,
+		store:       store,
+		tokenHasher: tokenHasher,
+		mailer:      mailer,
+		baseURL:     strings.TrimRight(strings.TrimSpace(baseURL), "/"),
+		now:         time.Now,
+	}
+}
+
+// Request emails a confirmation link to newEmail, replacing any earlier pending change.
+func (e *EmailChanger) Request(ctx context.Context, user User, newEmail string, password string, client Client) (PendingEmailChange, error) {
+	if err := e.service.Reauthenticate(ctx, user, password, client); err != nil {
+		return PendingEmailChange{}, err
+	}
+
+	normalizedEmail, err := normalizeEmail(newEmail)
+	if err != nil {
+		return PendingEmailChange{}, err
+	}
+	if normalizedEmail == user.Email {
+		return PendingEmailChange{}, ErrSameEmail
+	}
+	if err := e.checkEmailAvailable(ctx, normalizedEmail); err != nil {
+		return PendingEmailChange{}, err
+	}
+
+	changeToken, err := security.GeneratePrefixedToken("ect", 24)
+	if err != nil {
+		return PendingEmailChange{}, fmt.Errorf("generate email change token: %w", err)
+	}
+	changeID, err := security.GeneratePrefixedToken("ecr", 12)
+	if err != nil {
+		return PendingEmailChange{}, fmt.Errorf("generate email change id: %w", err)
+	}
+
+	now := e.now().UTC()
+	record := data.EmailChangeRecord{
+		ID:        changeID,
+		UserID:    user.ID,
+		NewEmail:  normalizedEmail,
+		TokenHash: e.tokenHasher.Hash(changeToken).Hash,
+		ExpiresAt: now.Add(EmailChangeTTL),
+		CreatedAt: now,
+	}
+	if err := e.store.CreateEmailChange(ctx, record); err != nil {
+		return PendingEmailChange{}, fmt.Errorf("create email change: %w", err)
+	}
+
+	if err := e.mailer.Send(ctx, e.buildConfirmationMessage(normalizedEmail, changeToken)); err != nil {
+		return PendingEmailChange{}, fmt.Errorf("send email change confirmation: %w", err)
+	}
+
+	pending := PendingEmailChange{NewEmail: record.NewEmail, ExpiresAt: record.ExpiresAt}
+	recordUserEvent(ctx, e.service.audit, user.ID, user.ID, "user.email_change_requested", map[string]audit.Change{
+		"email": {From: user.Email, To: record.NewEmail},
+	})
+
+	return pending, nil
+}
+
+func (e *EmailChanger) Pending(ctx context.Context, user User) (PendingEmailChange, bool, error) {
+	record, found, err := e.store.GetEmailChangeByUserID(ctx, user.ID)
+	if err != nil {
+		return PendingEmailChange{}, false, fmt.Errorf("lookup pending email change: %w", err)
+	}
+	if !found || !e.now().UTC().Before(record.ExpiresAt) {
+		return PendingEmailChange{}, false, nil
+	}
+
+	return PendingEmailChange{NewEmail: record.NewEmail, ExpiresAt: record.ExpiresAt}, true, nil
+}
+
+func (e *EmailChanger) Cancel(ctx context.Context, user User) error {
+	if _, err := e.store.DeleteEmailChange(ctx, user.ID); err != nil {
+		return fmt.Errorf("cancel email change: %w", err)
+	}
+
+	return nil
+}
+
+// Confirm consumes a change token, moves its user to the new address and marks that address
+// verified. The previous address gets a notice.
+func (e *EmailChanger) Confirm(ctx context.Context, changeToken string) (User, error) {
+	changeToken = strings.TrimSpace(changeToken)
+	if changeToken == "" {
+		return User{}, ErrInvalidEmailChangeToken
+	}
+	tokenHash := e.tokenHasher.Hash(changeToken).Hash
+
+	pending, found, err := e.store.GetEmailChangeByTokenHash(ctx, tokenHash)
+	if err != nil {
+		return User{}, fmt.Errorf("lookup email change: %w", err)
+	}
+	if !found {
+		return User{}, ErrInvalidEmailChangeToken
+	}
+	previous, err := e.service.getUserRecord(ctx, pending.UserID)
+	if err != nil {
+		return User{}, err
+	}
+	// The address may have been registered since the change was requested.
+	if err := e.checkEmailAvailable(ctx, pending.NewEmail); err != nil {
+		return User{}, err
+	}
+
+	change, confirmed, err := e.store.ConfirmEmailChange(ctx, tokenHash, e.now().UTC())
+	if err != nil {
+		return User{}, fmt.Errorf("confirm email change: %w", err)
+	}
+	if !confirmed {
+		return User{}, ErrInvalidEmailChangeToken
+	}
+
+	if err := e.mailer.Send(ctx, buildEmailChangedNotice(previous.Email, change.NewEmail)); err != nil {
+		return User{}, fmt.Errorf("send email change notice: %w", err)
+	}
+	recordUserEvent(ctx, e.service.audit, change.UserID, change.UserID, "user.email_changed", map[string]audit.Change{
+		"email": {From: previous.Email, To: change.NewEmail},
+	})
+
+	return e.service.GetUser(ctx, change.UserID)
+}
+
+func (e *EmailChanger) DeleteExpired(ctx context.Context) (int, error) {
+	deleted, err := e.store.DeleteExpiredEmailChanges(ctx, e.now().UTC())
+	if err != nil {
+		return 0, fmt.Errorf("delete expired email changes: %w", err)
+	}
+
+	return deleted, nil
+}
+
+func (e *EmailChanger) checkEmailAvailable(ctx context.Context, email string) error {
+	_, found, err := e.store.GetUserByEmail(ctx, email)
+	if err != nil {
+		return fmt.Errorf("lookup user by email: %w", err)
+	}
+	if found {
+		return ErrEmailAlreadyExists
+	}
+
+	return nil
+}
+
+func (e *EmailChanger) buildConfirmationMessage(newEmail string, changeToken string) mailer.Message {
+	confirmURL := e.baseURL + "/settings/account/email/confirm?token=" + url.QueryEscape(changeToken)
+
+	var body strings.Builder
+	body.WriteString("Confirm that you want to use this address for your BBAAS account:\n")
+	fmt.Fprintf(&body, "%s\n\n", confirmURL)
+	body.WriteString("The link expires in 24 hours. Until then you keep signing in with your current address. If you did not ask for this, you can ignore this email.\n")
+
+	return mailer.Message{
+		To:      []string{newEmail},
+		Subject: "Confirm your new BBAAS email address",
+		Body:    body.String(),
+	}
+}
+
+func buildEmailChangedNotice(previousEmail string, newEmail string) mailer.Message {
+	var body strings.Builder
+	fmt.Fprintf(&body, "The email address of your BBAAS account was changed to %s.\n\n", newEmail)
+	body.WriteString("If you did not make this change, contact an administrator right away.\n")
+
+	return mailer.Message{
+		To:      []string{previousEmail},
+		Subject: "Your BBAAS email address was changed",
+		Body:    body.String(),
+	}
+}
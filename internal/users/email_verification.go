@@ -0,0 +1,129 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/mailer"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+)
+
+const (
+	// EmailVerificationTokenTTL is how long an emailed verification link stays valid.
+	EmailVerificationTokenTTL = 24 * time.Hour
+	// EmailVerificationResendInterval is the minimum wait between verification emails.
+	EmailVerificationResendInterval = time.Minute
+)
+
+var (
+	ErrInvalidVerificationToken = errors.New("email verification link is invalid or has expired")
+	ErrEmailAlreadyVerified     = errors.New("email address is already verified")
+	ErrVerificationRecentlySent = errors.New("a verification email was just sent; check your inbox or try again in a minute")
+)
+
+// EmailVerifier emails single-use links that prove a user owns their address.
+type EmailVerifier struct {
+	store       *data.Store
+	tokenHasher *security.TokenHasher
+	mailer      mailer.Mailer
+	baseURL     string
+	now         func() time.Time
+}
+
+func NewEmailVerifier(store *data.Store, tokenHasher *security.TokenHasher, mailer mailer.Mailer, baseURL string) *EmailVerifier {
+	return &EmailVerifier{
+		store:       store,
+		tokenHasher: tokenHasher,
+		mailer:      mailer,
+		baseURL:     strings.TrimRight(strings.TrimSpace(baseURL), "/"),
+		now:         time.Now,
+	}
+}
+
+// SendVerification emails user a new verification link, replacing any earlier one.
+func (v *EmailVerifier) SendVerification(ctx context.Context, user User) error {
+	if user.IsVerified() {
+		return ErrEmailAlreadyVerified
+	}
+
+	now := v.now().UTC()
+	sentAt, found, err := v.store.GetEmailVerificationSentAt(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("lookup previous verification email: %w", err)
+	}
+	if found && now.Sub(sentAt) < EmailVerificationResendInterval {
+		return ErrVerificationRecentlySent
+	}
+
+	verificationToken, err := security.GeneratePrefixedToken("evt", 24)
+	if err != nil {
+		return fmt.Errorf("generate email verification token: %w", err)
+	}
+	tokenID, err := security.GeneratePrefixedToken("evr", 12)
+	if err != nil {
+		return fmt.Errorf("generate email verification token id: %w", err)
+	}
+
+	if err := v.store.CreateEmailVerificationToken(ctx, data.EmailVerificationTokenRecord{
+		ID:        tokenID,
+		UserID:    user.ID,
+		TokenHash: v.tokenHasher.Hash(verificationToken).Hash,
+		ExpiresAt: now.Add(EmailVerificationTokenTTL),
+		CreatedAt: now,
+	}); err != nil {
+		return fmt.Errorf("create email verification token: %w", err)
+	}
+
+	if err := v.mailer.Send(ctx, v.buildVerificationMessage(user.Email, verificationToken)); err != nil {
+		return fmt.Errorf("send verification email: %w", err)
+	}
+
+	return nil
+}
+
+// Verify consumes a verification token and marks its user verified.
+func (v *EmailVerifier) Verify(ctx context.Context, verificationToken string) error {
+	verificationToken = strings.TrimSpace(verificationToken)
+	if verificationToken == "" {
+		return ErrInvalidVerificationToken
+	}
+
+	_, verified, err := v.store.VerifyEmailWithToken(ctx, v.tokenHasher.Hash(verificationToken).Hash, v.now().UTC())
+	if err != nil {
+		return fmt.Errorf("verify email: %w", err)
+	}
+	if !verified {
+		return ErrInvalidVerificationToken
+	}
+
+	return nil
+}
+
+func (v *EmailVerifier) DeleteExpiredTokens(ctx context.Context) (int, error) {
+	deleted, err := v.store.DeleteExpiredEmailVerificationTokens(ctx, v.now().UTC())
+	if err != nil {
+		return 0, fmt.Errorf("delete expired email verification tokens: %w", err)
+	}
+
+	return deleted, nil
+}
+
+func (v *EmailVerifier) buildVerificationMessage(email string, verificationToken string) mailer.Message {
+	verifyURL := v.baseURL + "/verify-email?token=" + url.QueryEscape(verificationToken)
+
+	var body strings.Builder
+	body.WriteString("Confirm that this address belongs to your BBAAS account:\n")
+	fmt.Fprintf(&body, "%s\n\n", verifyURL)
+	body.WriteString("The link expires in 24 hours. If you did not create an account, you can ignore this email.\n")
+
+	return mailer.Message{
+		To:      []string{email},
+		Subject: "Verify your BBAAS email address",
+		Body:    body.String(),
+	}
+}
@@ -0,0 +1,136 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/data/datatest"
+	"github.com/brian-nunez/bbaas-api/internal/mailer"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+)
+
+func TestEmailVerification(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	tokenHasher := security.NewTokenHasher("test-pepper")
+	service := NewService(store, tokenHasher, SessionPolicy{})
+	outbox := &recordingMailer{}
+	verifier := NewEmailVerifier(store, tokenHasher, outbox, "https://bbaas.example.com")
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	verifier.now = func() time.Time { return now }
+
+	admin, err := service.Register(ctx, "admin@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register admin: %v", err)
+	}
+	if !admin.IsAdmin() || !admin.IsVerified() {
+		t.Fatalf("expected the bootstrap admin to be verified, got %+v", admin)
+	}
+	if err := verifier.SendVerification(ctx, admin); !errors.Is(err, ErrEmailAlreadyVerified) {
+		t.Fatalf("expected verified users to be skipped, got %v", err)
+	}
+
+	user, err := service.Register(ctx, "member@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	if user.IsVerified() {
+		t.Fatalf("expected later registrations to start unverified")
+	}
+
+	if err := verifier.SendVerification(ctx, user); err != nil {
+		t.Fatalf("send verification: %v", err)
+	}
+	if err := verifier.SendVerification(ctx, user); !errors.Is(err, ErrVerificationRecentlySent) {
+		t.Fatalf("expected an immediate resend to be throttled, got %v", err)
+	}
+	now = now.Add(EmailVerificationResendInterval)
+	if err := verifier.SendVerification(ctx, user); err != nil {
+		t.Fatalf("resend verification: %v", err)
+	}
+
+	messages := outbox.sent()
+	if len(messages) != 2 {
+		t.Fatalf("expected two verification emails, got %d", len(messages))
+	}
+	firstToken := verificationTokenFromMessage(t, messages[0])
+	secondToken := verificationTokenFromMessage(t, messages[1])
+
+	if err := verifier.Verify(ctx, firstToken); !errors.Is(err, ErrInvalidVerificationToken) {
+		t.Fatalf("expected a superseded token to be rejected, got %v", err)
+	}
+	if err := verifier.Verify(ctx, secondToken); err != nil {
+		t.Fatalf("verify email: %v", err)
+	}
+	if err := verifier.Verify(ctx, secondToken); !errors.Is(err, ErrInvalidVerificationToken) {
+		t.Fatalf("expected a used token to be rejected, got %v", err)
+	}
+
+	verified, _, err := service.Login(ctx, "member@example.com", "password123", Client{})
+	if err != nil {
+		t.Fatalf("login user: %v", err)
+	}
+	if !verified.IsVerified() || !verified.VerifiedAt.Equal(now) {
+		t.Fatalf("expected the user to be verified at %v, got %v", now, verified.VerifiedAt)
+	}
+}
+
+func TestEmailVerificationTokensExpire(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	tokenHasher := security.NewTokenHasher("test-pepper")
+	service := NewService(store, tokenHasher, SessionPolicy{})
+	outbox := &recordingMailer{}
+	verifier := NewEmailVerifier(store, tokenHasher, outbox, "https://bbaas.example.com")
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	verifier.now = func() time.Time { return now }
+
+	if _, err := service.Register(ctx, "admin@example.com", "password123"); err != nil {
+		t.Fatalf("register admin: %v", err)
+	}
+	user, err := service.Register(ctx, "late@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	if err := verifier.SendVerification(ctx, user); err != nil {
+		t.Fatalf("send verification: %v", err)
+	}
+
+	now = now.Add(EmailVerificationTokenTTL)
+	if err := verifier.Verify(ctx, verificationTokenFromMessage(t, outbox.sent()[0])); !errors.Is(err, ErrInvalidVerificationToken) {
+		t.Fatalf("expected an expired token to be rejected, got %v", err)
+	}
+
+	deleted, err := verifier.DeleteExpiredTokens(ctx)
+	if err != nil {
+		t.Fatalf("delete expired tokens: %v", err)
+	}
+	if deleted != 1 {
+		t.Fatalf("expected the expired token to be deleted, deleted %d", deleted)
+	}
+}
+
+var verificationLinkPattern = regexp.MustCompile(`https://bbaas\.example\.com/verify-email\?token=(\S+)`)
+
+func verificationTokenFromMessage(t *testing.T, message mailer.Message) string {
+	t.Helper()
+
+	match := verificationLinkPattern.FindStringSubmatch(message.Body)
+	if match == nil {
+		t.Fatalf("expected a verification link in %q", message.Body)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatalf("decode verification token: %v", err)
+	}
+
+	return token
+}
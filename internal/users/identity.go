@@ -0,0 +1,130 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+)
+
+var (
+	ErrPasswordLoginDisabled   = errors.New("password login is disabled; sign in with single sign-on")
+	ErrInvalidIdentity         = errors.New("identity provider did not return a usable account")
+	ErrIdentityEmailUnverified = errors.New("identity provider has not verified your email address")
+)
+
+// ExternalIdentity is an account asserted by an external identity provider.
+type ExternalIdentity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	// Role, when set, replaces the user's role on every login so the provider stays the
+	// source of truth for who is an admin.
+	Role string
+}
+
+// LoginWithIdentity signs in the user linked to identity. Unknown identities are linked to
+// the account with the same verified email, or provisioned as a new user. Users with 2FA
+// enabled still get a SecondFactorRequiredError.
+func (s *Service) LoginWithIdentity(ctx context.Context, identity ExternalIdentity, client Client) (User, IssuedSession, error) {
+	if strings.TrimSpace(identity.Issuer) == "" || strings.TrimSpace(identity.Subject) == "" {
+		return User{}, IssuedSession{}, ErrInvalidIdentity
+	}
+
+	userRecord, found, err := s.store.GetUserByIdentity(ctx, identity.Issuer, identity.Subject)
+	if err != nil {
+		return User{}, IssuedSession{}, fmt.Errorf("lookup user by identity: %w", err)
+	}
+	if !found {
+		userRecord, err = s.linkOrProvision(ctx, identity)
+		if err != nil {
+			return User{}, IssuedSession{}, err
+		}
+	}
+
+	if identity.Role != "" && identity.Role != userRecord.Role {
+		now := s.now().UTC()
+		if _, err := s.store.UpdateUserRole(ctx, userRecord.ID, identity.Role, now); err != nil {
+			return User{}, IssuedSession{}, fmt.Errorf("sync user role: %w", err)
+		}
+		userRecord.Role = identity.Role
+		userRecord.UpdatedAt = now
+	}
+
+	return s.finishLogin(ctx, userRecord, client)
+}
+
+func (s *Service) linkOrProvision(ctx context.Context, identity ExternalIdentity) (data.UserRecord, error) {
+	normalizedEmail, err := normalizeEmail(identity.Email)
+	if err != nil {
+		return data.UserRecord{}, ErrInvalidIdentity
+	}
+	// An unverified address could belong to someone else's account here.
+	if !identity.EmailVerified {
+		return data.UserRecord{}, ErrIdentityEmailUnverified
+	}
+
+	identityID, err := security.GeneratePrefixedToken("uid", 12)
+	if err != nil {
+		return data.UserRecord{}, fmt.Errorf("generate identity id: %w", err)
+	}
+	now := s.now().UTC()
+	identityRecord := data.UserIdentityRecord{
+		ID:        identityID,
+		Issuer:    identity.Issuer,
+		Subject:   identity.Subject,
+		CreatedAt: now,
+	}
+
+	existingUser, found, err := s.store.GetUserByEmail(ctx, normalizedEmail)
+	if err != nil {
+		return data.UserRecord{}, fmt.Errorf("lookup user by email: %w", err)
+	}
+	if found {
+		identityRecord.UserID = existingUser.ID
+		if err := s.store.LinkUserIdentity(ctx, identityRecord); err != nil {
+			return data.UserRecord{}, fmt.Errorf("link identity: %w", err)
+		}
+		if existingUser.VerifiedAt == nil {
+			existingUser.VerifiedAt = &now
+		}
+		return existingUser, nil
+	}
+
+	userID, err := security.GeneratePrefixedToken("usr", 18)
+	if err != nil {
+		return data.UserRecord{}, fmt.Errorf("generate user id: %w", err)
+	}
+
+	role := identity.Role
+	if role == "" {
+		role = "user"
+		usersCount, err := s.store.CountUsers(ctx)
+		if err != nil {
+			return data.UserRecord{}, fmt.Errorf("count existing users: %w", err)
+		}
+		if usersCount == 0 {
+			role = "admin"
+		}
+	}
+
+	// Provisioned users have no password; they can only sign in through the provider.
+	record := data.UserRecord{
+		ID:         userID,
+		Email:      normalizedEmail,
+		Role:       role,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		VerifiedAt: &now,
+	}
+	identityRecord.UserID = userID
+	if err := s.store.CreateUserWithIdentity(ctx, record, identityRecord); err != nil {
+		return data.UserRecord{}, fmt.Errorf("provision user: %w", err)
+	}
+
+	return record, nil
+}
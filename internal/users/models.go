@@ -8,8 +8,60 @@ type User struct {
 	Role      string
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	// VerifiedAt is when the user confirmed they own Email; nil until then.
+	VerifiedAt *time.Time
+	// TwoFactorEnabledAt is when the user turned on TOTP; nil if they have not.
+	TwoFactorEnabledAt *time.Time
+	// DisabledAt is when an admin disabled the account; nil while it is active.
+	DisabledAt *time.Time
+	// HasPassword is false for accounts provisioned through single sign-on.
+	HasPassword bool
 }
 
 func (u User) IsAdmin() bool {
 	return u.Role == "admin"
 }
+
+func (u User) IsVerified() bool {
+	return u.VerifiedAt != nil
+}
+
+func (u User) HasTwoFactor() bool {
+	return u.TwoFactorEnabledAt != nil
+}
+
+func (u User) IsDisabled() bool {
+	return u.DisabledAt != nil
+}
+
+// Client describes where a web request came from.
+type Client struct {
+	IP        string
+	UserAgent string
+}
+
+// IssuedSession is a session created by a login; Token is only available here.
+type IssuedSession struct {
+	ID        string
+	Token     string
+	ExpiresAt time.Time
+}
+
+type AuthenticatedSession struct {
+	User      User
+	SessionID string
+	ExpiresAt time.Time
+	// RotatedToken replaces the presented token when set; the old token no longer works.
+	RotatedToken string
+}
+
+type Session struct {
+	ID         string
+	IP         string
+	UserAgent  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	// Current marks the session the listing was requested from.
+	Current bool
+}
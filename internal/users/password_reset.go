@@ -0,0 +1,146 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/audit"
+	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/mailer"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordResetTokenTTL is how long an emailed reset link stays valid.
+const PasswordResetTokenTTL = time.Hour
+
+var ErrInvalidResetToken = errors.New("password reset link is invalid or has expired")
+
+// PasswordResetter issues single-use password reset links by email and applies them.
+type PasswordResetter struct {
+	store       *data.Store
+	tokenHasher *security.TokenHasher
+	mailer      mailer.Mailer
+	baseURL     string
+	audit       *audit.Recorder
+	now         func() time.Time
+}
+
+// NewPasswordResetter builds reset links under baseURL, the externally reachable address of
+// the web UI (for example https://bbaas.example.com).
+func NewPasswordResetter(store *data.Store, tokenHasher *security.TokenHasher, mailer mailer.Mailer, baseURL string) *PasswordResetter {
+	return &PasswordResetter{
+		store:       store,
+		tokenHasher: tokenHasher,
+		mailer:      mailer,
+		baseURL:     strings.TrimRight(strings.TrimSpace(baseURL), "/"),
+		now:         time.Now,
+	}
+}
+
+// UseAuditRecorder records completed password resets in the audit trail.
+func (r *PasswordResetter) UseAuditRecorder(recorder *audit.Recorder) {
+	r.audit = recorder
+}
+
+// RequestReset emails a reset link when email belongs to an account. Unknown addresses are
+// ignored without an error so the response does not reveal which emails are registered.
+func (r *PasswordResetter) RequestReset(ctx context.Context, email string) error {
+	normalizedEmail, err := normalizeEmail(email)
+	if err != nil {
+		return err
+	}
+
+	userRecord, found, err := r.store.GetUserByEmail(ctx, normalizedEmail)
+	if err != nil {
+		return fmt.Errorf("lookup user by email: %w", err)
+	}
+	if !found {
+		return nil
+	}
+
+	resetToken, err := security.GeneratePrefixedToken("pwr", 24)
+	if err != nil {
+		return fmt.Errorf("generate password reset token: %w", err)
+	}
+	tokenID, err := security.GeneratePrefixedToken("prt", 12)
+	if err != nil {
+		return fmt.Errorf("generate password reset token id: %w", err)
+	}
+
+	now := r.now().UTC()
+	if err := r.store.CreatePasswordResetToken(ctx, data.PasswordResetTokenRecord{
+		ID:        tokenID,
+		UserID:    userRecord.ID,
+		TokenHash: r.tokenHasher.Hash(resetToken).Hash,
+		ExpiresAt: now.Add(PasswordResetTokenTTL),
+		CreatedAt: now,
+	}); err != nil {
+		return fmt.Errorf("create password reset token: %w", err)
+	}
+
+	if err := r.mailer.Send(ctx, r.buildResetMessage(userRecord.Email, resetToken)); err != nil {
+		return fmt.Errorf("send password reset email: %w", err)
+	}
+
+	return nil
+}
+
+// ResetPassword sets a new password using a reset token. The token is consumed and every
+// existing session for the user is logged out.
+func (r *PasswordResetter) ResetPassword(ctx context.Context, resetToken string, newPassword string) error {
+	resetToken = strings.TrimSpace(resetToken)
+	if resetToken == "" {
+		return ErrInvalidResetToken
+	}
+	if err := validatePassword(newPassword); err != nil {
+		return err
+	}
+
+	passwordHashBytes, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+
+	userID, reset, err := r.store.ResetPasswordWithToken(ctx, r.tokenHasher.Hash(resetToken).Hash, string(passwordHashBytes), r.now().UTC())
+	if err != nil {
+		return fmt.Errorf("reset password: %w", err)
+	}
+	if !reset {
+		return ErrInvalidResetToken
+	}
+
+	recordUserEvent(ctx, r.audit, userID, userID, "user.password_reset", nil)
+
+	return nil
+}
+
+// DeleteStaleTokens removes reset tokens that have expired or already been used.
+func (r *PasswordResetter) DeleteStaleTokens(ctx context.Context) (int, error) {
+	deleted, err := r.store.DeleteStalePasswordResetTokens(ctx, r.now().UTC())
+	if err != nil {
+		return 0, fmt.Errorf("delete stale password reset tokens: %w", err)
+	}
+
+	return deleted, nil
+}
+
+func (r *PasswordResetter) buildResetMessage(email string, resetToken string) mailer.Message {
+	resetURL := r.baseURL + "/password-reset/confirm?token=" + url.QueryEscape(resetToken)
+
+	var body strings.Builder
+	body.WriteString("Someone asked to reset the password for your BBAAS account.\n\n")
+	fmt.Fprintf(&body, "Choose a new password here within the next %d minutes:\n%s\n\n", int(PasswordResetTokenTTL/time.Minute), resetURL)
+	body.WriteString("The link works once. Resetting your password logs out every active session.\n")
+	body.WriteString("If you did not ask for this, you can ignore this email; your password has not changed.\n")
+
+	return mailer.Message{
+		To:      []string{email},
+		Subject: "Reset your BBAAS password",
+		Body:    body.String(),
+	}
+}
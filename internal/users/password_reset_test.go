@@ -0,0 +1,132 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/data/datatest"
+	"github.com/brian-nunez/bbaas-api/internal/mailer"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+)
+
+func TestPasswordResetFlow(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	tokenHasher := security.NewTokenHasher("test-pepper")
+	service := NewService(store, tokenHasher, SessionPolicy{})
+	outbox := &recordingMailer{}
+	resetter := NewPasswordResetter(store, tokenHasher, outbox, "https://bbaas.example.com/")
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	resetter.now = func() time.Time { return now }
+
+	if _, err := service.Register(ctx, "reset@example.com", "password123"); err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	_, session, err := service.Login(ctx, "reset@example.com", "password123", Client{})
+	if err != nil {
+		t.Fatalf("login user: %v", err)
+	}
+
+	if err := resetter.RequestReset(ctx, "missing@example.com"); err != nil {
+		t.Fatalf("expected unknown emails to be accepted silently, got %v", err)
+	}
+	if len(outbox.sent()) != 0 {
+		t.Fatalf("expected no email for an unknown address")
+	}
+
+	if err := resetter.RequestReset(ctx, "Reset@Example.com"); err != nil {
+		t.Fatalf("request reset: %v", err)
+	}
+	firstToken := resetTokenFromMessage(t, outbox.sent()[0])
+	if err := resetter.RequestReset(ctx, "reset@example.com"); err != nil {
+		t.Fatalf("request reset: %v", err)
+	}
+	secondToken := resetTokenFromMessage(t, outbox.sent()[1])
+
+	if err := resetter.ResetPassword(ctx, firstToken, "new-password"); !errors.Is(err, ErrInvalidResetToken) {
+		t.Fatalf("expected a superseded token to be rejected, got %v", err)
+	}
+	if err := resetter.ResetPassword(ctx, secondToken, "short"); !errors.Is(err, ErrPasswordTooShort) {
+		t.Fatalf("expected password validation, got %v", err)
+	}
+	if err := resetter.ResetPassword(ctx, secondToken, "new-password"); err != nil {
+		t.Fatalf("reset password: %v", err)
+	}
+	if err := resetter.ResetPassword(ctx, secondToken, "another-password"); !errors.Is(err, ErrInvalidResetToken) {
+		t.Fatalf("expected a used token to be rejected, got %v", err)
+	}
+
+	if _, found, _ := service.AuthenticateSession(ctx, session.Token, Client{}); found {
+		t.Fatalf("expected existing sessions to be logged out by the reset")
+	}
+	if _, _, err := service.Login(ctx, "reset@example.com", "password123", Client{}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected the old password to stop working, got %v", err)
+	}
+	if _, _, err := service.Login(ctx, "reset@example.com", "new-password", Client{}); err != nil {
+		t.Fatalf("login with new password: %v", err)
+	}
+
+	if err := resetter.RequestReset(ctx, "reset@example.com"); err != nil {
+		t.Fatalf("request reset: %v", err)
+	}
+	expiredToken := resetTokenFromMessage(t, outbox.sent()[2])
+	now = now.Add(PasswordResetTokenTTL)
+	if err := resetter.ResetPassword(ctx, expiredToken, "expired-password"); !errors.Is(err, ErrInvalidResetToken) {
+		t.Fatalf("expected an expired token to be rejected, got %v", err)
+	}
+
+	deleted, err := resetter.DeleteStaleTokens(ctx)
+	if err != nil {
+		t.Fatalf("delete stale tokens: %v", err)
+	}
+	if deleted != 2 {
+		t.Fatalf("expected the used and expired tokens to be deleted, deleted %d", deleted)
+	}
+}
+
+var resetLinkPattern = regexp.MustCompile(`https://bbaas\.example\.com/password-reset/confirm\?token=(\S+)`)
+
+func resetTokenFromMessage(t *testing.T, message mailer.Message) string {
+	t.Helper()
+
+	if len(message.To) != 1 || message.To[0] != "reset@example.com" {
+		t.Fatalf("unexpected recipients %v", message.To)
+	}
+	match := resetLinkPattern.FindStringSubmatch(message.Body)
+	if match == nil {
+		t.Fatalf("expected a reset link in %q", message.Body)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatalf("decode reset token: %v", err)
+	}
+
+	return token
+}
+
+type recordingMailer struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+}
+
+func (m *recordingMailer) Send(_ context.Context, message mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+func (m *recordingMailer) sent() []mailer.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]mailer.Message(nil), m.messages...)
+}
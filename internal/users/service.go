@@ -8,7 +8,9 @@ import (
 	"strings"
 	"time"
 
+	"github.com/brian-nunez/bbaas-api/internal/audit"
 	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/lockout"
 	"github.com/brian-nunez/bbaas-api/internal/security"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -21,23 +23,87 @@ var (
 	ErrEmailAlreadyExists = errors.New("email is already registered")
 	ErrInvalidCredentials = errors.New("invalid email or password")
 	ErrSessionExpired     = errors.New("session has expired")
+	ErrSessionNotFound    = errors.New("session not found")
+	ErrAccountDisabled    = errors.New("this account has been disabled; contact an administrator")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrInvalidRole        = errors.New("role must be user or admin")
 )
 
+const (
+	DefaultSessionIdleTimeout     = 7 * 24 * time.Hour
+	DefaultSessionAbsoluteTimeout = 30 * 24 * time.Hour
+
+	maxUserAgentLength = 512
+
+	// sessionTouchInterval limits how often a session's last-seen details are written.
+	sessionTouchInterval = time.Minute
+)
+
+// SessionPolicy bounds how long a web session lives. Zero values use the defaults.
+type SessionPolicy struct {
+	// IdleTimeout ends a session that has not been used for this long.
+	IdleTimeout time.Duration
+	// AbsoluteTimeout ends a session this long after login, however active it is.
+	AbsoluteTimeout time.Duration
+}
+
 type Service struct {
-	store      *data.Store
-	now        func() time.Time
-	sessionTTL time.Duration
+	store       *data.Store
+	tokenHasher *security.TokenHasher
+	now         func() time.Time
+	policy      SessionPolicy
+	twoFactor   *TwoFactor
+	lockout     *lockout.Guard
+	audit       *audit.Recorder
+	// passwordLoginDisabled leaves single sign-on as the only way in.
+	passwordLoginDisabled bool
 }
 
-func NewService(store *data.Store) *Service {
+func NewService(store *data.Store, tokenHasher *security.TokenHasher, policy SessionPolicy) *Service {
+	if policy.IdleTimeout <= 0 {
+		policy.IdleTimeout = DefaultSessionIdleTimeout
+	}
+	if policy.AbsoluteTimeout <= 0 {
+		policy.AbsoluteTimeout = DefaultSessionAbsoluteTimeout
+	}
+
 	return &Service{
-		store:      store,
-		now:        time.Now,
-		sessionTTL: 30 * 24 * time.Hour,
+		store:       store,
+		tokenHasher: tokenHasher,
+		now:         time.Now,
+		policy:      policy,
 	}
 }
 
+// UseTwoFactor makes Login stop at a second step for users with 2FA enabled.
+func (s *Service) UseTwoFactor(twoFactor *TwoFactor) {
+	s.twoFactor = twoFactor
+}
+
+// UseLockout throttles repeated failed logins per email address and per source IP.
+func (s *Service) UseLockout(guard *lockout.Guard) {
+	s.lockout = guard
+}
+
+// UseAuditRecorder records password, email and session changes in the audit trail.
+func (s *Service) UseAuditRecorder(recorder *audit.Recorder) {
+	s.audit = recorder
+}
+
+// AllowPasswordLogin turns email and password registration and login on or off.
+func (s *Service) AllowPasswordLogin(allowed bool) {
+	s.passwordLoginDisabled = !allowed
+}
+
+func (s *Service) PasswordLoginAllowed() bool {
+	return !s.passwordLoginDisabled
+}
+
 func (s *Service) Register(ctx context.Context, email string, password string) (User, error) {
+	if s.passwordLoginDisabled {
+		return User{}, ErrPasswordLoginDisabled
+	}
+
 	normalizedEmail, err := normalizeEmail(email)
 	if err != nil {
 		return User{}, err
@@ -64,16 +130,20 @@ func (s *Service) Register(ctx context.Context, email string, password string) (
 		return User{}, fmt.Errorf("generate user id: %w", err)
 	}
 
+	now := s.now().UTC()
 	role := "user"
+	var verifiedAt *time.Time
 	usersCount, err := s.store.CountUsers(ctx)
 	if err != nil {
 		return User{}, fmt.Errorf("count existing users: %w", err)
 	}
 	if usersCount == 0 {
+		// The bootstrap admin is verified up front: mail delivery may not be set up yet,
+		// and there is nobody else who could hold the address.
 		role = "admin"
+		verifiedAt = &now
 	}
 
-	now := s.now().UTC()
 	record := data.UserRecord{
 		ID:           userID,
 		Email:        normalizedEmail,
@@ -81,6 +151,7 @@ func (s *Service) Register(ctx context.Context, email string, password string) (
 		Role:         role,
 		CreatedAt:    now,
 		UpdatedAt:    now,
+		VerifiedAt:   verifiedAt,
 	}
 	if err := s.store.CreateUser(ctx, record); err != nil {
 		return User{}, fmt.Errorf("create user: %w", err)
@@ -89,70 +160,268 @@ func (s *Service) Register(ctx context.Context, email string, password string) (
 	return mapUserRecord(record), nil
 }
 
-func (s *Service) Login(ctx context.Context, email string, password string) (User, string, error) {
+func (s *Service) Login(ctx context.Context, email string, password string, client Client) (User, IssuedSession, error) {
+	if s.passwordLoginDisabled {
+		return User{}, IssuedSession{}, ErrPasswordLoginDisabled
+	}
+
+	// Checked before the user lookup, so a locked address reads the same whether or not it
+	// is registered.
+	if err := s.lockout.Check(ctx, lockout.LoginAccount(email), lockout.LoginIP(client.IP)); err != nil {
+		return User{}, IssuedSession{}, err
+	}
+
 	normalizedEmail, err := normalizeEmail(email)
 	if err != nil {
-		return User{}, "", ErrInvalidCredentials
+		return User{}, IssuedSession{}, s.rejectLogin(ctx, email, client, ErrInvalidCredentials)
 	}
 
 	userRecord, found, err := s.store.GetUserByEmail(ctx, normalizedEmail)
 	if err != nil {
-		return User{}, "", fmt.Errorf("lookup user by email: %w", err)
+		return User{}, IssuedSession{}, fmt.Errorf("lookup user by email: %w", err)
 	}
 	if !found {
-		return User{}, "", ErrInvalidCredentials
+		return User{}, IssuedSession{}, s.rejectLogin(ctx, normalizedEmail, client, ErrInvalidCredentials)
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(userRecord.PasswordHash), []byte(password)); err != nil {
-		return User{}, "", ErrInvalidCredentials
+		return User{}, IssuedSession{}, s.rejectLogin(ctx, normalizedEmail, client, ErrInvalidCredentials)
 	}
 
-	sessionToken, err := security.GeneratePrefixedToken("sess", 24)
+	return s.finishLogin(ctx, userRecord, client)
+}
+
+// finishLogin issues a session for a user whose first factor checked out, or pauses the login
+// for a second factor when they have one.
+func (s *Service) finishLogin(ctx context.Context, userRecord data.UserRecord, client Client) (User, IssuedSession, error) {
+	if userRecord.DisabledAt != nil {
+		return User{}, IssuedSession{}, ErrAccountDisabled
+	}
+	if userRecord.TOTPEnabledAt != nil {
+		// Fail closed: without the encryption key the code cannot be checked.
+		if !s.twoFactor.Available() {
+			return User{}, IssuedSession{}, ErrTwoFactorUnavailable
+		}
+		return User{}, IssuedSession{}, s.startLoginChallenge(ctx, userRecord)
+	}
+
+	return s.issueSession(ctx, userRecord, client)
+}
+
+// CompleteLogin finishes a login that Login paused with a SecondFactorRequiredError. code may
+// be a TOTP code or a recovery code.
+func (s *Service) CompleteLogin(ctx context.Context, challengeToken string, code string, client Client) (User, IssuedSession, error) {
+	challengeToken = strings.TrimSpace(challengeToken)
+	if challengeToken == "" {
+		return User{}, IssuedSession{}, ErrLoginChallengeExpired
+	}
+
+	challenge, userRecord, found, err := s.store.GetLoginChallengeWithUserByTokenHash(ctx, s.tokenHasher.Hash(challengeToken).Hash)
 	if err != nil {
-		return User{}, "", fmt.Errorf("generate session token: %w", err)
+		return User{}, IssuedSession{}, fmt.Errorf("lookup login challenge: %w", err)
+	}
+	if !found {
+		return User{}, IssuedSession{}, ErrLoginChallengeExpired
+	}
+	if !challenge.ExpiresAt.After(s.now().UTC()) || userRecord.TOTPEnabledAt == nil {
+		_, _ = s.store.DeleteLoginChallenge(ctx, challenge.ID)
+		return User{}, IssuedSession{}, ErrLoginChallengeExpired
+	}
+	if userRecord.DisabledAt != nil {
+		_, _ = s.store.DeleteLoginChallenge(ctx, challenge.ID)
+		return User{}, IssuedSession{}, ErrAccountDisabled
+	}
+	if err := s.lockout.Check(ctx, lockout.LoginAccount(userRecord.Email), lockout.LoginIP(client.IP)); err != nil {
+		return User{}, IssuedSession{}, err
 	}
 
-	sessionID, err := security.GeneratePrefixedToken("sid", 12)
+	if err := s.twoFactor.verify(ctx, userRecord, code); err != nil {
+		if !errors.Is(err, ErrInvalidTwoFactorCode) {
+			return User{}, IssuedSession{}, err
+		}
+		// Wrong codes count against the account too, so starting over with the password
+		// does not buy a fresh set of guesses.
+		if recordErr := s.lockout.RecordFailure(ctx, lockout.LoginAccount(userRecord.Email), lockout.LoginIP(client.IP)); recordErr != nil {
+			return User{}, IssuedSession{}, recordErr
+		}
+
+		attempts, recordErr := s.store.RecordLoginChallengeAttempt(ctx, challenge.ID)
+		if recordErr != nil {
+			return User{}, IssuedSession{}, recordErr
+		}
+		if attempts >= MaxLoginChallengeAttempts {
+			_, _ = s.store.DeleteLoginChallenge(ctx, challenge.ID)
+			return User{}, IssuedSession{}, ErrLoginChallengeExpired
+		}
+		return User{}, IssuedSession{}, err
+	}
+
+	deleted, err := s.store.DeleteLoginChallenge(ctx, challenge.ID)
 	if err != nil {
-		return User{}, "", fmt.Errorf("generate session id: %w", err)
+		return User{}, IssuedSession{}, err
+	}
+	if !deleted {
+		return User{}, IssuedSession{}, ErrLoginChallengeExpired
+	}
+
+	return s.issueSession(ctx, userRecord, client)
+}
+
+// rejectLogin counts a failed login against the email address and source IP, then returns err.
+func (s *Service) rejectLogin(ctx context.Context, email string, client Client, err error) error {
+	if recordErr := s.lockout.RecordFailure(ctx, lockout.LoginAccount(email), lockout.LoginIP(client.IP)); recordErr != nil {
+		return fmt.Errorf("record failed login: %w", recordErr)
+	}
+
+	return err
+}
+
+func (s *Service) startLoginChallenge(ctx context.Context, userRecord data.UserRecord) error {
+	challengeToken, err := security.GeneratePrefixedToken("lch", 24)
+	if err != nil {
+		return fmt.Errorf("generate login challenge token: %w", err)
+	}
+	challengeID, err := security.GeneratePrefixedToken("lcr", 12)
+	if err != nil {
+		return fmt.Errorf("generate login challenge id: %w", err)
 	}
 
 	now := s.now().UTC()
-	sessionRecord := data.SessionRecord{
-		ID:        sessionID,
+	record := data.LoginChallengeRecord{
+		ID:        challengeID,
 		UserID:    userRecord.ID,
-		TokenHash: security.DigestSHA256(sessionToken),
+		TokenHash: s.tokenHasher.Hash(challengeToken).Hash,
+		ExpiresAt: now.Add(LoginChallengeTTL),
 		CreatedAt: now,
-		ExpiresAt: now.Add(s.sessionTTL),
+	}
+	if err := s.store.CreateLoginChallenge(ctx, record); err != nil {
+		return fmt.Errorf("create login challenge: %w", err)
+	}
+
+	return &SecondFactorRequiredError{
+		ChallengeToken: challengeToken,
+		ExpiresAt:      record.ExpiresAt,
+	}
+}
+
+func (s *Service) issueSession(ctx context.Context, userRecord data.UserRecord, client Client) (User, IssuedSession, error) {
+	sessionToken, err := generateSessionToken()
+	if err != nil {
+		return User{}, IssuedSession{}, err
+	}
+
+	sessionID, err := security.GeneratePrefixedToken("sid", 12)
+	if err != nil {
+		return User{}, IssuedSession{}, fmt.Errorf("generate session id: %w", err)
+	}
+
+	now := s.now().UTC()
+	tokenDigest := s.tokenHasher.Hash(sessionToken)
+	sessionRecord := data.SessionRecord{
+		ID:          sessionID,
+		UserID:      userRecord.ID,
+		TokenHash:   tokenDigest.Hash,
+		HashVersion: tokenDigest.Version,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.policy.AbsoluteTimeout),
+		LastSeenAt:  now,
+		IP:          client.IP,
+		UserAgent:   truncateUserAgent(client.UserAgent),
+		Role:        userRecord.Role,
 	}
 	if err := s.store.CreateSession(ctx, sessionRecord); err != nil {
-		return User{}, "", fmt.Errorf("create session: %w", err)
+		return User{}, IssuedSession{}, fmt.Errorf("create session: %w", err)
+	}
+	if err := s.lockout.Reset(ctx, lockout.LoginAccount(userRecord.Email)); err != nil {
+		return User{}, IssuedSession{}, fmt.Errorf("reset failed logins: %w", err)
 	}
 
-	return mapUserRecord(userRecord), sessionToken, nil
+	return mapUserRecord(userRecord), IssuedSession{
+		ID:        sessionID,
+		Token:     sessionToken,
+		ExpiresAt: sessionRecord.ExpiresAt,
+	}, nil
 }
 
-func (s *Service) AuthenticateSession(ctx context.Context, sessionToken string) (User, bool, error) {
+// AuthenticateSession resolves a session token. Sessions past their idle or absolute timeout
+// are deleted and reported as ErrSessionExpired. When the user's role changed since the token
+// was issued, the token is rotated and the replacement returned in RotatedToken.
+func (s *Service) AuthenticateSession(ctx context.Context, sessionToken string, client Client) (AuthenticatedSession, bool, error) {
 	sessionToken = strings.TrimSpace(sessionToken)
 	if sessionToken == "" {
-		return User{}, false, nil
+		return AuthenticatedSession{}, false, nil
 	}
 
-	sessionRecord, userRecord, found, err := s.store.GetSessionWithUserByTokenHash(ctx, security.DigestSHA256(sessionToken))
+	sessionRecord, userRecord, found, err := s.store.GetSessionWithUserByTokenHash(ctx, s.tokenHasher.CandidateHashes(sessionToken)...)
 	if err != nil {
-		return User{}, false, fmt.Errorf("lookup session: %w", err)
+		return AuthenticatedSession{}, false, fmt.Errorf("lookup session: %w", err)
 	}
 	if !found {
-		return User{}, false, nil
+		return AuthenticatedSession{}, false, nil
 	}
 
 	now := s.now().UTC()
-	if !sessionRecord.ExpiresAt.After(now) {
+	if !sessionRecord.ExpiresAt.After(now) || !sessionRecord.LastSeenAt.Add(s.policy.IdleTimeout).After(now) {
 		_ = s.store.DeleteSessionByTokenHash(ctx, sessionRecord.TokenHash)
-		return User{}, false, ErrSessionExpired
+		return AuthenticatedSession{}, false, ErrSessionExpired
+	}
+	if userRecord.DisabledAt != nil {
+		// Disabling deletes sessions already; this covers a login that raced with it.
+		_ = s.store.DeleteSessionByTokenHash(ctx, sessionRecord.TokenHash)
+		return AuthenticatedSession{}, false, nil
 	}
 
-	return mapUserRecord(userRecord), true, nil
+	authenticated := AuthenticatedSession{
+		User:      mapUserRecord(userRecord),
+		SessionID: sessionRecord.ID,
+		ExpiresAt: sessionRecord.ExpiresAt,
+	}
+
+	if sessionRecord.Role != userRecord.Role {
+		rotatedToken, err := s.rotateSessionToken(ctx, sessionRecord, userRecord.Role)
+		if err != nil {
+			return AuthenticatedSession{}, false, err
+		}
+		if rotatedToken == "" {
+			// Another request rotated the token first; this one is no longer valid.
+			return AuthenticatedSession{}, false, nil
+		}
+		authenticated.RotatedToken = rotatedToken
+	} else if !s.tokenHasher.IsCurrent(sessionRecord.HashVersion) {
+		tokenDigest := s.tokenHasher.Hash(sessionToken)
+		if err := s.store.UpdateSessionTokenHash(ctx, sessionRecord.ID, sessionRecord.TokenHash, tokenDigest.Hash, tokenDigest.Version); err != nil {
+			return AuthenticatedSession{}, false, fmt.Errorf("rehash session token: %w", err)
+		}
+	}
+
+	userAgent := truncateUserAgent(client.UserAgent)
+	if now.Sub(sessionRecord.LastSeenAt) >= sessionTouchInterval || client.IP != sessionRecord.IP || userAgent != sessionRecord.UserAgent {
+		if err := s.store.TouchSession(ctx, sessionRecord.ID, now, client.IP, userAgent); err != nil {
+			return AuthenticatedSession{}, false, fmt.Errorf("touch session: %w", err)
+		}
+	}
+
+	return authenticated, true, nil
+}
+
+// rotateSessionToken issues a new token for an existing session and returns it, or "" if the
+// session's token changed concurrently.
+func (s *Service) rotateSessionToken(ctx context.Context, sessionRecord data.SessionRecord, role string) (string, error) {
+	sessionToken, err := generateSessionToken()
+	if err != nil {
+		return "", err
+	}
+
+	tokenDigest := s.tokenHasher.Hash(sessionToken)
+	rotated, err := s.store.RotateSessionToken(ctx, sessionRecord.ID, sessionRecord.TokenHash, tokenDigest.Hash, tokenDigest.Version, role)
+	if err != nil {
+		return "", fmt.Errorf("rotate session token: %w", err)
+	}
+	if !rotated {
+		return "", nil
+	}
+
+	return sessionToken, nil
 }
 
 func (s *Service) Logout(ctx context.Context, sessionToken string) error {
@@ -161,13 +430,81 @@ func (s *Service) Logout(ctx context.Context, sessionToken string) error {
 		return nil
 	}
 
-	if err := s.store.DeleteSessionByTokenHash(ctx, security.DigestSHA256(sessionToken)); err != nil {
+	if err := s.store.DeleteSessionByTokenHash(ctx, s.tokenHasher.CandidateHashes(sessionToken)...); err != nil {
 		return fmt.Errorf("delete session: %w", err)
 	}
 
 	return nil
 }
 
+// ListSessions returns the user's active sessions, flagging currentSessionID.
+func (s *Service) ListSessions(ctx context.Context, user User, currentSessionID string) ([]Session, error) {
+	records, err := s.store.ListSessionsByUserID(ctx, user.ID, s.now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("list user sessions: %w", err)
+	}
+
+	idleCutoff := s.now().UTC().Add(-s.policy.IdleTimeout)
+	sessions := make([]Session, 0, len(records))
+	for _, record := range records {
+		if !record.LastSeenAt.After(idleCutoff) {
+			continue
+		}
+		sessions = append(sessions, Session{
+			ID:         record.ID,
+			IP:         record.IP,
+			UserAgent:  record.UserAgent,
+			CreatedAt:  record.CreatedAt,
+			LastSeenAt: record.LastSeenAt,
+			ExpiresAt:  record.ExpiresAt,
+			Current:    record.ID == currentSessionID,
+		})
+	}
+
+	return sessions, nil
+}
+
+func (s *Service) RevokeSession(ctx context.Context, user User, sessionID string) error {
+	deleted, err := s.store.DeleteSessionForUser(ctx, user.ID, strings.TrimSpace(sessionID))
+	if err != nil {
+		return fmt.Errorf("revoke session: %w", err)
+	}
+	if !deleted {
+		return ErrSessionNotFound
+	}
+
+	recordUserEvent(ctx, s.audit, user.ID, user.ID, "session.revoked", map[string]audit.Change{
+		"session_id": {From: strings.TrimSpace(sessionID), To: nil},
+	})
+
+	return nil
+}
+
+// RevokeAllSessions logs the user out everywhere, including the current session.
+func (s *Service) RevokeAllSessions(ctx context.Context, user User) (int, error) {
+	revoked, err := s.store.DeleteSessionsByUserID(ctx, user.ID)
+	if err != nil {
+		return 0, fmt.Errorf("revoke user sessions: %w", err)
+	}
+
+	recordUserEvent(ctx, s.audit, user.ID, user.ID, "user.sessions_revoked", map[string]audit.Change{
+		"sessions": {From: revoked, To: 0},
+	})
+
+	return revoked, nil
+}
+
+// DeleteExpiredSessions removes sessions past their idle or absolute timeout.
+func (s *Service) DeleteExpiredSessions(ctx context.Context) (int, error) {
+	now := s.now().UTC()
+	deleted, err := s.store.DeleteExpiredSessions(ctx, now, now.Add(-s.policy.IdleTimeout))
+	if err != nil {
+		return 0, fmt.Errorf("delete expired sessions: %w", err)
+	}
+
+	return deleted, nil
+}
+
 func (s *Service) ListUsersForViewer(ctx context.Context, viewer User) ([]User, error) {
 	if viewer.IsAdmin() {
 		allUsers, err := s.store.ListUsers(ctx, 50)
@@ -194,6 +531,75 @@ func (s *Service) ListUsersForViewer(ctx context.Context, viewer User) ([]User,
 	return []User{mapUserRecord(currentUser)}, nil
 }
 
+// SearchUsers pages through users whose email contains query. page starts at 1.
+func (s *Service) SearchUsers(ctx context.Context, query string, page int, pageSize int) ([]User, int, error) {
+	if page < 1 {
+		page = 1
+	}
+
+	records, total, err := s.store.SearchUsers(ctx, strings.TrimSpace(query), pageSize, (page-1)*pageSize)
+	if err != nil {
+		return nil, 0, fmt.Errorf("search users: %w", err)
+	}
+
+	users := make([]User, 0, len(records))
+	for _, record := range records {
+		users = append(users, mapUserRecord(record))
+	}
+
+	return users, total, nil
+}
+
+func (s *Service) GetUser(ctx context.Context, userID string) (User, error) {
+	record, found, err := s.store.GetUserByID(ctx, strings.TrimSpace(userID))
+	if err != nil {
+		return User{}, fmt.Errorf("lookup user by id: %w", err)
+	}
+	if !found {
+		return User{}, ErrUserNotFound
+	}
+
+	return mapUserRecord(record), nil
+}
+
+// UpdateRole changes a user's site role. Their sessions pick the change up on the next request.
+func (s *Service) UpdateRole(ctx context.Context, userID string, role string) error {
+	if role != "user" && role != "admin" {
+		return ErrInvalidRole
+	}
+
+	updated, err := s.store.UpdateUserRole(ctx, strings.TrimSpace(userID), role, s.now().UTC())
+	if err != nil {
+		return fmt.Errorf("update user role: %w", err)
+	}
+	if !updated {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// Disable blocks the user from logging in and ends their sessions. It reports false if the
+// user was already disabled.
+func (s *Service) Disable(ctx context.Context, userID string) (bool, error) {
+	disabled, err := s.store.DisableUser(ctx, strings.TrimSpace(userID), s.now().UTC())
+	if err != nil {
+		return false, fmt.Errorf("disable user: %w", err)
+	}
+
+	return disabled, nil
+}
+
+// Enable reports false if the user was not disabled.
+func (s *Service) Enable(ctx context.Context, userID string) (bool, error) {
+	enabled, err := s.store.EnableUser(ctx, strings.TrimSpace(userID), s.now().UTC())
+	if err != nil {
+		return false, fmt.Errorf("enable user: %w", err)
+	}
+
+	return enabled, nil
+}
+
 func normalizeEmail(email string) (string, error) {
 	trimmed := strings.TrimSpace(strings.ToLower(email))
 	if trimmed == "" {
@@ -207,6 +613,23 @@ func normalizeEmail(email string) (string, error) {
 	return trimmed, nil
 }
 
+func generateSessionToken() (string, error) {
+	sessionToken, err := security.GeneratePrefixedToken("sess", 24)
+	if err != nil {
+		return "", fmt.Errorf("generate session token: %w", err)
+	}
+
+	return sessionToken, nil
+}
+
+func truncateUserAgent(userAgent string) string {
+	if len(userAgent) > maxUserAgentLength {
+		return userAgent[:maxUserAgentLength]
+	}
+
+	return userAgent
+}
+
 func validatePassword(password string) error {
 	if password == "" {
 		return ErrPasswordRequired
@@ -220,10 +643,28 @@ func validatePassword(password string) error {
 
 func mapUserRecord(record data.UserRecord) User {
 	return User{
-		ID:        record.ID,
-		Email:     record.Email,
-		Role:      record.Role,
-		CreatedAt: record.CreatedAt,
-		UpdatedAt: record.UpdatedAt,
+		ID:         record.ID,
+		Email:      record.Email,
+		Role:       record.Role,
+		CreatedAt:  record.CreatedAt,
+		UpdatedAt:  record.UpdatedAt,
+		VerifiedAt: record.VerifiedAt,
+		DisabledAt: record.DisabledAt,
+
+		TwoFactorEnabledAt: record.TOTPEnabledAt,
+		HasPassword:        record.PasswordHash != "",
 	}
 }
+
+// recordUserEvent adds an action on the user targetUserID to the audit trail. It is filed
+// under their personal organization so the user can review their own account's history.
+func recordUserEvent(ctx context.Context, recorder *audit.Recorder, actorUserID string, targetUserID string, action string, changes map[string]audit.Change) {
+	recorder.Record(ctx, audit.Event{
+		ActorUserID:    actorUserID,
+		Action:         action,
+		TargetType:     "user",
+		TargetID:       targetUserID,
+		OrganizationID: data.PersonalOrganizationID(targetUserID),
+		Changes:        changes,
+	})
+}
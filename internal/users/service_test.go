@@ -2,17 +2,22 @@ package users
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
-	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/data/datatest"
+	"github.com/brian-nunez/bbaas-api/internal/lockout"
+	"github.com/brian-nunez/bbaas-api/internal/security"
 )
 
 func TestRegisterLoginAndAuthenticateSession(t *testing.T) {
 	t.Parallel()
 
 	ctx := context.Background()
-	store := setupStore(t)
-	service := NewService(store)
+	store := datatest.NewStore(t)
+	service := NewService(store, security.NewTokenHasher("test-pepper"), SessionPolicy{})
 
 	registered, err := service.Register(ctx, "owner@example.com", "password123")
 	if err != nil {
@@ -22,38 +27,337 @@ func TestRegisterLoginAndAuthenticateSession(t *testing.T) {
 		t.Fatalf("first registered user should be admin")
 	}
 
-	_, sessionToken, err := service.Login(ctx, "owner@example.com", "password123")
+	_, session, err := service.Login(ctx, "owner@example.com", "password123", Client{IP: "192.0.2.1", UserAgent: "test"})
 	if err != nil {
 		t.Fatalf("login user: %v", err)
 	}
 
-	authenticated, found, err := service.AuthenticateSession(ctx, sessionToken)
+	authenticated, found, err := service.AuthenticateSession(ctx, session.Token, Client{IP: "192.0.2.1", UserAgent: "test"})
 	if err != nil {
 		t.Fatalf("authenticate session: %v", err)
 	}
 	if !found {
 		t.Fatalf("expected authenticated session")
 	}
-	if authenticated.Email != "owner@example.com" {
-		t.Fatalf("expected email owner@example.com, got %s", authenticated.Email)
+	if authenticated.User.Email != "owner@example.com" {
+		t.Fatalf("expected email owner@example.com, got %s", authenticated.User.Email)
+	}
+	if authenticated.SessionID != session.ID || authenticated.RotatedToken != "" {
+		t.Fatalf("unexpected authenticated session %+v", authenticated)
 	}
 }
 
-func setupStore(t *testing.T) *data.Store {
-	t.Helper()
+func TestLegacySessionHashesAreUpgraded(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	legacyService := NewService(store, security.NewTokenHasher(""), SessionPolicy{})
+	service := NewService(store, security.NewTokenHasher("test-pepper"), SessionPolicy{})
+
+	if _, err := legacyService.Register(ctx, "legacy@example.com", "password123"); err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	_, session, err := legacyService.Login(ctx, "legacy@example.com", "password123", Client{})
+	if err != nil {
+		t.Fatalf("login user: %v", err)
+	}
 
-	db, _, err := data.Open(data.Config{
-		Driver: "sqlite",
-		DSN:    "file::memory:?cache=shared",
+	counts, err := store.CountLegacyTokenHashes(ctx, security.HashVersionHMACSHA256, time.Now())
+	if err != nil {
+		t.Fatalf("count legacy hashes: %v", err)
+	}
+	if counts.Sessions != 1 {
+		t.Fatalf("expected one legacy session, got %d", counts.Sessions)
+	}
+
+	if _, found, err := service.AuthenticateSession(ctx, session.Token, Client{}); err != nil || !found {
+		t.Fatalf("expected legacy session to authenticate, got found=%v err=%v", found, err)
+	}
+
+	counts, err = store.CountLegacyTokenHashes(ctx, security.HashVersionHMACSHA256, time.Now())
+	if err != nil {
+		t.Fatalf("count legacy hashes: %v", err)
+	}
+	if counts.Sessions != 0 {
+		t.Fatalf("expected the session to be rehashed on use, %d legacy sessions remain", counts.Sessions)
+	}
+	if _, found, err := service.AuthenticateSession(ctx, session.Token, Client{}); err != nil || !found {
+		t.Fatalf("expected rehashed session to authenticate, got found=%v err=%v", found, err)
+	}
+
+	if err := service.Logout(ctx, session.Token); err != nil {
+		t.Fatalf("logout: %v", err)
+	}
+	if _, found, _ := service.AuthenticateSession(ctx, session.Token, Client{}); found {
+		t.Fatalf("expected session to be deleted on logout")
+	}
+}
+
+func TestSessionIdleAndAbsoluteTimeouts(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	service := NewService(store, security.NewTokenHasher("test-pepper"), SessionPolicy{
+		IdleTimeout:     time.Hour,
+		AbsoluteTimeout: 3 * time.Hour,
 	})
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	service.now = func() time.Time { return now }
+
+	if _, err := service.Register(ctx, "timeouts@example.com", "password123"); err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	_, idle, err := service.Login(ctx, "timeouts@example.com", "password123", Client{})
 	if err != nil {
-		t.Fatalf("open test database: %v", err)
+		t.Fatalf("login user: %v", err)
+	}
+	_, active, err := service.Login(ctx, "timeouts@example.com", "password123", Client{})
+	if err != nil {
+		t.Fatalf("login user: %v", err)
+	}
+
+	// Keep one session active past the idle timeout of the other.
+	for range 2 {
+		now = now.Add(50 * time.Minute)
+		if _, found, err := service.AuthenticateSession(ctx, active.Token, Client{}); err != nil || !found {
+			t.Fatalf("expected active session to authenticate, got found=%v err=%v", found, err)
+		}
 	}
-	t.Cleanup(func() { _ = db.Close() })
 
-	if err := data.RunMigrations(context.Background(), db); err != nil {
-		t.Fatalf("run migrations: %v", err)
+	if _, found, err := service.AuthenticateSession(ctx, idle.Token, Client{}); !errors.Is(err, ErrSessionExpired) || found {
+		t.Fatalf("expected idle session to expire, got found=%v err=%v", found, err)
 	}
 
-	return data.NewStore(db)
+	now = now.Add(50 * time.Minute)
+	if _, found, err := service.AuthenticateSession(ctx, active.Token, Client{}); err != nil || !found {
+		t.Fatalf("expected active session to authenticate, got found=%v err=%v", found, err)
+	}
+
+	now = now.Add(50 * time.Minute)
+	if _, found, err := service.AuthenticateSession(ctx, active.Token, Client{}); !errors.Is(err, ErrSessionExpired) || found {
+		t.Fatalf("expected session to expire at the absolute timeout, got found=%v err=%v", found, err)
+	}
+}
+
+func TestDeleteExpiredSessions(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	service := NewService(store, security.NewTokenHasher("test-pepper"), SessionPolicy{IdleTimeout: time.Hour})
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	service.now = func() time.Time { return now }
+
+	user, err := service.Register(ctx, "cleanup@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	if _, _, err := service.Login(ctx, "cleanup@example.com", "password123", Client{}); err != nil {
+		t.Fatalf("login user: %v", err)
+	}
+
+	now = now.Add(30 * time.Minute)
+	_, fresh, err := service.Login(ctx, "cleanup@example.com", "password123", Client{})
+	if err != nil {
+		t.Fatalf("login user: %v", err)
+	}
+
+	now = now.Add(45 * time.Minute)
+	deleted, err := service.DeleteExpiredSessions(ctx)
+	if err != nil {
+		t.Fatalf("delete expired sessions: %v", err)
+	}
+	if deleted != 1 {
+		t.Fatalf("expected the idle session to be deleted, deleted %d", deleted)
+	}
+
+	sessions, err := service.ListSessions(ctx, user, fresh.ID)
+	if err != nil {
+		t.Fatalf("list sessions: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != fresh.ID {
+		t.Fatalf("expected only the fresh session to remain, got %+v", sessions)
+	}
+}
+
+func TestSessionTokenRotatesOnRoleChange(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	service := NewService(store, security.NewTokenHasher("test-pepper"), SessionPolicy{})
+
+	user, err := service.Register(ctx, "rotate@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	_, session, err := service.Login(ctx, "rotate@example.com", "password123", Client{})
+	if err != nil {
+		t.Fatalf("login user: %v", err)
+	}
+
+	if _, err := store.UpdateUserRole(ctx, user.ID, "user", time.Now().UTC()); err != nil {
+		t.Fatalf("update user role: %v", err)
+	}
+
+	authenticated, found, err := service.AuthenticateSession(ctx, session.Token, Client{})
+	if err != nil || !found {
+		t.Fatalf("expected session to authenticate after a role change, got found=%v err=%v", found, err)
+	}
+	if authenticated.RotatedToken == "" || authenticated.RotatedToken == session.Token {
+		t.Fatalf("expected the session token to rotate, got %q", authenticated.RotatedToken)
+	}
+	if authenticated.User.Role != "user" || authenticated.SessionID != session.ID {
+		t.Fatalf("unexpected authenticated session %+v", authenticated)
+	}
+
+	if _, found, err := service.AuthenticateSession(ctx, session.Token, Client{}); err != nil || found {
+		t.Fatalf("expected the old token to stop working, got found=%v err=%v", found, err)
+	}
+
+	authenticated, found, err = service.AuthenticateSession(ctx, authenticated.RotatedToken, Client{})
+	if err != nil || !found {
+		t.Fatalf("expected the rotated token to authenticate, got found=%v err=%v", found, err)
+	}
+	if authenticated.RotatedToken != "" {
+		t.Fatalf("expected no further rotation without a role change")
+	}
+}
+
+func TestListAndRevokeSessions(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	service := NewService(store, security.NewTokenHasher("test-pepper"), SessionPolicy{})
+
+	owner, err := service.Register(ctx, "sessions@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+	if _, err := service.Register(ctx, "other@example.com", "password123"); err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+
+	_, laptop, err := service.Login(ctx, "sessions@example.com", "password123", Client{IP: "192.0.2.10", UserAgent: "laptop"})
+	if err != nil {
+		t.Fatalf("login user: %v", err)
+	}
+	_, phone, err := service.Login(ctx, "sessions@example.com", "password123", Client{IP: "192.0.2.20", UserAgent: "phone"})
+	if err != nil {
+		t.Fatalf("login user: %v", err)
+	}
+	_, otherSession, err := service.Login(ctx, "other@example.com", "password123", Client{})
+	if err != nil {
+		t.Fatalf("login user: %v", err)
+	}
+
+	if _, _, err := service.AuthenticateSession(ctx, phone.Token, Client{IP: "198.51.100.7", UserAgent: "phone"}); err != nil {
+		t.Fatalf("authenticate session: %v", err)
+	}
+
+	sessions, err := service.ListSessions(ctx, owner, laptop.ID)
+	if err != nil {
+		t.Fatalf("list sessions: %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("expected two sessions, got %+v", sessions)
+	}
+	for _, session := range sessions {
+		switch session.ID {
+		case laptop.ID:
+			if !session.Current || session.IP != "192.0.2.10" || session.UserAgent != "laptop" {
+				t.Fatalf("unexpected current session %+v", session)
+			}
+		case phone.ID:
+			if session.Current || session.IP != "198.51.100.7" {
+				t.Fatalf("expected the phone session to record its latest IP, got %+v", session)
+			}
+		default:
+			t.Fatalf("unexpected session %+v", session)
+		}
+	}
+
+	if err := service.RevokeSession(ctx, owner, otherSession.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("expected revoking another user's session to fail, got %v", err)
+	}
+	if err := service.RevokeSession(ctx, owner, phone.ID); err != nil {
+		t.Fatalf("revoke session: %v", err)
+	}
+	if _, found, _ := service.AuthenticateSession(ctx, phone.Token, Client{}); found {
+		t.Fatalf("expected revoked session to stop working")
+	}
+
+	revoked, err := service.RevokeAllSessions(ctx, owner)
+	if err != nil {
+		t.Fatalf("revoke all sessions: %v", err)
+	}
+	if revoked != 1 {
+		t.Fatalf("expected one remaining session to be revoked, got %d", revoked)
+	}
+	if _, found, _ := service.AuthenticateSession(ctx, laptop.Token, Client{}); found {
+		t.Fatalf("expected every session to be logged out")
+	}
+	if _, found, err := service.AuthenticateSession(ctx, otherSession.Token, Client{}); err != nil || !found {
+		t.Fatalf("expected other users' sessions to survive, got found=%v err=%v", found, err)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	service := NewService(store, security.NewTokenHasher("test-pepper"), SessionPolicy{})
+	guard := lockout.NewGuard(store, map[lockout.Scope]lockout.Policy{
+		lockout.ScopeLoginAccount: {FreeFailures: 2, BaseDelay: time.Hour, MaxDelay: time.Hour, ResetAfter: 2 * time.Hour},
+		lockout.ScopeLoginIP:      {FreeFailures: 5, BaseDelay: time.Hour, MaxDelay: time.Hour, ResetAfter: 2 * time.Hour},
+	})
+	service.UseLockout(guard)
+
+	if _, err := service.Register(ctx, "locked@example.com", "password123"); err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+
+	// A registered and an unknown address lock out the same way.
+	for _, email := range []string{"locked@example.com", "nobody@example.com"} {
+		client := Client{IP: "192.0.2.1"}
+		if email == "nobody@example.com" {
+			client.IP = "192.0.2.2"
+		}
+		for attempt := 1; attempt <= 3; attempt++ {
+			if _, _, err := service.Login(ctx, email, "wrong-password", client); !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("attempt %d for %s: expected invalid credentials, got %v", attempt, email, err)
+			}
+		}
+
+		var locked *lockout.LockedError
+		if _, _, err := service.Login(ctx, email, "password123", client); !errors.As(err, &locked) {
+			t.Fatalf("expected %s to be locked, got %v", email, err)
+		}
+	}
+
+	// Failures from many addresses add up against one source IP.
+	for attempt := 1; attempt <= 6; attempt++ {
+		_, _, _ = service.Login(ctx, fmt.Sprintf("spray-%d@example.com", attempt), "wrong-password", Client{IP: "198.51.100.9"})
+	}
+	var locked *lockout.LockedError
+	if _, _, err := service.Login(ctx, "spray-7@example.com", "wrong-password", Client{IP: "198.51.100.9"}); !errors.As(err, &locked) {
+		t.Fatalf("expected the source IP to be locked, got %v", err)
+	}
+
+	if _, err := guard.Clear(ctx, lockout.LoginAccount("locked@example.com")); err != nil {
+		t.Fatalf("clear lockout: %v", err)
+	}
+	if _, _, err := service.Login(ctx, "locked@example.com", "wrong-password", Client{IP: "192.0.2.3"}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected invalid credentials, got %v", err)
+	}
+	if _, _, err := service.Login(ctx, "locked@example.com", "password123", Client{IP: "192.0.2.3"}); err != nil {
+		t.Fatalf("expected login to succeed once cleared, got %v", err)
+	}
+	if _, found, err := store.GetAuthFailure(ctx, string(lockout.ScopeLoginAccount), "locked@example.com"); err != nil || found {
+		t.Fatalf("expected a successful login to reset the account's failures, got found=%v err=%v", found, err)
+	}
 }
@@ -0,0 +1,406 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/audit"
+	"github.com/brian-nunez/bbaas-api/internal/data"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+	"github.com/brian-nunez/bbaas-api/internal/totp"
+)
+
+const (
+	// RecoveryCodeCount is how many single-use recovery codes an enrollment issues.
+	RecoveryCodeCount = 10
+	// LoginChallengeTTL is how long a user has to enter their code after their password.
+	LoginChallengeTTL = 5 * time.Minute
+	// MaxLoginChallengeAttempts is how many wrong codes end a login challenge.
+	MaxLoginChallengeAttempts = 5
+
+	requireTwoFactorSetting = "require_two_factor"
+	recoveryCodeAlphabet    = "abcdefghijkmnpqrstuvwxyz23456789"
+	recoveryCodeHalfLength  = 5
+)
+
+var (
+	ErrTwoFactorUnavailable    = errors.New("two-factor authentication is not configured on this server")
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorRequired       = errors.New("two-factor authentication is required for every account")
+	ErrNoPendingEnrollment     = errors.New("start two-factor setup again; no pending enrollment was found")
+	ErrInvalidTwoFactorCode    = errors.New("invalid authentication code")
+	ErrLoginChallengeExpired   = errors.New("login attempt has expired; enter your password again")
+	ErrTwoFactorAdminOnly      = errors.New("only admins can change the two-factor requirement")
+)
+
+// SecondFactorRequiredError is returned by Login when the password was correct but the user
+// must still enter a code. ChallengeToken identifies the half-finished login to CompleteLogin.
+type SecondFactorRequiredError struct {
+	ChallengeToken string
+	ExpiresAt      time.Time
+}
+
+func (e *SecondFactorRequiredError) Error() string {
+	return "a second authentication factor is required"
+}
+
+// TwoFactorEnrollment is a pending TOTP secret for the user to add to an authenticator app.
+type TwoFactorEnrollment struct {
+	Secret          string
+	ProvisioningURI string
+}
+
+type TwoFactorStatus struct {
+	Enabled                bool
+	EnabledAt              *time.Time
+	RecoveryCodesRemaining int
+}
+
+// TwoFactor manages TOTP enrollment and verification. Secrets are encrypted with secretBox;
+// without one, users cannot enroll and accounts that already have 2FA cannot log in.
+type TwoFactor struct {
+	store       *data.Store
+	tokenHasher *security.TokenHasher
+	secretBox   *security.SecretBox
+	issuer      string
+	audit       *audit.Recorder
+	now         func() time.Time
+}
+
+func NewTwoFactor(store *data.Store, tokenHasher *security.TokenHasher, secretBox *security.SecretBox, issuer string) *TwoFactor {
+	return &TwoFactor{
+		store:       store,
+		tokenHasher: tokenHasher,
+		secretBox:   secretBox,
+		issuer:      issuer,
+		now:         time.Now,
+	}
+}
+
+// UseAuditRecorder records enrollment changes and the site-wide requirement in the audit trail.
+func (t *TwoFactor) UseAuditRecorder(recorder *audit.Recorder) {
+	t.audit = recorder
+}
+
+func (t *TwoFactor) Available() bool {
+	return t != nil && t.secretBox != nil
+}
+
+func (t *TwoFactor) Status(ctx context.Context, user User) (TwoFactorStatus, error) {
+	record, err := t.lookupUser(ctx, user.ID)
+	if err != nil {
+		return TwoFactorStatus{}, err
+	}
+	if record.TOTPEnabledAt == nil {
+		return TwoFactorStatus{}, nil
+	}
+
+	remaining, err := t.store.CountUnusedRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		return TwoFactorStatus{}, fmt.Errorf("count recovery codes: %w", err)
+	}
+
+	return TwoFactorStatus{
+		Enabled:                true,
+		EnabledAt:              record.TOTPEnabledAt,
+		RecoveryCodesRemaining: remaining,
+	}, nil
+}
+
+// BeginEnrollment returns the user's pending secret, generating one if needed, so reloading
+// the setup page keeps showing the same QR code.
+func (t *TwoFactor) BeginEnrollment(ctx context.Context, user User) (TwoFactorEnrollment, error) {
+	if !t.Available() {
+		return TwoFactorEnrollment{}, ErrTwoFactorUnavailable
+	}
+
+	record, err := t.lookupUser(ctx, user.ID)
+	if err != nil {
+		return TwoFactorEnrollment{}, err
+	}
+	if record.TOTPEnabledAt != nil {
+		return TwoFactorEnrollment{}, ErrTwoFactorAlreadyEnabled
+	}
+
+	secret := ""
+	if record.TOTPSecret != "" {
+		if opened, err := t.secretBox.Open(record.TOTPSecret, record.ID); err == nil {
+			secret = opened
+		}
+	}
+	if secret == "" {
+		secret, err = totp.GenerateSecret()
+		if err != nil {
+			return TwoFactorEnrollment{}, err
+		}
+		sealed, err := t.secretBox.Seal(secret, record.ID)
+		if err != nil {
+			return TwoFactorEnrollment{}, fmt.Errorf("encrypt TOTP secret: %w", err)
+		}
+		stored, err := t.store.SetPendingTOTPSecret(ctx, record.ID, sealed, t.now().UTC())
+		if err != nil {
+			return TwoFactorEnrollment{}, err
+		}
+		if !stored {
+			return TwoFactorEnrollment{}, ErrTwoFactorAlreadyEnabled
+		}
+	}
+
+	return TwoFactorEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(t.issuer, record.Email, secret),
+	}, nil
+}
+
+// ConfirmEnrollment turns on 2FA once code proves the authenticator app has the pending
+// secret. It returns the recovery codes, which are only available here.
+func (t *TwoFactor) ConfirmEnrollment(ctx context.Context, user User, code string) ([]string, error) {
+	if !t.Available() {
+		return nil, ErrTwoFactorUnavailable
+	}
+
+	record, err := t.lookupUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if record.TOTPEnabledAt != nil {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if record.TOTPSecret == "" {
+		return nil, ErrNoPendingEnrollment
+	}
+
+	secret, err := t.secretBox.Open(record.TOTPSecret, record.ID)
+	if err != nil {
+		return nil, ErrNoPendingEnrollment
+	}
+	now := t.now().UTC()
+	step, valid, err := totp.Validate(secret, code, now)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	recoveryCodes, recoveryRecords, err := t.generateRecoveryCodes(now)
+	if err != nil {
+		return nil, err
+	}
+
+	enabled, err := t.store.EnableTOTP(ctx, record.ID, record.TOTPSecret, step, recoveryRecords, now)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, ErrNoPendingEnrollment
+	}
+
+	recordUserEvent(ctx, t.audit, record.ID, record.ID, "user.two_factor_enabled", map[string]audit.Change{
+		"two_factor": {From: false, To: true},
+	})
+
+	return recoveryCodes, nil
+}
+
+// Disable turns off 2FA after checking a current code or recovery code.
+func (t *TwoFactor) Disable(ctx context.Context, user User, code string) error {
+	required, err := t.Required(ctx)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrTwoFactorRequired
+	}
+
+	record, err := t.lookupUser(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if record.TOTPEnabledAt == nil {
+		return ErrTwoFactorNotEnabled
+	}
+	if err := t.verify(ctx, record, code); err != nil {
+		return err
+	}
+
+	if err := t.store.DisableTOTP(ctx, record.ID, t.now().UTC()); err != nil {
+		return err
+	}
+
+	recordUserEvent(ctx, t.audit, record.ID, record.ID, "user.two_factor_disabled", map[string]audit.Change{
+		"two_factor": {From: true, To: false},
+	})
+
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces all of the user's recovery codes after checking a code.
+func (t *TwoFactor) RegenerateRecoveryCodes(ctx context.Context, user User, code string) ([]string, error) {
+	record, err := t.lookupUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if record.TOTPEnabledAt == nil {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	if err := t.verify(ctx, record, code); err != nil {
+		return nil, err
+	}
+
+	recoveryCodes, recoveryRecords, err := t.generateRecoveryCodes(t.now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	if err := t.store.ReplaceRecoveryCodes(ctx, record.ID, recoveryRecords); err != nil {
+		return nil, err
+	}
+
+	recordUserEvent(ctx, t.audit, record.ID, record.ID, "user.recovery_codes_regenerated", nil)
+
+	return recoveryCodes, nil
+}
+
+// Required reports whether an admin has made 2FA mandatory for every account.
+func (t *TwoFactor) Required(ctx context.Context) (bool, error) {
+	value, found, err := t.store.GetSetting(ctx, requireTwoFactorSetting)
+	if err != nil {
+		return false, err
+	}
+
+	return found && value == "true", nil
+}
+
+func (t *TwoFactor) SetRequired(ctx context.Context, actor User, required bool) error {
+	if !actor.IsAdmin() {
+		return ErrTwoFactorAdminOnly
+	}
+	if required && !t.Available() {
+		return ErrTwoFactorUnavailable
+	}
+
+	wasRequired, err := t.Required(ctx)
+	if err != nil {
+		return err
+	}
+	if err := t.store.SetSetting(ctx, requireTwoFactorSetting, fmt.Sprint(required), t.now().UTC()); err != nil {
+		return err
+	}
+
+	t.audit.Record(ctx, audit.Event{
+		ActorUserID: actor.ID,
+		Action:      "settings.two_factor_required_updated",
+		TargetType:  "setting",
+		TargetID:    requireTwoFactorSetting,
+		Changes:     map[string]audit.Change{"required": {From: wasRequired, To: required}},
+	})
+
+	return nil
+}
+
+func (t *TwoFactor) DeleteExpiredChallenges(ctx context.Context) (int, error) {
+	deleted, err := t.store.DeleteExpiredLoginChallenges(ctx, t.now().UTC())
+	if err != nil {
+		return 0, fmt.Errorf("delete expired login challenges: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// verify accepts either a TOTP code, which cannot be reused, or an unused recovery code,
+// which is spent.
+func (t *TwoFactor) verify(ctx context.Context, record data.UserRecord, code string) error {
+	if !t.Available() {
+		return ErrTwoFactorUnavailable
+	}
+
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return ErrInvalidTwoFactorCode
+	}
+
+	if len(strings.ReplaceAll(code, " ", "")) != totp.Digits {
+		used, err := t.store.UseRecoveryCode(ctx, record.ID, t.hashRecoveryCode(code), t.now().UTC())
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInvalidTwoFactorCode
+		}
+		return nil
+	}
+
+	secret, err := t.secretBox.Open(record.TOTPSecret, record.ID)
+	if err != nil {
+		return fmt.Errorf("decrypt TOTP secret: %w", err)
+	}
+	step, valid, err := totp.Validate(secret, code, t.now().UTC())
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return ErrInvalidTwoFactorCode
+	}
+
+	advanced, err := t.store.AdvanceTOTPStep(ctx, record.ID, step)
+	if err != nil {
+		return err
+	}
+	if !advanced {
+		return ErrInvalidTwoFactorCode
+	}
+
+	return nil
+}
+
+func (t *TwoFactor) lookupUser(ctx context.Context, userID string) (data.UserRecord, error) {
+	record, found, err := t.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return data.UserRecord{}, fmt.Errorf("lookup user: %w", err)
+	}
+	if !found {
+		return data.UserRecord{}, ErrSessionNotFound
+	}
+
+	return record, nil
+}
+
+func (t *TwoFactor) generateRecoveryCodes(now time.Time) ([]string, []data.RecoveryCodeRecord, error) {
+	codes := make([]string, 0, RecoveryCodeCount)
+	records := make([]data.RecoveryCodeRecord, 0, RecoveryCodeCount)
+	for range RecoveryCodeCount {
+		random := make([]byte, 2*recoveryCodeHalfLength)
+		if _, err := rand.Read(random); err != nil {
+			return nil, nil, fmt.Errorf("generate recovery code: %w", err)
+		}
+		for i, b := range random {
+			random[i] = recoveryCodeAlphabet[int(b)%len(recoveryCodeAlphabet)]
+		}
+		code := string(random[:recoveryCodeHalfLength]) + "-" + string(random[recoveryCodeHalfLength:])
+
+		codeID, err := security.GeneratePrefixedToken("rc", 12)
+		if err != nil {
+			return nil, nil, fmt.Errorf("generate recovery code id: %w", err)
+		}
+
+		codes = append(codes, code)
+		records = append(records, data.RecoveryCodeRecord{
+			ID:        codeID,
+			CodeHash:  t.hashRecoveryCode(code),
+			CreatedAt: now,
+		})
+	}
+
+	return codes, records, nil
+}
+
+// hashRecoveryCode ignores case, spaces and dashes so codes can be typed loosely.
+func (t *TwoFactor) hashRecoveryCode(code string) string {
+	normalized := strings.NewReplacer(" ", "", "-", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+	return t.tokenHasher.Hash(normalized).Hash
+}
@@ -0,0 +1,205 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brian-nunez/bbaas-api/internal/data/datatest"
+	"github.com/brian-nunez/bbaas-api/internal/security"
+	"github.com/brian-nunez/bbaas-api/internal/totp"
+)
+
+func TestTwoFactorEnrollmentAndLogin(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	tokenHasher := security.NewTokenHasher("test-pepper")
+	secretBox, err := security.NewSecretBox("test-encryption-key")
+	if err != nil {
+		t.Fatalf("new secret box: %v", err)
+	}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	service := NewService(store, tokenHasher, SessionPolicy{})
+	service.now = clock
+	twoFactor := NewTwoFactor(store, tokenHasher, secretBox, "BBAAS")
+	twoFactor.now = clock
+	service.UseTwoFactor(twoFactor)
+
+	user, err := service.Register(ctx, "admin@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register user: %v", err)
+	}
+
+	enrollment, err := twoFactor.BeginEnrollment(ctx, user)
+	if err != nil {
+		t.Fatalf("begin enrollment: %v", err)
+	}
+	if !strings.HasPrefix(enrollment.ProvisioningURI, "otpauth://totp/BBAAS:admin@example.com?") {
+		t.Fatalf("unexpected provisioning URI %q", enrollment.ProvisioningURI)
+	}
+	again, err := twoFactor.BeginEnrollment(ctx, user)
+	if err != nil || again.Secret != enrollment.Secret {
+		t.Fatalf("expected the pending secret to be reused, got %q, %v", again.Secret, err)
+	}
+
+	record, _, err := store.GetUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("lookup user: %v", err)
+	}
+	if strings.Contains(record.TOTPSecret, enrollment.Secret) {
+		t.Fatalf("expected the TOTP secret to be encrypted at rest")
+	}
+	if opened, err := secretBox.Open(record.TOTPSecret, "usr_other"); err == nil || opened != "" {
+		t.Fatalf("expected the secret to be bound to its user")
+	}
+
+	codeAt := func(at time.Time) string {
+		code, err := totp.Code(enrollment.Secret, totp.Step(at))
+		if err != nil {
+			t.Fatalf("generate code: %v", err)
+		}
+		return code
+	}
+
+	if _, err := twoFactor.ConfirmEnrollment(ctx, user, codeAt(now.Add(time.Hour))); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("expected a wrong code to be rejected, got %v", err)
+	}
+	recoveryCodes, err := twoFactor.ConfirmEnrollment(ctx, user, codeAt(now))
+	if err != nil {
+		t.Fatalf("confirm enrollment: %v", err)
+	}
+	if len(recoveryCodes) != RecoveryCodeCount {
+		t.Fatalf("expected %d recovery codes, got %d", RecoveryCodeCount, len(recoveryCodes))
+	}
+
+	login := func() string {
+		_, _, err := service.Login(ctx, "admin@example.com", "password123", Client{})
+		var secondFactor *SecondFactorRequiredError
+		if !errors.As(err, &secondFactor) {
+			t.Fatalf("expected a second factor to be required, got %v", err)
+		}
+		return secondFactor.ChallengeToken
+	}
+
+	// The enrollment code's step was recorded, so the same code cannot be replayed.
+	challenge := login()
+	if _, _, err := service.CompleteLogin(ctx, challenge, codeAt(now), Client{}); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("expected a reused code to be rejected, got %v", err)
+	}
+	now = now.Add(totp.Period)
+	loggedIn, session, err := service.CompleteLogin(ctx, challenge, codeAt(now), Client{})
+	if err != nil {
+		t.Fatalf("complete login: %v", err)
+	}
+	if !loggedIn.HasTwoFactor() || session.Token == "" {
+		t.Fatalf("expected a session for a 2FA user, got %+v", loggedIn)
+	}
+	if _, _, err := service.CompleteLogin(ctx, challenge, codeAt(now.Add(totp.Period)), Client{}); !errors.Is(err, ErrLoginChallengeExpired) {
+		t.Fatalf("expected a completed challenge to be spent, got %v", err)
+	}
+
+	// Recovery codes work once each.
+	challenge = login()
+	if _, _, err := service.CompleteLogin(ctx, challenge, strings.ToUpper(recoveryCodes[0]), Client{}); err != nil {
+		t.Fatalf("complete login with recovery code: %v", err)
+	}
+	challenge = login()
+	if _, _, err := service.CompleteLogin(ctx, challenge, recoveryCodes[0], Client{}); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("expected a used recovery code to be rejected, got %v", err)
+	}
+	status, err := twoFactor.Status(ctx, user)
+	if err != nil || !status.Enabled || status.RecoveryCodesRemaining != RecoveryCodeCount-1 {
+		t.Fatalf("unexpected status %+v, %v", status, err)
+	}
+
+	// Too many wrong codes end the challenge; the used recovery code was the first.
+	for attempt := 2; attempt < MaxLoginChallengeAttempts; attempt++ {
+		if _, _, err := service.CompleteLogin(ctx, challenge, "bad-code", Client{}); !errors.Is(err, ErrInvalidTwoFactorCode) {
+			t.Fatalf("attempt %d: expected an invalid code error, got %v", attempt, err)
+		}
+	}
+	if _, _, err := service.CompleteLogin(ctx, challenge, "bad-code", Client{}); !errors.Is(err, ErrLoginChallengeExpired) {
+		t.Fatalf("expected the last allowed attempt to end the challenge, got %v", err)
+	}
+	if _, _, err := service.CompleteLogin(ctx, challenge, codeAt(now.Add(totp.Period)), Client{}); !errors.Is(err, ErrLoginChallengeExpired) {
+		t.Fatalf("expected the challenge to be locked after repeated failures, got %v", err)
+	}
+
+	challenge = login()
+	now = now.Add(LoginChallengeTTL)
+	if _, _, err := service.CompleteLogin(ctx, challenge, codeAt(now), Client{}); !errors.Is(err, ErrLoginChallengeExpired) {
+		t.Fatalf("expected an expired challenge to be rejected, got %v", err)
+	}
+
+	// Without the encryption key, 2FA accounts cannot log in at all.
+	withoutKey := NewService(store, tokenHasher, SessionPolicy{})
+	if _, _, err := withoutKey.Login(ctx, "admin@example.com", "password123", Client{}); !errors.Is(err, ErrTwoFactorUnavailable) {
+		t.Fatalf("expected login to fail closed, got %v", err)
+	}
+
+	now = now.Add(totp.Period)
+	regenerated, err := twoFactor.RegenerateRecoveryCodes(ctx, user, codeAt(now))
+	if err != nil {
+		t.Fatalf("regenerate recovery codes: %v", err)
+	}
+	if regenerated[1] == recoveryCodes[1] {
+		t.Fatalf("expected new recovery codes")
+	}
+
+	if err := twoFactor.SetRequired(ctx, user, true); err != nil {
+		t.Fatalf("require two-factor: %v", err)
+	}
+	if err := twoFactor.Disable(ctx, user, regenerated[0]); !errors.Is(err, ErrTwoFactorRequired) {
+		t.Fatalf("expected disabling to be refused while required, got %v", err)
+	}
+	if err := twoFactor.SetRequired(ctx, user, false); err != nil {
+		t.Fatalf("stop requiring two-factor: %v", err)
+	}
+	if err := twoFactor.Disable(ctx, user, regenerated[0]); err != nil {
+		t.Fatalf("disable two-factor: %v", err)
+	}
+	if _, session, err := service.Login(ctx, "admin@example.com", "password123", Client{}); err != nil || session.Token == "" {
+		t.Fatalf("expected a password-only login after disabling, got %v", err)
+	}
+}
+
+func TestTwoFactorRequirementIsAdminOnly(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := datatest.NewStore(t)
+	tokenHasher := security.NewTokenHasher("test-pepper")
+	service := NewService(store, tokenHasher, SessionPolicy{})
+	secretBox, err := security.NewSecretBox("test-encryption-key")
+	if err != nil {
+		t.Fatalf("new secret box: %v", err)
+	}
+	twoFactor := NewTwoFactor(store, tokenHasher, secretBox, "BBAAS")
+
+	if _, err := service.Register(ctx, "admin@example.com", "password123"); err != nil {
+		t.Fatalf("register admin: %v", err)
+	}
+	member, err := service.Register(ctx, "member@example.com", "password123")
+	if err != nil {
+		t.Fatalf("register member: %v", err)
+	}
+
+	if err := twoFactor.SetRequired(ctx, member, true); !errors.Is(err, ErrTwoFactorAdminOnly) {
+		t.Fatalf("expected members to be refused, got %v", err)
+	}
+	required, err := twoFactor.Required(ctx)
+	if err != nil || required {
+		t.Fatalf("expected two-factor to stay optional, got %v, %v", required, err)
+	}
+
+	unavailable := NewTwoFactor(store, tokenHasher, nil, "BBAAS")
+	if _, err := unavailable.BeginEnrollment(ctx, member); !errors.Is(err, ErrTwoFactorUnavailable) {
+		t.Fatalf("expected enrollment to need an encryption key, got %v", err)
+	}
+}
@@ -4,26 +4,36 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"path"
 	"strings"
+	"sync"
 	"time"
 )
 
 type Option func(*Client)
 
 type Client struct {
-	baseURL    *url.URL
-	httpClient *http.Client
-	apiToken   string
+	baseURL     *url.URL
+	httpClient  *http.Client
+	apiToken    string
+	tokenSource TokenSource
+
+	tokenMu     sync.Mutex
+	cachedToken AccessToken
 }
 
 type APIError struct {
 	StatusCode int
-	Message    string
+	// Code is the API error code, e.g. "INSUFFICIENT_SCOPE", when the response carried one.
+	Code    string
+	Message string
+	// MissingScopes lists the scopes the API key lacks when Code is "INSUFFICIENT_SCOPE".
+	MissingScopes []string
 }
 
 func (e *APIError) Error() string {
@@ -129,13 +139,77 @@ func (c *Client) CloseBrowser(ctx context.Context, browserID string) error {
 	return c.do(ctx, http.MethodDelete, path.Join("/api/v1/browsers", browserID), nil, true, http.StatusOK, nil)
 }
 
+// RotateAPIKey replaces the client's API key with a new one and switches the client over to it.
+// The old key keeps working for gracePeriod so other deployments can be updated.
+func (c *Client) RotateAPIKey(ctx context.Context, gracePeriod time.Duration) (RotateAPIKeyResponse, error) {
+	request := struct {
+		GracePeriodSeconds int `json:"gracePeriodSeconds"`
+	}{
+		GracePeriodSeconds: int(gracePeriod / time.Second),
+	}
+
+	var response RotateAPIKeyResponse
+	if err := c.do(ctx, http.MethodPost, "/api/v1/api-keys/current/rotate", request, true, http.StatusCreated, &response); err != nil {
+		return RotateAPIKeyResponse{}, err
+	}
+
+	c.SetAPIToken(response.Token)
+	return response, nil
+}
+
+// GetAPIKey fetches a key of the client's application. Use "current" for the key in use.
+func (c *Client) GetAPIKey(ctx context.Context, keyID string) (APIKey, error) {
+	var response struct {
+		APIKey APIKey `json:"apiKey"`
+	}
+
+	if err := c.do(ctx, http.MethodGet, path.Join("/api/v1/api-keys", keyID), nil, true, http.StatusOK, &response); err != nil {
+		return APIKey{}, err
+	}
+
+	return response.APIKey, nil
+}
+
 func (c *Client) do(ctx context.Context, method string, resourcePath string, requestBody any, requiresAuth bool, expectedStatus int, output any) error {
-	var body io.Reader
-	if requestBody != nil {
-		payload, err := json.Marshal(requestBody)
-		if err != nil {
-			return fmt.Errorf("encode request body: %w", err)
+	payload, err := encodeRequestBody(requestBody)
+	if err != nil {
+		return err
+	}
+
+	if !requiresAuth {
+		return c.send(ctx, method, resourcePath, payload, "", expectedStatus, output)
+	}
+
+	if c.tokenSource == nil {
+		if strings.TrimSpace(c.apiToken) == "" {
+			return fmt.Errorf("API token is required for this endpoint")
 		}
+		return c.send(ctx, method, resourcePath, payload, c.apiToken, expectedStatus, output)
+	}
+
+	bearerToken, err := c.accessToken(ctx, false)
+	if err != nil {
+		return err
+	}
+
+	err = c.send(ctx, method, resourcePath, payload, bearerToken, expectedStatus, output)
+	var apiError *APIError
+	if !errors.As(err, &apiError) || apiError.Code != "ACCESS_TOKEN_EXPIRED" {
+		return err
+	}
+
+	// The cached token expired early, e.g. because of clock skew. Retry once with a new one.
+	bearerToken, err = c.accessToken(ctx, true)
+	if err != nil {
+		return err
+	}
+
+	return c.send(ctx, method, resourcePath, payload, bearerToken, expectedStatus, output)
+}
+
+func (c *Client) send(ctx context.Context, method string, resourcePath string, payload []byte, bearerToken string, expectedStatus int, output any) error {
+	var body io.Reader
+	if payload != nil {
 		body = bytes.NewReader(payload)
 	}
 
@@ -147,15 +221,11 @@ func (c *Client) do(ctx context.Context, method string, resourcePath string, req
 		return fmt.Errorf("build request: %w", err)
 	}
 	httpRequest.Header.Set("Accept", "application/json")
-	if requestBody != nil {
+	if payload != nil {
 		httpRequest.Header.Set("Content-Type", "application/json")
 	}
-
-	if requiresAuth {
-		if strings.TrimSpace(c.apiToken) == "" {
-			return fmt.Errorf("API token is required for this endpoint")
-		}
-		httpRequest.Header.Set("Authorization", "Bearer "+c.apiToken)
+	if bearerToken != "" {
+		httpRequest.Header.Set("Authorization", "Bearer "+bearerToken)
 	}
 
 	httpResponse, err := c.httpClient.Do(httpRequest)
@@ -184,6 +254,19 @@ func (c *Client) do(ctx context.Context, method string, resourcePath string, req
 	return nil
 }
 
+func encodeRequestBody(requestBody any) ([]byte, error) {
+	if requestBody == nil {
+		return nil, nil
+	}
+
+	payload, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("encode request body: %w", err)
+	}
+
+	return payload, nil
+}
+
 func parseAPIError(statusCode int, body []byte) error {
 	message := strings.TrimSpace(string(body))
 	if message == "" {
@@ -193,7 +276,9 @@ func parseAPIError(statusCode int, body []byte) error {
 	var structured struct {
 		Message string `json:"message"`
 		Error   struct {
-			ErrorMessage string `json:"error_message"`
+			ErrorCode     string   `json:"error_code"`
+			ErrorMessage  string   `json:"error_message"`
+			MissingScopes []string `json:"missing_scopes"`
 		} `json:"error"`
 	}
 	if err := json.Unmarshal(body, &structured); err == nil {
@@ -205,7 +290,9 @@ func parseAPIError(statusCode int, body []byte) error {
 	}
 
 	return &APIError{
-		StatusCode: statusCode,
-		Message:    message,
+		StatusCode:    statusCode,
+		Code:          structured.Error.ErrorCode,
+		Message:       message,
+		MissingScopes: structured.Error.MissingScopes,
 	}
 }
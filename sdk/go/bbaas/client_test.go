@@ -2,10 +2,13 @@ package bbaas
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestClientSpawn(t *testing.T) {
@@ -51,6 +54,71 @@ func TestClientRequiresTokenForProtectedEndpoints(t *testing.T) {
 	}
 }
 
+func TestClientReportsMissingScopes(t *testing.T) {
+	t.Parallel()
+
+	httpClient := &http.Client{Transport: roundTripFunc(func(request *http.Request) (*http.Response, error) {
+		return jsonResponse(http.StatusForbidden, `{"status":403,"error":{"error_code":"INSUFFICIENT_SCOPE","error_message":"API key is missing required scopes: browsers:spawn","missing_scopes":["browsers:spawn"]}}`), nil
+	})}
+
+	client, err := NewClient("http://bbaas.local", WithHTTPClient(httpClient), WithAPIToken("bbaas_token"))
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+
+	_, err = client.SpawnBrowser(context.Background(), SpawnBrowserRequest{})
+	var apiError *APIError
+	if !errors.As(err, &apiError) {
+		t.Fatalf("expected APIError, got %v", err)
+	}
+	if apiError.Code != "INSUFFICIENT_SCOPE" || len(apiError.MissingScopes) != 1 || apiError.MissingScopes[0] != "browsers:spawn" {
+		t.Fatalf("expected missing spawn scope, got %+v", apiError)
+	}
+}
+
+func TestClientRefreshesAccessTokens(t *testing.T) {
+	t.Parallel()
+
+	var mints int
+	httpClient := &http.Client{Transport: roundTripFunc(func(request *http.Request) (*http.Response, error) {
+		authorization := request.Header.Get("Authorization")
+		switch request.URL.Path {
+		case "/api/v1/tokens":
+			if authorization != "Bearer bka_key" {
+				t.Fatalf("expected tokens to be minted with the API key, got %q", authorization)
+			}
+			mints++
+			expiresAt := time.Now().Add(15 * time.Minute).UTC().Format(time.RFC3339)
+			return jsonResponse(http.StatusCreated, fmt.Sprintf(`{"token":"a.b.%d","tokenType":"Bearer","expiresAt":%q}`, mints, expiresAt)), nil
+		case "/api/v1/browsers":
+			if authorization == "Bearer a.b.1" {
+				return jsonResponse(http.StatusUnauthorized, `{"status":401,"error":{"error_code":"ACCESS_TOKEN_EXPIRED","error_message":"Access token has expired"}}`), nil
+			}
+			return jsonResponse(http.StatusOK, `{"browsers":[]}`), nil
+		}
+		return jsonResponse(http.StatusNotFound, `{}`), nil
+	})}
+
+	keyClient, err := NewClient("http://bbaas.local", WithHTTPClient(httpClient), WithAPIToken("bka_key"))
+	if err != nil {
+		t.Fatalf("new key client: %v", err)
+	}
+	client, err := NewClient("http://bbaas.local", WithHTTPClient(httpClient), WithTokenSource(keyClient.AccessTokenSource(MintAccessTokenRequest{})))
+	if err != nil {
+		t.Fatalf("new token client: %v", err)
+	}
+
+	if _, err := client.ListBrowsers(context.Background()); err != nil {
+		t.Fatalf("list browsers: %v", err)
+	}
+	if _, err := client.ListBrowsers(context.Background()); err != nil {
+		t.Fatalf("list browsers with cached token: %v", err)
+	}
+	if mints != 2 {
+		t.Fatalf("expected one mint plus one refresh after expiry, got %d", mints)
+	}
+}
+
 type roundTripFunc func(request *http.Request) (*http.Response, error)
 
 func (f roundTripFunc) RoundTrip(request *http.Request) (*http.Response, error) {
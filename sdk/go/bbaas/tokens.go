@@ -0,0 +1,90 @@
+package bbaas
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// tokenRefreshWindow is how long before expiry a cached access token is replaced.
+const tokenRefreshWindow = 30 * time.Second
+
+// TokenSource supplies access tokens to a client configured with WithTokenSource.
+type TokenSource interface {
+	AccessToken(ctx context.Context) (AccessToken, error)
+}
+
+// TokenSourceFunc adapts a function to TokenSource.
+type TokenSourceFunc func(ctx context.Context) (AccessToken, error)
+
+func (f TokenSourceFunc) AccessToken(ctx context.Context) (AccessToken, error) {
+	return f(ctx)
+}
+
+// WithTokenSource makes the client authenticate with access tokens from source instead of
+// an API key. Tokens are cached and refreshed shortly before they expire, or when the API
+// reports one as expired.
+func WithTokenSource(source TokenSource) Option {
+	return func(c *Client) {
+		c.tokenSource = source
+	}
+}
+
+// MintAccessToken exchanges the client's API key for a short-lived access token.
+func (c *Client) MintAccessToken(ctx context.Context, request MintAccessTokenRequest) (AccessToken, error) {
+	if c.apiToken == "" {
+		return AccessToken{}, fmt.Errorf("API token is required to mint access tokens")
+	}
+
+	body := struct {
+		TTLSeconds int      `json:"ttlSeconds,omitempty"`
+		Scopes     []string `json:"scopes,omitempty"`
+		BrowserID  string   `json:"browserId,omitempty"`
+	}{
+		TTLSeconds: int(request.TTL / time.Second),
+		Scopes:     request.Scopes,
+		BrowserID:  request.BrowserID,
+	}
+	payload, err := encodeRequestBody(body)
+	if err != nil {
+		return AccessToken{}, err
+	}
+
+	var response AccessToken
+	if err := c.send(ctx, http.MethodPost, "/api/v1/tokens", payload, c.apiToken, http.StatusCreated, &response); err != nil {
+		return AccessToken{}, err
+	}
+
+	return response, nil
+}
+
+// AccessTokenSource returns a TokenSource that mints tokens with the client's API key, for
+// use with WithTokenSource on a client that should not hold the key itself.
+func (c *Client) AccessTokenSource(request MintAccessTokenRequest) TokenSource {
+	return TokenSourceFunc(func(ctx context.Context) (AccessToken, error) {
+		return c.MintAccessToken(ctx, request)
+	})
+}
+
+// accessToken returns the cached access token, fetching a new one when it is close to
+// expiring or when forceRefresh is set.
+func (c *Client) accessToken(ctx context.Context, forceRefresh bool) (string, error) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+
+	if !forceRefresh && c.cachedToken.Token != "" && time.Until(c.cachedToken.ExpiresAt) > tokenRefreshWindow {
+		return c.cachedToken.Token, nil
+	}
+
+	token, err := c.tokenSource.AccessToken(ctx)
+	if err != nil {
+		return "", fmt.Errorf("obtain access token: %w", err)
+	}
+	if token.Token == "" {
+		return "", fmt.Errorf("token source returned an empty access token")
+	}
+
+	c.cachedToken = token
+	return token.Token, nil
+}
@@ -18,8 +18,49 @@ type Browser struct {
 	ExpiresAt          time.Time `json:"expiresAt"`
 }
 
+type APIKey struct {
+	ID               string     `json:"id"`
+	Name             string     `json:"name"`
+	KeyPrefix        string     `json:"keyPrefix"`
+	Scopes           []string   `json:"scopes"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	LastUsedAt       *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt        *time.Time `json:"revokedAt,omitempty"`
+	ExpiresAt        *time.Time `json:"expiresAt,omitempty"`
+	RotatedFromKeyID string     `json:"rotatedFromKeyId,omitempty"`
+	ReplacedByKeyID  string     `json:"replacedByKeyId,omitempty"`
+	RotatedAt        *time.Time `json:"rotatedAt,omitempty"`
+	GraceExpiresAt   *time.Time `json:"graceExpiresAt,omitempty"`
+	UsedDuringGrace  bool       `json:"usedDuringGrace"`
+	AllowedCIDRs     []string   `json:"allowedCidrs,omitempty"`
+}
+
+type RotateAPIKeyResponse struct {
+	APIKey     APIKey `json:"apiKey"`
+	Token      string `json:"token"`
+	RotatedKey APIKey `json:"rotatedKey"`
+}
+
 type SpawnBrowserResponse struct {
 	Browser            Browser `json:"browser"`
 	SpawnTaskProcessID string  `json:"spawnTaskProcessId"`
 	SpawnedByWorkerID  int     `json:"spawnedByWorkerId"`
 }
+
+// AccessToken is a short-lived token minted from an API key. It is sent in place of the key.
+type AccessToken struct {
+	Token     string    `json:"token"`
+	TokenType string    `json:"tokenType"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Scopes    []string  `json:"scopes"`
+	BrowserID string    `json:"browserId,omitempty"`
+}
+
+type MintAccessTokenRequest struct {
+	// TTL is rounded down to whole seconds; zero uses the server default (15 minutes).
+	TTL time.Duration
+	// Scopes narrows the token to a subset of the key's scopes; empty keeps all of them.
+	Scopes []string
+	// BrowserID restricts the token to a single browser.
+	BrowserID string
+}
@@ -0,0 +1,18 @@
+package pages
+
+import "context"
+
+// CSRFFieldName is the form field the CSRF middleware reads the token from.
+const CSRFFieldName = "csrf_token"
+
+type csrfTokenContextKey struct{}
+
+// WithCSRFToken makes the request's CSRF token available to CSRFField.
+func WithCSRFToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, csrfTokenContextKey{}, token)
+}
+
+func csrfToken(ctx context.Context) string {
+	token, _ := ctx.Value(csrfTokenContextKey{}).(string)
+	return token
+}